@@ -21,7 +21,8 @@ func InitializeAdapter(ctx context.Context, maxConnsPerHost clients.MaxConnsPerH
 	}
 	subscription := adapter.NewPubSubSubscription(ctx, client, subscriptionID)
 	httpClient := clients.NewHTTPClient(ctx, maxConnsPerHost)
-	converter := converters.NewPubSubConverter()
+	v := _wireValue
+	converter := converters.NewPubSubConverter(v...)
 	statsReporter, err := adapter.NewStatsReporter(name, namespace, resourceGroup)
 	if err != nil {
 		return nil, err
@@ -29,3 +30,7 @@ func InitializeAdapter(ctx context.Context, maxConnsPerHost clients.MaxConnsPerH
 	adapterAdapter := adapter.NewAdapter(ctx, projectID, namespace, name, resourceGroup, subscription, httpClient, converter, statsReporter, args)
 	return adapterAdapter, nil
 }
+
+var (
+	_wireValue = []converters.ConverterOption(nil)
+)
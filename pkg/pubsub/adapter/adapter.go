@@ -142,6 +142,9 @@ func (a *Adapter) Stop() {
 // TODO refactor this method. As our RA code is used both for Sources and our Channel, it also supports replies
 //  (in the case of Channels) and the logic is more convoluted.
 func (a *Adapter) receive(ctx context.Context, msg *pubsub.Message) {
+	if msg.DeliveryAttempt != nil {
+		ctx = WithDeliveryAttempt(ctx, *msg.DeliveryAttempt)
+	}
 	event, err := a.converter.Convert(ctx, msg, a.args.ConverterType)
 	if err != nil {
 		a.logger.Debug("Failed to convert received message to an event, check the msg format: %v", zap.Error(err))
@@ -99,6 +99,19 @@ func (c *mockConverter) Convert(ctx context.Context, msg *pubsub.Message, conver
 	return c.converted, nil
 }
 
+func (c *mockConverter) ConvertBatch(ctx context.Context, msgs []*pubsub.Message, converterType converters.ConverterType) ([]*cev2.Event, []error) {
+	events := make([]*cev2.Event, len(msgs))
+	errs := make([]error, len(msgs))
+	for i, msg := range msgs {
+		events[i], errs[i] = c.Convert(ctx, msg, converterType)
+	}
+	return events, errs
+}
+
+func (c *mockConverter) ConvertToMessage(ctx context.Context, event *cev2.Event, converterType converters.ConverterType) (*pubsub.Message, error) {
+	return nil, errors.New("not implemented")
+}
+
 func TestAdapter(t *testing.T) {
 	sampleEvent := newSampleEvent()
 	convertedEvent := sampleEvent.Clone()
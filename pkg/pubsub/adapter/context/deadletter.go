@@ -0,0 +1,37 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package context
+
+import (
+	"context"
+)
+
+// The key used to store/retrieve the dead letter topic in the context.
+type deadLetterKey struct{}
+
+// WithDeadLetterKey sets a dead letter topic key in the context.
+func WithDeadLetterKey(ctx context.Context, topic string) context.Context {
+	return context.WithValue(ctx, deadLetterKey{}, topic)
+}
+
+// GetDeadLetterKey gets the dead letter topic key from the context. Unlike
+// the other keys, the dead letter topic is optional, so its absence is not an
+// error.
+func GetDeadLetterKey(ctx context.Context) (string, bool) {
+	topic, ok := ctx.Value(deadLetterKey{}).(string)
+	return topic, ok
+}
@@ -0,0 +1,38 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package context
+
+import (
+	"context"
+	"testing"
+)
+
+func TestDeadLetterKey(t *testing.T) {
+	if _, ok := GetDeadLetterKey(context.Background()); ok {
+		t.Errorf("GetDeadLetterKey got a dead letter topic from an empty context")
+	}
+
+	wantKey := "projects/test-project/topics/dead-letter"
+	ctx := WithDeadLetterKey(context.Background(), wantKey)
+	gotKey, ok := GetDeadLetterKey(ctx)
+	if !ok {
+		t.Errorf("GetDeadLetterKey got no dead letter topic")
+	}
+	if gotKey != wantKey {
+		t.Errorf("dead letter key from context got=%s, want=%s", gotKey, wantKey)
+	}
+}
@@ -0,0 +1,38 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package context
+
+import (
+	"context"
+)
+
+// The key used to store/retrieve the delivery attempt in the context.
+type deliveryAttemptKey struct{}
+
+// WithDeliveryAttempt sets the delivery attempt of a pubsub message in the
+// context.
+func WithDeliveryAttempt(ctx context.Context, n int) context.Context {
+	return context.WithValue(ctx, deliveryAttemptKey{}, n)
+}
+
+// GetDeliveryAttempt gets the delivery attempt of a pubsub message from the
+// context. Pubsub only reports delivery attempts for the subscriptions with a
+// dead letter policy, so its absence is not an error.
+func GetDeliveryAttempt(ctx context.Context) (int, bool) {
+	n, ok := ctx.Value(deliveryAttemptKey{}).(int)
+	return n, ok
+}
@@ -0,0 +1,38 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package context
+
+import (
+	"context"
+	"testing"
+)
+
+func TestDeliveryAttempt(t *testing.T) {
+	if _, ok := GetDeliveryAttempt(context.Background()); ok {
+		t.Errorf("GetDeliveryAttempt got a delivery attempt from an empty context")
+	}
+
+	want := 3
+	ctx := WithDeliveryAttempt(context.Background(), want)
+	got, ok := GetDeliveryAttempt(ctx)
+	if !ok {
+		t.Errorf("GetDeliveryAttempt got no delivery attempt")
+	}
+	if got != want {
+		t.Errorf("delivery attempt from context got=%d, want=%d", got, want)
+	}
+}
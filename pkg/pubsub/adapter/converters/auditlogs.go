@@ -19,7 +19,6 @@ package converters
 import (
 	"bytes"
 	"context"
-	"errors"
 	"fmt"
 	"log"
 	"reflect"
@@ -126,7 +125,11 @@ func convertCloudAuditLogs(ctx context.Context, msg *pubsub.Message) (*cev2.Even
 		event.SetTime(timestamp)
 	}
 	event.SetType(schemasv1.CloudAuditLogsLogWrittenEventType)
-	event.SetSource(schemasv1.CloudAuditLogsEventSource(parentResource, logActivity))
+	source, err := schemasv1.CloudAuditLogsEventSourceE(parentResource, logActivity)
+	if err != nil {
+		return nil, err
+	}
+	event.SetSource(source)
 	event.SetDataSchema(schemasv1.CloudAuditLogsEventDataSchema)
 	event.SetData(cev2.ApplicationJSON, msg.Data)
 
@@ -143,10 +146,10 @@ func convertCloudAuditLogs(ctx context.Context, msg *pubsub.Message) (*cev2.Even
 			event.SetExtension(schemasv1.MethodNameExtension, proto.MethodName)
 			event.SetExtension(schemasv1.ResourceNameExtension, proto.ResourceName)
 		default:
-			return nil, fmt.Errorf("unhandled proto payload type: %T", proto)
+			return nil, fmt.Errorf("%w: unhandled proto payload type: %T", ErrUnsupportedConverter, proto)
 		}
 	default:
-		return nil, errors.New("non-AuditLog log entry")
+		return nil, fmt.Errorf("%w: non-AuditLog log entry", ErrUnsupportedConverter)
 	}
 	return &event, nil
 }
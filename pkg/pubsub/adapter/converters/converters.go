@@ -20,12 +20,15 @@ package converters
 
 import (
 	"context"
+	"errors"
 	"fmt"
 
 	"cloud.google.com/go/pubsub"
 	cepubsub "github.com/cloudevents/sdk-go/protocol/pubsub/v2"
 	cev2 "github.com/cloudevents/sdk-go/v2"
 	"github.com/cloudevents/sdk-go/v2/binding"
+
+	. "github.com/google/knative-gcp/pkg/pubsub/adapter/context"
 )
 
 // ModeType is the type for mode enum.
@@ -42,6 +45,10 @@ const (
 
 type ConverterType string
 
+// DeliveryAttemptExtension is the CloudEvent extension which holds the number
+// of times the pubsub message has been delivered, if known.
+const DeliveryAttemptExtension = "deliveryattempt"
+
 const (
 	// The different type of Converters for the different sources.
 	CloudPubSub    ConverterType = "pubsub"
@@ -52,10 +59,56 @@ const (
 	PubSubPull     ConverterType = "pubsub_pull"
 )
 
+var (
+	// ErrUnsupportedConverter is returned by Convert when the converter does not
+	// support the kind of the message, e.g. a GCS notification of an unknown
+	// event type. Retrying the conversion of such a message cannot succeed.
+	ErrUnsupportedConverter = errors.New("unsupported message")
+	// ErrMalformedMessage is returned by Convert when the message is missing
+	// required attributes, or its payload cannot be decoded.
+	ErrMalformedMessage = errors.New("malformed message")
+)
+
+// malformedMessageError wraps the errors of the converters which are not
+// otherwise classified, so that they match ErrMalformedMessage while keeping
+// their message.
+type malformedMessageError struct {
+	err error
+}
+
+func (e *malformedMessageError) Error() string {
+	return e.err.Error()
+}
+
+func (e *malformedMessageError) Unwrap() error {
+	return e.err
+}
+
+func (e *malformedMessageError) Is(target error) bool {
+	return target == ErrMalformedMessage
+}
+
+// classifyError makes a conversion error match either ErrUnsupportedConverter
+// or ErrMalformedMessage.
+func classifyError(err error) error {
+	if err == nil || errors.Is(err, ErrUnsupportedConverter) || errors.Is(err, ErrMalformedMessage) {
+		return err
+	}
+	return &malformedMessageError{err: err}
+}
+
 type converterFn func(context.Context, *pubsub.Message) (*cev2.Event, error)
 
+type messageConverterFn func(context.Context, *cev2.Event) (*pubsub.Message, error)
+
+// batchConverterFn prepares a converter which shares its state across the
+// conversions of a batch of messages from the same subscription.
+type batchConverterFn func(context.Context) (converterFn, error)
+
 type Converter interface {
 	Convert(ctx context.Context, msg *pubsub.Message, converterType ConverterType) (*cev2.Event, error)
+	ConvertBatch(ctx context.Context, msgs []*pubsub.Message, converterType ConverterType) ([]*cev2.Event, []error)
+	ConvertToMessage(ctx context.Context, event *cev2.Event, converterType ConverterType) (*pubsub.Message, error)
 }
 
 type PubSubConverter struct {
@@ -66,10 +119,39 @@ type PubSubConverter struct {
 	// we assume it's a PubSub message and a default
 	// one will be used.
 	converters map[ConverterType]converterFn
+
+	// messageConverters is the map for handling the inverse conversions,
+	// from Source specific events back to pubsub messages. If not present,
+	// the default PubSub binding is used.
+	messageConverters map[ConverterType]messageConverterFn
+
+	// batchConverters is the map for handling the batch conversions of the
+	// Source specific events which can share state across a batch. If not
+	// present, the messages of a batch are converted one by one.
+	batchConverters map[ConverterType]batchConverterFn
+
+	// schemas is the registry of the schemas which the data of the
+	// converted events are validated against. Validation is skipped if nil.
+	schemas SchemaRegistry
+
+	// aliases maps the aliases of the message attributes to their canonical
+	// extensions. Normalization is skipped if nil.
+	aliases AttributeAliases
 }
 
-func NewPubSubConverter() Converter {
-	return &PubSubConverter{
+// ConverterOption is for providing individual options of a PubSubConverter.
+type ConverterOption func(*PubSubConverter)
+
+// WithSchemaValidation makes the converter validate the data of the converted
+// events against the schemas of a registry.
+func WithSchemaValidation(registry SchemaRegistry) ConverterOption {
+	return func(c *PubSubConverter) {
+		c.schemas = registry
+	}
+}
+
+func NewPubSubConverter(opts ...ConverterOption) Converter {
+	c := &PubSubConverter{
 		converters: map[ConverterType]converterFn{
 			CloudPubSub:    convertCloudPubSub,
 			CloudAuditLogs: convertCloudAuditLogs,
@@ -78,24 +160,118 @@ func NewPubSubConverter() Converter {
 			CloudBuild:     convertCloudBuild,
 			PubSubPull:     convertPubSubPull,
 		},
+		batchConverters: map[ConverterType]batchConverterFn{
+			CloudPubSub: newCloudPubSubBatchConverter,
+		},
+	}
+	// The message converters undo the normalization of the converter.
+	c.messageConverters = map[ConverterType]messageConverterFn{
+		CloudPubSub: c.convertCloudPubSubToMessage,
+	}
+	for _, opt := range opts {
+		opt(c)
 	}
+	return c
 }
 
 // Convert converts a message off the pubsub format to a source specific if
 // there's a registered handler for the type in the converters map.
 // If there's no registered handler, a default Pubsub one will be used.
+// If attribute normalization is enabled, the aliased attributes of the
+// message are set as their canonical extensions. If schema validation is
+// enabled, the data of the converted event is validated against its schema. The returned errors match either
+// ErrUnsupportedConverter or ErrMalformedMessage.
 func (c *PubSubConverter) Convert(ctx context.Context, msg *pubsub.Message, converterType ConverterType) (*cev2.Event, error) {
-	if msg == nil {
-		return nil, fmt.Errorf("nil pubsub message")
+	return c.convertWith(ctx, msg, c.converter(converterType))
+}
+
+// ConvertBatch converts a batch of messages from the same subscription the
+// same way as Convert. The returned events and errors align index for index
+// with the messages. The converters which support it share state across the
+// batch, such as the parsed event source and the encoding buffers.
+func (c *PubSubConverter) ConvertBatch(ctx context.Context, msgs []*pubsub.Message, converterType ConverterType) ([]*cev2.Event, []error) {
+	convert := c.converter(converterType)
+	if newBatchConverter, ok := c.batchConverters[converterType]; ok {
+		batchConvert, err := newBatchConverter(ctx)
+		if err != nil {
+			// Fail every message the same way as Convert would.
+			batchConvert = func(context.Context, *pubsub.Message) (*cev2.Event, error) {
+				return nil, err
+			}
+		}
+		convert = batchConvert
 	}
-	// Try the converterType, if specified.
+
+	events := make([]*cev2.Event, len(msgs))
+	errs := make([]error, len(msgs))
+	for i, msg := range msgs {
+		msgCtx := ctx
+		if msg != nil && msg.DeliveryAttempt != nil {
+			msgCtx = WithDeliveryAttempt(ctx, *msg.DeliveryAttempt)
+		}
+		events[i], errs[i] = c.convertWith(msgCtx, msg, convert)
+	}
+	return events, errs
+}
+
+// converter returns the converter for the converterType, if specified and
+// registered in the converters map.
+func (c *PubSubConverter) converter(converterType ConverterType) converterFn {
 	if converterType != "" {
 		if c, ok := c.converters[converterType]; ok {
-			return c(ctx, msg)
+			return c
 		}
 	}
-
 	// No converter, PubSub is the default one.
+	return convertDefault
+}
+
+func convertDefault(ctx context.Context, msg *pubsub.Message) (*cev2.Event, error) {
 	return binding.ToEvent(ctx, cepubsub.NewMessage(msg))
+}
+
+func (c *PubSubConverter) convertWith(ctx context.Context, msg *pubsub.Message, convert converterFn) (*cev2.Event, error) {
+	if msg == nil {
+		return nil, fmt.Errorf("%w: nil pubsub message", ErrMalformedMessage)
+	}
+	event, err := convert(ctx, msg)
+	if err != nil {
+		return nil, classifyError(err)
+	}
+	if c.aliases != nil {
+		if err := c.aliases.normalize(msg, event); err != nil {
+			return nil, classifyError(err)
+		}
+	}
+	if attempt, ok := GetDeliveryAttempt(ctx); ok {
+		event.SetExtension(DeliveryAttemptExtension, attempt)
+	}
+	if c.schemas != nil {
+		if err := c.schemas.validate(event); err != nil {
+			return nil, err
+		}
+	}
+	return event, nil
+}
 
+// ConvertToMessage converts a source specific event back to the pubsub format
+// if there's a registered handler for the type in the message converters map.
+// If there's no registered handler, a default Pubsub one will be used.
+func (c *PubSubConverter) ConvertToMessage(ctx context.Context, event *cev2.Event, converterType ConverterType) (*pubsub.Message, error) {
+	if event == nil {
+		return nil, fmt.Errorf("nil event")
+	}
+	// Try the converterType, if specified.
+	if converterType != "" {
+		if c, ok := c.messageConverters[converterType]; ok {
+			return c(ctx, event)
+		}
+	}
+
+	// No converter, PubSub is the default one.
+	msg := new(pubsub.Message)
+	if err := cepubsub.WritePubSubMessage(ctx, binding.ToMessage(event), msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
 }
@@ -0,0 +1,246 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package converters
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	cev2 "github.com/cloudevents/sdk-go/v2"
+	"github.com/cloudevents/sdk-go/v2/types"
+	"github.com/google/go-cmp/cmp"
+
+	. "github.com/google/knative-gcp/pkg/pubsub/adapter/context"
+)
+
+func TestConvertErrors(t *testing.T) {
+	tests := []struct {
+		name          string
+		message       *pubsub.Message
+		converterType ConverterType
+		wantErr       error
+	}{{
+		name:          "nil message",
+		converterType: CloudPubSub,
+		wantErr:       ErrMalformedMessage,
+	}, {
+		name:          "pubsub message without context",
+		message:       &pubsub.Message{ID: "id"},
+		converterType: CloudPubSub,
+		wantErr:       ErrMalformedMessage,
+	}, {
+		name: "storage message without bucketId",
+		message: &pubsub.Message{
+			Attributes: map[string]string{
+				"eventType": eventType,
+				"objectId":  objectId,
+			},
+		},
+		converterType: CloudStorage,
+		wantErr:       ErrMalformedMessage,
+	}, {
+		name: "storage message of unknown eventType",
+		message: &pubsub.Message{
+			Attributes: map[string]string{
+				"eventType": "RANDOM_EVENT",
+				"bucketId":  bucket,
+				"objectId":  objectId,
+			},
+		},
+		converterType: CloudStorage,
+		wantErr:       ErrUnsupportedConverter,
+	}, {
+		name:          "undecodable audit log entry",
+		message:       &pubsub.Message{Data: []byte("not a log entry")},
+		converterType: CloudAuditLogs,
+		wantErr:       ErrMalformedMessage,
+	}, {
+		name: "non-AuditLog log entry",
+		message: &pubsub.Message{
+			Data: []byte(fmt.Sprintf(`{"insertId":%q,"logName":%q,"timestamp":%q,"textPayload":"text"}`, insertID, logName, testTs)),
+		},
+		converterType: CloudAuditLogs,
+		wantErr:       ErrUnsupportedConverter,
+	}, {
+		name:          "scheduler message without jobName",
+		message:       &pubsub.Message{},
+		converterType: CloudScheduler,
+		wantErr:       ErrMalformedMessage,
+	}, {
+		name:    "undecodable default message",
+		message: &pubsub.Message{},
+		wantErr: ErrMalformedMessage,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := NewPubSubConverter().Convert(context.Background(), test.message, test.converterType)
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("converters.Convert got error %v, want error matching %v", err, test.wantErr)
+			}
+			for _, otherErr := range []error{ErrMalformedMessage, ErrUnsupportedConverter} {
+				if otherErr != test.wantErr && errors.Is(err, otherErr) {
+					t.Errorf("converters.Convert got error %v, which unexpectedly matches %v", err, otherErr)
+				}
+			}
+		})
+	}
+}
+
+func TestConvertBatch(t *testing.T) {
+	ctx := WithProjectKey(context.Background(), "testproject")
+	ctx = WithTopicKey(ctx, "testtopic")
+	ctx = WithSubscriptionKey(ctx, "testsubscription")
+	msgs := []*pubsub.Message{{
+		ID:         "id1",
+		Data:       []byte("\"test data\""),
+		Attributes: map[string]string{"attribute1": "value1"},
+	}, nil, {
+		ID:          "id2",
+		Data:        []byte("\"other data\""),
+		OrderingKey: "key",
+	}}
+
+	c := NewPubSubConverter()
+	gotEvents, gotErrs := c.ConvertBatch(ctx, msgs, CloudPubSub)
+	if len(gotEvents) != len(msgs) || len(gotErrs) != len(msgs) {
+		t.Fatalf("converters.ConvertBatch got %d events and %d errors, want %d", len(gotEvents), len(gotErrs), len(msgs))
+	}
+	for i, msg := range msgs {
+		wantEvent, wantErr := c.Convert(ctx, msg, CloudPubSub)
+		if (gotErrs[i] != nil) != (wantErr != nil) {
+			t.Errorf("converters.ConvertBatch got error %v for message %d, want %v", gotErrs[i], i, wantErr)
+		}
+		if diff := cmp.Diff(wantEvent, gotEvents[i]); diff != "" {
+			t.Errorf("converters.ConvertBatch got unexpected event for message %d (-want +got) %s", i, diff)
+		}
+	}
+	if !errors.Is(gotErrs[1], ErrMalformedMessage) {
+		t.Errorf("converters.ConvertBatch got error %v for nil message, want error matching %v", gotErrs[1], ErrMalformedMessage)
+	}
+}
+
+func TestConvertBatchInvalidContext(t *testing.T) {
+	msgs := []*pubsub.Message{{ID: "id1"}, {ID: "id2"}}
+	gotEvents, gotErrs := NewPubSubConverter().ConvertBatch(context.Background(), msgs, CloudPubSub)
+	for i := range msgs {
+		if gotEvents[i] != nil || !errors.Is(gotErrs[i], ErrMalformedMessage) {
+			t.Errorf("converters.ConvertBatch got event %v and error %v for message %d, want error matching %v", gotEvents[i], gotErrs[i], i, ErrMalformedMessage)
+		}
+	}
+}
+
+func benchmarkMessages() []*pubsub.Message {
+	msgs := make([]*pubsub.Message, 100)
+	for i := range msgs {
+		msgs[i] = &pubsub.Message{
+			ID:          fmt.Sprintf("id%d", i),
+			Data:        []byte("\"test data\""),
+			Attributes:  map[string]string{"attribute1": "value1"},
+			PublishTime: time.Now(),
+		}
+	}
+	return msgs
+}
+
+func benchmarkContext() context.Context {
+	ctx := WithProjectKey(context.Background(), "testproject")
+	ctx = WithTopicKey(ctx, "testtopic")
+	return WithSubscriptionKey(ctx, "testsubscription")
+}
+
+func BenchmarkConvert(b *testing.B) {
+	ctx := benchmarkContext()
+	msgs := benchmarkMessages()
+	c := NewPubSubConverter()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		for _, msg := range msgs {
+			if _, err := c.Convert(ctx, msg, CloudPubSub); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkConvertBatch(b *testing.B) {
+	ctx := benchmarkContext()
+	msgs := benchmarkMessages()
+	c := NewPubSubConverter()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		if _, errs := c.ConvertBatch(ctx, msgs, CloudPubSub); errs[0] != nil {
+			b.Fatal(errs[0])
+		}
+	}
+}
+
+func TestConvertDeliveryAttempt(t *testing.T) {
+	ctx := WithProjectKey(context.Background(), "testproject")
+	ctx = WithTopicKey(ctx, "testtopic")
+	ctx = WithSubscriptionKey(ctx, "testsubscription")
+	attempt := 3
+
+	tests := []struct {
+		name        string
+		convert     func(c Converter) (*cev2.Event, error)
+		wantAttempt bool
+	}{{
+		name: "with delivery attempt",
+		convert: func(c Converter) (*cev2.Event, error) {
+			return c.Convert(WithDeliveryAttempt(ctx, attempt), &pubsub.Message{ID: "id"}, CloudPubSub)
+		},
+		wantAttempt: true,
+	}, {
+		name: "without delivery attempt",
+		convert: func(c Converter) (*cev2.Event, error) {
+			return c.Convert(ctx, &pubsub.Message{ID: "id"}, CloudPubSub)
+		},
+	}, {
+		name: "batch with message delivery attempt",
+		convert: func(c Converter) (*cev2.Event, error) {
+			events, errs := c.ConvertBatch(ctx, []*pubsub.Message{{ID: "id", DeliveryAttempt: &attempt}}, CloudPubSub)
+			return events[0], errs[0]
+		},
+		wantAttempt: true,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gotEvent, err := test.convert(NewPubSubConverter())
+			if err != nil {
+				t.Fatalf("converters.Convert got error %v", err)
+			}
+			gotAttempt, ok := gotEvent.Extensions()[DeliveryAttemptExtension]
+			if ok != test.wantAttempt {
+				t.Fatalf("converters.Convert got %s extension=%v, want=%v", DeliveryAttemptExtension, ok, test.wantAttempt)
+			}
+			if !ok {
+				return
+			}
+			if n, err := types.ToInteger(gotAttempt); err != nil || int(n) != attempt {
+				t.Errorf("converters.Convert got %s extension %v, want %d", DeliveryAttemptExtension, gotAttempt, attempt)
+			}
+		})
+	}
+}
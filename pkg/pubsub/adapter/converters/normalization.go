@@ -0,0 +1,104 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package converters
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"cloud.google.com/go/pubsub"
+	cev2 "github.com/cloudevents/sdk-go/v2"
+	"github.com/cloudevents/sdk-go/v2/event"
+)
+
+// AttributeAliases maps the names under which sources set the pubsub message
+// attributes of the same logical attribute to the name of the canonical
+// CloudEvent extension of the attribute. The aliases are matched regardless of
+// their casing and of the 'ce-' prefix.
+type AttributeAliases map[string]string
+
+// WithAttributeNormalization makes the converter set the canonical extensions
+// of the message attributes which are set under one of their aliases, in place
+// of the extensions they would otherwise be promoted to.
+func WithAttributeNormalization(aliases AttributeAliases) ConverterOption {
+	return func(c *PubSubConverter) {
+		c.aliases = make(AttributeAliases, len(aliases))
+		for alias, canonical := range aliases {
+			canonical = strings.ToLower(canonical)
+			c.aliases[normalizeAttributeName(alias)] = canonical
+			// The canonical name is an alias of itself.
+			c.aliases[canonical] = canonical
+		}
+	}
+}
+
+// normalizeAttributeName returns the name of a pubsub message attribute, or
+// of its alias, stripped of its casing and of the 'ce-' prefix.
+func normalizeAttributeName(attribute string) string {
+	return strings.TrimPrefix(strings.ToLower(attribute), attributeExtensionPrefix)
+}
+
+// canonical returns the canonical extension of a pubsub message attribute, if
+// it is set under one of its aliases.
+func (a AttributeAliases) canonical(attribute string) (string, bool) {
+	canonical, ok := a[normalizeAttributeName(attribute)]
+	return canonical, ok
+}
+
+// normalize sets the canonical extensions of the aliased attributes of a
+// message on the event converted from it, and removes the extensions which the
+// aliased attributes were promoted to. An attribute set under the canonical
+// name itself takes precedence over its aliases, which otherwise apply in the
+// order of their names.
+func (a AttributeAliases) normalize(msg *pubsub.Message, ev *cev2.Event) error {
+	attributes := make([]string, 0, len(msg.Attributes))
+	for attribute := range msg.Attributes {
+		attributes = append(attributes, attribute)
+	}
+	sort.Strings(attributes)
+
+	values := map[string]string{}
+	canonicalValues := map[string]string{}
+	for _, attribute := range attributes {
+		name := normalizeAttributeName(attribute)
+		canonical, ok := a.canonical(attribute)
+		if !ok {
+			continue
+		}
+		if !event.IsAlphaNumeric(canonical) || reservedAttributeExtensions[canonical] {
+			return fmt.Errorf("invalid canonical extension %q of attribute %q", canonical, attribute)
+		}
+		if extension, ok := attributeExtensionName(attribute); ok && extension != canonical {
+			ev.SetExtension(extension, nil)
+		}
+		if name == canonical {
+			canonicalValues[canonical] = msg.Attributes[attribute]
+		} else if _, ok := values[canonical]; !ok {
+			values[canonical] = msg.Attributes[attribute]
+		}
+	}
+	for canonical, value := range canonicalValues {
+		values[canonical] = value
+	}
+	for canonical, value := range values {
+		if err := ev.Context.SetExtension(canonical, value); err != nil {
+			return fmt.Errorf("failed to set canonical extension %q: %w", canonical, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package converters
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"cloud.google.com/go/pubsub"
+	"github.com/google/go-cmp/cmp"
+
+	. "github.com/google/knative-gcp/pkg/pubsub/adapter/context"
+)
+
+func TestConvertWithAttributeNormalization(t *testing.T) {
+	ctx := WithProjectKey(context.Background(), "testproject")
+	ctx = WithTopicKey(ctx, "testtopic")
+	ctx = WithSubscriptionKey(ctx, "testsubscription")
+	aliases := AttributeAliases{
+		"Region":       "region",
+		"region_name":  "region",
+		"ce-RegionTag": "region",
+		"Tenant-Id":    "tenant",
+	}
+
+	tests := []struct {
+		name           string
+		aliases        AttributeAliases
+		attributes     map[string]string
+		wantExtensions map[string]interface{}
+		wantErr        bool
+	}{{
+		name:           "aliased attributes",
+		aliases:        aliases,
+		attributes:     map[string]string{"region_name": "us-east1", "Tenant-Id": "acme", "other": "value"},
+		wantExtensions: map[string]interface{}{"region": "us-east1", "tenant": "acme", "other": "value"},
+	}, {
+		name:           "aliases matched regardless of casing and prefix",
+		aliases:        aliases,
+		attributes:     map[string]string{"REGION_NAME": "us-east1", "ce-tenant-id": "acme"},
+		wantExtensions: map[string]interface{}{"region": "us-east1", "tenant": "acme"},
+	}, {
+		name:           "canonical attribute takes precedence",
+		aliases:        aliases,
+		attributes:     map[string]string{"region_name": "us-east1", "RegionTag": "us-west1", "Region": "europe-west1"},
+		wantExtensions: map[string]interface{}{"region": "europe-west1"},
+	}, {
+		name:           "aliases applied in order",
+		aliases:        aliases,
+		attributes:     map[string]string{"region_name": "us-east1", "regiontag": "us-west1"},
+		wantExtensions: map[string]interface{}{"region": "us-east1"},
+	}, {
+		name:           "without normalization",
+		attributes:     map[string]string{"regiontag": "us-west1"},
+		wantExtensions: map[string]interface{}{"regiontag": "us-west1"},
+	}, {
+		name:       "reserved canonical extension",
+		aliases:    AttributeAliases{"kind": "type"},
+		attributes: map[string]string{"kind": "value"},
+		wantErr:    true,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var opts []ConverterOption
+			if test.aliases != nil {
+				opts = append(opts, WithAttributeNormalization(test.aliases))
+			}
+			msg := &pubsub.Message{ID: "id", Attributes: test.attributes}
+			gotEvent, err := NewPubSubConverter(opts...).Convert(ctx, msg, CloudPubSub)
+			if test.wantErr {
+				if !errors.Is(err, ErrMalformedMessage) {
+					t.Fatalf("converters.Convert got error %v, want error matching %v", err, ErrMalformedMessage)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("converters.Convert got error %v", err)
+			}
+			if diff := cmp.Diff(test.wantExtensions, gotEvent.Extensions()); diff != "" {
+				t.Errorf("converters.Convert got unexpected extensions (-want +got): %s", diff)
+			}
+		})
+	}
+}
+
+func TestConvertToMessageWithAttributeNormalization(t *testing.T) {
+	ctx := WithProjectKey(context.Background(), "testproject")
+	ctx = WithTopicKey(ctx, "testtopic")
+	ctx = WithSubscriptionKey(ctx, "testsubscription")
+	aliases := AttributeAliases{
+		"tenant_id":    "tenant",
+		"ce-RegionTag": "region",
+	}
+
+	tests := []struct {
+		name       string
+		attributes map[string]string
+	}{{
+		name:       "aliased attribute",
+		attributes: map[string]string{"tenant_id": "v"},
+	}, {
+		name:       "aliased attributes with casing and prefix",
+		attributes: map[string]string{"Tenant_ID": "v", "ce-regiontag": "us-east1"},
+	}, {
+		name:       "canonical and aliased attributes",
+		attributes: map[string]string{"tenant": "v", "tenant_id": "w", "other": "value"},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			converter := NewPubSubConverter(WithAttributeNormalization(aliases))
+			msg := &pubsub.Message{ID: "id", Data: []byte("test data"), Attributes: test.attributes}
+			event, err := converter.Convert(ctx, msg, CloudPubSub)
+			if err != nil {
+				t.Fatalf("converters.Convert got error %v", err)
+			}
+			gotMessage, err := converter.ConvertToMessage(ctx, event, CloudPubSub)
+			if err != nil {
+				t.Fatalf("converters.ConvertToMessage got error %v", err)
+			}
+			if diff := cmp.Diff(test.attributes, gotMessage.Attributes); diff != "" {
+				t.Errorf("converters.ConvertToMessage got unexpected attributes (-want +got): %s", diff)
+			}
+		})
+	}
+}
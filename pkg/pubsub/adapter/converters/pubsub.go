@@ -17,19 +17,103 @@ limitations under the License.
 package converters
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
 
 	"cloud.google.com/go/pubsub"
 	cev2 "github.com/cloudevents/sdk-go/v2"
+	"github.com/cloudevents/sdk-go/v2/event"
+	"github.com/cloudevents/sdk-go/v2/types"
+	"go.uber.org/zap"
+
+	"github.com/google/knative-gcp/pkg/logging"
 	. "github.com/google/knative-gcp/pkg/pubsub/adapter/context"
 	schemasv1 "github.com/google/knative-gcp/pkg/schemas/v1"
 )
 
+const (
+	// OrderingKeyExtension is the CloudEvent extension which holds the ordering key
+	// of the pubsub message.
+	OrderingKeyExtension = "orderingkey"
+
+	// DeadLetterTopicExtension is the CloudEvent extension which holds the dead
+	// letter topic of the subscription the pubsub message was received from,
+	// if it has one.
+	DeadLetterTopicExtension = "deadlettertopic"
+
+	// attributeExtensionPrefix is the prefix of the pubsub message attributes
+	// which the CloudEvents Pub/Sub binding uses for CloudEvent attributes.
+	attributeExtensionPrefix = "ce-"
+)
+
+// reservedAttributeExtensions are the names which message attributes cannot
+// be promoted to, since they are CloudEvent context attributes or extensions
+// set by the converter.
+var reservedAttributeExtensions = map[string]bool{
+	"id":                     true,
+	"source":                 true,
+	"specversion":            true,
+	"type":                   true,
+	"datacontenttype":        true,
+	"dataschema":             true,
+	"subject":                true,
+	"time":                   true,
+	"data":                   true,
+	"data_base64":            true,
+	OrderingKeyExtension:     true,
+	DeadLetterTopicExtension: true,
+	DeliveryAttemptExtension: true,
+}
+
+// attributeExtensionName returns the name of the CloudEvent extension which a
+// pubsub message attribute is promoted to. Names are lowercased, and stripped
+// of the 'ce-' prefix. The attribute is not promoted if the resulting name is
+// not a valid extension name, or if it is reserved for a CloudEvent context
+// attribute such as 'type' or 'source', or for an extension set by the
+// converter.
+func attributeExtensionName(attribute string) (string, bool) {
+	name := strings.TrimPrefix(strings.ToLower(attribute), attributeExtensionPrefix)
+	if !event.IsAlphaNumeric(name) || reservedAttributeExtensions[name] {
+		return "", false
+	}
+	return name, true
+}
+
 func convertCloudPubSub(ctx context.Context, msg *pubsub.Message) (*cev2.Event, error) {
-	event := cev2.NewEvent(cev2.VersionV1)
-	event.SetID(msg.ID)
-	event.SetTime(msg.PublishTime)
+	c, err := newCloudPubSubConversion(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return c.convert(ctx, msg)
+}
+
+func newCloudPubSubBatchConverter(ctx context.Context) (converterFn, error) {
+	c, err := newCloudPubSubConversion(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return c.convert, nil
+}
+
+// cloudPubSubConversion holds the state which the conversions of the messages
+// of a subscription share.
+type cloudPubSubConversion struct {
+	subscription    string
+	deadLetterTopic string
+	source          types.URIRef
+	dataSchema      types.URI
+
+	// buf and encoder encode the push messages. They are reused across the
+	// conversions, and hence not safe for concurrent use.
+	buf     bytes.Buffer
+	encoder *json.Encoder
+}
 
+func newCloudPubSubConversion(ctx context.Context) (*cloudPubSubConversion, error) {
 	project, err := GetProjectKey(ctx)
 	if err != nil {
 		return nil, err
@@ -38,17 +122,60 @@ func convertCloudPubSub(ctx context.Context, msg *pubsub.Message) (*cev2.Event,
 	if err != nil {
 		return nil, err
 	}
-
-	event.SetSource(schemasv1.CloudPubSubEventSource(project, topic))
-	event.SetType(schemasv1.CloudPubSubMessagePublishedEventType)
-	event.SetDataSchema(schemasv1.CloudPubSubEventDataSchema)
 	subscription, err := GetSubscriptionKey(ctx)
 	if err != nil {
 		return nil, err
 	}
+	source := types.ParseURIRef(schemasv1.CloudPubSubEventSource(project, topic))
+	if source == nil {
+		return nil, fmt.Errorf("invalid source for project %q and topic %q", project, topic)
+	}
+	dataSchema := types.ParseURI(schemasv1.CloudPubSubEventDataSchema)
+	if dataSchema == nil {
+		return nil, fmt.Errorf("invalid data schema %q", schemasv1.CloudPubSubEventDataSchema)
+	}
+
+	deadLetterTopic, _ := GetDeadLetterKey(ctx)
+
+	c := &cloudPubSubConversion{
+		subscription:    subscription,
+		deadLetterTopic: deadLetterTopic,
+		source:          *source,
+		dataSchema:      *dataSchema,
+	}
+	c.encoder = json.NewEncoder(&c.buf)
+	return c, nil
+}
+
+func (c *cloudPubSubConversion) convert(ctx context.Context, msg *pubsub.Message) (*cev2.Event, error) {
+	event := cev2.NewEvent(cev2.VersionV1)
+	event.SetID(msg.ID)
+	event.SetTime(msg.PublishTime)
+	event.SetType(schemasv1.CloudPubSubMessagePublishedEventType)
+
+	ec := event.Context.(*cev2.EventContextV1)
+	ec.Source = c.source
+	dataSchema := c.dataSchema
+	ec.DataSchema = &dataSchema
+
+	// Promote the attributes which are valid extension names to extensions.
+	for k, v := range msg.Attributes {
+		name, ok := attributeExtensionName(k)
+		if !ok {
+			logging.FromContext(ctx).Debug("Skipping attribute which cannot be converted to an extension", zap.String("attribute", k))
+			continue
+		}
+		event.SetExtension(name, v)
+	}
+	if msg.OrderingKey != "" {
+		event.SetExtension(OrderingKeyExtension, msg.OrderingKey)
+	}
+	if c.deadLetterTopic != "" {
+		event.SetExtension(DeadLetterTopicExtension, c.deadLetterTopic)
+	}
 
 	pushMessage := &schemasv1.PushMessage{
-		Subscription: subscription,
+		Subscription: c.subscription,
 		Message: &schemasv1.PubSubMessage{
 			ID:          msg.ID,
 			Attributes:  msg.Attributes,
@@ -57,8 +184,77 @@ func convertCloudPubSub(ctx context.Context, msg *pubsub.Message) (*cev2.Event,
 		},
 	}
 
-	if err := event.SetData(cev2.ApplicationJSON, pushMessage); err != nil {
+	c.buf.Reset()
+	if err := c.encoder.Encode(pushMessage); err != nil {
+		return nil, err
+	}
+	// The encoder terminates the encoded push message with a newline.
+	data := make([]byte, c.buf.Len()-1)
+	copy(data, c.buf.Bytes())
+	if err := event.SetData(cev2.ApplicationJSON, data); err != nil {
 		return nil, err
 	}
+	// The data is JSON rather than opaque bytes.
+	event.DataBase64 = false
 	return &event, nil
 }
+
+// pushMessageData is the data of the events converted from pubsub messages,
+// with the message data decoded.
+type pushMessageData struct {
+	Message *struct {
+		ID          string            `json:"messageId"`
+		Data        []byte            `json:"data"`
+		Attributes  map[string]string `json:"attributes"`
+		PublishTime time.Time         `json:"publishTime"`
+	} `json:"message"`
+}
+
+func (c *PubSubConverter) convertCloudPubSubToMessage(ctx context.Context, event *cev2.Event) (*pubsub.Message, error) {
+	var data pushMessageData
+	if err := event.DataAs(&data); err != nil {
+		return nil, fmt.Errorf("decoding push message: %w", err)
+	}
+	if data.Message == nil {
+		return nil, fmt.Errorf("push message has no message")
+	}
+
+	msg := &pubsub.Message{
+		ID:          data.Message.ID,
+		Data:        data.Message.Data,
+		Attributes:  map[string]string{},
+		PublishTime: data.Message.PublishTime,
+	}
+	// The extensions which the attributes of the message were promoted or
+	// normalized to are carried by the attributes themselves.
+	promoted := make(map[string]bool, len(data.Message.Attributes))
+	for k := range data.Message.Attributes {
+		if name, ok := attributeExtensionName(k); ok {
+			promoted[name] = true
+		}
+		if canonical, ok := c.aliases.canonical(k); ok {
+			promoted[canonical] = true
+		}
+	}
+	// Extensions which were added to the event are carried as attributes,
+	// except for the ordering key, and the dead letter topic and delivery
+	// attempt which belong to the delivery rather than the message.
+	for k, v := range event.Extensions() {
+		if k == DeadLetterTopicExtension || k == DeliveryAttemptExtension || promoted[k] {
+			continue
+		}
+		s, err := types.ToString(v)
+		if err != nil {
+			return nil, fmt.Errorf("converting extension %q: %w", k, err)
+		}
+		if k == OrderingKeyExtension {
+			msg.OrderingKey = s
+			continue
+		}
+		msg.Attributes[k] = s
+	}
+	for k, v := range data.Message.Attributes {
+		msg.Attributes[k] = v
+	}
+	return msg, nil
+}
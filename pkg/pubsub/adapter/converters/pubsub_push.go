@@ -0,0 +1,150 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package converters
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"strings"
+	"time"
+
+	cev2 "github.com/cloudevents/sdk-go/v2"
+	"go.uber.org/zap"
+
+	"github.com/google/knative-gcp/pkg/logging"
+	. "github.com/google/knative-gcp/pkg/pubsub/adapter/context"
+	schemasv1 "github.com/google/knative-gcp/pkg/schemas/v1"
+)
+
+const (
+	// SubscriptionExtension is the CloudEvent extension which holds the
+	// subscription which pushed the pubsub message.
+	SubscriptionExtension = "subscription"
+
+	// MessageIDExtension is the CloudEvent extension which holds the ID of the
+	// pushed pubsub message.
+	MessageIDExtension = "messageid"
+
+	// contentTypeAttribute is the pubsub message attribute which holds the
+	// content type of the message data, as written by the CloudEvents Pub/Sub
+	// binding.
+	contentTypeAttribute = "content-type"
+)
+
+// reservedPushAttributeExtensions are the names which the attributes of pushed
+// messages cannot be promoted to, besides the reserved attribute extensions,
+// since they are set from the push envelope.
+var reservedPushAttributeExtensions = map[string]bool{
+	SubscriptionExtension: true,
+	MessageIDExtension:    true,
+}
+
+// pushEnvelope is the JSON body of the requests of Pub/Sub push
+// subscriptions. The data of the message is base64-encoded.
+type pushEnvelope struct {
+	Subscription    string `json:"subscription"`
+	DeliveryAttempt *int   `json:"deliveryAttempt"`
+	Message         *struct {
+		ID          string            `json:"messageId"`
+		Data        []byte            `json:"data"`
+		Attributes  map[string]string `json:"attributes"`
+		PublishTime time.Time         `json:"publishTime"`
+		OrderingKey string            `json:"orderingKey"`
+	} `json:"message"`
+}
+
+// PubSubPush converts the body of a Pub/Sub push request to a CloudEvent. The
+// data of the message is decoded from base64, and its content type is taken
+// from its 'Content-Type' attribute, defaulting to opaque bytes. The other
+// attributes are promoted to extensions the same way as the CloudPubSub
+// converter does, and the subscription and the ID of the message are carried
+// in the subscription and messageid extensions, which no attribute is promoted
+// to. The returned errors match
+// ErrMalformedMessage.
+func PubSubPush(ctx context.Context, body []byte) (*cev2.Event, error) {
+	event, err := convertPubSubPush(ctx, body)
+	if err != nil {
+		return nil, classifyError(err)
+	}
+	return event, nil
+}
+
+func convertPubSubPush(ctx context.Context, body []byte) (*cev2.Event, error) {
+	var envelope pushEnvelope
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		return nil, fmt.Errorf("%w: decoding push envelope: %v", ErrMalformedMessage, err)
+	}
+	if envelope.Message == nil {
+		return nil, fmt.Errorf("%w: push envelope has no message", ErrMalformedMessage)
+	}
+	if envelope.Message.ID == "" {
+		return nil, fmt.Errorf("%w: pushed message has no messageId", ErrMalformedMessage)
+	}
+	project, err := GetProjectKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	topic, err := GetTopicKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := envelope.Message
+	event := cev2.NewEvent(cev2.VersionV1)
+	event.SetID(msg.ID)
+	event.SetTime(msg.PublishTime)
+	event.SetSource(schemasv1.CloudPubSubEventSource(project, topic))
+	event.SetType(schemasv1.CloudPubSubMessagePublishedEventType)
+
+	contentType := "application/octet-stream"
+	for k, v := range msg.Attributes {
+		if strings.ToLower(k) == contentTypeAttribute {
+			contentType = v
+			continue
+		}
+		name, ok := attributeExtensionName(k)
+		if !ok || reservedPushAttributeExtensions[name] {
+			logging.FromContext(ctx).Debug("Skipping attribute which cannot be converted to an extension", zap.String("attribute", k))
+			continue
+		}
+		event.SetExtension(name, v)
+	}
+	if msg.OrderingKey != "" {
+		event.SetExtension(OrderingKeyExtension, msg.OrderingKey)
+	}
+	if envelope.Subscription != "" {
+		event.SetExtension(SubscriptionExtension, envelope.Subscription)
+	}
+	event.SetExtension(MessageIDExtension, msg.ID)
+	if envelope.DeliveryAttempt != nil {
+		event.SetExtension(DeliveryAttemptExtension, *envelope.DeliveryAttempt)
+	}
+
+	if err := event.SetData(contentType, msg.Data); err != nil {
+		return nil, err
+	}
+	// JSON data is carried as is rather than as opaque bytes.
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil && (mediaType == cev2.ApplicationJSON || strings.HasSuffix(mediaType, "+json")) {
+		if !json.Valid(msg.Data) {
+			return nil, fmt.Errorf("%w: pushed message data is not valid JSON", ErrMalformedMessage)
+		}
+		event.DataBase64 = false
+	}
+	return &event, nil
+}
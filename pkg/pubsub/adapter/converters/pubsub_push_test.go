@@ -0,0 +1,169 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package converters
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	cev2 "github.com/cloudevents/sdk-go/v2"
+	"github.com/google/go-cmp/cmp"
+	. "github.com/google/knative-gcp/pkg/pubsub/adapter/context"
+	schemasv1 "github.com/google/knative-gcp/pkg/schemas/v1"
+)
+
+const testPushSubscription = "projects/testproject/subscriptions/testsubscription"
+
+func TestConvertPubSubPush(t *testing.T) {
+	publishTime := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name        string
+		body        string
+		wantEventFn func() *cev2.Event
+	}{{
+		name: "binary data",
+		// "dGVzdCBkYXRh" is "test data" encoded in base64.
+		body: `{
+			"message": {
+				"attributes": {"attribute1": "value1", "Invalid-Attrib#$^": "value2"},
+				"data": "dGVzdCBkYXRh",
+				"messageId": "id",
+				"publishTime": "2021-03-01T12:00:00Z"
+			},
+			"subscription": "` + testPushSubscription + `"
+		}`,
+		wantEventFn: func() *cev2.Event {
+			e := pubSubPush(publishTime, map[string]interface{}{
+				"attribute1": "value1",
+			})
+			e.SetData("application/octet-stream", []byte("test data"))
+			return e
+		},
+	}, {
+		name: "JSON data",
+		// "eyJrZXkiOiJ2YWx1ZSJ9" is `{"key":"value"}` encoded in base64.
+		body: `{
+			"deliveryAttempt": 2,
+			"message": {
+				"attributes": {"Content-Type": "application/json"},
+				"data": "eyJrZXkiOiJ2YWx1ZSJ9",
+				"messageId": "id",
+				"orderingKey": "key",
+				"publishTime": "2021-03-01T12:00:00Z"
+			},
+			"subscription": "` + testPushSubscription + `"
+		}`,
+		wantEventFn: func() *cev2.Event {
+			e := pubSubPush(publishTime, map[string]interface{}{
+				OrderingKeyExtension:     "key",
+				DeliveryAttemptExtension: 2,
+			})
+			e.SetData(cev2.ApplicationJSON, []byte(`{"key":"value"}`))
+			e.DataBase64 = false
+			return e
+		},
+	}, {
+		name: "attributes named as envelope extensions",
+		body: `{
+			"message": {
+				"attributes": {"attribute1": "value1", "Subscription": "projects/other/subscriptions/spoofed", "ce-messageid": "spoofed"},
+				"data": "dGVzdCBkYXRh",
+				"messageId": "id",
+				"publishTime": "2021-03-01T12:00:00Z"
+			}
+		}`,
+		wantEventFn: func() *cev2.Event {
+			e := pubSubPush(publishTime, map[string]interface{}{
+				"attribute1": "value1",
+			})
+			// The envelope has no subscription.
+			e.SetExtension(SubscriptionExtension, nil)
+			e.SetData("application/octet-stream", []byte("test data"))
+			return e
+		},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := WithProjectKey(context.Background(), "testproject")
+			ctx = WithTopicKey(ctx, "testtopic")
+
+			gotEvent, err := PubSubPush(ctx, []byte(test.body))
+			if err != nil {
+				t.Fatalf("converters.PubSubPush got error %v", err)
+			}
+			if diff := cmp.Diff(test.wantEventFn(), gotEvent); diff != "" {
+				t.Errorf("converters.PubSubPush got unexpected cloudevents.Event (-want +got) %s", diff)
+			}
+		})
+	}
+}
+
+func TestConvertPubSubPushErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+	}{{
+		name: "not JSON",
+		body: "not a push envelope",
+	}, {
+		name: "no message",
+		body: `{"subscription": "` + testPushSubscription + `"}`,
+	}, {
+		name: "no message ID",
+		body: `{"message": {"data": "dGVzdCBkYXRh"}}`,
+	}, {
+		name: "invalid base64 data",
+		body: `{"message": {"data": "not base64!", "messageId": "id"}}`,
+	}, {
+		name: "invalid JSON data",
+		body: `{"message": {"attributes": {"Content-Type": "application/json"}, "data": "dGVzdCBkYXRh", "messageId": "id"}}`,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := WithProjectKey(context.Background(), "testproject")
+			ctx = WithTopicKey(ctx, "testtopic")
+
+			if _, err := PubSubPush(ctx, []byte(test.body)); !errors.Is(err, ErrMalformedMessage) {
+				t.Errorf("converters.PubSubPush got error %v, want error matching %v", err, ErrMalformedMessage)
+			}
+		})
+	}
+
+	// The push messages also need the project and topic of their source.
+	if _, err := PubSubPush(context.Background(), []byte(`{"message": {"messageId": "id"}}`)); !errors.Is(err, ErrMalformedMessage) {
+		t.Errorf("converters.PubSubPush without context got error %v, want error matching %v", err, ErrMalformedMessage)
+	}
+}
+
+func pubSubPush(publishTime time.Time, extensions map[string]interface{}) *cev2.Event {
+	e := cev2.NewEvent(cev2.VersionV1)
+	e.SetID("id")
+	e.SetTime(publishTime)
+	e.SetSource(schemasv1.CloudPubSubEventSource("testproject", "testtopic"))
+	e.SetType(schemasv1.CloudPubSubMessagePublishedEventType)
+	e.SetExtension(SubscriptionExtension, testPushSubscription)
+	e.SetExtension(MessageIDExtension, "id")
+	for k, v := range extensions {
+		e.SetExtension(k, v)
+	}
+	return &e
+}
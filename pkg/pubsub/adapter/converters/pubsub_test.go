@@ -21,10 +21,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"testing"
+	"time"
 
 	"cloud.google.com/go/pubsub"
 	cev2 "github.com/cloudevents/sdk-go/v2"
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
 	. "github.com/google/knative-gcp/pkg/pubsub/adapter/context"
 	schemasv1 "github.com/google/knative-gcp/pkg/schemas/v1"
 )
@@ -48,10 +50,49 @@ func TestConvertCloudPubSub(t *testing.T) {
 			},
 		},
 		wantEventFn: func() *cev2.Event {
-			return pubSubCloudEvent(map[string]string{
+			e := pubSubCloudEvent(map[string]string{
 				"attribute1":        "value1",
 				"Invalid-Attrib#$^": "value2",
 			}, "\"InRlc3QgZGF0YSI=\"")
+			e.SetExtension("attribute1", "value1")
+			return e
+		},
+	}, {
+		name: "attributes with ce prefix",
+		message: &pubsub.Message{
+			ID:   "id",
+			Data: []byte("\"test data\""), // Data passed in quotes for it to be marshalled properly
+			Attributes: map[string]string{
+				"ce-Attribute1": "value1",
+				"Attribute2":    "value2",
+			},
+		},
+		wantEventFn: func() *cev2.Event {
+			e := pubSubCloudEvent(map[string]string{
+				"ce-Attribute1": "value1",
+				"Attribute2":    "value2",
+			}, "\"InRlc3QgZGF0YSI=\"")
+			e.SetExtension("attribute1", "value1")
+			e.SetExtension("attribute2", "value2")
+			return e
+		},
+	}, {
+		name: "reserved attributes",
+		message: &pubsub.Message{
+			ID:   "id",
+			Data: []byte("\"test data\""), // Data passed in quotes for it to be marshalled properly
+			Attributes: map[string]string{
+				"type":               "value1",
+				"ce-source":          "value2",
+				OrderingKeyExtension: "value3",
+			},
+		},
+		wantEventFn: func() *cev2.Event {
+			return pubSubCloudEvent(map[string]string{
+				"type":               "value1",
+				"ce-source":          "value2",
+				OrderingKeyExtension: "value3",
+			}, "\"InRlc3QgZGF0YSI=\"")
 		},
 	}, {
 		name: "no attributes",
@@ -63,6 +104,19 @@ func TestConvertCloudPubSub(t *testing.T) {
 		wantEventFn: func() *cev2.Event {
 			return pubSubCloudEvent(nil, "\"InRlc3QgZGF0YSI=\"")
 		},
+	}, {
+		name: "ordering key",
+		message: &pubsub.Message{
+			ID:          "id",
+			Data:        []byte("\"test data\""), // Data passed in quotes for it to be marshalled properly
+			Attributes:  map[string]string{},
+			OrderingKey: "key",
+		},
+		wantEventFn: func() *cev2.Event {
+			e := pubSubCloudEvent(nil, "\"InRlc3QgZGF0YSI=\"")
+			e.SetExtension(OrderingKeyExtension, "key")
+			return e
+		},
 	}, {
 		name: "invalid context",
 		message: &pubsub.Message{
@@ -98,6 +152,95 @@ func TestConvertCloudPubSub(t *testing.T) {
 	}
 }
 
+func TestConvertCloudPubSubOrderingKey(t *testing.T) {
+	tests := []struct {
+		name            string
+		orderingKey     string
+		wantOrderingKey bool
+	}{{
+		name:            "with ordering key",
+		orderingKey:     "key",
+		wantOrderingKey: true,
+	}, {
+		name: "without ordering key",
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := WithProjectKey(context.Background(), "testproject")
+			ctx = WithTopicKey(ctx, "testtopic")
+			ctx = WithSubscriptionKey(ctx, "testsubscription")
+			message := &pubsub.Message{
+				ID:          "id",
+				Data:        []byte("\"test data\""),
+				OrderingKey: test.orderingKey,
+			}
+
+			gotEvent, err := NewPubSubConverter().Convert(ctx, message, CloudPubSub)
+			if err != nil {
+				t.Fatalf("converters.convertPubsub got error %v", err)
+			}
+			gotOrderingKey, ok := gotEvent.Extensions()[OrderingKeyExtension]
+			if ok != test.wantOrderingKey {
+				t.Fatalf("converters.convertPubsub got %s extension=%v, want=%v", OrderingKeyExtension, ok, test.wantOrderingKey)
+			}
+			if ok && gotOrderingKey != test.orderingKey {
+				t.Errorf("converters.convertPubsub got %s extension %v, want %s", OrderingKeyExtension, gotOrderingKey, test.orderingKey)
+			}
+		})
+	}
+}
+
+func TestConvertCloudPubSubDeadLetterTopic(t *testing.T) {
+	tests := []struct {
+		name                string
+		deadLetterTopic     string
+		wantDeadLetterTopic bool
+	}{{
+		name:                "with dead letter topic",
+		deadLetterTopic:     "projects/testproject/topics/deadletter",
+		wantDeadLetterTopic: true,
+	}, {
+		name: "without dead letter topic",
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := WithProjectKey(context.Background(), "testproject")
+			ctx = WithTopicKey(ctx, "testtopic")
+			ctx = WithSubscriptionKey(ctx, "testsubscription")
+			if test.deadLetterTopic != "" {
+				ctx = WithDeadLetterKey(ctx, test.deadLetterTopic)
+			}
+			message := &pubsub.Message{
+				ID:   "id",
+				Data: []byte("\"test data\""),
+			}
+
+			gotEvent, err := NewPubSubConverter().Convert(ctx, message, CloudPubSub)
+			if err != nil {
+				t.Fatalf("converters.convertPubsub got error %v", err)
+			}
+			gotDeadLetterTopic, ok := gotEvent.Extensions()[DeadLetterTopicExtension]
+			if ok != test.wantDeadLetterTopic {
+				t.Fatalf("converters.convertPubsub got %s extension=%v, want=%v", DeadLetterTopicExtension, ok, test.wantDeadLetterTopic)
+			}
+			if ok && gotDeadLetterTopic != test.deadLetterTopic {
+				t.Errorf("converters.convertPubsub got %s extension %v, want %s", DeadLetterTopicExtension, gotDeadLetterTopic, test.deadLetterTopic)
+			}
+
+			// The dead letter topic is not carried back as an attribute.
+			gotMessage, err := NewPubSubConverter().ConvertToMessage(ctx, gotEvent, CloudPubSub)
+			if err != nil {
+				t.Fatalf("converters.ConvertToMessage got error %v", err)
+			}
+			if _, ok := gotMessage.Attributes[DeadLetterTopicExtension]; ok {
+				t.Errorf("converters.ConvertToMessage got %s attribute", DeadLetterTopicExtension)
+			}
+		})
+	}
+}
+
 func pubSubCloudEvent(attributes map[string]string, data string) *cev2.Event {
 	e := cev2.NewEvent(cev2.VersionV1)
 	e.SetID("id")
@@ -114,3 +257,130 @@ func pubSubCloudEvent(attributes map[string]string, data string) *cev2.Event {
 	e.DataBase64 = false
 	return &e
 }
+
+func TestConvertCloudPubSubToMessage(t *testing.T) {
+	publishTime := time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name    string
+		message *pubsub.Message
+	}{{
+		name: "attributes and data",
+		message: &pubsub.Message{
+			ID:          "id",
+			Data:        []byte("test data"),
+			PublishTime: publishTime,
+			Attributes: map[string]string{
+				"attribute1":        "value1",
+				"Invalid-Attrib#$^": "value2",
+			},
+		},
+	}, {
+		name: "mixed case attributes",
+		message: &pubsub.Message{
+			ID:          "id",
+			Data:        []byte("test data"),
+			PublishTime: publishTime,
+			Attributes: map[string]string{
+				"Attribute2": "v",
+			},
+		},
+	}, {
+		name: "ce- prefixed attributes",
+		message: &pubsub.Message{
+			ID:          "id",
+			Data:        []byte("test data"),
+			PublishTime: publishTime,
+			Attributes: map[string]string{
+				"Attribute2": "v",
+				"ce-foo":     "x",
+			},
+		},
+	}, {
+		name: "no attributes",
+		message: &pubsub.Message{
+			ID:          "id",
+			Data:        []byte("test data"),
+			PublishTime: publishTime,
+		},
+	}, {
+		name: "ordering key",
+		message: &pubsub.Message{
+			ID:          "id",
+			Data:        []byte("test data"),
+			PublishTime: publishTime,
+			OrderingKey: "key",
+			Attributes: map[string]string{
+				"attribute1": "value1",
+			},
+		},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := WithProjectKey(context.Background(), "testproject")
+			ctx = WithTopicKey(ctx, "testtopic")
+			ctx = WithSubscriptionKey(ctx, "testsubscription")
+
+			converter := NewPubSubConverter()
+			event, err := converter.Convert(ctx, test.message, CloudPubSub)
+			if err != nil {
+				t.Fatalf("converters.convertPubsub got error %v", err)
+			}
+			gotMessage, err := converter.ConvertToMessage(ctx, event, CloudPubSub)
+			if err != nil {
+				t.Fatalf("converters.convertPubsubToMessage got error %v", err)
+			}
+			if diff := cmp.Diff(test.message, gotMessage, cmpopts.EquateEmpty(), cmpopts.IgnoreUnexported(pubsub.Message{})); diff != "" {
+				t.Errorf("converters.convertPubsubToMessage got unexpected pubsub.Message (-want +got) %s", diff)
+			}
+		})
+	}
+}
+
+func TestConvertCloudPubSubToMessageExtensions(t *testing.T) {
+	event := pubSubCloudEvent(map[string]string{"attribute1": "value1"}, "\"InRlc3QgZGF0YSI=\"")
+	event.SetExtension("extension1", "value2")
+	event.SetExtension(OrderingKeyExtension, "key")
+
+	gotMessage, err := NewPubSubConverter().ConvertToMessage(context.Background(), event, CloudPubSub)
+	if err != nil {
+		t.Fatalf("converters.convertPubsubToMessage got error %v", err)
+	}
+	wantMessage := &pubsub.Message{
+		ID:          "id",
+		Data:        []byte("\"test data\""),
+		OrderingKey: "key",
+		Attributes: map[string]string{
+			"attribute1": "value1",
+			"extension1": "value2",
+		},
+	}
+	if diff := cmp.Diff(wantMessage, gotMessage, cmpopts.IgnoreUnexported(pubsub.Message{})); diff != "" {
+		t.Errorf("converters.convertPubsubToMessage got unexpected pubsub.Message (-want +got) %s", diff)
+	}
+}
+
+func TestConvertToMessageErrors(t *testing.T) {
+	invalidData := cev2.NewEvent(cev2.VersionV1)
+	invalidData.SetID("id")
+	invalidData.SetSource("source")
+	invalidData.SetType(schemasv1.CloudPubSubMessagePublishedEventType)
+	invalidData.SetData(cev2.ApplicationJSON, []byte(`{"subscription":"testsubscription"}`))
+
+	tests := []struct {
+		name  string
+		event *cev2.Event
+	}{{
+		name: "nil event",
+	}, {
+		name:  "push message without message",
+		event: &invalidData,
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := NewPubSubConverter().ConvertToMessage(context.Background(), test.event, CloudPubSub); err == nil {
+				t.Error("converters.ConvertToMessage got no error, want error")
+			}
+		})
+	}
+}
@@ -39,6 +39,7 @@ func convertCloudScheduler(ctx context.Context, msg *pubsub.Message) (*cev2.Even
 		return nil, errors.New("received event did not have jobName")
 	}
 	event.SetSource(schemasv1.CloudSchedulerEventSource(jobName))
+	event.SetSubject(schemasv1.CloudSchedulerEventSubject(jobName))
 
 	if err := event.SetData(cev2.ApplicationJSON, &schemasv1.SchedulerJobData{CustomData: msg.Data}); err != nil {
 		return nil, err
@@ -50,7 +50,7 @@ func TestConvertCloudSchedulerSource(t *testing.T) {
 			},
 		},
 		wantEventFn: func() *cev2.Event {
-			return schedulerCloudEvent("//cloudscheduler.googleapis.com/projects/knative-gcp-test/locations/us-east4/jobs/cre-scheduler-test")
+			return schedulerCloudEvent("//cloudscheduler.googleapis.com/projects/knative-gcp-test/locations/us-east4/jobs/cre-scheduler-test", "jobs/cre-scheduler-test")
 		},
 	}, {
 		name: "missing jobName attribute",
@@ -102,12 +102,13 @@ func TestConvertCloudSchedulerSource(t *testing.T) {
 	}
 }
 
-func schedulerCloudEvent(source string) *cev2.Event {
+func schedulerCloudEvent(source, subject string) *cev2.Event {
 	e := cev2.NewEvent(cev2.VersionV1)
 	e.SetID("id")
 	e.SetData(cev2.ApplicationJSON, &schemasv1.SchedulerJobData{CustomData: []byte("test data")})
 	e.SetType(schemasv1.CloudSchedulerJobExecutedEventType)
 	e.SetDataSchema(schemasv1.CloudSchedulerEventDataSchema)
 	e.SetSource(source)
+	e.SetSubject(subject)
 	return &e
 }
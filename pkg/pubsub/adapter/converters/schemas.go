@@ -0,0 +1,72 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package converters
+
+import (
+	"fmt"
+
+	cev2 "github.com/cloudevents/sdk-go/v2"
+	schemasv1 "github.com/google/knative-gcp/pkg/schemas/v1"
+)
+
+// SchemaValidator validates the data of an event against a schema.
+type SchemaValidator func(data []byte) error
+
+// SchemaRegistry maps the data schemas of the converted events to the
+// validators of their data.
+type SchemaRegistry map[string]SchemaValidator
+
+// DefaultSchemaRegistry returns the registry of the schemas of the events
+// whose data the converters pass through from the pubsub message payload.
+func DefaultSchemaRegistry() SchemaRegistry {
+	return SchemaRegistry{
+		schemasv1.CloudStorageEventDataSchema:   schemasv1.ValidateCloudStorageEventData,
+		schemasv1.CloudAuditLogsEventDataSchema: schemasv1.ValidateCloudAuditLogsEventData,
+	}
+}
+
+// SchemaValidationError is returned by Convert when the data of the converted
+// event does not conform to its schema. It matches ErrMalformedMessage.
+type SchemaValidationError struct {
+	DataSchema string
+	Err        error
+}
+
+func (e *SchemaValidationError) Error() string {
+	return fmt.Sprintf("event data does not conform to schema %s: %v", e.DataSchema, e.Err)
+}
+
+func (e *SchemaValidationError) Unwrap() error {
+	return e.Err
+}
+
+func (e *SchemaValidationError) Is(target error) bool {
+	return target == ErrMalformedMessage
+}
+
+// validate validates the data of an event against the schema implied by its
+// data schema attribute. Events whose schema is not registered are valid.
+func (r SchemaRegistry) validate(event *cev2.Event) error {
+	validator, ok := r[event.DataSchema()]
+	if !ok {
+		return nil
+	}
+	if err := validator(event.Data()); err != nil {
+		return &SchemaValidationError{DataSchema: event.DataSchema(), Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package converters
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"cloud.google.com/go/pubsub"
+	schemasv1 "github.com/google/knative-gcp/pkg/schemas/v1"
+)
+
+func TestConvertWithSchemaValidation(t *testing.T) {
+	storageMessage := func(data string) *pubsub.Message {
+		return &pubsub.Message{
+			ID:   "id",
+			Data: []byte(data),
+			Attributes: map[string]string{
+				"bucketId":  bucket,
+				"eventType": eventType,
+				"objectId":  objectId,
+			},
+		}
+	}
+
+	tests := []struct {
+		name    string
+		opts    []ConverterOption
+		message *pubsub.Message
+		wantErr bool
+	}{{
+		name:    "conforming payload",
+		opts:    []ConverterOption{WithSchemaValidation(DefaultSchemaRegistry())},
+		message: storageMessage(`{"bucket":"my-bucket","name":"myfile.jpg"}`),
+	}, {
+		name:    "non-conforming payload",
+		opts:    []ConverterOption{WithSchemaValidation(DefaultSchemaRegistry())},
+		message: storageMessage(`{"kind":"storage#object"}`),
+		wantErr: true,
+	}, {
+		name:    "non-conforming payload without validation",
+		message: storageMessage(`{"kind":"storage#object"}`),
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := NewPubSubConverter(test.opts...).Convert(context.Background(), test.message, CloudStorage)
+			if !test.wantErr {
+				if err != nil {
+					t.Fatalf("converters.Convert got error %v", err)
+				}
+				return
+			}
+			var validationErr *SchemaValidationError
+			if !errors.As(err, &validationErr) {
+				t.Fatalf("converters.Convert got error %v, want SchemaValidationError", err)
+			}
+			if validationErr.DataSchema != schemasv1.CloudStorageEventDataSchema {
+				t.Errorf("DataSchema %q != %q", validationErr.DataSchema, schemasv1.CloudStorageEventDataSchema)
+			}
+			if !errors.Is(err, ErrMalformedMessage) {
+				t.Errorf("converters.Convert got error %v, want error matching %v", err, ErrMalformedMessage)
+			}
+		})
+	}
+}
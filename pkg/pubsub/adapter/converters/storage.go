@@ -26,16 +26,27 @@ import (
 	schemasv1 "github.com/google/knative-gcp/pkg/schemas/v1"
 )
 
-var (
-	// Mapping of GCS eventTypes to CloudEvent types.
-	storageEventTypes = map[string]string{
-		"OBJECT_FINALIZE":        schemasv1.CloudStorageObjectFinalizedEventType,
-		"OBJECT_ARCHIVE":         schemasv1.CloudStorageObjectArchivedEventType,
-		"OBJECT_DELETE":          schemasv1.CloudStorageObjectDeletedEventType,
-		"OBJECT_METADATA_UPDATE": schemasv1.CloudStorageObjectMetadataUpdatedEventType,
-	}
+const (
+	// ObjectGenerationExtension is the CloudEvent extension which holds the
+	// generation of the object a GCS notification is about.
+	ObjectGenerationExtension = "objectgeneration"
 )
 
+// UnknownStorageEventTypeError is returned when a GCS notification carries an
+// eventType which has no CloudEvent type mapping.
+type UnknownStorageEventTypeError struct {
+	EventType string
+}
+
+func (e *UnknownStorageEventTypeError) Error() string {
+	return fmt.Sprintf("unknown event type %s", e.EventType)
+}
+
+// Is makes UnknownStorageEventTypeError match ErrUnsupportedConverter.
+func (e *UnknownStorageEventTypeError) Is(target error) bool {
+	return target == ErrUnsupportedConverter
+}
+
 func convertCloudStorage(ctx context.Context, msg *pubsub.Message) (*cev2.Event, error) {
 	event := cev2.NewEvent(cev2.VersionV1)
 	event.SetID(msg.ID)
@@ -44,7 +55,11 @@ func convertCloudStorage(ctx context.Context, msg *pubsub.Message) (*cev2.Event,
 
 	// TODO: figure out if we want to continue to add these as extensions.
 	if val, ok := msg.Attributes["bucketId"]; ok {
-		event.SetSource(schemasv1.CloudStorageEventSource(val))
+		source, err := schemasv1.CloudStorageEventSourceE(val)
+		if err != nil {
+			return nil, err
+		}
+		event.SetSource(source)
 	} else {
 		return nil, errors.New("received event did not have bucketId")
 	}
@@ -55,14 +70,18 @@ func convertCloudStorage(ctx context.Context, msg *pubsub.Message) (*cev2.Event,
 	}
 
 	if val, ok := msg.Attributes["eventType"]; ok {
-		if eventType, ok := storageEventTypes[val]; ok {
+		if eventType, ok := schemasv1.CloudStorageEventType(val); ok {
 			event.SetType(eventType)
+			event.SetExtension(schemasv1.StorageEventTypeExtension, val)
 		} else {
-			return nil, fmt.Errorf("unknown event type %s", val)
+			return nil, &UnknownStorageEventTypeError{EventType: val}
 		}
 	} else {
 		return nil, errors.New("received event did not have eventType")
 	}
+	if val, ok := msg.Attributes["objectGeneration"]; ok {
+		event.SetExtension(ObjectGenerationExtension, val)
+	}
 
 	if err := event.SetData(cev2.ApplicationJSON, msg.Data); err != nil {
 		return nil, err
@@ -18,6 +18,7 @@ package converters
 
 import (
 	"context"
+	"errors"
 	"testing"
 	"time"
 
@@ -26,9 +27,10 @@ import (
 )
 
 const (
-	bucket    = "my-bucket"
-	objectId  = "myfile.jpg"
-	eventType = "OBJECT_FINALIZE"
+	bucket           = "my-bucket"
+	objectId         = "myfile.jpg"
+	objectGeneration = "1588778055917163"
+	eventType        = "OBJECT_FINALIZE"
 )
 
 var (
@@ -38,9 +40,10 @@ var (
 func TestConvertCloudStorageSource(t *testing.T) {
 
 	tests := []struct {
-		name    string
-		message *pubsub.Message
-		wantErr bool
+		name                 string
+		message              *pubsub.Message
+		wantErr              bool
+		wantObjectGeneration string
 	}{{
 		name: "no attributes",
 		message: &pubsub.Message{
@@ -58,6 +61,17 @@ func TestConvertCloudStorageSource(t *testing.T) {
 			},
 		},
 		wantErr: true,
+	}, {
+		name: "invalid bucketId attribute",
+		message: &pubsub.Message{
+			Data: []byte("test data"),
+			Attributes: map[string]string{
+				"bucketId":  "My Bucket",
+				"eventType": eventType,
+				"objectId":  objectId,
+			},
+		},
+		wantErr: true,
 	}, {
 		name: "no eventType attribute",
 		message: &pubsub.Message{
@@ -101,6 +115,20 @@ func TestConvertCloudStorageSource(t *testing.T) {
 				"objectId":  objectId,
 			},
 		},
+	}, {
+		name: "valid message with objectGeneration",
+		message: &pubsub.Message{
+			ID:          "id",
+			PublishTime: storagePublishTime,
+			Data:        []byte("test data"),
+			Attributes: map[string]string{
+				"bucketId":         bucket,
+				"eventType":        eventType,
+				"objectId":         objectId,
+				"objectGeneration": objectGeneration,
+			},
+		},
+		wantObjectGeneration: objectGeneration,
 	}}
 
 	for _, test := range tests {
@@ -124,13 +152,39 @@ func TestConvertCloudStorageSource(t *testing.T) {
 				if gotEvent.Type() != schemasv1.CloudStorageObjectFinalizedEventType {
 					t.Errorf(`Type %q != %q`, gotEvent.Type(), schemasv1.CloudStorageObjectFinalizedEventType)
 				}
+				if got := gotEvent.Extensions()[schemasv1.StorageEventTypeExtension]; got != eventType {
+					t.Errorf("Extension %s %v != %q", schemasv1.StorageEventTypeExtension, got, eventType)
+				}
 				if want := schemasv1.CloudStorageEventSubject(objectId); gotEvent.Subject() != want {
 					t.Errorf("Subject %q != %q", gotEvent.Subject(), objectId)
 				}
 				if gotEvent.DataSchema() != schemasv1.CloudStorageEventDataSchema {
 					t.Errorf("DataSchema %q != %q", gotEvent.DataSchema(), schemasv1.CloudStorageEventDataSchema)
 				}
+				if got, _ := gotEvent.Extensions()[ObjectGenerationExtension].(string); got != test.wantObjectGeneration {
+					t.Errorf("Extension %s %q != %q", ObjectGenerationExtension, got, test.wantObjectGeneration)
+				}
 			}
 		})
 	}
 }
+
+func TestConvertCloudStorageUnknownEventType(t *testing.T) {
+	message := &pubsub.Message{
+		Data: []byte("test data"),
+		Attributes: map[string]string{
+			"eventType": "RANDOM_EVENT",
+			"bucketId":  bucket,
+			"objectId":  objectId,
+		},
+	}
+
+	_, err := NewPubSubConverter().Convert(context.Background(), message, CloudStorage)
+	var unknownErr *UnknownStorageEventTypeError
+	if !errors.As(err, &unknownErr) {
+		t.Fatalf("converters.convertCloudStorage got error %v, want UnknownStorageEventTypeError", err)
+	}
+	if unknownErr.EventType != "RANDOM_EVENT" {
+		t.Errorf("EventType %q != %q", unknownErr.EventType, "RANDOM_EVENT")
+	}
+}
@@ -25,6 +25,7 @@ var AdapterSet wire.ProviderSet = wire.NewSet(
 	clients.NewPubsubClient,
 	NewPubSubSubscription,
 	converters.NewPubSubConverter,
+	wire.Value([]converters.ConverterOption(nil)),
 	NewStatsReporter,
 	clients.NewHTTPClient,
 )
@@ -18,7 +18,10 @@ package v1
 
 import (
 	"crypto/md5"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"regexp"
 )
 
 const (
@@ -30,9 +33,50 @@ const (
 	ResourceNameExtension = "resourcename"
 )
 
+var (
+	// parentResourceRegexp matches the resources which audit logs belong to,
+	// e.g. projects/project-id or organizations/123.
+	parentResourceRegexp = regexp.MustCompile(`^(projects|organizations|folders|billingAccounts)/([^/]+)$`)
+	// projectRegexp matches project IDs, including domain-scoped ones, and
+	// project numbers.
+	projectRegexp = regexp.MustCompile(`^(([a-z][a-z0-9.-]*[a-z0-9]:)?[a-z][-a-z0-9]{4,28}[a-z0-9]|[0-9]+)$`)
+	// activityRegexp matches the audit log activities, e.g. data_access.
+	activityRegexp = regexp.MustCompile(`^[a-z_]+$`)
+)
+
 // CloudAuditLogsEventSource returns the Cloud Audit Logs CloudEvent source value.
 // Format e.g. //cloudaudit.googleapis.com/projects/project-id/logs/[activity|data_access]
+// It panics if the parent resource is empty. Use CloudAuditLogsEventSourceE to
+// validate parent resources and activities which are not known to be valid.
 func CloudAuditLogsEventSource(parentResource, activity string) string {
+	if parentResource == "" {
+		panic("empty Cloud Audit Logs parent resource")
+	}
+	return cloudAuditLogsEventSource(parentResource, activity)
+}
+
+// CloudAuditLogsEventSourceE returns the Cloud Audit Logs CloudEvent source
+// value, or an error if the parent resource is not of the form <type>/<id>,
+// with a valid project ID for projects, or if the activity is malformed. The
+// activity may be empty.
+func CloudAuditLogsEventSourceE(parentResource, activity string) (string, error) {
+	if parentResource == "" {
+		return "", errors.New("empty Cloud Audit Logs parent resource")
+	}
+	parts := parentResourceRegexp.FindStringSubmatch(parentResource)
+	if parts == nil {
+		return "", fmt.Errorf("invalid Cloud Audit Logs parent resource %q", parentResource)
+	}
+	if parts[1] == "projects" && !projectRegexp.MatchString(parts[2]) {
+		return "", fmt.Errorf("invalid project %q in Cloud Audit Logs parent resource", parts[2])
+	}
+	if activity != "" && !activityRegexp.MatchString(activity) {
+		return "", fmt.Errorf("invalid Cloud Audit Logs activity %q", activity)
+	}
+	return cloudAuditLogsEventSource(parentResource, activity), nil
+}
+
+func cloudAuditLogsEventSource(parentResource, activity string) string {
 	src := fmt.Sprintf("//cloudaudit.googleapis.com/%s", parentResource)
 	if activity != "" {
 		src = src + "/logs/" + activity
@@ -50,3 +94,22 @@ func CloudAuditLogsEventID(id, logName, timestamp string) string {
 func CloudAuditLogsEventSubject(serviceName, resourceName string) string {
 	return fmt.Sprintf("%s/%s", serviceName, resourceName)
 }
+
+// ValidateCloudAuditLogsEventData checks that the data of a Cloud Audit Logs
+// CloudEvent is a JSON log entry carrying a proto payload.
+func ValidateCloudAuditLogsEventData(data []byte) error {
+	var entry struct {
+		LogName      string          `json:"logName"`
+		ProtoPayload json.RawMessage `json:"protoPayload"`
+	}
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return fmt.Errorf("failed to decode log entry: %w", err)
+	}
+	if entry.LogName == "" {
+		return errors.New("log entry has no logName")
+	}
+	if len(entry.ProtoPayload) == 0 {
+		return errors.New("log entry has no protoPayload")
+	}
+	return nil
+}
@@ -28,6 +28,84 @@ func TestCloudAuditLogsEventSource(t *testing.T) {
 	}
 }
 
+func TestCloudAuditLogsEventSourceE(t *testing.T) {
+	tests := []struct {
+		name           string
+		parentResource string
+		activity       string
+		want           string
+		wantErr        bool
+	}{{
+		name:           "project",
+		parentResource: "projects/test-project",
+		activity:       "activity",
+		want:           "//cloudaudit.googleapis.com/projects/test-project/logs/activity",
+	}, {
+		name:           "domain-scoped project",
+		parentResource: "projects/example.com:test-project",
+		activity:       "data_access",
+		want:           "//cloudaudit.googleapis.com/projects/example.com:test-project/logs/data_access",
+	}, {
+		name:           "project number",
+		parentResource: "projects/123456789",
+		activity:       "activity",
+		want:           "//cloudaudit.googleapis.com/projects/123456789/logs/activity",
+	}, {
+		name:           "organization without activity",
+		parentResource: "organizations/123456789",
+		want:           "//cloudaudit.googleapis.com/organizations/123456789",
+	}, {
+		name:     "empty parent resource",
+		activity: "activity",
+		wantErr:  true,
+	}, {
+		name:           "empty project",
+		parentResource: "projects/",
+		activity:       "activity",
+		wantErr:        true,
+	}, {
+		name:           "unknown resource type",
+		parentResource: "buckets/test-bucket",
+		activity:       "activity",
+		wantErr:        true,
+	}, {
+		name:           "non-conforming project",
+		parentResource: "projects/PROJECT",
+		activity:       "activity",
+		wantErr:        true,
+	}, {
+		name:           "nested parent resource",
+		parentResource: "projects/test-project/topics/test-topic",
+		activity:       "activity",
+		wantErr:        true,
+	}, {
+		name:           "malformed activity",
+		parentResource: "projects/test-project",
+		activity:       "logs/activity",
+		wantErr:        true,
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := CloudAuditLogsEventSourceE(test.parentResource, test.activity)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("CloudAuditLogsEventSourceE got error %v, want error=%v", err, test.wantErr)
+			}
+			if got != test.want {
+				t.Errorf("CloudAuditLogsEventSourceE got=%s, want=%s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestCloudAuditLogsEventSourceEmptyParentResource(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("CloudAuditLogsEventSource did not panic on an empty parent resource")
+		}
+	}()
+	CloudAuditLogsEventSource("", "activity")
+}
+
 func TestCloudAuditLogsEventSubject(t *testing.T) {
 	want := "pubsub.googleapis.com/projects/PROJECT/topics/TOPIC"
 	got := CloudAuditLogsEventSubject("pubsub.googleapis.com", "projects/PROJECT/topics/TOPIC")
@@ -43,3 +121,33 @@ func TestCloudAuditLogsEventID(t *testing.T) {
 		t.Errorf("CloudAuditLogsEventID got=%s, want=%s", got, want)
 	}
 }
+
+func TestValidateCloudAuditLogsEventData(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr bool
+	}{{
+		name: "valid log entry",
+		data: `{"logName":"projects/PROJECT/logs/cloudaudit.googleapis.com%2Factivity","protoPayload":{"serviceName":"pubsub.googleapis.com"}}`,
+	}, {
+		name:    "not a log entry",
+		data:    `"test data"`,
+		wantErr: true,
+	}, {
+		name:    "no logName",
+		data:    `{"protoPayload":{"serviceName":"pubsub.googleapis.com"}}`,
+		wantErr: true,
+	}, {
+		name:    "no protoPayload",
+		data:    `{"logName":"projects/PROJECT/logs/cloudaudit.googleapis.com%2Factivity","textPayload":"text"}`,
+		wantErr: true,
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := ValidateCloudAuditLogsEventData([]byte(test.data)); (err != nil) != test.wantErr {
+				t.Errorf("ValidateCloudAuditLogsEventData got error %v, want error=%v", err, test.wantErr)
+			}
+		})
+	}
+}
@@ -16,7 +16,10 @@ limitations under the License.
 
 package v1
 
-import "fmt"
+import (
+	"fmt"
+	"path"
+)
 
 const (
 	CloudSchedulerJobExecutedEventType = "google.cloud.scheduler.job.v1.executed"
@@ -27,6 +30,13 @@ func CloudSchedulerEventSource(jobName string) string {
 	return fmt.Sprintf("//cloudscheduler.googleapis.com/%s", jobName)
 }
 
+// CloudSchedulerEventSubject returns the Cloud Scheduler CloudEvent subject
+// value, which identifies the job by the last segment of its name.
+// Format e.g. jobs/job-id
+func CloudSchedulerEventSubject(jobName string) string {
+	return fmt.Sprintf("jobs/%s", path.Base(jobName))
+}
+
 type SchedulerJobData struct {
 	CustomData []byte `json:"custom_data,omitempty"`
 }
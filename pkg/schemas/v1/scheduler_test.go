@@ -27,3 +27,13 @@ func TestCloudSchedulerEventSource(t *testing.T) {
 		t.Errorf("CloudSchedulerEventSource got=%s, want=%s", got, want)
 	}
 }
+
+func TestCloudSchedulerEventSubject(t *testing.T) {
+	for _, jobName := range []string{"JOB_ID", "projects/PROJECT/locations/LOCATION/jobs/JOB_ID"} {
+		want := "jobs/JOB_ID"
+		got := CloudSchedulerEventSubject(jobName)
+		if got != want {
+			t.Errorf("CloudSchedulerEventSubject(%s) got=%s, want=%s", jobName, got, want)
+		}
+	}
+}
@@ -16,7 +16,12 @@ limitations under the License.
 
 package v1
 
-import "fmt"
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+)
 
 const (
 	CloudStorageObjectFinalizedEventType       = "google.cloud.storage.object.v1.finalized"
@@ -24,12 +29,91 @@ const (
 	CloudStorageObjectDeletedEventType         = "google.cloud.storage.object.v1.deleted"
 	CloudStorageObjectMetadataUpdatedEventType = "google.cloud.storage.object.v1.metadataUpdated"
 	CloudStorageEventDataSchema                = "https://raw.githubusercontent.com/googleapis/google-cloudevents/master/proto/google/events/cloud/storage/v1/data.proto"
+
+	// The eventType attribute values of the GCS notifications.
+	CloudStorageObjectFinalizeNotificationType       = "OBJECT_FINALIZE"
+	CloudStorageObjectArchiveNotificationType        = "OBJECT_ARCHIVE"
+	CloudStorageObjectDeleteNotificationType         = "OBJECT_DELETE"
+	CloudStorageObjectMetadataUpdateNotificationType = "OBJECT_METADATA_UPDATE"
+
+	// StorageEventTypeExtension is the CloudEvent extension which holds the
+	// eventType of the GCS notification a Cloud Storage event is converted from.
+	// The events about the same object share their subject, so the extension
+	// tells them apart without relying on the CloudEvent type alone.
+	StorageEventTypeExtension = "storageeventtype"
 )
 
+// bucketNameRegexp matches the valid names of Cloud Storage buckets.
+var bucketNameRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{1,220}[a-z0-9]$`)
+
+var cloudStorageEventTypes = map[string]string{
+	CloudStorageObjectFinalizeNotificationType:       CloudStorageObjectFinalizedEventType,
+	CloudStorageObjectArchiveNotificationType:        CloudStorageObjectArchivedEventType,
+	CloudStorageObjectDeleteNotificationType:         CloudStorageObjectDeletedEventType,
+	CloudStorageObjectMetadataUpdateNotificationType: CloudStorageObjectMetadataUpdatedEventType,
+}
+
+// CloudStorageEventType returns the CloudEvent type of the events converted
+// from the GCS notifications of an eventType, if there is one.
+func CloudStorageEventType(notificationType string) (string, bool) {
+	eventType, ok := cloudStorageEventTypes[notificationType]
+	return eventType, ok
+}
+
+// CloudStorageEventSource returns the Cloud Storage CloudEvent source value.
+// It panics if the bucket is empty. Use CloudStorageEventSourceE to validate
+// buckets which are not known to be valid.
 func CloudStorageEventSource(bucket string) string {
+	if bucket == "" {
+		panic("empty Cloud Storage bucket")
+	}
+	return cloudStorageEventSource(bucket)
+}
+
+// CloudStorageEventSourceE returns the Cloud Storage CloudEvent source value,
+// or an error if the bucket is not a valid bucket name.
+func CloudStorageEventSourceE(bucket string) (string, error) {
+	if bucket == "" {
+		return "", errors.New("empty Cloud Storage bucket")
+	}
+	if !bucketNameRegexp.MatchString(bucket) {
+		return "", fmt.Errorf("invalid Cloud Storage bucket %q", bucket)
+	}
+	return cloudStorageEventSource(bucket), nil
+}
+
+func cloudStorageEventSource(bucket string) string {
 	return fmt.Sprintf("//storage.googleapis.com/projects/_/buckets/%s", bucket)
 }
 
 func CloudStorageEventSubject(object string) string {
 	return fmt.Sprintf("objects/%s", object)
 }
+
+// CloudStorageEventSubjectWithGeneration returns the Cloud Storage CloudEvent
+// subject value of a specific generation of an object, which disambiguates
+// the versions of an object in versioned buckets.
+// Format e.g. objects/object-name#1588778055917163
+func CloudStorageEventSubjectWithGeneration(object string, generation int64) string {
+	return fmt.Sprintf("%s#%d", CloudStorageEventSubject(object), generation)
+}
+
+// ValidateCloudStorageEventData checks that the data of a Cloud Storage
+// CloudEvent is a JSON object describing a storage object, i.e. that it has
+// the bucket and name of the object.
+func ValidateCloudStorageEventData(data []byte) error {
+	var object struct {
+		Bucket string `json:"bucket"`
+		Name   string `json:"name"`
+	}
+	if err := json.Unmarshal(data, &object); err != nil {
+		return fmt.Errorf("failed to decode storage object: %w", err)
+	}
+	if object.Bucket == "" {
+		return errors.New("storage object has no bucket")
+	}
+	if object.Name == "" {
+		return errors.New("storage object has no name")
+	}
+	return nil
+}
@@ -28,6 +28,70 @@ func TestCloudStorageEventSource(t *testing.T) {
 	}
 }
 
+func TestCloudStorageEventSourceE(t *testing.T) {
+	tests := []struct {
+		name    string
+		bucket  string
+		want    string
+		wantErr bool
+	}{{
+		name:   "valid bucket",
+		bucket: "my-bucket.example_1",
+		want:   "//storage.googleapis.com/projects/_/buckets/my-bucket.example_1",
+	}, {
+		name:    "empty bucket",
+		wantErr: true,
+	}, {
+		name:    "uppercase bucket",
+		bucket:  "Bucket",
+		wantErr: true,
+	}, {
+		name:    "bucket with path",
+		bucket:  "bucket/object",
+		wantErr: true,
+	}, {
+		name:    "bucket ending with dash",
+		bucket:  "bucket-",
+		wantErr: true,
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := CloudStorageEventSourceE(test.bucket)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("CloudStorageEventSourceE got error %v, want error=%v", err, test.wantErr)
+			}
+			if got != test.want {
+				t.Errorf("CloudStorageEventSourceE got=%s, want=%s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestCloudStorageEventSourceEmptyBucket(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("CloudStorageEventSource did not panic on an empty bucket")
+		}
+	}()
+	CloudStorageEventSource("")
+}
+
+func TestCloudStorageEventType(t *testing.T) {
+	for notificationType, want := range map[string]string{
+		CloudStorageObjectFinalizeNotificationType:       CloudStorageObjectFinalizedEventType,
+		CloudStorageObjectArchiveNotificationType:        CloudStorageObjectArchivedEventType,
+		CloudStorageObjectDeleteNotificationType:         CloudStorageObjectDeletedEventType,
+		CloudStorageObjectMetadataUpdateNotificationType: CloudStorageObjectMetadataUpdatedEventType,
+	} {
+		if got, ok := CloudStorageEventType(notificationType); !ok || got != want {
+			t.Errorf("CloudStorageEventType(%s) got=%s,%v, want=%s", notificationType, got, ok, want)
+		}
+	}
+	if got, ok := CloudStorageEventType("RANDOM_EVENT"); ok {
+		t.Errorf("CloudStorageEventType(RANDOM_EVENT) got=%s, want none", got)
+	}
+}
+
 func TestCloudStorageEventSubject(t *testing.T) {
 	want := "objects/obj"
 	got := CloudStorageEventSubject("obj")
@@ -35,3 +99,41 @@ func TestCloudStorageEventSubject(t *testing.T) {
 		t.Errorf("CloudStorageEventSubject got=%s, want=%s", got, want)
 	}
 }
+
+func TestCloudStorageEventSubjectWithGeneration(t *testing.T) {
+	want := "objects/obj#1588778055917163"
+	got := CloudStorageEventSubjectWithGeneration("obj", 1588778055917163)
+	if got != want {
+		t.Errorf("CloudStorageEventSubjectWithGeneration got=%s, want=%s", got, want)
+	}
+}
+
+func TestValidateCloudStorageEventData(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr bool
+	}{{
+		name: "valid object",
+		data: `{"bucket":"bucket","name":"obj","generation":"1"}`,
+	}, {
+		name:    "not an object",
+		data:    `"test data"`,
+		wantErr: true,
+	}, {
+		name:    "no bucket",
+		data:    `{"name":"obj"}`,
+		wantErr: true,
+	}, {
+		name:    "no name",
+		data:    `{"bucket":"bucket"}`,
+		wantErr: true,
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := ValidateCloudStorageEventData([]byte(test.data)); (err != nil) != test.wantErr {
+				t.Errorf("ValidateCloudStorageEventData got error %v, want error=%v", err, test.wantErr)
+			}
+		})
+	}
+}
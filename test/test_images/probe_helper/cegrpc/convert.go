@@ -0,0 +1,203 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cegrpc
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/cloudevents/sdk-go/v2/types"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// The names of the optional CloudEvent attributes, which are carried along
+// with the extensions in the attributes of the protobuf format.
+const (
+	dataContentTypeAttribute = "datacontenttype"
+	dataSchemaAttribute      = "dataschema"
+	subjectAttribute         = "subject"
+	timeAttribute            = "time"
+)
+
+// ToEvent converts a CloudEvent in the protobuf format to a CloudEvent. Events
+// carrying protobuf data are not supported.
+func ToEvent(pb *CloudEvent) (*cloudevents.Event, error) {
+	if pb == nil {
+		return nil, errors.New("missing event")
+	}
+	switch pb.GetSpecVersion() {
+	case cloudevents.VersionV1, cloudevents.VersionV03:
+	default:
+		return nil, fmt.Errorf("unsupported spec version %q", pb.GetSpecVersion())
+	}
+	event := cloudevents.NewEvent(pb.GetSpecVersion())
+	event.SetID(pb.GetId())
+	event.SetSource(pb.GetSource())
+	event.SetType(pb.GetType())
+	for name, pbValue := range pb.GetAttributes() {
+		value, err := attributeValue(pbValue)
+		if err != nil {
+			return nil, fmt.Errorf("invalid attribute %q: %w", name, err)
+		}
+		if err := setAttribute(&event, name, value); err != nil {
+			return nil, fmt.Errorf("invalid attribute %q: %w", name, err)
+		}
+	}
+	switch data := pb.GetData().(type) {
+	case nil:
+	case *CloudEvent_BinaryData:
+		event.DataEncoded = data.BinaryData
+	case *CloudEvent_TextData:
+		event.DataEncoded = []byte(data.TextData)
+	default:
+		return nil, fmt.Errorf("unsupported data %T", data)
+	}
+	if err := event.Validate(); err != nil {
+		return nil, err
+	}
+	return &event, nil
+}
+
+func attributeValue(pb *CloudEventAttributeValue) (interface{}, error) {
+	switch attr := pb.GetAttr().(type) {
+	case *CloudEventAttributeValue_CeBoolean:
+		return attr.CeBoolean, nil
+	case *CloudEventAttributeValue_CeInteger:
+		return attr.CeInteger, nil
+	case *CloudEventAttributeValue_CeString:
+		return attr.CeString, nil
+	case *CloudEventAttributeValue_CeBytes:
+		return attr.CeBytes, nil
+	case *CloudEventAttributeValue_CeUri:
+		if uri := types.ParseURI(attr.CeUri); uri != nil {
+			return *uri, nil
+		}
+		return nil, fmt.Errorf("invalid URI %q", attr.CeUri)
+	case *CloudEventAttributeValue_CeUriRef:
+		if uriRef := types.ParseURIRef(attr.CeUriRef); uriRef != nil {
+			return *uriRef, nil
+		}
+		return nil, fmt.Errorf("invalid URI reference %q", attr.CeUriRef)
+	case *CloudEventAttributeValue_CeTimestamp:
+		if err := attr.CeTimestamp.CheckValid(); err != nil {
+			return nil, err
+		}
+		return attr.CeTimestamp.AsTime(), nil
+	default:
+		return nil, errors.New("missing value")
+	}
+}
+
+func setAttribute(event *cloudevents.Event, name string, value interface{}) error {
+	switch name {
+	case dataContentTypeAttribute, dataSchemaAttribute, subjectAttribute:
+		s, err := attributeString(value)
+		if err != nil {
+			return err
+		}
+		switch name {
+		case dataContentTypeAttribute:
+			event.SetDataContentType(s)
+		case dataSchemaAttribute:
+			event.SetDataSchema(s)
+		case subjectAttribute:
+			event.SetSubject(s)
+		}
+	case timeAttribute:
+		t, err := types.ToTime(value)
+		if err != nil {
+			return err
+		}
+		event.SetTime(t)
+	default:
+		event.SetExtension(name, value)
+	}
+	return nil
+}
+
+// attributeString returns the string of an attribute value, which may be a URI
+// or URI reference.
+func attributeString(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case types.URI:
+		return v.String(), nil
+	case types.URIRef:
+		return v.String(), nil
+	default:
+		return types.ToString(value)
+	}
+}
+
+// FromEvent converts a CloudEvent to the protobuf format. The data of the
+// event, if any, is carried as binary data.
+func FromEvent(event cloudevents.Event) (*CloudEvent, error) {
+	pb := &CloudEvent{
+		Id:          event.ID(),
+		Source:      event.Source(),
+		SpecVersion: event.SpecVersion(),
+		Type:        event.Type(),
+		Attributes:  map[string]*CloudEventAttributeValue{},
+	}
+	if v := event.DataContentType(); v != "" {
+		pb.Attributes[dataContentTypeAttribute] = &CloudEventAttributeValue{Attr: &CloudEventAttributeValue_CeString{CeString: v}}
+	}
+	if v := event.DataSchema(); v != "" {
+		pb.Attributes[dataSchemaAttribute] = &CloudEventAttributeValue{Attr: &CloudEventAttributeValue_CeUri{CeUri: v}}
+	}
+	if v := event.Subject(); v != "" {
+		pb.Attributes[subjectAttribute] = &CloudEventAttributeValue{Attr: &CloudEventAttributeValue_CeString{CeString: v}}
+	}
+	if v := event.Time(); !v.IsZero() {
+		pb.Attributes[timeAttribute] = &CloudEventAttributeValue{Attr: &CloudEventAttributeValue_CeTimestamp{CeTimestamp: timestamppb.New(v)}}
+	}
+	for name, value := range event.Extensions() {
+		pbValue, err := toAttributeValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid extension %q: %w", name, err)
+		}
+		pb.Attributes[name] = pbValue
+	}
+	if data := event.Data(); data != nil {
+		pb.Data = &CloudEvent_BinaryData{BinaryData: data}
+	}
+	return pb, nil
+}
+
+func toAttributeValue(value interface{}) (*CloudEventAttributeValue, error) {
+	switch v := value.(type) {
+	case bool:
+		return &CloudEventAttributeValue{Attr: &CloudEventAttributeValue_CeBoolean{CeBoolean: v}}, nil
+	case int32:
+		return &CloudEventAttributeValue{Attr: &CloudEventAttributeValue_CeInteger{CeInteger: v}}, nil
+	case string:
+		return &CloudEventAttributeValue{Attr: &CloudEventAttributeValue_CeString{CeString: v}}, nil
+	case []byte:
+		return &CloudEventAttributeValue{Attr: &CloudEventAttributeValue_CeBytes{CeBytes: v}}, nil
+	case types.URI:
+		return &CloudEventAttributeValue{Attr: &CloudEventAttributeValue_CeUri{CeUri: v.String()}}, nil
+	case types.URIRef:
+		return &CloudEventAttributeValue{Attr: &CloudEventAttributeValue_CeUriRef{CeUriRef: v.String()}}, nil
+	case types.Timestamp:
+		return &CloudEventAttributeValue{Attr: &CloudEventAttributeValue_CeTimestamp{CeTimestamp: timestamppb.New(v.Time)}}, nil
+	case time.Time:
+		return &CloudEventAttributeValue{Attr: &CloudEventAttributeValue_CeTimestamp{CeTimestamp: timestamppb.New(v)}}, nil
+	default:
+		return nil, fmt.Errorf("unsupported value type %T", value)
+	}
+}
@@ -0,0 +1,115 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cegrpc
+
+import (
+	"testing"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/cloudevents/sdk-go/v2/types"
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+func TestEventRoundTrip(t *testing.T) {
+	event := cloudevents.NewEvent()
+	event.SetID("probe-1234567890")
+	event.SetSource("probe")
+	event.SetType("broker-e2e-delivery-probe")
+	event.SetSubject("subject")
+	event.SetDataSchema("https://example.com/schema")
+	event.SetTime(time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC))
+	event.SetExtension("targetpath", "/test-namespace")
+	event.SetExtension("retried", true)
+	event.SetExtension("attempt", 2)
+	event.SetExtension("origin", *types.ParseURIRef("/origin"))
+	if err := event.SetData(cloudevents.ApplicationJSON, map[string]string{"key": "value"}); err != nil {
+		t.Fatal("Failed to set event data:", err)
+	}
+
+	pb, err := FromEvent(event)
+	if err != nil {
+		t.Fatal("FromEvent failed:", err)
+	}
+	got, err := ToEvent(pb)
+	if err != nil {
+		t.Fatal("ToEvent failed:", err)
+	}
+	if diff := cmp.Diff(event.String(), got.String()); diff != "" {
+		t.Errorf("round tripped event (-want, +got) = %v", diff)
+	}
+}
+
+func TestToEventInvalid(t *testing.T) {
+	valid := func() *CloudEvent {
+		return &CloudEvent{
+			Id:          "probe-1234567890",
+			Source:      "probe",
+			SpecVersion: "1.0",
+			Type:        "broker-e2e-delivery-probe",
+		}
+	}
+	cases := []struct {
+		name  string
+		event *CloudEvent
+	}{{
+		name: "missing event",
+	}, {
+		name: "missing id",
+		event: func() *CloudEvent {
+			pb := valid()
+			pb.Id = ""
+			return pb
+		}(),
+	}, {
+		name: "unknown spec version",
+		event: func() *CloudEvent {
+			pb := valid()
+			pb.SpecVersion = "0.1"
+			return pb
+		}(),
+	}, {
+		name: "missing attribute value",
+		event: func() *CloudEvent {
+			pb := valid()
+			pb.Attributes = map[string]*CloudEventAttributeValue{"targetpath": {}}
+			return pb
+		}(),
+	}, {
+		name: "invalid time",
+		event: func() *CloudEvent {
+			pb := valid()
+			pb.Attributes = map[string]*CloudEventAttributeValue{"time": {Attr: &CloudEventAttributeValue_CeBoolean{CeBoolean: true}}}
+			return pb
+		}(),
+	}, {
+		name: "protobuf data",
+		event: func() *CloudEvent {
+			pb := valid()
+			pb.Data = &CloudEvent_ProtoData{ProtoData: &anypb.Any{}}
+			return pb
+		}(),
+	}}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if event, err := ToEvent(tc.event); err == nil {
+				t.Errorf("ToEvent got event %v, want error", event)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package cegrpc implements the CloudEvents protobuf format and the gRPC
+// service over which CloudEvents are accepted in that format. The protobuf
+// messages are generated from cloudevents.proto, while the gRPC service is
+// written by hand since it only has a single unary method.
+package cegrpc
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+const publishFullMethod = "/io.cloudevents.v1.CloudEventService/Publish"
+
+// CloudEventServiceServer is the server API for the CloudEventService.
+type CloudEventServiceServer interface {
+	// Publish delivers a CloudEvent. An OK status acknowledges the event.
+	Publish(context.Context, *PublishRequest) (*emptypb.Empty, error)
+}
+
+// RegisterCloudEventServiceServer registers the implementation of the
+// CloudEventService with a gRPC server.
+func RegisterCloudEventServiceServer(s *grpc.Server, srv CloudEventServiceServer) {
+	s.RegisterService(&cloudEventServiceDesc, srv)
+}
+
+func publishHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PublishRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CloudEventServiceServer).Publish(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: publishFullMethod,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CloudEventServiceServer).Publish(ctx, req.(*PublishRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var cloudEventServiceDesc = grpc.ServiceDesc{
+	ServiceName: "io.cloudevents.v1.CloudEventService",
+	HandlerType: (*CloudEventServiceServer)(nil),
+	Methods: []grpc.MethodDesc{{
+		MethodName: "Publish",
+		Handler:    publishHandler,
+	}},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "test/test_images/probe_helper/cegrpc/cloudevents.proto",
+}
+
+// CloudEventServiceClient is the client API for the CloudEventService.
+type CloudEventServiceClient interface {
+	// Publish delivers a CloudEvent. An OK status acknowledges the event.
+	Publish(ctx context.Context, in *PublishRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
+}
+
+type cloudEventServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+// NewCloudEventServiceClient creates a client of the CloudEventService.
+func NewCloudEventServiceClient(cc grpc.ClientConnInterface) CloudEventServiceClient {
+	return &cloudEventServiceClient{cc: cc}
+}
+
+func (c *cloudEventServiceClient) Publish(ctx context.Context, in *PublishRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	out := new(emptypb.Empty)
+	if err := c.cc.Invoke(ctx, publishFullMethod, in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
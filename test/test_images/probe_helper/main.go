@@ -18,6 +18,7 @@ package main
 
 import (
 	"fmt"
+	"os"
 	"time"
 
 	"github.com/kelseyhightower/envconfig"
@@ -25,10 +26,13 @@ import (
 
 	"knative.dev/pkg/logging"
 	"knative.dev/pkg/signals"
+	"knative.dev/pkg/tracing"
 
+	tracingconfig "github.com/google/knative-gcp/pkg/tracing"
 	pkgutils "github.com/google/knative-gcp/pkg/utils"
 	"github.com/google/knative-gcp/pkg/utils/clients"
 	"github.com/google/knative-gcp/test/test_images/probe_helper/probe"
+	"github.com/google/knative-gcp/test/test_images/probe_helper/probe/handlers"
 )
 
 /*
@@ -40,6 +44,59 @@ Helper is able to send and receive events. It exposes an HTTP endpoint which
 forwards probe requests to different destinations, and waits for events to be
 delivered back to it.
 
+The sending and receiving halves of the Probe Helper can also run apart from
+each other, as selected by ROLE: a 'forwarder' only accepts probe requests and
+waits on their events, while a 'receiver' only accepts the delivered events.
+
+Several probe requests can be submitted at once in the CloudEvents batch JSON
+format, in which case each of their probes succeeds or fails on its own, and
+the batch is answered with the array of their results. The batches of more
+than MAX_BATCH_SIZE probes are rejected, and at most BATCH_CONCURRENCY probes
+of a batch are submitted at once.
+
+A probe never outlives the request which submitted it: it is aborted once the
+request is cancelled, and times out at the deadline of the request if that
+comes before its own timeout.
+
+A probe request with the 'warmup' extension set to true only sends its event,
+which opens the forward connection and primes the source path, and is ACKed
+once its target accepts it. Nothing waits on its event, which the receiver
+drops, and it is not counted in the probe metrics, so that a warmup probe
+scheduled before the real probes absorbs their cold-start latency. The broker
+e2e delivery and CloudPubSubSource probes can be warmed up.
+
+The delivered events which match no probe in flight are acknowledged and
+dropped, unless UNMATCHED_EVENT_POLICY is 'nack', which rejects them for their
+sender to redeliver them, or 'log', which also logs them as warnings.
+When MAX_EVENT_TIME_SKEW is set, a delivered event carrying the ID of a probe
+in flight is also treated as unmatched if its time precedes the forward time of
+the probe by more than the skew, as a replay of an older probe with the same ID
+would. The forwarded events which have no time are stamped with it.
+
+When SOURCE_RECEIVER_PATHS is set, each source delivers the events of its
+probes along its own path, i.e. the target path of the probe followed by the
+name of the source, such as '/some-path/cloudpubsubsource'. The events of a
+source delivered along any other path are treated as unmatched, so that the
+sources of a probe helper cannot stand in for each other.
+
+The Pub/Sub and Storage clients authenticate with the Application Default
+Credentials, e.g. those of the workload identity, unless CREDENTIALS_FILE
+names a credentials file. Either way, they can impersonate the service account
+named by IMPERSONATE_SERVICE_ACCOUNT.
+
+The probe requests of an unrecognized type are rejected before anything is
+tracked for them, and at most MAX_PROBE_TYPES distinct probe types are handled,
+so that the clients cannot grow the probes in flight or the metric series at
+will.
+
+Running the Probe Helper as 'probe-helper status' queries a running Probe
+Helper instead, and prints whether it is healthy, the median and 99th
+percentile of its probe latencies, and its most recent probe results. The
+'-url' flag holds the base URL of its receiver client, 'http://localhost:8080'
+by default, and the '-n' flag the number of results to print. The command
+exits with 1 if the Probe Helper is unhealthy, and with 2 if it cannot be
+queried.
+
 The Probe Helper can handle multiple different types of probes.
 
 1. Broker E2E Delivery Probe
@@ -54,12 +111,41 @@ The Probe Helper can handle multiple different types of probes.
 	Probe ---(event)-----> ProbeHelper ----(event)-----> Broker ------> trigger ------
 							 1.                           2.             3. (blackbox)
 
+	When the Broker rewrites the IDs of the events it delivers, the probe event
+	can carry a 'correlateby' extension set to 'datahash', so that it is
+	correlated on a hash of its type and data rather than on its ID. When the
+	Broker only prepends a known prefix to the IDs, e.g. the ID of the
+	subscriber, the probe event can rather carry it in an 'idprefixstrip'
+	extension, so that it is stripped from the IDs of the delivered events
+	before they are correlated. The same applies to the Channel E2E Delivery
+	Probe.
+
+	When the Broker must deliver some extensions of the probe event intact, the
+	probe event can list them in its 'matchextensions' extension. The probe then
+	fails, naming the offending extension, if any of them is delivered back
+	with another value. The same applies to the Channel E2E Delivery Probe.
+
+	Likewise, a probe event carrying an 'expectcontenttype' extension, e.g.
+	'text/plain', is forwarded with that datacontenttype, and the probe fails
+	if the event is delivered back with another one.
+
+	When only the acceptance of the probe event by the Broker ingress matters,
+	e.g. behind an auth proxy, the probe event can carry an 'expectstatus'
+	extension holding the expected HTTP status code, e.g. 202. The probe then
+	succeeds as soon as the Broker ingress answers with that status, without
+	waiting for the event to be delivered back, and fails on any other status.
+
 2. CloudPubSubSource Probe
 
 	The Probe Helper receives an event, publishes it as a message to a Cloud
 	Pub/Sub topic, and waits for it to be delivered back wrapped in a CloudEvent
 	from a CloudPubSubSource.
 
+	When several CloudPubSubSources subscribe to the topic, the probe event can
+	name one of their subscriptions in its 'subscription' extension, so that the
+	probe only succeeds once the message is delivered through that
+	subscription.
+
 3. CloudStorageSource Probe
 
 	This probe involves multiple steps executed in sequence which are intended to
@@ -79,6 +165,42 @@ The Probe Helper can handle multiple different types of probes.
 		 the object, and waits to be notified that the object has been deleted by a
 		 CloudStorageSource.
 
+	Additionally, the Probe Helper can receive an event with a given ID and a list
+	of source objects, compose the source objects into an object named with that
+	ID, and wait to be notified of the composite object having been finalized by a
+	CloudStorageSource.
+
+	The Probe Helper can also receive an event of type
+	`cloudstoragesource-probe-prefix` with a given ID and object name prefix,
+	write an object under the prefix and another one outside of it, and succeed
+	if only the former is notified by a CloudStorageSource before the probe times
+	out.
+
+	The Probe Helper can also receive an event of type
+	`cloudstoragesource-probe-resumable` with a given ID, upload an object named
+	after it in two chunks with a resumable upload, as large objects are
+	uploaded, and succeed once the completed object is notified by a
+	CloudStorageSource as created.
+
+	When the notification subjects do not name the objects after the probe event,
+	such as when the objects are rewritten by a bucket's lifecycle, any of these
+	probe events can carry a regular expression in its 'subjectpattern'
+	extension. Notifications whose subject matches it are then attributed to the
+	probe. Probe events with an invalid pattern are rejected.
+
+	The update-metadata probe event can carry a 'metadatakey' and a
+	'metadatavalue' extension, in which case the Probe Helper updates that key of
+	the object's metadata to that value, and succeeds only if the notification
+	carries it in its data. The probe fails if the key is absent from the
+	notification data or if its value differs.
+
+	Any of these probe events, other than the prefix probe event, can also carry
+	a 'payloadformat' extension, either 'v1' or 'legacy', in which case the probe
+	succeeds only if the notification data conforms to that payload format: the
+	v1 StorageObjectData of the google-cloudevents schema, or the object
+	resource of the legacy JSON API notifications. The probe fails naming the
+	first top-level field which the notification data lacks.
+
 4. CloudSchedulerSource Probe
 
 		This probe is unlike the others in that it does not measure e2e delivery
@@ -95,6 +217,10 @@ The Probe Helper can handle multiple different types of probes.
 	The Probe Helper receives an event, creates a Pub/Sub topic named after it,
 	and waits to observe its creation having been logged by a CloudAuditLogsSource.
 
+	The Probe Helper receives an event of type `cloudauditlogssource-probe-delete`,
+	deletes the Pub/Sub topic of the create probe with the same ID suffix, and
+	waits to observe its deletion having been logged by a CloudAuditLogsSource.
+
 6. PingSource Probe
 
 	This is similar to the CloudSchedulerSource Probe.
@@ -103,6 +229,75 @@ The Probe Helper can handle multiple different types of probes.
 	compares the delay between the current time and the last observed PingSource
 	tick. The probe fails if the delay exceeds a threshold.
 
+	When the event names a PingSource in its `pingsource` extension, only the
+	ticks of that PingSource are observed, so that several PingSources can be
+	probed at once.
+
+	The CloudSchedulerSource and PingSource probe events can carry a 'jitter'
+	extension, a window no longer than their period within which each probe
+	delays its first check, so that many probes with the same period do not
+	check the ticks in lockstep.
+
+7. Broker DLQ Probe
+
+	The Probe Helper receives an event, forwards it to a Broker, and rejects its
+	deliveries until the Broker gives up on it and sends it to the dead letter
+	sink of the Trigger. The probe succeeds once the event is delivered back
+	along the DLQ receiver path, and fails if a delivery is ever accepted.
+
+8. Channel E2E Delivery Probe
+
+	The Probe Helper receives an event, forwards it to a Channel, and waits for
+	it to be delivered back through a Subscription to the Channel.
+
+9. ApiServerSource Probe
+
+	The Probe Helper receives an event of type `apiserversource-probe-create`,
+	`apiserversource-probe-update` or `apiserversource-probe-delete`, creates,
+	updates or deletes a test resource named after it, and waits to be notified
+	of the change by an ApiServerSource.
+
+	A probe event can carry an 'expectcount' extension, in which case the probe
+	waits for that many notifications of any kind on its test resource, such as
+	its whole create, update and delete lifecycle, and fails if fewer of them
+	are received before it times out.
+
+10. Pub/Sub Roundtrip Probe
+
+	The Probe Helper receives an event of type `pubsub-roundtrip-probe`,
+	publishes a message carrying the probe ID in its 'probeid' attribute to the
+	Cloud Pub/Sub topic named in the 'topic' extension, and waits for the
+	message to be pulled from the subscription named in the 'subscription'
+	extension. Unlike the CloudPubSubSource Probe, no source is involved.
+
+11. Broker Reject Probe
+
+	The Probe Helper receives an event of type `broker-reject-probe`, and
+	forwards it to a Broker without its 'type' attribute. The probe succeeds
+	if the Broker ingress rejects the malformed event with a 4xx status, and
+	fails if the Broker accepts it.
+
+12. Ordering Probe
+
+	The Probe Helper receives an event of type `ordering-probe`, and publishes
+	a sequence of events to the Cloud Pub/Sub topic named in the 'topic'
+	extension, with the probe ID as their ordering key and their position in
+	the sequence in their 'seq' extension. The sequence is 3 events long unless
+	the probe event carries a 'sequencelength' extension. The probe succeeds
+	once a CloudPubSubSource of an ordered subscription of the topic delivers
+	all of them in order, and fails as soon as one of them is delivered out of
+	order.
+
+13. CloudSchedulerSource Rate Probe
+
+	The Probe Helper receives an event of type `cloudschedulersource-rate-probe`,
+	and counts the Cloud Scheduler ticks received over the duration held in its
+	'window' extension, in the same scope as the CloudSchedulerSource Probe and
+	of the job named in the 'job' extension if any. The probe succeeds if the
+	count is within one tick of the number of periods of the 'period' extension
+	within the window, and fails if the scheduler ticked too few or too many
+	times, i.e. missed or duplicated executions.
+
 */
 
 type envConfig struct {
@@ -113,34 +308,58 @@ type envConfig struct {
 	ReceiverPort probe.ReceivePort `envconfig:"RECEIVER_PORT" default:"8080"`
 	// Environment variable containing the base URL for the brokercell ingress, used in the broker e2e delivery probe
 	BrokerCellIngressBaseURL string `envconfig:"BROKER_CELL_INGRESS_BASE_URL" default:"http://default-brokercell-ingress.events-system.svc.cluster.local"`
+	// Environment variable containing the template of the broker ingress URL targeted in the broker e2e delivery probe, e.g. 'http://broker-ingress.{{.Namespace}}.svc/{{.Namespace}}/{{.Broker}}', defaulting to the brokercell ingress
+	BrokerIngressTemplate string `envconfig:"BROKER_INGRESS_TEMPLATE"`
+	// Environment variable containing the template of the channel ingress URL targeted in the channel e2e delivery probe, e.g. 'http://{{.Channel}}-kn-channel.{{.Namespace}}.svc.cluster.local'
+	ChannelIngressTemplate string `envconfig:"CHANNEL_INGRESS_TEMPLATE" default:"http://{{.Channel}}-kn-channel.{{.Namespace}}.svc.cluster.local"`
 	// Environment variable containing the maximum tolerated staleness duration for Cloud Scheduler job / PingSource ticks before they are discarded
 	CronStaleDuration time.Duration `envconfig:"CRON_STALE_DURATION" default:"3m"`
+	// Environment variable containing the JSON marshaled tracing config, used to publish the probe spans if tracing is enabled
+	TracingConfigJson string `envconfig:"K_TRACING_CONFIG"`
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == statusCommand {
+		os.Exit(runStatus(os.Args[2:]))
+	}
+
 	var env envConfig
 	if err := envconfig.Process("", &env); err != nil {
 		panic(fmt.Sprintf("Failed to process env var: %s", err))
 	}
 
 	// Create the logger and attach it to the context
-	loggingConfig, err := logging.NewConfigFromMap(map[string]string{
-		"loglevel.probe-helper": "debug",
-	})
+	loggingConfig, err := probe.NewLoggingConfig(env.LogFormat)
 	if err != nil {
 		// If this fails, there is no recovering.
 		panic(err)
 	}
-	logger, _ := logging.NewLoggerFromConfig(loggingConfig, "probe-helper")
+	logger, _ := logging.NewLoggerFromConfig(loggingConfig, probe.LoggerName)
 	ctx := logging.WithLogger(signals.NewContext(), logger)
 
+	// Publish the probe spans if tracing is enabled
+	if env.TracingEnabled {
+		tracingConfig, err := tracingconfig.JSONToConfig(env.TracingConfigJson)
+		if err != nil {
+			logging.FromContext(ctx).Fatal("Failed to process tracing options", zap.Error(err))
+		}
+		if err := tracing.SetupStaticPublishing(logger, "probe-helper", tracingConfig); err != nil {
+			logging.FromContext(ctx).Fatal("Failed to setup tracing", zap.Error(err), zap.Any("tracingConfig", tracingConfig))
+		}
+	}
+
 	// Get the default project ID
 	projectID, err := pkgutils.ProjectIDOrDefault("")
 	if err != nil {
 		logging.FromContext(ctx).Fatal("Failed to get the default project ID", zap.Error(err))
 	}
 
-	ph, err := InitializeProbeHelper(ctx, env.BrokerCellIngressBaseURL, clients.ProjectID(projectID), env.CronStaleDuration, env.EnvConfig, env.ProbePort, env.ReceiverPort)
+	brokerIngressTemplate := env.BrokerIngressTemplate
+	if brokerIngressTemplate == "" {
+		brokerIngressTemplate = env.BrokerCellIngressBaseURL + "/{{.Namespace}}/{{.Broker}}"
+	}
+
+	ph, err := InitializeProbeHelper(ctx, brokerIngressTemplate, handlers.ChannelIngressTemplate(env.ChannelIngressTemplate), clients.ProjectID(projectID), env.CronStaleDuration, env.EnvConfig, env.ProbePort, env.ReceiverPort)
 	if err != nil {
 		logging.FromContext(ctx).Fatal("Failed to initialize probe helper", zap.Error(err))
 	}
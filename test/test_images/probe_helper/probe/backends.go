@@ -0,0 +1,58 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package probe
+
+import (
+	"context"
+	"fmt"
+
+	"cloud.google.com/go/pubsub"
+	"google.golang.org/api/iterator"
+	"k8s.io/apimachinery/pkg/util/clock"
+
+	"github.com/google/knative-gcp/test/test_images/probe_helper/utils"
+)
+
+// PubSubBackend is the name of the Pub/Sub backend, which is checked by
+// listing the topics of the project of the Pub/Sub client.
+const PubSubBackend = "pubsub"
+
+// NewBackendChecker creates the checker of the backends which are enabled in
+// the environment, timed by a given clock.
+func NewBackendChecker(env EnvConfig, pubsubClient *pubsub.Client, clock clock.Clock) (*utils.BackendChecker, error) {
+	checker := utils.NewBackendChecker(env.BackendCheckInterval, clock)
+	for _, backend := range env.BackendChecks {
+		switch backend {
+		case PubSubBackend:
+			checker.Register(PubSubBackend, checkPubSub(pubsubClient))
+		default:
+			return nil, fmt.Errorf("unsupported backend %q", backend)
+		}
+	}
+	return checker, nil
+}
+
+// checkPubSub returns a BackendCheck which lists at most one topic with a
+// given Pub/Sub client.
+func checkPubSub(client *pubsub.Client) utils.BackendCheck {
+	return func(ctx context.Context) error {
+		if _, err := client.Topics(ctx).Next(); err != nil && err != iterator.Done {
+			return fmt.Errorf("failed to list Pub/Sub topics: %w", err)
+		}
+		return nil
+	}
+}
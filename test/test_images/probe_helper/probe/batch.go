@@ -0,0 +1,185 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package probe
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"sync"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/cloudevents/sdk-go/v2/binding"
+	cehttp "github.com/cloudevents/sdk-go/v2/protocol/http"
+	"go.uber.org/zap"
+	"knative.dev/pkg/logging"
+
+	"github.com/google/knative-gcp/test/test_images/probe_helper/utils"
+)
+
+// BatchResult is the result of one of the probes submitted in a batch.
+type BatchResult struct {
+	// ID is the ID of the probe event.
+	ID string `json:"id"`
+	// Result is either ProbeResultACK or ProbeResultNACK.
+	Result string `json:"result"`
+	// StatusCode is the HTTP status code with which the probe would have been
+	// answered had it been submitted on its own.
+	StatusCode int `json:"statusCode"`
+	// Failure is the reason why the probe failed, if it is known.
+	Failure *FailureResult `json:"failure,omitempty"`
+}
+
+// batchMiddleware makes the forward client accept batches of probe events in
+// the CloudEvents batch JSON format. Each probe of a batch is submitted on its
+// own to the next handler, by at most concurrency probes at once, and the batch
+// is answered with the array of their results once they are all completed.
+// The batches of more than maxSize probes are rejected as a whole.
+func batchMiddleware(maxSize, concurrency int) cehttp.Middleware {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type")); mediaType != cloudevents.ApplicationCloudEventsBatchJSON {
+				next.ServeHTTP(w, req)
+				return
+			}
+			var events []json.RawMessage
+			if err := json.NewDecoder(req.Body).Decode(&events); err != nil {
+				http.Error(w, "malformed batch of probe events: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			if len(events) > maxSize {
+				http.Error(w, fmt.Sprintf("batch of %d probe events, at most %d are accepted", len(events), maxSize), http.StatusRequestEntityTooLarge)
+				return
+			}
+
+			results := make([]BatchResult, len(events))
+			indices := make(chan int)
+			workers := concurrency
+			if workers > len(events) {
+				workers = len(events)
+			}
+			var wg sync.WaitGroup
+			wg.Add(workers)
+			for n := 0; n < workers; n++ {
+				go func() {
+					defer wg.Done()
+					for i := range indices {
+						results[i] = forwardBatchEvent(next, req, events[i])
+					}
+				}()
+			}
+			for i := range events {
+				indices <- i
+			}
+			close(indices)
+			wg.Wait()
+
+			w.Header().Set("Content-Type", "application/json")
+			if err := json.NewEncoder(w).Encode(results); err != nil {
+				logging.FromContext(req.Context()).Warnw("Failed to write batch results", zap.Error(err))
+			}
+		})
+	}
+}
+
+// forwardBatchEvent submits a probe event of a batch to a handler in the
+// structured content mode, and returns its result.
+func forwardBatchEvent(next http.Handler, batch *http.Request, event json.RawMessage) BatchResult {
+	var probe struct {
+		ID string `json:"id"`
+	}
+	// A malformed event is rejected by the next handler.
+	_ = json.Unmarshal(event, &probe)
+
+	req, err := http.NewRequestWithContext(batch.Context(), batch.Method, batch.URL.String(), bytes.NewReader(event))
+	if err != nil {
+		return BatchResult{ID: probe.ID, Result: utils.ProbeResultNACK, StatusCode: http.StatusInternalServerError}
+	}
+	// The probe is submitted with the headers of the batch, such as its trace
+	// context, in place of the headers of the batch body.
+	req.Header = batch.Header.Clone()
+	req.Header.Del("Content-Length")
+	req.Header.Set("Content-Type", cloudevents.ApplicationCloudEventsJSON)
+	rw := &batchResponseWriter{header: http.Header{}}
+	next.ServeHTTP(rw, req)
+
+	result := BatchResult{
+		ID:         probe.ID,
+		Result:     utils.ProbeResultACK,
+		StatusCode: rw.statusCode(),
+	}
+	if result.StatusCode/100 == 2 {
+		return result
+	}
+	result.Result = utils.ProbeResultNACK
+	// The failed probes are answered with an event carrying their failure.
+	if response, err := binding.ToEvent(batch.Context(), cehttp.NewMessageFromHttpResponse(rw.response())); err == nil {
+		var failure FailureResult
+		if err := response.DataAs(&failure); err == nil && failure.Reason != "" {
+			result.Failure = &failure
+		}
+	}
+	return result
+}
+
+// batchResponseWriter captures the response of the next handler to a probe of
+// a batch.
+type batchResponseWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (w *batchResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *batchResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *batchResponseWriter) Write(b []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.body.Write(b)
+}
+
+// statusCode returns the status code of the response, which defaults to
+// http.StatusOK like that of an HTTP server.
+func (w *batchResponseWriter) statusCode() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+// response returns the captured response.
+func (w *batchResponseWriter) response() *http.Response {
+	return &http.Response{
+		StatusCode:    w.statusCode(),
+		Header:        w.header,
+		Body:          ioutil.NopCloser(bytes.NewReader(w.body.Bytes())),
+		ContentLength: int64(w.body.Len()),
+	}
+}
@@ -0,0 +1,137 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package probe
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/google/knative-gcp/test/test_images/probe_helper/utils"
+)
+
+func TestBatchMiddlewareHeaders(t *testing.T) {
+	const traceparent = "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"
+	var mu sync.Mutex
+	var got []http.Header
+	next := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		got = append(got, req.Header)
+		mu.Unlock()
+		if req.Header.Get("Traceparent") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	})
+
+	batch, err := json.Marshal([]*cloudevents.Event{
+		probeEvent("broker-e2e-delivery-probe", withProbeID("broker-e2e-delivery-probe-batch-1")),
+		probeEvent("broker-e2e-delivery-probe", withProbeID("broker-e2e-delivery-probe-batch-2")),
+	})
+	if err != nil {
+		t.Fatal("Failed to marshal batch of probe events:", err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(batch))
+	req.Header.Set("Content-Type", cloudevents.ApplicationCloudEventsBatchJSON)
+	req.Header.Set("Content-Length", "1")
+	req.Header.Set("Traceparent", traceparent)
+	rw := httptest.NewRecorder()
+	batchMiddleware(2, 2)(next).ServeHTTP(rw, req)
+
+	var results []BatchResult
+	if err := json.NewDecoder(rw.Body).Decode(&results); err != nil {
+		t.Fatal("Failed to decode batch results:", err)
+	}
+	want := []BatchResult{{
+		ID:         "broker-e2e-delivery-probe-batch-1",
+		Result:     utils.ProbeResultACK,
+		StatusCode: http.StatusOK,
+	}, {
+		ID:         "broker-e2e-delivery-probe-batch-2",
+		Result:     utils.ProbeResultACK,
+		StatusCode: http.StatusOK,
+	}}
+	if diff := cmp.Diff(want, results); diff != "" {
+		t.Errorf("batch results (-want,+got): %s", diff)
+	}
+
+	// Each probe carries the headers of the batch, except those of its body.
+	for _, header := range got {
+		if got := header.Get("Traceparent"); got != traceparent {
+			t.Errorf("probe Traceparent header got=%q, want=%q", got, traceparent)
+		}
+		if got := header.Get("Content-Type"); got != cloudevents.ApplicationCloudEventsJSON {
+			t.Errorf("probe Content-Type header got=%q, want=%q", got, cloudevents.ApplicationCloudEventsJSON)
+		}
+		if got := header.Get("Content-Length"); got != "" {
+			t.Errorf("probe Content-Length header got=%q, want none", got)
+		}
+	}
+}
+
+func TestBatchMiddlewareConcurrency(t *testing.T) {
+	const concurrency = 3
+	var mu sync.Mutex
+	var running, maxRunning int
+	next := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	})
+
+	events := make([]*cloudevents.Event, 20)
+	for i := range events {
+		events[i] = probeEvent("broker-e2e-delivery-probe", withProbeID(fmt.Sprintf("broker-e2e-delivery-probe-batch-%d", i)))
+	}
+	batch, err := json.Marshal(events)
+	if err != nil {
+		t.Fatal("Failed to marshal batch of probe events:", err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(batch))
+	req.Header.Set("Content-Type", cloudevents.ApplicationCloudEventsBatchJSON)
+	rw := httptest.NewRecorder()
+	batchMiddleware(len(events), concurrency)(next).ServeHTTP(rw, req)
+
+	if rw.Code != http.StatusOK {
+		t.Fatalf("batch status code got=%d, want=%d", rw.Code, http.StatusOK)
+	}
+	var got []BatchResult
+	if err := json.NewDecoder(rw.Body).Decode(&got); err != nil {
+		t.Fatal("Failed to decode batch results:", err)
+	}
+	if len(got) != len(events) {
+		t.Errorf("batch results got=%d, want=%d", len(got), len(events))
+	}
+	if maxRunning > concurrency {
+		t.Errorf("concurrently submitted probes got=%d, want at most %d", maxRunning, concurrency)
+	}
+}
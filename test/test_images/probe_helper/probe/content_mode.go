@@ -0,0 +1,116 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package probe
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strconv"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/cloudevents/sdk-go/v2/client"
+	cehttp "github.com/cloudevents/sdk-go/v2/protocol/http"
+
+	"github.com/google/knative-gcp/test/test_images/probe_helper/utils"
+)
+
+const (
+	// BinaryContentMode encodes the CloudEvent attributes as HTTP headers.
+	BinaryContentMode = "binary"
+	// StructuredContentMode encodes the whole CloudEvent as a JSON HTTP body.
+	StructuredContentMode = "structured"
+)
+
+// contentModeClientOptions returns the CloudEvents client options which force
+// the outbound events of a client to be encoded in a given content mode. The
+// binary content mode is used if none is given.
+func contentModeClientOptions(mode string) ([]client.Option, error) {
+	switch mode {
+	case "", BinaryContentMode:
+		return []client.Option{client.WithForceBinary()}, nil
+	case StructuredContentMode:
+		return []client.Option{client.WithForceStructured()}, nil
+	default:
+		return nil, fmt.Errorf("unsupported content mode %q", mode)
+	}
+}
+
+// isStructuredRequest returns whether an HTTP request carries a CloudEvent
+// encoded in structured content mode.
+func isStructuredRequest(req *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	return err == nil && mediaType == cloudevents.ApplicationCloudEventsJSON
+}
+
+// injectReceiverPath sets the receiverpath extension of the CloudEvent carried
+// by an HTTP request to the request path. In binary content mode the
+// extension is set as a header, while in structured content mode it has to be
+// added to the JSON body for the CloudEvents SDK to decode it.
+func injectReceiverPath(req *http.Request) error {
+	if !isStructuredRequest(req) {
+		req.Header.Set(utils.ProbeEventReceiverPathHeader, req.URL.Path)
+		return nil
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return err
+	}
+	var event map[string]json.RawMessage
+	if err := json.Unmarshal(body, &event); err != nil {
+		return fmt.Errorf("failed to unmarshal structured event: %v", err)
+	}
+	if event[utils.ProbeEventReceiverPathExtension], err = json.Marshal(req.URL.Path); err != nil {
+		return err
+	}
+	if body, err = json.Marshal(event); err != nil {
+		return err
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+	req.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	return nil
+}
+
+// receiverMiddleware returns the middleware which prepares the events
+// delivered to the receiver client for correlation. Events which are not
+// encoded in the expected content mode, or which are delivered along a path
+// outside of the receiver path prefix, are rejected.
+func receiverMiddleware(mode, pathPrefix string) cehttp.Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			if req.Method == http.MethodPost {
+				if !isUnderReceiverPathPrefix(pathPrefix, req.URL.Path) {
+					http.NotFound(rw, req)
+					return
+				}
+				if structured := isStructuredRequest(req); structured != (mode == StructuredContentMode) {
+					http.Error(rw, fmt.Sprintf("expected event in %s content mode", mode), http.StatusUnsupportedMediaType)
+					return
+				}
+				if err := injectReceiverPath(req); err != nil {
+					http.Error(rw, err.Error(), http.StatusBadRequest)
+					return
+				}
+			}
+			next.ServeHTTP(rw, req)
+		})
+	}
+}
@@ -0,0 +1,163 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package probe
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/cloudevents/sdk-go/v2/binding"
+	cehttp "github.com/cloudevents/sdk-go/v2/protocol/http"
+
+	"github.com/google/knative-gcp/test/test_images/probe_helper/utils"
+)
+
+func TestReceiverMiddleware(t *testing.T) {
+	cases := []struct {
+		name           string
+		mode           string
+		contentType    string
+		headers        map[string]string
+		body           string
+		wantStatusCode int
+		wantEvent      bool
+	}{{
+		name:        "binary event",
+		mode:        BinaryContentMode,
+		contentType: "application/json",
+		headers: map[string]string{
+			"Ce-Id":          "test-id",
+			"Ce-Source":      "test-source",
+			"Ce-Type":        "test-type",
+			"Ce-Specversion": "1.0",
+		},
+		body:           `{}`,
+		wantStatusCode: http.StatusOK,
+		wantEvent:      true,
+	}, {
+		name:           "structured event",
+		mode:           StructuredContentMode,
+		contentType:    "application/cloudevents+json; charset=UTF-8",
+		body:           `{"id":"test-id","source":"test-source","type":"test-type","specversion":"1.0","data":{}}`,
+		wantStatusCode: http.StatusOK,
+		wantEvent:      true,
+	}, {
+		name:           "unexpected structured event",
+		mode:           BinaryContentMode,
+		contentType:    "application/cloudevents+json",
+		body:           `{"id":"test-id","source":"test-source","type":"test-type","specversion":"1.0"}`,
+		wantStatusCode: http.StatusUnsupportedMediaType,
+	}, {
+		name:        "unexpected binary event",
+		mode:        StructuredContentMode,
+		contentType: "application/json",
+		headers: map[string]string{
+			"Ce-Id":          "test-id",
+			"Ce-Source":      "test-source",
+			"Ce-Type":        "test-type",
+			"Ce-Specversion": "1.0",
+		},
+		body:           `{}`,
+		wantStatusCode: http.StatusUnsupportedMediaType,
+	}, {
+		name:           "malformed structured event",
+		mode:           StructuredContentMode,
+		contentType:    "application/cloudevents+json",
+		body:           `{`,
+		wantStatusCode: http.StatusBadRequest,
+	}}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var gotEvent *cloudevents.Event
+			handler := receiverMiddleware(tc.mode, "/")(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				event, err := binding.ToEvent(req.Context(), cehttp.NewMessageFromHttpRequest(req))
+				if err != nil {
+					t.Fatalf("Failed to decode event: %v", err)
+				}
+				gotEvent = event
+			}))
+			req := httptest.NewRequest(http.MethodPost, "/"+testTargetReceiverPath, strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", tc.contentType)
+			for k, v := range tc.headers {
+				req.Header.Set(k, v)
+			}
+			rw := httptest.NewRecorder()
+			handler.ServeHTTP(rw, req)
+
+			if rw.Code != tc.wantStatusCode {
+				t.Errorf("status code got=%d, want=%d", rw.Code, tc.wantStatusCode)
+			}
+			if (gotEvent != nil) != tc.wantEvent {
+				t.Fatalf("got event %v, want event %v", gotEvent, tc.wantEvent)
+			}
+			if gotEvent == nil {
+				return
+			}
+			if gotEvent.ID() != "test-id" {
+				t.Errorf("event ID got=%s, want=test-id", gotEvent.ID())
+			}
+			if got := fmt.Sprint(gotEvent.Extensions()[utils.ProbeEventReceiverPathExtension]); got != "/"+testTargetReceiverPath {
+				t.Errorf("receiverpath extension got=%s, want=/%s", got, testTargetReceiverPath)
+			}
+		})
+	}
+}
+
+func TestReceiverMiddlewarePathPrefix(t *testing.T) {
+	cases := []struct {
+		name           string
+		prefix         string
+		path           string
+		wantStatusCode int
+	}{{
+		name:           "root prefix",
+		prefix:         "/",
+		path:           "/test-namespace",
+		wantStatusCode: http.StatusOK,
+	}, {
+		name:           "prefix itself",
+		prefix:         "/test-namespace",
+		path:           "/test-namespace",
+		wantStatusCode: http.StatusOK,
+	}, {
+		name:           "path under the prefix",
+		prefix:         "/test-namespace/",
+		path:           "/test-namespace/dlq",
+		wantStatusCode: http.StatusOK,
+	}, {
+		name:           "path outside of the prefix",
+		prefix:         "/test-namespace",
+		path:           "/test-namespace-other",
+		wantStatusCode: http.StatusNotFound,
+	}}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			handler := receiverMiddleware(BinaryContentMode, tc.prefix)(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}))
+			req := httptest.NewRequest(http.MethodPost, tc.path, strings.NewReader(`{}`))
+			req.Header.Set("Content-Type", "application/json")
+			rw := httptest.NewRecorder()
+			handler.ServeHTTP(rw, req)
+			if rw.Code != tc.wantStatusCode {
+				t.Errorf("status code got=%d, want=%d", rw.Code, tc.wantStatusCode)
+			}
+		})
+	}
+}
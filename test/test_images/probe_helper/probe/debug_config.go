@@ -0,0 +1,85 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package probe
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+)
+
+const (
+	// debugConfigPath is the path along which the effective configuration of
+	// the probe helper is served.
+	debugConfigPath = "/debug/config"
+
+	// redactedValue replaces the values of the secret configuration fields,
+	// which are tagged with `redact:"true"`.
+	redactedValue = "REDACTED"
+)
+
+// effectiveConfig returns the configuration of the probe helper keyed by the
+// environment variables it is parsed from, with the durations and the other
+// values which are parsed from strings formatted back, and the secret values
+// redacted.
+func effectiveConfig(env EnvConfig) map[string]interface{} {
+	config := map[string]interface{}{}
+	v := reflect.ValueOf(env)
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		name, ok := field.Tag.Lookup("envconfig")
+		if !ok {
+			continue
+		}
+		value := v.Field(i)
+		if field.Tag.Get("redact") == "true" && !value.IsZero() {
+			config[name] = redactedValue
+			continue
+		}
+		config[name] = configValue(value)
+	}
+	return config
+}
+
+// configValue returns a configuration value in the form in which it is
+// served, formatting the values and map elements which are fmt.Stringers.
+func configValue(value reflect.Value) interface{} {
+	if s, ok := value.Interface().(fmt.Stringer); ok {
+		return s.String()
+	}
+	if value.Kind() == reflect.Map && !value.IsNil() {
+		m := make(map[string]interface{}, value.Len())
+		iter := value.MapRange()
+		for iter.Next() {
+			m[fmt.Sprint(iter.Key().Interface())] = configValue(iter.Value())
+		}
+		return m
+	}
+	return value.Interface()
+}
+
+// configHandlerFunc returns the HTTP handler which serves the effective
+// configuration of the probe helper.
+func (ph *Helper) configHandlerFunc() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(effectiveConfig(ph.env)); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package probe
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+
+	"github.com/google/knative-gcp/test/test_images/probe_helper/probe/handlers"
+	"github.com/google/knative-gcp/test/test_images/probe_helper/utils"
+)
+
+const (
+	// The dryrun extension makes the probe helper validate a probe event
+	// without forwarding it, as does DRY_RUN for every probe event.
+	dryRunExtension = "dryrun"
+)
+
+// isDryRun returns whether a probe event is only to be validated.
+func (ph *Helper) isDryRun(event cloudevents.Event) bool {
+	if ph.env.DryRun {
+		return true
+	}
+	value, ok := event.Extensions()[dryRunExtension]
+	if !ok {
+		return false
+	}
+	dryRun, err := strconv.ParseBool(fmt.Sprint(value))
+	return err == nil && dryRun
+}
+
+// validateProbe checks that a probe event is well-formed: its type must be
+// recognized, the extensions its probe requires must be present, and its
+// timeout must be within the maximum.
+func (ph *Helper) validateProbe(event cloudevents.Event) error {
+	if v, ok := ph.probeHandler.(handlers.Validator); ok {
+		if err := v.Validate(event); err != nil {
+			return err
+		}
+	}
+	if value, ok := event.Extensions()[utils.ProbeEventTimeoutExtension]; ok {
+		timeout, err := time.ParseDuration(fmt.Sprint(value))
+		if err != nil {
+			return fmt.Errorf("failed to parse timeout extension: %v", err)
+		}
+		if timeout > ph.env.MaxTimeoutDuration {
+			return fmt.Errorf("timeout %s exceeds the maximum %s", timeout, ph.env.MaxTimeoutDuration)
+		}
+	}
+	return nil
+}
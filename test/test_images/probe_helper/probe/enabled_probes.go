@@ -0,0 +1,82 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package probe
+
+import (
+	"sync"
+
+	"github.com/google/knative-gcp/test/test_images/probe_helper/probe/handlers"
+	"github.com/google/knative-gcp/test/test_images/probe_helper/utils"
+)
+
+// isProbeTypeEnabled returns whether the probes of a given type are enabled,
+// which they all are unless ENABLED_PROBES lists the enabled probe types.
+func (ph *Helper) isProbeTypeEnabled(probeType string) bool {
+	if len(ph.env.EnabledProbes) == 0 {
+		return true
+	}
+	for _, enabled := range ph.env.EnabledProbes {
+		if enabled == probeType {
+			return true
+		}
+	}
+	return false
+}
+
+// isProbeTypeRecognized returns whether the probe handler handles the probes
+// of a given type, which it does unless it tells otherwise.
+func (ph *Helper) isProbeTypeRecognized(probeType string) bool {
+	if r, ok := ph.probeHandler.(handlers.TypeRecognizer); ok {
+		return r.Recognizes(probeType)
+	}
+	return true
+}
+
+// metricsProbeType returns the probe type which labels the metrics of the
+// probes of a given type. The types of the probes which are rejected for being
+// unrecognized or disabled are all labeled as other, so that the clients
+// cannot grow the number of metric series at will.
+func (ph *Helper) metricsProbeType(probeType string) string {
+	if !ph.isProbeTypeRecognized(probeType) || !ph.isProbeTypeEnabled(probeType) {
+		return utils.OtherLabelValue
+	}
+	return probeType
+}
+
+// probeTypes is the synchronized set of the distinct probe types which are
+// handled, bounded by MAX_PROBE_TYPES.
+type probeTypes struct {
+	mu    sync.Mutex
+	max   int
+	types map[string]struct{}
+}
+
+// admit adds a probe type to the set, and returns whether it is in the set,
+// which it is not once the set is full.
+func (t *probeTypes) admit(probeType string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, ok := t.types[probeType]; ok || t.max <= 0 {
+		return true
+	}
+	if len(t.types) >= t.max {
+		return false
+	}
+	t.types[probeType] = struct{}{}
+	return true
+}
@@ -0,0 +1,171 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package probe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	cehttp "github.com/cloudevents/sdk-go/v2/protocol/http"
+
+	"github.com/google/knative-gcp/test/test_images/probe_helper/probe/handlers"
+	"github.com/google/knative-gcp/test/test_images/probe_helper/utils"
+)
+
+// FailureReason is the machine-readable reason why a probe is NACKed.
+type FailureReason string
+
+const (
+	// The reasons why a probe is NACKed.
+	MissingExtensionReason      FailureReason = "MissingExtension"
+	InvalidExtensionReason      FailureReason = "InvalidExtension"
+	ReservedExtensionReason     FailureReason = "ReservedExtension"
+	InvalidTargetPathReason     FailureReason = "InvalidTargetPath"
+	PayloadTooLargeReason       FailureReason = "PayloadTooLarge"
+	UnrecognizedProbeTypeReason FailureReason = "UnrecognizedProbeType"
+	TooManyInFlightProbesReason FailureReason = "TooManyInFlightProbes"
+	ProbeTypeDisabledReason     FailureReason = "ProbeTypeDisabled"
+	TooManyProbeTypesReason     FailureReason = "TooManyProbeTypes"
+	WarmupUnsupportedReason     FailureReason = "WarmupUnsupported"
+	TimeoutReason               FailureReason = "Timeout"
+	RetryBudgetExhaustedReason  FailureReason = "RetryBudgetExhausted"
+	ForwardFailedReason         FailureReason = "ForwardFailed"
+)
+
+const (
+	// failureEventType is the type of the event which the probe helper
+	// responds with to a NACKed probe request.
+	failureEventType = "probe-helper.failure"
+
+	// failureReasonExtension is the extension of the response event which
+	// carries its failure reason, i.e. the 'Ce-Failurereason' HTTP header.
+	failureReasonExtension = "failurereason"
+)
+
+// failureStatusCodes are the HTTP status codes of the probe requests which
+// fail with specific reasons, rather than the default status code of a NACK.
+var failureStatusCodes = map[FailureReason]int{
+	TooManyInFlightProbesReason: http.StatusTooManyRequests,
+}
+
+// FailureResult is the NACK result of a probe which carries the reason of its
+// failure. It matches cloudevents.ResultNACK.
+type FailureResult struct {
+	Reason  FailureReason `json:"reason"`
+	Message string        `json:"message"`
+}
+
+func newFailureResult(reason FailureReason, format string, args ...interface{}) *FailureResult {
+	return &FailureResult{
+		Reason:  reason,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+// Error returns the reason of the failure followed by its message.
+func (r *FailureResult) Error() string {
+	return fmt.Sprintf("%s: %s", r.Reason, r.Message)
+}
+
+// Unwrap makes the failure result a NACK, which carries the HTTP status code
+// of its reason if it has one.
+func (r *FailureResult) Unwrap() error {
+	if code, ok := failureStatusCodes[r.Reason]; ok {
+		return cehttp.NewResult(code, "%w", cloudevents.ResultNACK)
+	}
+	return cloudevents.ResultNACK
+}
+
+// validationFailureReason returns the failure reason of a probe event which
+// did not pass the validation of its probe handler.
+func validationFailureReason(err error) FailureReason {
+	switch {
+	case errors.Is(err, handlers.ErrUnrecognizedProbeType):
+		return UnrecognizedProbeTypeReason
+	case errors.Is(err, handlers.ErrMissingExtension):
+		return MissingExtensionReason
+	default:
+		return InvalidExtensionReason
+	}
+}
+
+// forwardFailureReason returns the failure reason of a probe which failed to
+// be forwarded with a given context.
+func forwardFailureReason(ctx context.Context, err error) FailureReason {
+	switch {
+	case errors.Is(err, handlers.ErrUnrecognizedProbeType):
+		return UnrecognizedProbeTypeReason
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return TimeoutReason
+	default:
+		return ForwardFailedReason
+	}
+}
+
+// respondWithFailureReason makes a forward probe request handler respond to
+// the probe requests which fail with a reason with an event carrying it, so
+// that the reason reaches the sender in the HTTP response headers and body.
+// The response event has the identity of the probe helper and the ID of the
+// probe event.
+func respondWithFailureReason(forward cloudEventsFunc, identity eventIdentity) func(cloudevents.Event) (*cloudevents.Event, cloudevents.Result) {
+	return func(event cloudevents.Event) (*cloudevents.Event, cloudevents.Result) {
+		result := forward(event)
+		var failure *FailureResult
+		if !errors.As(result, &failure) {
+			return nil, result
+		}
+		response := cloudevents.NewEvent()
+		identity.identify(&response, event.ID())
+		response.SetType(failureEventType)
+		response.SetExtension(failureReasonExtension, string(failure.Reason))
+		if err := response.SetData(cloudevents.ApplicationJSON, failure); err != nil {
+			return nil, result
+		}
+		return &response, result
+	}
+}
+
+// retryAfterMiddleware sets the Retry-After header of the probe requests which
+// are rejected with HTTP status 429, so that their senders back off for about
+// as long as a probe currently takes to complete, and at least a second.
+func retryAfterMiddleware(metrics *utils.ProbeMetrics) cehttp.Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			next.ServeHTTP(&retryAfterResponseWriter{ResponseWriter: w, metrics: metrics}, req)
+		})
+	}
+}
+
+// retryAfterResponseWriter sets the Retry-After header before writing a 429
+// status code.
+type retryAfterResponseWriter struct {
+	http.ResponseWriter
+	metrics *utils.ProbeMetrics
+}
+
+func (w *retryAfterResponseWriter) WriteHeader(statusCode int) {
+	if statusCode == http.StatusTooManyRequests {
+		seconds := math.Max(1, math.Ceil(w.metrics.AverageLatency().Seconds()))
+		w.Header().Set("Retry-After", strconv.Itoa(int(seconds)))
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
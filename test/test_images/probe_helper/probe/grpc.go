@@ -0,0 +1,105 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package probe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	cehttp "github.com/cloudevents/sdk-go/v2/protocol/http"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"knative.dev/pkg/logging"
+
+	"github.com/google/knative-gcp/test/test_images/probe_helper/cegrpc"
+	"github.com/google/knative-gcp/test/test_images/probe_helper/utils"
+)
+
+const (
+	// HTTPProbeProtocol accepts the probe requests as CloudEvents over HTTP.
+	HTTPProbeProtocol = "http"
+	// GRPCProbeProtocol accepts the probe requests as CloudEvents over gRPC.
+	GRPCProbeProtocol = "grpc"
+)
+
+// ProbeGRPCListener is the listener of the gRPC server which accepts the probe
+// requests, which is nil unless the probe requests are accepted over gRPC.
+type ProbeGRPCListener net.Listener
+
+// validateProbeProtocol ensures that the probe protocol is supported.
+func validateProbeProtocol(protocol string) error {
+	switch protocol {
+	case "", HTTPProbeProtocol, GRPCProbeProtocol:
+		return nil
+	default:
+		return fmt.Errorf("unsupported probe protocol %q", protocol)
+	}
+}
+
+// probeGRPCServer routes the probe requests accepted over gRPC into the same
+// forward handler as the probe requests accepted over HTTP.
+type probeGRPCServer struct {
+	forward cloudEventsFunc
+	// The registry of the contexts of the probe requests, which bounds each
+	// probe by the deadline and cancellation of its RPC
+	requests *utils.ProbeRequests
+}
+
+func (s *probeGRPCServer) Publish(ctx context.Context, req *cegrpc.PublishRequest) (*emptypb.Empty, error) {
+	event, err := cegrpc.ToEvent(req.GetEvent())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	token, unregister := s.requests.Register(ctx)
+	defer unregister()
+	event.SetExtension(utils.ProbeEventRequestExtension, token)
+	if result := s.forward(*event); !cloudevents.IsACK(result) {
+		return nil, resultStatus(result)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+// resultStatus returns the gRPC status matching a NACK result of the forward
+// handler.
+func resultStatus(result cloudevents.Result) error {
+	var httpResult *cehttp.Result
+	if errors.As(result, &httpResult) && httpResult.StatusCode == http.StatusServiceUnavailable {
+		return status.Error(codes.Unavailable, result.Error())
+	}
+	return status.Error(codes.Unknown, result.Error())
+}
+
+// runProbeGRPCServer accepts the probe requests over gRPC until the context is
+// done.
+func (ph *Helper) runProbeGRPCServer(ctx context.Context) {
+	srv := grpc.NewServer()
+	cegrpc.RegisterCloudEventServiceServer(srv, &probeGRPCServer{forward: ph.forwardFromProbe(ctx), requests: ph.probeRequests})
+	go func() {
+		<-ctx.Done()
+		srv.Stop()
+	}()
+	if err := srv.Serve(ph.probeGRPCListener); err != nil {
+		logging.FromContext(ctx).Errorw("Probe gRPC server failed", zap.Error(err))
+	}
+}
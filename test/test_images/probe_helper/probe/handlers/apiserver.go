@@ -18,8 +18,10 @@ package handlers
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"strings"
+	"sync"
 
 	cloudevents "github.com/cloudevents/sdk-go/v2"
 	"github.com/google/knative-gcp/pkg/utils/clients"
@@ -30,6 +32,7 @@ import (
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
 	"knative.dev/eventing/pkg/apis/sources"
+	sourcesv1 "knative.dev/eventing/pkg/apis/sources/v1"
 	"knative.dev/pkg/logging"
 )
 
@@ -46,14 +49,27 @@ const (
 	// ApiServerSource delete probes.
 	ApiServerSourceDeleteProbeEventType = "apiserversource-probe-delete"
 
-	testPodName = "apiserversource-test-pod"
+	testPodName       = "apiserversource-test-pod"
+	testConfigMapName = "apiserversource-test-configmap"
+
+	// The resource extension holds the kind of the resources which the
+	// ApiServerSource probes create, update and delete.
+	resourceExtension  = "resource"
+	podsResource       = "pods"
+	configMapsResource = "configmaps"
+
+	// The eventmode extension holds the event mode, either 'Reference' or
+	// 'Resource', in which the ApiServerSource is expected to deliver the
+	// events of a probe.
+	eventModeExtension = "eventmode"
 )
 
-func NewApiServerSourceProbe(projectID clients.ProjectID, k8sClient kubernetes.Interface) *ApiServerSourceProbe {
+func NewApiServerSourceProbe(projectID clients.ProjectID, k8sClient kubernetes.Interface, store utils.CorrelationStore) *ApiServerSourceProbe {
 	return &ApiServerSourceProbe{
 		projectID:      projectID,
 		k8sClient:      k8sClient,
-		receivedEvents: utils.NewSyncReceivedEvents(),
+		receivedEvents: utils.NewSyncReceivedEvents(store, "apiserversource"),
+		eventModes:     map[string]string{},
 	}
 }
 
@@ -63,11 +79,15 @@ type ApiServerSourceProbe struct {
 	// The project ID
 	projectID clients.ProjectID
 
-	// Kubernetes client used to create a test pod
+	// Kubernetes client used to create test resources
 	k8sClient kubernetes.Interface
 
 	// The map of received events to be tracked by the forwarder and receiver
-	receivedEvents *utils.SyncReceivedEvents
+	receivedEvents utils.ReceivedEvents
+
+	// The event modes expected by the probes, keyed by receiver channel
+	eventModes   map[string]string
+	eventModesMu sync.RWMutex
 }
 
 type ApiServerSourceCreateProbe struct {
@@ -82,82 +102,266 @@ type ApiServerSourceDeleteProbe struct {
 	*ApiServerSourceProbe
 }
 
-// Forward creates a pod in order to generate an ApiServerSource notification event.
+// apiServerResource returns the kind of the resource of an ApiServerSource
+// probe, held in its resource extension and pods by default, along with the
+// name of the test resource named after the probe.
+func apiServerResource(event cloudevents.Event) (string, string, error) {
+	resource := podsResource
+	if r, ok := event.Extensions()[resourceExtension]; ok {
+		resource = fmt.Sprint(r)
+	}
+	var name string
+	switch resource {
+	case podsResource:
+		name = testPodName
+	case configMapsResource:
+		name = testConfigMapName
+	default:
+		return "", "", fmt.Errorf("Unsupported ApiServerSource probe resource '%s'", resource)
+	}
+	return resource, fmt.Sprintf("%s.%s", name, event.ID()[len(event.Type())+1:]), nil
+}
+
+// apiServerEventMode returns the event mode of an ApiServerSource probe, held
+// in its eventmode extension, if any.
+func apiServerEventMode(event cloudevents.Event) (string, error) {
+	mode, ok := event.Extensions()[eventModeExtension]
+	if !ok {
+		return "", nil
+	}
+	switch m := fmt.Sprint(mode); m {
+	case sourcesv1.ReferenceMode, sourcesv1.ResourceMode:
+		return m, nil
+	default:
+		return "", fmt.Errorf("Unsupported ApiServerSource probe event mode '%s'", m)
+	}
+}
+
+// apiServerChannelID returns the ID of the receiver channel of the probe of a
+// given type on a named test resource.
+func apiServerChannelID(namespace, forwardType, name string) string {
+	return channelID(namespace, fmt.Sprintf("%s/%s", forwardType, name))
+}
+
+// apiServerResourceChannelID returns the ID of the receiver channel of the
+// probe which expects several events on a named test resource, and thus
+// counts the events of every type on that resource.
+func apiServerResourceChannelID(namespace, name string) string {
+	return channelID(namespace, name)
+}
+
+// createReceiverChannel creates the receiver channel of a probe on a named test
+// resource, and returns its ID along with the function which cleans it up. A
+// probe which expects several events waits on them on the channel of its
+// resource, so that the events of all the types on the resource count toward
+// the probe.
+func (p *ApiServerSourceProbe) createReceiverChannel(event cloudevents.Event, namespace, name string) (string, func(), error) {
+	count, err := expectCount(event)
+	if err != nil {
+		return "", nil, err
+	}
+	channelID := apiServerChannelID(namespace, event.Type(), name)
+	if count > 1 {
+		channelID = apiServerResourceChannelID(namespace, name)
+	}
+	cleanupFunc, err := p.receivedEvents.ExpectReceiverChannel(channelID, count)
+	if err != nil {
+		return "", nil, fmt.Errorf("Failed to create receiver channel: %v", err)
+	}
+	return channelID, cleanupFunc, nil
+}
+
+// expectEventMode records the event mode in which the event of a probe is
+// expected to be delivered, if any, and returns the function which forgets it.
+func (p *ApiServerSourceProbe) expectEventMode(channelID, mode string) func() {
+	if mode == "" {
+		return func() {}
+	}
+	p.eventModesMu.Lock()
+	defer p.eventModesMu.Unlock()
+	p.eventModes[channelID] = mode
+	return func() {
+		p.eventModesMu.Lock()
+		defer p.eventModesMu.Unlock()
+		delete(p.eventModes, channelID)
+	}
+}
+
+// checkEventMode verifies that the data of an event delivered for a named
+// resource matches the event mode expected by its probe, if any. Events in
+// Reference mode carry no data, while events in Resource mode carry the full
+// resource.
+func (p *ApiServerSourceProbe) checkEventMode(channelID, name string, event cloudevents.Event) error {
+	p.eventModesMu.RLock()
+	mode, ok := p.eventModes[channelID]
+	p.eventModesMu.RUnlock()
+	if !ok {
+		return nil
+	}
+	switch mode {
+	case sourcesv1.ReferenceMode:
+		if len(event.Data()) != 0 {
+			return fmt.Errorf("expected event in %s mode without data, got %d bytes of data", mode, len(event.Data()))
+		}
+	case sourcesv1.ResourceMode:
+		var object metav1.PartialObjectMetadata
+		if err := json.Unmarshal(event.Data(), &object); err != nil {
+			return fmt.Errorf("expected event in %s mode carrying the resource, got undecodable data: %v", mode, err)
+		}
+		if object.Name != name {
+			return fmt.Errorf("expected event in %s mode carrying the resource '%s', got resource '%s'", mode, name, object.Name)
+		}
+	}
+	return nil
+}
+
+// Validate checks the resource kind, the event mode and the expected count of
+// events of the event, if any.
+func (p *ApiServerSourceProbe) Validate(event cloudevents.Event) error {
+	if _, _, err := apiServerResource(event); err != nil {
+		return err
+	}
+	if _, err := apiServerEventMode(event); err != nil {
+		return err
+	}
+	_, err := expectCount(event)
+	return err
+}
+
+// Forward creates a resource in order to generate an ApiServerSource notification event.
 func (p *ApiServerSourceCreateProbe) Forward(ctx context.Context, event cloudevents.Event) error {
+	resource, name, err := apiServerResource(event)
+	if err != nil {
+		return err
+	}
+	mode, err := apiServerEventMode(event)
+	if err != nil {
+		return err
+	}
+
 	// Create the receiver channel
 	namespace := fmt.Sprint(event.Extensions()[utils.ProbeEventTargetPathExtension])[1:]
-	channelID := channelID(namespace, event.ID())
-	cleanupFunc, err := p.receivedEvents.CreateReceiverChannel(channelID)
+	channelID, cleanupFunc, err := p.createReceiverChannel(event, namespace, name)
 	if err != nil {
-		return fmt.Errorf("Failed to create receiver channel: %v", err)
+		return err
 	}
 	defer cleanupFunc()
+	defer p.expectEventMode(channelID, mode)()
 
-	// The probe creates a pod.
-	podName := fmt.Sprintf("%s.%s", testPodName, event.ID()[len(event.Type())+1:])
-	logging.FromContext(ctx).Infow("Creating pod", zap.String("podName", podName))
-	_, err = p.k8sClient.CoreV1().Pods(namespace).Create(ctx, &corev1.Pod{
-		ObjectMeta: metav1.ObjectMeta{
-			Name: podName,
-		},
-		Spec: corev1.PodSpec{
-			RestartPolicy: corev1.RestartPolicyNever,
-			Containers: []corev1.Container{
-				{
-					Name:            "busybox",
-					Image:           "busybox",
-					ImagePullPolicy: corev1.PullIfNotPresent,
+	switch resource {
+	case configMapsResource:
+		// The probe creates a config map.
+		logging.FromContext(ctx).Infow("Creating config map", zap.String("configMapName", name))
+		_, err = p.k8sClient.CoreV1().ConfigMaps(namespace).Create(ctx, &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: name,
+			},
+			Data: map[string]string{
+				"probe": "created",
+			},
+		}, metav1.CreateOptions{})
+		if err != nil {
+			return fmt.Errorf("Failed to create test config map: %v", err)
+		}
+	default:
+		// The probe creates a pod.
+		logging.FromContext(ctx).Infow("Creating pod", zap.String("podName", name))
+		_, err = p.k8sClient.CoreV1().Pods(namespace).Create(ctx, &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: name,
+			},
+			Spec: corev1.PodSpec{
+				RestartPolicy: corev1.RestartPolicyNever,
+				Containers: []corev1.Container{
+					{
+						Name:            "busybox",
+						Image:           "busybox",
+						ImagePullPolicy: corev1.PullIfNotPresent,
+					},
 				},
 			},
-		},
-	}, metav1.CreateOptions{})
-	if err != nil {
-		return fmt.Errorf("Failed to create test pod: %v", err)
+		}, metav1.CreateOptions{})
+		if err != nil {
+			return fmt.Errorf("Failed to create test pod: %v", err)
+		}
 	}
 
 	return p.receivedEvents.WaitOnReceiverChannel(ctx, channelID)
 }
 
-// Forward updates a pod in order to generate an ApiServerSource notification event.
+// Forward updates a resource in order to generate an ApiServerSource notification event.
 func (p *ApiServerSourceUpdateProbe) Forward(ctx context.Context, event cloudevents.Event) error {
+	resource, name, err := apiServerResource(event)
+	if err != nil {
+		return err
+	}
+	mode, err := apiServerEventMode(event)
+	if err != nil {
+		return err
+	}
+
 	// Create the receiver channel
 	namespace := fmt.Sprint(event.Extensions()[utils.ProbeEventTargetPathExtension])[1:]
-	channelID := channelID(namespace, event.ID())
-	cleanupFunc, err := p.receivedEvents.CreateReceiverChannel(channelID)
+	channelID, cleanupFunc, err := p.createReceiverChannel(event, namespace, name)
 	if err != nil {
-		return fmt.Errorf("Failed to create receiver channel: %v", err)
+		return err
 	}
 	defer cleanupFunc()
+	defer p.expectEventMode(channelID, mode)()
 
-	// The probe updates a pod.
-	podName := fmt.Sprintf("%s.%s", testPodName, event.ID()[len(event.Type())+1:])
-	logging.FromContext(ctx).Infow("Updating pod", zap.String("podName", podName))
-
-	_, err = p.k8sClient.CoreV1().Pods(namespace).Patch(ctx, podName, types.JSONPatchType, []byte(`[{"op": "replace", "path": "/spec/containers/0/image", "value":"alpine"}]`), metav1.PatchOptions{})
-	if err != nil {
-		return fmt.Errorf("Failed to update test pod: %v", err)
+	switch resource {
+	case configMapsResource:
+		// The probe updates a config map.
+		logging.FromContext(ctx).Infow("Updating config map", zap.String("configMapName", name))
+		_, err = p.k8sClient.CoreV1().ConfigMaps(namespace).Patch(ctx, name, types.JSONPatchType, []byte(`[{"op": "replace", "path": "/data/probe", "value":"updated"}]`), metav1.PatchOptions{})
+		if err != nil {
+			return fmt.Errorf("Failed to update test config map: %v", err)
+		}
+	default:
+		// The probe updates a pod.
+		logging.FromContext(ctx).Infow("Updating pod", zap.String("podName", name))
+		_, err = p.k8sClient.CoreV1().Pods(namespace).Patch(ctx, name, types.JSONPatchType, []byte(`[{"op": "replace", "path": "/spec/containers/0/image", "value":"alpine"}]`), metav1.PatchOptions{})
+		if err != nil {
+			return fmt.Errorf("Failed to update test pod: %v", err)
+		}
 	}
 
 	return p.receivedEvents.WaitOnReceiverChannel(ctx, channelID)
 }
 
-// Forward deletes a pod in order to generate an ApiServerSource notification event.
+// Forward deletes a resource in order to generate an ApiServerSource notification event.
 func (p *ApiServerSourceDeleteProbe) Forward(ctx context.Context, event cloudevents.Event) error {
+	resource, name, err := apiServerResource(event)
+	if err != nil {
+		return err
+	}
+	mode, err := apiServerEventMode(event)
+	if err != nil {
+		return err
+	}
+
 	// Create the receiver channel
 	namespace := fmt.Sprint(event.Extensions()[utils.ProbeEventTargetPathExtension])[1:]
-	channelID := channelID(namespace, event.ID())
-	cleanupFunc, err := p.receivedEvents.CreateReceiverChannel(channelID)
+	channelID, cleanupFunc, err := p.createReceiverChannel(event, namespace, name)
 	if err != nil {
-		return fmt.Errorf("Failed to create receiver channel: %v", err)
+		return err
 	}
 	defer cleanupFunc()
+	defer p.expectEventMode(channelID, mode)()
 
-	// The probe deletes a pod.
-	podName := fmt.Sprintf("%s.%s", testPodName, event.ID()[len(event.Type())+1:])
-	logging.FromContext(ctx).Infow("Deleting pod", zap.String("podName", podName))
-	err = p.k8sClient.CoreV1().Pods(namespace).Delete(ctx, podName, metav1.DeleteOptions{})
-	if err != nil {
-		return fmt.Errorf("Failed to delete test pod: %v", err)
+	switch resource {
+	case configMapsResource:
+		// The probe deletes a config map.
+		logging.FromContext(ctx).Infow("Deleting config map", zap.String("configMapName", name))
+		if err := p.k8sClient.CoreV1().ConfigMaps(namespace).Delete(ctx, name, metav1.DeleteOptions{}); err != nil {
+			return fmt.Errorf("Failed to delete test config map: %v", err)
+		}
+	default:
+		// The probe deletes a pod.
+		logging.FromContext(ctx).Infow("Deleting pod", zap.String("podName", name))
+		if err := p.k8sClient.CoreV1().Pods(namespace).Delete(ctx, name, metav1.DeleteOptions{}); err != nil {
+			return fmt.Errorf("Failed to delete test pod: %v", err)
+		}
 	}
 
 	return p.receivedEvents.WaitOnReceiverChannel(ctx, channelID)
@@ -196,13 +400,34 @@ func (p *ApiServerSourceProbe) Receive(ctx context.Context, event cloudevents.Ev
 	if len(sepNameExtension) != 2 {
 		return fmt.Errorf("Failed to read ApiServer event, unexpected name extension: %s", nameExtension)
 	}
-	eventID := sepNameExtension[1]
-	eventID = fmt.Sprintf("%s-%s", forwardType, eventID)
 	namespace := fmt.Sprint(event.Extensions()[utils.ProbeEventReceiverPathExtension])[1:]
-	channelID := channelID(namespace, eventID)
-	if err := p.receivedEvents.SignalReceiverChannel(channelID); err != nil {
-		return err
+
+	// The event is signaled both to the probe of its type and to the probe
+	// expecting several events on its resource, whichever is waiting on it.
+	// A redelivered event only counts once toward the expected events.
+	var signalErr error
+	signaled := false
+	for _, channelID := range []string{
+		apiServerChannelID(namespace, forwardType, nameExtension),
+		apiServerResourceChannelID(namespace, nameExtension),
+	} {
+		var err error
+		if modeErr := p.checkEventMode(channelID, nameExtension, event); modeErr != nil {
+			err = p.receivedEvents.FailReceiverChannel(channelID, modeErr)
+		} else {
+			err = p.receivedEvents.SignalReceiverChannelOnce(channelID, event.ID())
+		}
+		if err == nil {
+			signaled = true
+		} else if signalErr == nil {
+			signalErr = err
+		}
+	}
+	if !signaled {
+		return signalErr
 	}
-	logging.FromContext(ctx).Info("Successfully received ApiServerSource probe event")
+	// The test resource is named after the ID suffix of the probe.
+	probeID := fmt.Sprintf("%s-%s", forwardType, sepNameExtension[1])
+	logging.FromContext(utils.WithProbeIDLogger(ctx, probeID)).Info("Successfully received ApiServerSource probe event")
 	return nil
 }
@@ -0,0 +1,206 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	cecontext "github.com/cloudevents/sdk-go/v2/context"
+	"github.com/google/knative-gcp/test/test_images/probe_helper/utils"
+	"go.uber.org/zap"
+	"knative.dev/pkg/logging"
+)
+
+const (
+	// BrokerDLQProbeEventType is the CloudEvent type of broker dead letter
+	// queue probes.
+	BrokerDLQProbeEventType = "broker-dlq-probe"
+
+	// nacksExtension holds the number of deliveries of a broker DLQ probe
+	// event which are rejected by the receiver before it accepts one. If
+	// there is no such extension, every delivery is rejected.
+	nacksExtension = "nacks"
+	// dlqPathExtension holds the receiver path along which the dead lettered
+	// broker DLQ probe event is expected to be delivered.
+	dlqPathExtension = "dlqpath"
+
+	// defaultDLQPathSuffix is appended to the target path of a broker DLQ
+	// probe event to build its DLQ path if it has no dlqpath extension.
+	defaultDLQPathSuffix = "/dlq"
+)
+
+// NewBrokerDLQProbe creates the broker DLQ probe handler. The broker ingress
+// template is the same as the one of the broker e2e delivery probe.
+func NewBrokerDLQProbe(brokerIngressTemplate string, client CeForwardClient, store utils.CorrelationStore) (*BrokerDLQProbe, error) {
+	ingressTemplate, err := template.New("broker-ingress").Option("missingkey=error").Parse(brokerIngressTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse broker ingress template %q: %w", brokerIngressTemplate, err)
+	}
+	return &BrokerDLQProbe{
+		brokerIngressTemplate: ingressTemplate,
+		client:                client,
+		receivedEvents:        utils.NewSyncReceivedEvents(store, "broker-dlq"),
+		deliveries:            map[string]int{},
+	}, nil
+}
+
+// BrokerDLQProbe is the probe handler for probe requests in the broker DLQ
+// probe. The probe event is rejected by the receiver until the broker gives
+// up on it and sends it to the dead letter sink, which delivers it back to
+// the receiver along the DLQ path.
+type BrokerDLQProbe struct {
+	// The template from which the broker ingress target is built
+	brokerIngressTemplate *template.Template
+
+	// The client responsible for sending events to the BrokerCell Ingress
+	client CeForwardClient
+
+	// The map of received events to be tracked by the forwarder and receiver
+	receivedEvents utils.ReceivedEvents
+
+	// The number of deliveries of the in-flight probe events along their
+	// target path, keyed by their channel ID
+	deliveriesMu sync.Mutex
+	deliveries   map[string]int
+}
+
+// dlqPath returns the receiver path along which a dead lettered probe event
+// is expected to be delivered.
+func dlqPath(event cloudevents.Event) string {
+	if path, ok := event.Extensions()[dlqPathExtension]; ok {
+		return fmt.Sprint(path)
+	}
+	return fmt.Sprint(event.Extensions()[utils.ProbeEventTargetPathExtension]) + defaultDLQPathSuffix
+}
+
+// probeNacks returns the number of deliveries of a probe event to reject, or
+// false if every delivery is to be rejected.
+func probeNacks(event cloudevents.Event) (int, bool, error) {
+	value, ok := event.Extensions()[nacksExtension]
+	if !ok {
+		return 0, false, nil
+	}
+	nacks, err := strconv.Atoi(fmt.Sprint(value))
+	if err != nil || nacks < 0 {
+		return 0, false, fmt.Errorf("invalid '%s' extension %q", nacksExtension, fmt.Sprint(value))
+	}
+	return nacks, true, nil
+}
+
+// Validate checks that the event names the namespace of its broker, and that
+// its number of deliveries to reject is valid.
+func (p *BrokerDLQProbe) Validate(event cloudevents.Event) error {
+	if err := requireExtensions(event, "Broker DLQ", namespaceExtension); err != nil {
+		return err
+	}
+	_, _, err := probeNacks(event)
+	return err
+}
+
+// Forward sends an event to a given broker in a given namespace, and waits for
+// it to be delivered along its DLQ path.
+func (p *BrokerDLQProbe) Forward(ctx context.Context, event cloudevents.Event) error {
+	namespace, ok := event.Extensions()[namespaceExtension]
+	if !ok {
+		return fmt.Errorf("Broker DLQ probe event has no '%s' extension", namespaceExtension)
+	}
+	broker, ok := event.Extensions()[brokerExtension]
+	if !ok {
+		broker = defaultBroker
+	}
+	if _, _, err := probeNacks(event); err != nil {
+		return fmt.Errorf("Broker DLQ probe event has an %v", err)
+	}
+
+	// Create the receiver channel, which is signaled by the delivery along the
+	// DLQ path, or failed by an accepted delivery along the target path.
+	channelID := channelID(dlqPath(event), event.ID())
+	cleanupFunc, err := p.receivedEvents.CreateReceiverChannel(channelID)
+	if err != nil {
+		return fmt.Errorf("Failed to create receiver channel: %v", err)
+	}
+	defer cleanupFunc()
+	p.deliveriesMu.Lock()
+	p.deliveries[channelID] = 0
+	p.deliveriesMu.Unlock()
+	defer func() {
+		p.deliveriesMu.Lock()
+		delete(p.deliveries, channelID)
+		p.deliveriesMu.Unlock()
+	}()
+
+	// The probe sends the event to a given broker in a given namespace.
+	var target strings.Builder
+	if err := p.brokerIngressTemplate.Execute(&target, BrokerIngressTarget{
+		Namespace: fmt.Sprint(namespace),
+		Broker:    fmt.Sprint(broker),
+	}); err != nil {
+		return fmt.Errorf("Failed to build broker target: %v", err)
+	}
+	ctx = cecontext.WithTarget(ctx, target.String())
+	logging.FromContext(ctx).Infow("Sending event to broker target", zap.String("target", target.String()))
+	if res := p.client.Send(ctx, event); !cloudevents.IsACK(res) {
+		return fmt.Errorf("Could not send event to broker target '%s', got result %s", target.String(), res)
+	}
+
+	if err := p.receivedEvents.WaitOnReceiverChannel(ctx, channelID); err != nil {
+		return fmt.Errorf("Broker DLQ probe event was not dead lettered: %v", err)
+	}
+	return nil
+}
+
+// Receive rejects the deliveries of a probe event along its target path, and
+// signals the receiver channel once the event is delivered along its DLQ path.
+func (p *BrokerDLQProbe) Receive(ctx context.Context, event cloudevents.Event) error {
+	// The event is received as sent, with the extensions added by the broker
+	// when dead lettering it.
+	dlqPath := dlqPath(event)
+	channelID := channelID(dlqPath, event.ID())
+	if fmt.Sprint(event.Extensions()[utils.ProbeEventReceiverPathExtension]) == dlqPath {
+		if err := p.receivedEvents.SignalReceiverChannel(channelID); err != nil {
+			return err
+		}
+		logging.FromContext(utils.WithProbeIDLogger(ctx, event.ID())).Info("Successfully received dead lettered broker DLQ probe event")
+		return nil
+	}
+
+	nacks, ok, err := probeNacks(event)
+	if err != nil {
+		return err
+	}
+	p.deliveriesMu.Lock()
+	deliveries, tracked := p.deliveries[channelID]
+	if tracked {
+		p.deliveries[channelID] = deliveries + 1
+	}
+	p.deliveriesMu.Unlock()
+	if !tracked {
+		return fmt.Errorf("failed to track deliveries of non-existent channel:" + channelID)
+	}
+	if !ok || deliveries < nacks {
+		return ErrRedeliver
+	}
+
+	// The event is accepted, so the broker never dead letters it.
+	return p.receivedEvents.FailReceiverChannel(channelID, fmt.Errorf("event accepted after %d deliveries instead of being dead lettered", deliveries+1))
+}
@@ -19,9 +19,13 @@ package handlers
 import (
 	"context"
 	"fmt"
+	"strconv"
+	"strings"
+	"text/template"
 
 	cloudevents "github.com/cloudevents/sdk-go/v2"
 	cecontext "github.com/cloudevents/sdk-go/v2/context"
+	cehttp "github.com/cloudevents/sdk-go/v2/protocol/http"
 	"github.com/google/knative-gcp/test/test_images/probe_helper/utils"
 	"go.uber.org/zap"
 	"knative.dev/pkg/logging"
@@ -34,42 +38,128 @@ const (
 
 	brokerExtension    = "broker"
 	namespaceExtension = "namespace"
+
+	// The expectstatus extension holds the HTTP status code with which the
+	// broker ingress is expected to accept the event, e.g. 202. The probes
+	// with an expected status succeed as soon as their event is accepted with
+	// it, without waiting for it to be delivered back.
+	expectStatusExtension = "expectstatus"
+
+	// defaultBroker is the name of the broker which is probed if the probe
+	// event has no broker extension.
+	defaultBroker = "default"
 )
 
-func NewBrokerE2EDeliveryProbe(brokerCellIngressBaseURL string, client CeForwardClient) *BrokerE2EDeliveryProbe {
-	return &BrokerE2EDeliveryProbe{
-		brokerCellIngressBaseURL: brokerCellIngressBaseURL,
-		client:                   client,
-		receivedEvents:           utils.NewSyncReceivedEvents(),
+// BrokerIngressTarget holds the values with which the broker ingress template
+// is executed to build the target of a broker e2e delivery probe.
+type BrokerIngressTarget struct {
+	Namespace string
+	Broker    string
+}
+
+// NewBrokerE2EDeliveryProbe creates the broker e2e delivery probe handler. The
+// broker ingress template is a text/template which is executed with a
+// BrokerIngressTarget, e.g. 'http://broker-ingress.{{.Namespace}}.svc/{{.Namespace}}/{{.Broker}}'.
+func NewBrokerE2EDeliveryProbe(brokerIngressTemplate string, client CeForwardClient, store utils.CorrelationStore) (*BrokerE2EDeliveryProbe, error) {
+	ingressTemplate, err := template.New("broker-ingress").Option("missingkey=error").Parse(brokerIngressTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse broker ingress template %q: %w", brokerIngressTemplate, err)
 	}
+	return &BrokerE2EDeliveryProbe{
+		brokerIngressTemplate: ingressTemplate,
+		client:                client,
+		receivedEvents:        utils.NewSyncReceivedEvents(store, "broker-e2e-delivery"),
+	}, nil
 }
 
 // BrokerE2EDeliveryProbe is the probe handler for probe requests in the broker
 // e2e delivery probe.
 type BrokerE2EDeliveryProbe struct {
-	// The base URL for the BrokerCell Ingress
-	brokerCellIngressBaseURL string
+	// The template from which the broker ingress target is built
+	brokerIngressTemplate *template.Template
 
 	// The client responsible for sending events to the BrokerCell Ingress
 	client CeForwardClient
 
 	// The map of received events to be tracked by the forwarder and receiver
-	receivedEvents *utils.SyncReceivedEvents
+	receivedEvents utils.ReceivedEvents
 }
 
-// Forward sends an event to a given broker in a given namespace.
-func (p *BrokerE2EDeliveryProbe) Forward(ctx context.Context, event cloudevents.Event) error {
+// Validate checks that the event names the namespace of its broker, that it
+// carries the extensions it matches, that its expected content type is a
+// valid media type, and that it is correlated in a supported way.
+func (p *BrokerE2EDeliveryProbe) Validate(event cloudevents.Event) error {
+	if err := requireExtensions(event, "Broker e2e delivery", namespaceExtension); err != nil {
+		return err
+	}
+	if err := validateMatchExtensions(event, "Broker e2e delivery"); err != nil {
+		return err
+	}
+	if _, _, err := expectedContentType(event); err != nil {
+		return err
+	}
+	if _, _, err := expectedStatus(event); err != nil {
+		return err
+	}
+	return validateCorrelation(event)
+}
+
+// expectedStatus returns the HTTP status code with which the broker ingress is
+// expected to accept a probe event, held in its expectstatus extension, if
+// any.
+func expectedStatus(event cloudevents.Event) (int, bool, error) {
+	value, ok := event.Extensions()[expectStatusExtension]
+	if !ok {
+		return 0, false, nil
+	}
+	status, err := strconv.Atoi(fmt.Sprint(value))
+	if err != nil || status < 100 || status > 599 {
+		return 0, false, fmt.Errorf("invalid %s extension %q, want an HTTP status code", expectStatusExtension, value)
+	}
+	return status, true, nil
+}
+
+// brokerTarget returns the broker ingress target of a probe event, built from
+// its namespace and broker extensions.
+func (p *BrokerE2EDeliveryProbe) brokerTarget(event cloudevents.Event) (string, error) {
 	namespace, ok := event.Extensions()[namespaceExtension]
 	if !ok {
-		return fmt.Errorf("Broker e2e delivery probe event has no '%s' extension", namespaceExtension)
+		return "", fmt.Errorf("Broker e2e delivery probe event has no '%s' extension", namespaceExtension)
 	}
 	broker, ok := event.Extensions()[brokerExtension]
 	if !ok {
-		broker = "default"
+		broker = defaultBroker
+	}
+	var target strings.Builder
+	if err := p.brokerIngressTemplate.Execute(&target, BrokerIngressTarget{
+		Namespace: fmt.Sprint(namespace),
+		Broker:    fmt.Sprint(broker),
+	}); err != nil {
+		return "", fmt.Errorf("Failed to build broker target: %v", err)
+	}
+	return target.String(), nil
+}
+
+// Forward sends an event to a given broker in a given namespace.
+func (p *BrokerE2EDeliveryProbe) Forward(ctx context.Context, event cloudevents.Event) error {
+	target, err := p.brokerTarget(event)
+	if err != nil {
+		return err
+	}
+	status, ok, err := expectedStatus(event)
+	if err != nil {
+		return err
+	}
+	if ok {
+		return p.forwardExpectingStatus(ctx, event, target, status)
 	}
 
 	// Create the receiver channel
-	channelID := channelID(fmt.Sprint(event.Extensions()[utils.ProbeEventTargetPathExtension]), event.ID())
+	key, err := correlationKey(event)
+	if err != nil {
+		return err
+	}
+	channelID := channelID(fmt.Sprint(event.Extensions()[utils.ProbeEventTargetPathExtension]), key)
 	cleanupFunc, err := p.receivedEvents.CreateReceiverChannel(channelID)
 	if err != nil {
 		return fmt.Errorf("Failed to create receiver channel: %v", err)
@@ -77,16 +167,61 @@ func (p *BrokerE2EDeliveryProbe) Forward(ctx context.Context, event cloudevents.
 	defer cleanupFunc()
 
 	// The probe sends the event to a given broker in a given namespace.
-	target := fmt.Sprintf("%s/%s/%s", p.brokerCellIngressBaseURL, namespace, broker)
 	ctx = cecontext.WithTarget(ctx, target)
 	logging.FromContext(ctx).Infow("Sending event to broker target", zap.String("target", target))
-	if res := p.client.Send(ctx, event); !cloudevents.IsACK(res) {
+	if res := p.client.Send(ctx, withMatchValues(withExpectedContentType(event))); !cloudevents.IsACK(res) {
 		return fmt.Errorf("Could not send event to broker target '%s', got result %s", target, res)
 	}
 
 	return p.receivedEvents.WaitOnReceiverChannel(ctx, channelID)
 }
 
+// forwardExpectingStatus sends an event to a broker target, and checks that
+// the broker ingress accepts it with the expected HTTP status code, without
+// waiting for it to be delivered back.
+func (p *BrokerE2EDeliveryProbe) forwardExpectingStatus(ctx context.Context, event cloudevents.Event, target string, want int) error {
+	ctx = cecontext.WithTarget(ctx, target)
+	logging.FromContext(ctx).Infow("Sending event to broker target expecting status", zap.String("target", target), zap.Int("expectedStatus", want))
+	res := p.client.Send(ctx, withMatchValues(withExpectedContentType(event)))
+	status, ok := resultStatus(res)
+	if !ok {
+		return fmt.Errorf("Could not send event to broker target '%s', got result %s", target, res)
+	}
+	if status != want {
+		return fmt.Errorf("Broker target '%s' answered with status %d, want %d", target, status, want)
+	}
+	return nil
+}
+
+// resultStatus returns the HTTP status code of the result of sending an event,
+// which is that of its last attempt if it was retried.
+func resultStatus(res cloudevents.Result) (int, bool) {
+	var retries *cehttp.RetriesResult
+	if cloudevents.ResultAs(res, &retries) {
+		res = retries.Result
+	}
+	var httpResult *cehttp.Result
+	if !cloudevents.ResultAs(res, &httpResult) {
+		return 0, false
+	}
+	return httpResult.StatusCode, true
+}
+
+// Warmup sends an event to a given broker in a given namespace, which opens
+// the connection to its ingress, without waiting for it to be delivered back.
+func (p *BrokerE2EDeliveryProbe) Warmup(ctx context.Context, event cloudevents.Event) error {
+	target, err := p.brokerTarget(event)
+	if err != nil {
+		return err
+	}
+	ctx = cecontext.WithTarget(ctx, target)
+	logging.FromContext(ctx).Infow("Sending warmup event to broker target", zap.String("target", target))
+	if res := p.client.Send(ctx, event); !cloudevents.IsACK(res) {
+		return fmt.Errorf("Could not send warmup event to broker target '%s', got result %s", target, res)
+	}
+	return nil
+}
+
 // Receive closes the receiver channel associated with a particular event.
 func (p *BrokerE2EDeliveryProbe) Receive(ctx context.Context, event cloudevents.Event) error {
 	// The event is received as sent.
@@ -104,10 +239,22 @@ func (p *BrokerE2EDeliveryProbe) Receive(ctx context.Context, event cloudevents.
 	//     traceparent: 00-82b13494f5bcddc7b3007a7cd7668267-64e23f1193ceb1b7-00
 	//   Data,
 	//     { ... }
-	channelID := channelID(fmt.Sprint(event.Extensions()[utils.ProbeEventReceiverPathExtension]), event.ID())
+	key, err := receivedCorrelationKey(event)
+	if err != nil {
+		return err
+	}
+	channelID := channelID(fmt.Sprint(event.Extensions()[utils.ProbeEventReceiverPathExtension]), key)
+	// The probe fails if its matched extensions or its content type are not
+	// delivered intact.
+	if err := checkMatchValues(event); err != nil {
+		return p.receivedEvents.FailReceiverChannel(channelID, err)
+	}
+	if err := checkContentType(event); err != nil {
+		return p.receivedEvents.FailReceiverChannel(channelID, err)
+	}
 	if err := p.receivedEvents.SignalReceiverChannel(channelID); err != nil {
 		return err
 	}
-	logging.FromContext(ctx).Info("Successfully received broker e2e delivery probe event")
+	logging.FromContext(utils.WithProbeIDLogger(ctx, event.ID())).Info("Successfully received broker e2e delivery probe event")
 	return nil
 }
@@ -0,0 +1,149 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"text/template"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/cloudevents/sdk-go/v2/binding"
+	"github.com/cloudevents/sdk-go/v2/binding/spec"
+	cecontext "github.com/cloudevents/sdk-go/v2/context"
+	cehttp "github.com/cloudevents/sdk-go/v2/protocol/http"
+	"go.uber.org/zap"
+	"knative.dev/pkg/logging"
+)
+
+// BrokerRejectProbeEventType is the CloudEvent type of broker reject probes.
+const BrokerRejectProbeEventType = "broker-reject-probe"
+
+// NewBrokerRejectProbe creates the broker reject probe handler. The broker
+// ingress template is the same as the one of the broker e2e delivery probe.
+func NewBrokerRejectProbe(brokerIngressTemplate string, sender CeForwardSender) (*BrokerRejectProbe, error) {
+	ingressTemplate, err := template.New("broker-ingress").Option("missingkey=error").Parse(brokerIngressTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse broker ingress template %q: %w", brokerIngressTemplate, err)
+	}
+	return &BrokerRejectProbe{
+		brokerIngressTemplate: ingressTemplate,
+		sender:                sender,
+	}, nil
+}
+
+// BrokerRejectProbe is the probe handler for probe requests in the broker
+// reject probe. The probe event is sent to the broker without its type
+// attribute, and the probe succeeds once the broker ingress rejects it.
+type BrokerRejectProbe struct {
+	// The template from which the broker ingress target is built
+	brokerIngressTemplate *template.Template
+
+	// The sender responsible for sending malformed events to the BrokerCell
+	// Ingress, which the forward client would refuse to send
+	sender CeForwardSender
+}
+
+// untypedMessage is the binary mode message of an event without its type
+// attribute.
+type untypedMessage struct {
+	*binding.EventMessage
+}
+
+// untypedWriter drops the type attribute of the messages it writes.
+type untypedWriter struct {
+	binding.BinaryWriter
+}
+
+func (w untypedWriter) SetAttribute(attribute spec.Attribute, value interface{}) error {
+	if attribute.Kind() == spec.Type {
+		return nil
+	}
+	return w.BinaryWriter.SetAttribute(attribute, value)
+}
+
+func (m untypedMessage) ReadEncoding() binding.Encoding {
+	return binding.EncodingBinary
+}
+
+func (m untypedMessage) ReadStructured(context.Context, binding.StructuredWriter) error {
+	return binding.ErrNotStructured
+}
+
+func (m untypedMessage) ReadBinary(ctx context.Context, writer binding.BinaryWriter) error {
+	return m.EventMessage.ReadBinary(ctx, untypedWriter{writer})
+}
+
+// isRejection returns whether a status code rejects a malformed event. The
+// broker ingress answers 404 when the broker does not exist and 429 when it
+// throttles its senders, neither of which tells whether the event is
+// malformed.
+func isRejection(statusCode int) bool {
+	return statusCode/100 == 4 && statusCode != http.StatusNotFound && statusCode != http.StatusTooManyRequests
+}
+
+// Validate checks that the event names the namespace of its broker.
+func (p *BrokerRejectProbe) Validate(event cloudevents.Event) error {
+	return requireExtensions(event, "Broker reject", namespaceExtension)
+}
+
+// Forward sends an event without its type attribute to a given broker in a
+// given namespace, and checks that the broker ingress rejects it.
+func (p *BrokerRejectProbe) Forward(ctx context.Context, event cloudevents.Event) error {
+	namespace, ok := event.Extensions()[namespaceExtension]
+	if !ok {
+		return fmt.Errorf("Broker reject probe event has no '%s' extension", namespaceExtension)
+	}
+	broker, ok := event.Extensions()[brokerExtension]
+	if !ok {
+		broker = defaultBroker
+	}
+
+	// The probe sends the event to a given broker in a given namespace.
+	var target strings.Builder
+	if err := p.brokerIngressTemplate.Execute(&target, BrokerIngressTarget{
+		Namespace: fmt.Sprint(namespace),
+		Broker:    fmt.Sprint(broker),
+	}); err != nil {
+		return fmt.Errorf("Failed to build broker target: %v", err)
+	}
+	ctx = cecontext.WithTarget(ctx, target.String())
+	// The rejection is the expected response, so the event is sent only once.
+	ctx = cecontext.WithRetryParams(ctx, &cecontext.RetryParams{Strategy: cecontext.BackoffStrategyNone})
+	logging.FromContext(ctx).Infow("Sending malformed event to broker target", zap.String("target", target.String()))
+	res := p.sender.Send(ctx, untypedMessage{(*binding.EventMessage)(&event)})
+	var httpResult *cehttp.Result
+	if !errors.As(res, &httpResult) {
+		return fmt.Errorf("Could not send malformed event to broker target '%s', got result %s", target.String(), res)
+	}
+	if httpResult.StatusCode/100 == 2 {
+		return fmt.Errorf("Broker target '%s' accepted malformed event with status %d", target.String(), httpResult.StatusCode)
+	}
+	if !isRejection(httpResult.StatusCode) {
+		return fmt.Errorf("Broker target '%s' did not reject malformed event, got status %d", target.String(), httpResult.StatusCode)
+	}
+	return nil
+}
+
+// Receive fails on the delivery of a probe event, which should have been
+// rejected by the broker ingress.
+func (p *BrokerRejectProbe) Receive(ctx context.Context, event cloudevents.Event) error {
+	return fmt.Errorf("received broker reject probe event which should have been rejected: %s", event.ID())
+}
@@ -0,0 +1,156 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"text/template"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	cecontext "github.com/cloudevents/sdk-go/v2/context"
+	"github.com/google/knative-gcp/test/test_images/probe_helper/utils"
+	"go.uber.org/zap"
+	"knative.dev/pkg/logging"
+)
+
+const (
+	// ChannelE2EDeliveryProbeEventType is the CloudEvent type of channel e2e
+	// delivery probes.
+	ChannelE2EDeliveryProbeEventType = "channel-e2e-delivery-probe"
+
+	channelExtension = "channel"
+)
+
+// ChannelIngressTemplate is the text/template from which the target of a
+// channel e2e delivery probe is built.
+type ChannelIngressTemplate string
+
+// ChannelIngressTarget holds the values with which the channel ingress
+// template is executed to build the target of a channel e2e delivery probe.
+type ChannelIngressTarget struct {
+	Namespace string
+	Channel   string
+}
+
+// NewChannelE2EDeliveryProbe creates the channel e2e delivery probe handler.
+// The channel ingress template is executed with a ChannelIngressTarget, e.g.
+// 'http://{{.Channel}}-kn-channel.{{.Namespace}}.svc.cluster.local'.
+func NewChannelE2EDeliveryProbe(channelIngressTemplate ChannelIngressTemplate, client CeForwardClient, store utils.CorrelationStore) (*ChannelE2EDeliveryProbe, error) {
+	ingressTemplate, err := template.New("channel-ingress").Option("missingkey=error").Parse(string(channelIngressTemplate))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse channel ingress template %q: %w", channelIngressTemplate, err)
+	}
+	return &ChannelE2EDeliveryProbe{
+		channelIngressTemplate: ingressTemplate,
+		client:                 client,
+		receivedEvents:         utils.NewSyncReceivedEvents(store, "channel-e2e-delivery"),
+	}, nil
+}
+
+// ChannelE2EDeliveryProbe is the probe handler for probe requests in the
+// channel e2e delivery probe.
+type ChannelE2EDeliveryProbe struct {
+	// The template from which the channel ingress target is built
+	channelIngressTemplate *template.Template
+
+	// The client responsible for sending events to the channel ingress
+	client CeForwardClient
+
+	// The map of received events to be tracked by the forwarder and receiver
+	receivedEvents utils.ReceivedEvents
+}
+
+// Validate checks that the event names its channel and its namespace, that it
+// carries the extensions it matches, that its expected content type is a
+// valid media type, and that it is correlated in a supported way.
+func (p *ChannelE2EDeliveryProbe) Validate(event cloudevents.Event) error {
+	if err := requireExtensions(event, "Channel e2e delivery", namespaceExtension, channelExtension); err != nil {
+		return err
+	}
+	if err := validateMatchExtensions(event, "Channel e2e delivery"); err != nil {
+		return err
+	}
+	if _, _, err := expectedContentType(event); err != nil {
+		return err
+	}
+	return validateCorrelation(event)
+}
+
+// Forward sends an event to a given channel in a given namespace.
+func (p *ChannelE2EDeliveryProbe) Forward(ctx context.Context, event cloudevents.Event) error {
+	namespace, ok := event.Extensions()[namespaceExtension]
+	if !ok {
+		return fmt.Errorf("Channel e2e delivery probe event has no '%s' extension", namespaceExtension)
+	}
+	channel, ok := event.Extensions()[channelExtension]
+	if !ok {
+		return fmt.Errorf("Channel e2e delivery probe event has no '%s' extension", channelExtension)
+	}
+
+	// Create the receiver channel
+	key, err := correlationKey(event)
+	if err != nil {
+		return err
+	}
+	channelID := channelID(fmt.Sprint(event.Extensions()[utils.ProbeEventTargetPathExtension]), key)
+	cleanupFunc, err := p.receivedEvents.CreateReceiverChannel(channelID)
+	if err != nil {
+		return fmt.Errorf("Failed to create receiver channel: %v", err)
+	}
+	defer cleanupFunc()
+
+	// The probe sends the event to a given channel in a given namespace.
+	var target strings.Builder
+	if err := p.channelIngressTemplate.Execute(&target, ChannelIngressTarget{
+		Namespace: fmt.Sprint(namespace),
+		Channel:   fmt.Sprint(channel),
+	}); err != nil {
+		return fmt.Errorf("Failed to build channel target: %v", err)
+	}
+	ctx = cecontext.WithTarget(ctx, target.String())
+	logging.FromContext(ctx).Infow("Sending event to channel target", zap.String("target", target.String()))
+	if res := p.client.Send(ctx, withMatchValues(withExpectedContentType(event))); !cloudevents.IsACK(res) {
+		return fmt.Errorf("Could not send event to channel target '%s', got result %s", target.String(), res)
+	}
+
+	return p.receivedEvents.WaitOnReceiverChannel(ctx, channelID)
+}
+
+// Receive closes the receiver channel associated with a particular event.
+func (p *ChannelE2EDeliveryProbe) Receive(ctx context.Context, event cloudevents.Event) error {
+	// The event is received as sent, through a subscription to the channel.
+	key, err := receivedCorrelationKey(event)
+	if err != nil {
+		return err
+	}
+	channelID := channelID(fmt.Sprint(event.Extensions()[utils.ProbeEventReceiverPathExtension]), key)
+	// The probe fails if its matched extensions or its content type are not
+	// delivered intact.
+	if err := checkMatchValues(event); err != nil {
+		return p.receivedEvents.FailReceiverChannel(channelID, err)
+	}
+	if err := checkContentType(event); err != nil {
+		return p.receivedEvents.FailReceiverChannel(channelID, err)
+	}
+	if err := p.receivedEvents.SignalReceiverChannel(channelID); err != nil {
+		return err
+	}
+	logging.FromContext(utils.WithProbeIDLogger(ctx, event.ID())).Info("Successfully received channel e2e delivery probe event")
+	return nil
+}
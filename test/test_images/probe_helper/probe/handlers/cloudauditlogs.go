@@ -20,6 +20,7 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"time"
 
 	"cloud.google.com/go/pubsub"
 	cloudevents "github.com/cloudevents/sdk-go/v2"
@@ -33,13 +34,27 @@ const (
 	// CloudAuditLogsSourceProbeEventType is the CloudEvent type of forward
 	// CloudAuditLogsSource probes.
 	CloudAuditLogsSourceProbeEventType = "cloudauditlogssource-probe"
+
+	// CloudAuditLogsSourceDeleteProbeEventType is the CloudEvent type of forward
+	// CloudAuditLogsSource topic deletion probes.
+	CloudAuditLogsSourceDeleteProbeEventType = "cloudauditlogssource-probe-delete"
+
+	// The audit logged methods of the Pub/Sub topics created and deleted by the
+	// probes, as held in the methodname extension.
+	createTopicMethodName = "google.pubsub.v1.Publisher.CreateTopic"
+	deleteTopicMethodName = "google.pubsub.v1.Publisher.DeleteTopic"
 )
 
-func NewCloudAuditLogsSourceProbe(projectID clients.ProjectID, pubsubClient *pubsub.Client) *CloudAuditLogsSourceProbe {
+// AuditLogsPollInterval is the interval at which the existence of the Pub/Sub
+// topics deleted by the CloudAuditLogsSource probes is polled.
+type AuditLogsPollInterval time.Duration
+
+func NewCloudAuditLogsSourceProbe(projectID clients.ProjectID, pubsubClient *pubsub.Client, pollInterval AuditLogsPollInterval, store utils.CorrelationStore) *CloudAuditLogsSourceProbe {
 	return &CloudAuditLogsSourceProbe{
 		projectID:      projectID,
 		pubsubClient:   pubsubClient,
-		receivedEvents: utils.NewSyncReceivedEvents(),
+		pollInterval:   time.Duration(pollInterval),
+		receivedEvents: utils.NewSyncReceivedEvents(store, "cloudauditlogssource"),
 	}
 }
 
@@ -53,14 +68,28 @@ type CloudAuditLogsSourceProbe struct {
 	// probe and used for the CloudAuditLogsSource probe
 	pubsubClient *pubsub.Client
 
+	// The interval at which the existence of deleted topics is polled
+	pollInterval time.Duration
+
 	// The map of received events to be tracked by the forwarder and receiver
-	receivedEvents *utils.SyncReceivedEvents
+	receivedEvents utils.ReceivedEvents
+}
+
+type CloudAuditLogsSourceDeleteProbe struct {
+	*CloudAuditLogsSourceProbe
+}
+
+// auditLogsChannelID returns the ID of the receiver channel of the probe which
+// waits on a method to be logged for a topic. The method is part of the ID for
+// the create and delete probes of the same topic not to cross-match.
+func auditLogsChannelID(prefix, methodName, topic string) string {
+	return channelID(prefix, fmt.Sprintf("%s/%s", methodName, topic))
 }
 
 // Forward creates a Pub/Sub topic in order to generate a Cloud Audit Logs notification event.
 func (p *CloudAuditLogsSourceProbe) Forward(ctx context.Context, event cloudevents.Event) error {
 	// Create the receiver channel
-	channelID := channelID(fmt.Sprint(event.Extensions()[utils.ProbeEventTargetPathExtension]), event.ID())
+	channelID := auditLogsChannelID(fmt.Sprint(event.Extensions()[utils.ProbeEventTargetPathExtension]), createTopicMethodName, event.ID())
 	cleanupFunc, err := p.receivedEvents.CreateReceiverChannel(channelID)
 	if err != nil {
 		return fmt.Errorf("Failed to create receiver channel: %v", err)
@@ -77,10 +106,70 @@ func (p *CloudAuditLogsSourceProbe) Forward(ctx context.Context, event cloudeven
 	return p.receivedEvents.WaitOnReceiverChannel(ctx, channelID)
 }
 
+// Forward deletes a Pub/Sub topic in order to generate a Cloud Audit Logs
+// notification event. The topic is the one created by the create probe with the
+// same ID suffix, and is created first if it does not exist.
+func (p *CloudAuditLogsSourceDeleteProbe) Forward(ctx context.Context, event cloudevents.Event) error {
+	topicID := fmt.Sprintf("%s-%s", CloudAuditLogsSourceProbeEventType, event.ID()[len(event.Type())+1:])
+
+	// Create the receiver channel
+	channelID := auditLogsChannelID(fmt.Sprint(event.Extensions()[utils.ProbeEventTargetPathExtension]), deleteTopicMethodName, topicID)
+	cleanupFunc, err := p.receivedEvents.CreateReceiverChannel(channelID)
+	if err != nil {
+		return fmt.Errorf("Failed to create receiver channel: %v", err)
+	}
+	defer cleanupFunc()
+
+	topic := p.pubsubClient.Topic(topicID)
+	exists, err := topic.Exists(ctx)
+	if err != nil {
+		return fmt.Errorf("Failed to determine existence of pubsub topic '%s': %v", topicID, err)
+	}
+	if !exists {
+		logging.FromContext(ctx).Infow("Creating pubsub topic", zap.String("topic", topicID))
+		if topic, err = p.pubsubClient.CreateTopic(ctx, topicID); err != nil {
+			return fmt.Errorf("Failed to create pubsub topic '%s': %v", topicID, err)
+		}
+	}
+
+	// The probe deletes the Pub/Sub topic.
+	logging.FromContext(ctx).Infow("Deleting pubsub topic", zap.String("topic", topicID))
+	if err := topic.Delete(ctx); err != nil {
+		return fmt.Errorf("Failed to delete pubsub topic '%s': %v", topicID, err)
+	}
+	if err := p.waitOnTopicDeletion(ctx, topic); err != nil {
+		return err
+	}
+
+	return p.receivedEvents.WaitOnReceiverChannel(ctx, channelID)
+}
+
+// waitOnTopicDeletion polls the existence of a deleted Pub/Sub topic until it
+// disappears, or the probe times out.
+func (p *CloudAuditLogsSourceProbe) waitOnTopicDeletion(ctx context.Context, topic *pubsub.Topic) error {
+	ticker := time.NewTicker(p.pollInterval)
+	defer ticker.Stop()
+	for {
+		exists, err := topic.Exists(ctx)
+		if err != nil {
+			return fmt.Errorf("Failed to determine existence of pubsub topic '%s': %v", topic.ID(), err)
+		}
+		if !exists {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("Timed out waiting on pubsub topic '%s' to be deleted: %v", topic.ID(), ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
 // Receive closes the receiver channel associated with a Cloud Audit Logs notification event.
 func (p *CloudAuditLogsSourceProbe) Receive(ctx context.Context, event cloudevents.Event) error {
 	// The logged event type is held in the methodname extension. For creation
-	// of pubsub topics, the topic ID can be extracted from the event subject.
+	// and deletion of pubsub topics, the topic ID can be extracted from the
+	// event subject.
 	if _, ok := event.Extensions()["methodname"]; !ok {
 		return fmt.Errorf("Failed to read Cloud AuditLogs event, missing 'methodname' extension")
 	}
@@ -89,8 +178,8 @@ func (p *CloudAuditLogsSourceProbe) Receive(ctx context.Context, event cloudeven
 		return fmt.Errorf("Failed to read Cloud AuditLogs event, unexpected event subject")
 	}
 	methodname := fmt.Sprint(event.Extensions()["methodname"])
-	var eventID string
-	if methodname == "google.pubsub.v1.Publisher.CreateTopic" {
+	switch methodname {
+	case createTopicMethodName, deleteTopicMethodName:
 		// Example:
 		//   Context Attributes,
 		//     specversion: 1.0
@@ -107,14 +196,19 @@ func (p *CloudAuditLogsSourceProbe) Receive(ctx context.Context, event cloudeven
 		//     servicename: pubsub.googleapis.com
 		//   Data,
 		//     { ... }
-		eventID = sepSub[4]
-	} else {
+	default:
 		return fmt.Errorf("Failed to read Cloud AuditLogs event, unrecognized 'methodname' extension: %s", methodname)
 	}
-	channelID := channelID(fmt.Sprint(event.Extensions()[utils.ProbeEventReceiverPathExtension]), eventID)
+	channelID := auditLogsChannelID(fmt.Sprint(event.Extensions()[utils.ProbeEventReceiverPathExtension]), methodname, sepSub[4])
 	if err := p.receivedEvents.SignalReceiverChannel(channelID); err != nil {
 		return err
 	}
-	logging.FromContext(ctx).Info("Successfully received CloudAuditLogsSource probe event")
+	// The topic is named after the create probe, and shares its ID suffix
+	// with the delete probe.
+	probeID := sepSub[4]
+	if methodname == deleteTopicMethodName {
+		probeID = CloudAuditLogsSourceDeleteProbeEventType + strings.TrimPrefix(probeID, CloudAuditLogsSourceProbeEventType)
+	}
+	logging.FromContext(utils.WithProbeIDLogger(ctx, probeID)).Info("Successfully received CloudAuditLogsSource probe event")
 	return nil
 }
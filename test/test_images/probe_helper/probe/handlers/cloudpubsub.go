@@ -20,9 +20,11 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"strconv"
 
 	cloudevents "github.com/cloudevents/sdk-go/v2"
 	cecontext "github.com/cloudevents/sdk-go/v2/context"
+	adaptercontext "github.com/google/knative-gcp/pkg/pubsub/adapter/context"
 	schemasv1 "github.com/google/knative-gcp/pkg/schemas/v1"
 	"github.com/google/knative-gcp/test/test_images/probe_helper/utils"
 	"go.uber.org/zap"
@@ -35,12 +37,18 @@ const (
 	CloudPubSubSourceProbeEventType = "cloudpubsubsource-probe"
 
 	topicExtension = "topic"
+
+	// The subscription extension holds the ID of the subscription through
+	// which the CloudPubSubSource is expected to deliver the message of a
+	// probe.
+	subscriptionExtension = "subscription"
 )
 
-func NewCloudPubSubSourceProbe(cePubsubClient CePubSubClient) *CloudPubSubSourceProbe {
+func NewCloudPubSubSourceProbe(cePubsubClient CePubSubClient, store utils.CorrelationStore) *CloudPubSubSourceProbe {
 	return &CloudPubSubSourceProbe{
 		cePubsubClient: cePubsubClient,
-		receivedEvents: utils.NewSyncReceivedEvents(),
+		receivedEvents: utils.NewSyncReceivedEvents(store, "cloudpubsubsource"),
+		sequences:      &orderedSequences{sequences: map[string]*orderedSequence{}},
 	}
 }
 
@@ -53,13 +61,42 @@ type CloudPubSubSourceProbe struct {
 	cePubsubClient CePubSubClient
 
 	// The map of received events to be tracked by the forwarder and receiver
-	receivedEvents *utils.SyncReceivedEvents
+	receivedEvents utils.ReceivedEvents
+
+	// The order of arrival of the sequences of the ordering probes in flight
+	sequences *orderedSequences
+}
+
+// Validate checks that the event names the topic to publish to.
+func (p *CloudPubSubSourceProbe) Validate(event cloudevents.Event) error {
+	return requireExtensions(event, "CloudPubSubSource", topicExtension)
+}
+
+// pubSubSubscription returns the ID of the subscription through which the
+// message of a probe is expected to be delivered, held in its subscription
+// extension. The subscription of the adapter context is the default, and the
+// message can be delivered through any subscription if there is none.
+func pubSubSubscription(ctx context.Context, event cloudevents.Event) string {
+	if subscription, ok := event.Extensions()[subscriptionExtension]; ok {
+		return fmt.Sprint(subscription)
+	}
+	subscription, _ := adaptercontext.GetSubscriptionKey(ctx)
+	return subscription
+}
+
+// pubSubChannelID returns the ID of the receiver channel of the probe of a
+// given ID whose message is delivered through a given subscription, if any.
+func pubSubChannelID(prefix, subscription, eventID string) string {
+	if subscription == "" {
+		return channelID(prefix, eventID)
+	}
+	return channelID(prefix, fmt.Sprintf("%s/%s", subscription, eventID))
 }
 
 // Forward publishes to Pub/Sub in order to generate a notification event.
 func (p *CloudPubSubSourceProbe) Forward(ctx context.Context, event cloudevents.Event) error {
 	// Create the receiver channel
-	channelID := channelID(fmt.Sprint(event.Extensions()[utils.ProbeEventTargetPathExtension]), event.ID())
+	channelID := pubSubChannelID(fmt.Sprint(event.Extensions()[utils.ProbeEventTargetPathExtension]), pubSubSubscription(ctx, event), event.ID())
 	cleanupFunc, err := p.receivedEvents.CreateReceiverChannel(channelID)
 	if err != nil {
 		return fmt.Errorf("Failed to create receiver channel: %v", err)
@@ -80,6 +117,21 @@ func (p *CloudPubSubSourceProbe) Forward(ctx context.Context, event cloudevents.
 	return p.receivedEvents.WaitOnReceiverChannel(ctx, channelID)
 }
 
+// Warmup publishes an event to a given Pub/Sub topic, which opens the
+// connection to Pub/Sub, without waiting for its notification event.
+func (p *CloudPubSubSourceProbe) Warmup(ctx context.Context, event cloudevents.Event) error {
+	topic, ok := event.Extensions()[topicExtension]
+	if !ok {
+		return fmt.Errorf("CloudPubSubSource probe event has no '%s' extension", topicExtension)
+	}
+	ctx = cecontext.WithTopic(ctx, fmt.Sprint(topic))
+	logging.FromContext(ctx).Infow("Publishing warmup message to pubsub topic", zap.String("topic", fmt.Sprint(topic)))
+	if res := p.cePubsubClient.Send(ctx, event); !cloudevents.IsACK(res) {
+		return fmt.Errorf("Failed sending warmup event to topic %s, got result %s", topic, res)
+	}
+	return nil
+}
+
 // Receive closes the receiver channel associated with a Pub/Sub notification event.
 func (p *CloudPubSubSourceProbe) Receive(ctx context.Context, event cloudevents.Event) error {
 	// The original event is wrapped into a pubsub Message by the CloudEvents
@@ -113,14 +165,40 @@ func (p *CloudPubSubSourceProbe) Receive(ctx context.Context, event cloudevents.
 	if err := json.Unmarshal(event.Data(), &msgData); err != nil {
 		return fmt.Errorf("Error unmarshalling Pub/Sub message from event data: %v", err)
 	}
+	// The messages published by warmup probes are dropped.
+	if warmup, err := strconv.ParseBool(msgData.Message.Attributes["ce-"+utils.ProbeEventWarmupExtension]); err == nil && warmup {
+		logging.FromContext(ctx).Debugw("Dropping warmup probe event")
+		return nil
+	}
+	receiverPath := fmt.Sprint(event.Extensions()[utils.ProbeEventReceiverPathExtension])
+	// The events of the sequences of the ordering probes are recorded in their
+	// order of arrival.
+	if _, ok := msgData.Message.Attributes["ce-"+orderingKeyExtension]; ok {
+		return p.receiveOrdered(ctx, receiverPath, msgData.Message.Attributes)
+	}
 	eventID, ok := msgData.Message.Attributes["ce-id"]
 	if !ok {
 		return fmt.Errorf("Failed to read probe event ID from Pub/Sub message attributes")
 	}
-	channelID := channelID(fmt.Sprint(event.Extensions()[utils.ProbeEventReceiverPathExtension]), eventID)
-	if err := p.receivedEvents.SignalReceiverChannel(channelID); err != nil {
-		return err
+	// The event is signaled both to the probe expecting it through the
+	// subscription which delivered it and to the probe expecting it through
+	// any subscription, whichever is waiting on it.
+	channelIDs := []string{pubSubChannelID(receiverPath, "", eventID)}
+	if msgData.Subscription != "" {
+		channelIDs = append(channelIDs, pubSubChannelID(receiverPath, msgData.Subscription, eventID))
+	}
+	var signalErr error
+	signaled := false
+	for _, channelID := range channelIDs {
+		if err := p.receivedEvents.SignalReceiverChannel(channelID); err == nil {
+			signaled = true
+		} else if signalErr == nil {
+			signalErr = err
+		}
+	}
+	if !signaled {
+		return signalErr
 	}
-	logging.FromContext(ctx).Info("Successfully received CloudPubSubSource probe event")
+	logging.FromContext(utils.WithProbeIDLogger(ctx, eventID)).Info("Successfully received CloudPubSubSource probe event")
 	return nil
 }
@@ -22,8 +22,10 @@ import (
 	"time"
 
 	cloudevents "github.com/cloudevents/sdk-go/v2"
+	schemasv1 "github.com/google/knative-gcp/pkg/schemas/v1"
 	"github.com/google/knative-gcp/test/test_images/probe_helper/utils"
 	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/util/clock"
 	"knative.dev/pkg/logging"
 )
 
@@ -33,14 +35,26 @@ const (
 	CloudSchedulerSourceProbeEventType = "cloudschedulersource-probe"
 
 	cloudSchedulerPeriodExtension = "period"
+	cloudSchedulerJobExtension    = "job"
 )
 
-func NewCloudSchedulerSourceProbe(staleDuration time.Duration) *CloudSchedulerSourceProbe {
+// cloudSchedulerTimestampID returns the key of the time of the latest tick of
+// a scheduler job in a given scope. Ticks of any job are keyed by the scope.
+func cloudSchedulerTimestampID(scope string, subject string) string {
+	if subject == "" {
+		return scope
+	}
+	return scope + "/" + subject
+}
+
+func NewCloudSchedulerSourceProbe(staleDuration time.Duration, clock clock.Clock) *CloudSchedulerSourceProbe {
 	return &CloudSchedulerSourceProbe{
 		EventTimes: utils.SyncTimesMap{
 			Times: map[string]time.Time{},
 		},
 		StaleDuration: staleDuration,
+		clock:         clock,
+		tickCounts:    map[string]int64{},
 	}
 }
 
@@ -53,33 +67,43 @@ type CloudSchedulerSourceProbe struct {
 	// StaleDuration is the duration after which entries in the EventTimes map are
 	// considered stale and should be cleaned up in the liveness probe.
 	StaleDuration time.Duration
+
+	// The clock with which the ticks are timed
+	clock clock.Clock
+
+	// The probes waiting on the next scheduler ticks
+	waiters tickWaiters
+
+	// The number of observed ticks in each scope, guarded by the EventTimes
+	// lock and cleaned up along with the stale times
+	tickCounts map[string]int64
+}
+
+// Validate checks that the event holds a valid scheduler period, tolerance,
+// number of ticks and jitter.
+func (p *CloudSchedulerSourceProbe) Validate(event cloudevents.Event) error {
+	_, err := parsePeriodCheck(event, "CloudSchedulerSource", cloudSchedulerPeriodExtension)
+	return err
 }
 
 // Forward tests the delay between the current time and the latest recorded Cloud
-// Scheduler tick in a given scope.
+// Scheduler tick in a given scope, and between the consecutive ticks which
+// follow if it is to wait on several of them.
 func (p *CloudSchedulerSourceProbe) Forward(ctx context.Context, event cloudevents.Event) error {
-	period, ok := event.Extensions()[cloudSchedulerPeriodExtension]
-	if !ok {
-		return fmt.Errorf("CloudSchedulerProbe event has no '%s' extension", cloudSchedulerPeriodExtension)
-	}
-	periodDuration, err := time.ParseDuration(fmt.Sprint(period))
+	check, err := parsePeriodCheck(event, "CloudSchedulerSource", cloudSchedulerPeriodExtension)
 	if err != nil {
-		return fmt.Errorf("failed to parse CloudSchedulerSource probe period and time.Duration: " + fmt.Sprint(period))
+		return err
 	}
 
-	p.EventTimes.RLock()
-	defer p.EventTimes.RUnlock()
-
-	logging.FromContext(ctx).Infow("Checking last observed scheduler tick")
-	timestampID := fmt.Sprint(event.Extensions()[utils.ProbeEventTargetPathExtension])
-	schedulerTime, ok := p.EventTimes.Times[timestampID]
-	if !ok {
-		return fmt.Errorf("no scheduler tick observed")
-	}
-	if delay := time.Now().Sub(schedulerTime); delay.Nanoseconds() > periodDuration.Nanoseconds() {
-		return fmt.Errorf("scheduler probe delay %s exceeds period %s", delay, periodDuration)
+	// The probe waits on the ticks of a specific job if one is given.
+	var subject string
+	if job, ok := event.Extensions()[cloudSchedulerJobExtension]; ok {
+		subject = schemasv1.CloudSchedulerEventSubject(fmt.Sprint(job))
 	}
-	return nil
+
+	logging.FromContext(ctx).Infow("Checking last observed scheduler tick", zap.String("subject", subject), zap.Int("ticks", check.ticks))
+	timestampID := cloudSchedulerTimestampID(fmt.Sprint(event.Extensions()[utils.ProbeEventTargetPathExtension]), subject)
+	return checkTicks(ctx, p.clock, &p.EventTimes, &p.waiters, timestampID, "scheduler", check)
 }
 
 // Receive refreshes the latest timestamp for a Cloud Scheduler tick in a given scope.
@@ -91,6 +115,7 @@ func (p *CloudSchedulerSourceProbe) Receive(ctx context.Context, event cloudeven
 	//     specversion: 1.0
 	//     type: google.cloud.scheduler.job.v1.executed
 	//     source: //cloudscheduler.googleapis.com/projects/project-id/locations/location/jobs/cre-scheduler-9af24c86-8ba9-4688-80d0-e527678a6a63
+	//     subject: jobs/cre-scheduler-9af24c86-8ba9-4688-80d0-e527678a6a63
 	//     id: 1533039115503825
 	//     time: 2020-09-15T20:12:00.14Z
 	//     dataschema: https://raw.githubusercontent.com/googleapis/google-cloudevents/master/proto/google/events/cloud/scheduler/v1/data.proto
@@ -100,12 +125,29 @@ func (p *CloudSchedulerSourceProbe) Receive(ctx context.Context, event cloudeven
 	p.EventTimes.Lock()
 	defer p.EventTimes.Unlock()
 
-	timestampID := fmt.Sprint(event.Extensions()[utils.ProbeEventReceiverPathExtension])
-	p.EventTimes.Times[timestampID] = time.Now()
+	scope := fmt.Sprint(event.Extensions()[utils.ProbeEventReceiverPathExtension])
+	now := p.clock.Now()
+	p.EventTimes.Times[cloudSchedulerTimestampID(scope, "")] = now
+	p.tickCounts[cloudSchedulerTimestampID(scope, "")]++
+	p.waiters.tick(cloudSchedulerTimestampID(scope, ""))
+	if event.Subject() != "" {
+		p.EventTimes.Times[cloudSchedulerTimestampID(scope, event.Subject())] = now
+		p.tickCounts[cloudSchedulerTimestampID(scope, event.Subject())]++
+		p.waiters.tick(cloudSchedulerTimestampID(scope, event.Subject()))
+	}
 	logging.FromContext(ctx).Info("Successfully received CloudSchedulerSource probe event")
 	return nil
 }
 
+// tickCount returns the number of observed Cloud Scheduler ticks in a given
+// scope.
+func (p *CloudSchedulerSourceProbe) tickCount(timestampID string) int64 {
+	p.EventTimes.RLock()
+	defer p.EventTimes.RUnlock()
+
+	return p.tickCounts[timestampID]
+}
+
 // CleanupStaleSchedulerTimes returns a handler which loops through each scheduler
 // event time and clears the stale entries from the EventTimes map.
 func (p *CloudSchedulerSourceProbe) CleanupStaleSchedulerTimes() utils.ActionFunc {
@@ -114,9 +156,10 @@ func (p *CloudSchedulerSourceProbe) CleanupStaleSchedulerTimes() utils.ActionFun
 		defer p.EventTimes.Unlock()
 
 		for timestampID, schedulerTime := range p.EventTimes.Times {
-			if delay := time.Now().Sub(schedulerTime); delay.Nanoseconds() > p.StaleDuration.Nanoseconds() {
+			if delay := p.clock.Since(schedulerTime); delay.Nanoseconds() > p.StaleDuration.Nanoseconds() {
 				logging.FromContext(ctx).Infow("Deleting stale scheduler time", zap.String("timestampID", timestampID), zap.Duration("delay", delay))
 				delete(p.EventTimes.Times, timestampID)
+				delete(p.tickCounts, timestampID)
 			}
 		}
 		return nil
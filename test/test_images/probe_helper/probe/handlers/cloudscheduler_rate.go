@@ -0,0 +1,123 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"go.uber.org/zap"
+	"knative.dev/pkg/logging"
+
+	schemasv1 "github.com/google/knative-gcp/pkg/schemas/v1"
+	"github.com/google/knative-gcp/test/test_images/probe_helper/utils"
+)
+
+const (
+	// CloudSchedulerSourceRateProbeEventType is the CloudEvent type of forward
+	// CloudSchedulerSource rate probes.
+	CloudSchedulerSourceRateProbeEventType = "cloudschedulersource-rate-probe"
+
+	// The window extension holds the duration over which a CloudSchedulerSource
+	// rate probe counts the scheduler ticks.
+	cloudSchedulerWindowExtension = "window"
+)
+
+// CloudSchedulerSourceRateProbe is the probe handler for probe requests in the
+// CloudSchedulerSource rate probe. The probe counts the scheduler ticks
+// received by the CloudSchedulerSource probe over a window, and succeeds if
+// their number is within the range expected from the period of the scheduler,
+// so that both missed and duplicated executions are caught.
+type CloudSchedulerSourceRateProbe struct {
+	*CloudSchedulerSourceProbe
+}
+
+// tickRange is the range of the number of ticks expected over a window.
+type tickRange struct {
+	min, max int64
+}
+
+// parseTickRange parses the period and window of a CloudSchedulerSource rate
+// probe event, and returns the range of the number of ticks expected over the
+// window along with the window. The range spans one tick on either side of
+// the number of periods within the window, so that the ticks at the edges of
+// the window and the delays of the scheduler are tolerated.
+func parseTickRange(event cloudevents.Event) (tickRange, time.Duration, error) {
+	if err := requireExtensions(event, "CloudSchedulerSource rate", cloudSchedulerPeriodExtension, cloudSchedulerWindowExtension); err != nil {
+		return tickRange{}, 0, err
+	}
+	period, err := time.ParseDuration(fmt.Sprint(event.Extensions()[cloudSchedulerPeriodExtension]))
+	if err != nil || period <= 0 {
+		return tickRange{}, 0, fmt.Errorf("invalid CloudSchedulerSource rate probe period %v, it must be a positive duration", event.Extensions()[cloudSchedulerPeriodExtension])
+	}
+	window, err := time.ParseDuration(fmt.Sprint(event.Extensions()[cloudSchedulerWindowExtension]))
+	if err != nil || window < period {
+		return tickRange{}, 0, fmt.Errorf("invalid CloudSchedulerSource rate probe window %v, it must be a duration of at least the period", event.Extensions()[cloudSchedulerWindowExtension])
+	}
+	periods := int64(window / period)
+	expected := tickRange{min: periods - 1, max: periods + 1}
+	if window%period != 0 {
+		expected.max++
+	}
+	return expected, window, nil
+}
+
+// Validate checks that the event holds a valid scheduler period and window.
+func (p *CloudSchedulerSourceRateProbe) Validate(event cloudevents.Event) error {
+	_, _, err := parseTickRange(event)
+	return err
+}
+
+// Forward counts the Cloud Scheduler ticks in a given scope over the window of
+// the probe, and checks that their number is within the expected range.
+func (p *CloudSchedulerSourceRateProbe) Forward(ctx context.Context, event cloudevents.Event) error {
+	expected, window, err := parseTickRange(event)
+	if err != nil {
+		return err
+	}
+
+	// The probe counts the ticks of a specific job if one is given.
+	var subject string
+	if job, ok := event.Extensions()[cloudSchedulerJobExtension]; ok {
+		subject = schemasv1.CloudSchedulerEventSubject(fmt.Sprint(job))
+	}
+	timestampID := cloudSchedulerTimestampID(fmt.Sprint(event.Extensions()[utils.ProbeEventTargetPathExtension]), subject)
+
+	logging.FromContext(ctx).Infow("Counting scheduler ticks", zap.String("subject", subject), zap.Duration("window", window))
+	start := p.tickCount(timestampID)
+	timer := p.clock.NewTimer(window)
+	select {
+	case <-timer.C():
+	case <-ctx.Done():
+		timer.Stop()
+		return ctx.Err()
+	}
+	ticks := p.tickCount(timestampID) - start
+	// The count restarts if the ticks went stale during the window.
+	if ticks < 0 {
+		ticks = p.tickCount(timestampID)
+	}
+	if ticks < expected.min {
+		return fmt.Errorf("scheduler ticked %d times within window %s, want at least %d", ticks, window, expected.min)
+	}
+	if ticks > expected.max {
+		return fmt.Errorf("scheduler ticked %d times within window %s, want at most %d", ticks, window, expected.max)
+	}
+	return nil
+}
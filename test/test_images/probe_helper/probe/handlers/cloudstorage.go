@@ -19,12 +19,17 @@ package handlers
 import (
 	"context"
 	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
 
 	"cloud.google.com/go/storage"
 	cloudevents "github.com/cloudevents/sdk-go/v2"
 	schemasv1 "github.com/google/knative-gcp/pkg/schemas/v1"
 	"github.com/google/knative-gcp/test/test_images/probe_helper/utils"
 	"go.uber.org/zap"
+	"google.golang.org/api/googleapi"
 	"knative.dev/pkg/logging"
 )
 
@@ -45,18 +50,92 @@ const (
 	// CloudStorageSource delete probes.
 	CloudStorageSourceDeleteProbeEventType = "cloudstoragesource-probe-delete"
 
+	// CloudStorageSourceComposeProbeEventType is the CloudEvent type of forward
+	// CloudStorageSource compose probes.
+	CloudStorageSourceComposeProbeEventType = "cloudstoragesource-probe-compose"
+
+	// CloudStorageSourcePrefixProbeEventType is the CloudEvent type of forward
+	// CloudStorageSource prefix probes.
+	CloudStorageSourcePrefixProbeEventType = "cloudstoragesource-probe-prefix"
+
+	// CloudStorageSourceResumableProbeEventType is the CloudEvent type of
+	// forward CloudStorageSource resumable probes.
+	CloudStorageSourceResumableProbeEventType = "cloudstoragesource-probe-resumable"
+
 	// bucketExtension is the CloudEvent extension in which want the probe to
 	// manipulate Cloud Storage objects.
 	bucketExtension = "bucket"
+
+	// sourcesExtension is the CloudEvent extension containing the comma
+	// separated names of the objects which the probe composes.
+	sourcesExtension = "sources"
+
+	// prefixExtension is the CloudEvent extension containing the object name
+	// prefix to which the CloudStorageSource notifications are filtered.
+	prefixExtension = "prefix"
+
+	// outsidePrefixObjectPrefix is the prefix of the names of the objects
+	// which the prefix probe writes outside of the filtered prefix.
+	outsidePrefixObjectPrefix = "outside-prefix-"
+
+	// subjectPatternExtension is the CloudEvent extension containing a regular
+	// expression which the subject of the notification event is matched
+	// against, when it does not name the object after the probe event.
+	subjectPatternExtension = "subjectpattern"
+
+	// metadataKeyExtension and metadataValueExtension are the CloudEvent
+	// extensions containing the metadata key which the update-metadata probe
+	// updates, and the value which it updates it to. The notification event
+	// must carry the updated metadata for the probe to succeed.
+	metadataKeyExtension   = "metadatakey"
+	metadataValueExtension = "metadatavalue"
+
+	// resumableUploadChunkSize is the size of the chunks in which the
+	// resumable probe uploads its object, which is the smallest chunk size of
+	// the resumable uploads. The probe uploads one byte more than a chunk, so
+	// that the upload is resumable rather than multipart and takes two chunks.
+	resumableUploadChunkSize = googleapi.MinUploadChunkSize
+
+	// defaultMetadataKey and defaultMetadataValue are the metadata which the
+	// update-metadata probe updates when it is not given a metadata key.
+	defaultMetadataKey   = "some-key"
+	defaultMetadataValue = "Metadata updated!"
 )
 
-func NewCloudStorageSourceProbe(storageClient *storage.Client) *CloudStorageSourceProbe {
+// storageObjectData holds the fields of the Cloud Storage notification event
+// data which are relevant to the probes.
+type storageObjectData struct {
+	// ComponentCount is the number of source objects of a composite object.
+	ComponentCount int `json:"componentCount"`
+	// Metadata is the user-provided metadata of the object.
+	Metadata map[string]string `json:"metadata"`
+}
+
+func NewCloudStorageSourceProbe(storageClient *storage.Client, store utils.CorrelationStore) *CloudStorageSourceProbe {
 	return &CloudStorageSourceProbe{
-		storageClient:  storageClient,
-		receivedEvents: utils.NewSyncReceivedEvents(),
+		storageClient:   storageClient,
+		receivedEvents:  utils.NewSyncReceivedEvents(store, "cloudstoragesource"),
+		subjectPatterns: map[string]subjectPattern{},
+		wantMetadata:    map[string]metadataEntry{},
+		payloadFormats:  map[string]string{},
 	}
 }
 
+// metadataEntry is a metadata key of a Cloud Storage object along with its
+// value.
+type metadataEntry struct {
+	key   string
+	value string
+}
+
+// subjectPattern is the subject pattern of a forward probe, along with what
+// the notification events it matches must have in common with the probe.
+type subjectPattern struct {
+	forwardType  string
+	receiverPath string
+	pattern      *regexp.Regexp
+}
+
 // CloudStorageSourceProbe is the base probe type for probe requests in the
 // CloudStorageSource probes. Since all of the CloudStorageSource probes share
 // the same Receive logic, they all inherit it from this object.
@@ -65,7 +144,23 @@ type CloudStorageSourceProbe struct {
 	storageClient *storage.Client
 
 	// The map of received events to be tracked by the forwarder and receiver
-	receivedEvents *utils.SyncReceivedEvents
+	receivedEvents utils.ReceivedEvents
+
+	// The subject patterns of the forward probes, keyed by receiver channel
+	subjectPatternsMu sync.Mutex
+	subjectPatterns   map[string]subjectPattern
+
+	// The metadata which the notification events of the update-metadata
+	// probes must carry, keyed by receiver channel. Only the probes which are
+	// given a metadata key are registered.
+	wantMetadataMu sync.Mutex
+	wantMetadata   map[string]metadataEntry
+
+	// The payload formats to which the data of the notification events of
+	// the forward probes must conform, keyed by receiver channel. Only the
+	// probes which are given a payload format are registered.
+	payloadFormatsMu sync.Mutex
+	payloadFormats   map[string]string
 }
 
 // CloudStorageSourceCreateProbe is the probe handler for probe requests
@@ -92,14 +187,122 @@ type CloudStorageSourceDeleteProbe struct {
 	*CloudStorageSourceProbe
 }
 
+// CloudStorageSourceComposeProbe is the probe handler for probe requests in the
+// CloudStorageSource compose probe.
+type CloudStorageSourceComposeProbe struct {
+	*CloudStorageSourceProbe
+}
+
+// CloudStorageSourcePrefixProbe is the probe handler for probe requests in the
+// CloudStorageSource prefix probe.
+type CloudStorageSourcePrefixProbe struct {
+	*CloudStorageSourceProbe
+}
+
+// CloudStorageSourceResumableProbe is the probe handler for probe requests in
+// the CloudStorageSource resumable probe.
+type CloudStorageSourceResumableProbe struct {
+	*CloudStorageSourceProbe
+}
+
+// Validate checks that the event names its bucket, and that its subject
+// pattern and payload format, if any, are valid.
+func (p *CloudStorageSourceProbe) Validate(event cloudevents.Event) error {
+	if err := requireExtensions(event, "CloudStorageSource", bucketExtension); err != nil {
+		return err
+	}
+	if _, err := compileSubjectPattern(event); err != nil {
+		return err
+	}
+	_, err := payloadFormat(event)
+	return err
+}
+
+// compileSubjectPattern compiles the regular expression in the
+// 'subjectpattern' extension of a probe event. It returns nil if the event
+// has no such extension.
+func compileSubjectPattern(event cloudevents.Event) (*regexp.Regexp, error) {
+	expr, ok := event.Extensions()[subjectPatternExtension]
+	if !ok {
+		return nil, nil
+	}
+	pattern, err := regexp.Compile(fmt.Sprint(expr))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid '%s' extension in CloudStorageSource probe event: %v", subjectPatternExtension, err)
+	}
+	return pattern, nil
+}
+
+// createReceiverChannel creates the receiver channel of a forward probe, and
+// registers its subject pattern and payload format if it has them. The
+// returned function removes all of them.
+func (p *CloudStorageSourceProbe) createReceiverChannel(event cloudevents.Event) (string, func(), error) {
+	pattern, err := compileSubjectPattern(event)
+	if err != nil {
+		return "", nil, err
+	}
+	format, err := payloadFormat(event)
+	if err != nil {
+		return "", nil, err
+	}
+	targetPath := fmt.Sprint(event.Extensions()[utils.ProbeEventTargetPathExtension])
+	channelID := channelID(targetPath, event.ID())
+	cleanupFunc, err := p.receivedEvents.CreateReceiverChannel(channelID)
+	if err != nil {
+		return "", nil, fmt.Errorf("Failed to create receiver channel: %v", err)
+	}
+	if format != "" {
+		p.payloadFormatsMu.Lock()
+		p.payloadFormats[channelID] = format
+		p.payloadFormatsMu.Unlock()
+		channelCleanupFunc := cleanupFunc
+		cleanupFunc = func() {
+			p.payloadFormatsMu.Lock()
+			delete(p.payloadFormats, channelID)
+			p.payloadFormatsMu.Unlock()
+			channelCleanupFunc()
+		}
+	}
+	if pattern == nil {
+		return channelID, cleanupFunc, nil
+	}
+	p.subjectPatternsMu.Lock()
+	p.subjectPatterns[channelID] = subjectPattern{
+		forwardType:  event.Type(),
+		receiverPath: targetPath,
+		pattern:      pattern,
+	}
+	p.subjectPatternsMu.Unlock()
+	return channelID, func() {
+		p.subjectPatternsMu.Lock()
+		delete(p.subjectPatterns, channelID)
+		p.subjectPatternsMu.Unlock()
+		cleanupFunc()
+	}, nil
+}
+
+// matchSubjectPatterns returns the receiver channels of the forward probes
+// whose subject pattern matches a notification event.
+func (p *CloudStorageSourceProbe) matchSubjectPatterns(forwardType, receiverPath, subject string) []string {
+	p.subjectPatternsMu.Lock()
+	defer p.subjectPatternsMu.Unlock()
+
+	var channelIDs []string
+	for channelID, sp := range p.subjectPatterns {
+		if sp.forwardType == forwardType && sp.receiverPath == receiverPath && sp.pattern.MatchString(subject) {
+			channelIDs = append(channelIDs, channelID)
+		}
+	}
+	return channelIDs
+}
+
 // Forward writes an object to Cloud Storage in order to generate a notification
 // event.
 func (p *CloudStorageSourceCreateProbe) Forward(ctx context.Context, event cloudevents.Event) error {
 	// Create the receiver channel
-	channelID := channelID(fmt.Sprint(event.Extensions()[utils.ProbeEventTargetPathExtension]), event.ID())
-	cleanupFunc, err := p.receivedEvents.CreateReceiverChannel(channelID)
+	channelID, cleanupFunc, err := p.createReceiverChannel(event)
 	if err != nil {
-		return fmt.Errorf("Failed to create receiver channel: %v", err)
+		return err
 	}
 	defer cleanupFunc()
 
@@ -119,17 +322,51 @@ func (p *CloudStorageSourceCreateProbe) Forward(ctx context.Context, event cloud
 	return p.receivedEvents.WaitOnReceiverChannel(ctx, channelID)
 }
 
+// Validate checks that the event names its bucket, and that it gives either
+// both a metadata key and its value or neither.
+func (p *CloudStorageSourceUpdateMetadataProbe) Validate(event cloudevents.Event) error {
+	if err := p.CloudStorageSourceProbe.Validate(event); err != nil {
+		return err
+	}
+	_, hasKey := event.Extensions()[metadataKeyExtension]
+	_, hasValue := event.Extensions()[metadataValueExtension]
+	if hasKey != hasValue {
+		return requireExtensions(event, "CloudStorageSource update-metadata", metadataKeyExtension, metadataValueExtension)
+	}
+	if hasKey && fmt.Sprint(event.Extensions()[metadataKeyExtension]) == "" {
+		return fmt.Errorf("CloudStorageSource update-metadata probe event has an empty '%s' extension", metadataKeyExtension)
+	}
+	return nil
+}
+
 // Forward modifies a Cloud Storage object's metadata in order to generate a
-// notification event.
+// notification event. When the probe is given a metadata key, the
+// notification event must carry the updated value of that key.
 func (p *CloudStorageSourceUpdateMetadataProbe) Forward(ctx context.Context, event cloudevents.Event) error {
+	if err := p.Validate(event); err != nil {
+		return err
+	}
+
 	// Create the receiver channel
-	channelID := channelID(fmt.Sprint(event.Extensions()[utils.ProbeEventTargetPathExtension]), event.ID())
-	cleanupFunc, err := p.receivedEvents.CreateReceiverChannel(channelID)
+	channelID, cleanupFunc, err := p.createReceiverChannel(event)
 	if err != nil {
-		return fmt.Errorf("Failed to create receiver channel: %v", err)
+		return err
 	}
 	defer cleanupFunc()
 
+	metadata := metadataEntry{key: defaultMetadataKey, value: defaultMetadataValue}
+	if key, ok := event.Extensions()[metadataKeyExtension]; ok {
+		metadata = metadataEntry{key: fmt.Sprint(key), value: fmt.Sprint(event.Extensions()[metadataValueExtension])}
+		p.wantMetadataMu.Lock()
+		p.wantMetadata[channelID] = metadata
+		p.wantMetadataMu.Unlock()
+		defer func() {
+			p.wantMetadataMu.Lock()
+			delete(p.wantMetadata, channelID)
+			p.wantMetadataMu.Unlock()
+		}()
+	}
+
 	// The probe modifies an object's metadata.
 	bucket, ok := event.Extensions()[bucketExtension]
 	if !ok {
@@ -140,10 +377,10 @@ func (p *CloudStorageSourceUpdateMetadataProbe) Forward(ctx context.Context, eve
 	object := bucketHandle.Object(objectID)
 	objectAttrs := storage.ObjectAttrsToUpdate{
 		Metadata: map[string]string{
-			"some-key": "Metadata updated!",
+			metadata.key: metadata.value,
 		},
 	}
-	logging.FromContext(ctx).Infow("Updating object metadata in cloud storage bucket", zap.String("object", objectID), zap.String("bucket", fmt.Sprint(bucket)))
+	logging.FromContext(ctx).Infow("Updating object metadata in cloud storage bucket", zap.String("object", objectID), zap.String("bucket", fmt.Sprint(bucket)), zap.String("key", metadata.key))
 	if _, err := object.Update(ctx, objectAttrs); err != nil {
 		return fmt.Errorf("Failed to update object metadata: %v", err)
 	}
@@ -154,10 +391,9 @@ func (p *CloudStorageSourceUpdateMetadataProbe) Forward(ctx context.Context, eve
 // Forward archives a Cloud Storage object in order to generate a notification event.
 func (p *CloudStorageSourceArchiveProbe) Forward(ctx context.Context, event cloudevents.Event) error {
 	// Create the receiver channel
-	channelID := channelID(fmt.Sprint(event.Extensions()[utils.ProbeEventTargetPathExtension]), event.ID())
-	cleanupFunc, err := p.receivedEvents.CreateReceiverChannel(channelID)
+	channelID, cleanupFunc, err := p.createReceiverChannel(event)
 	if err != nil {
-		return fmt.Errorf("Failed to create receiver channel: %v", err)
+		return err
 	}
 	defer cleanupFunc()
 
@@ -182,10 +418,9 @@ func (p *CloudStorageSourceArchiveProbe) Forward(ctx context.Context, event clou
 // Forward deletes a Cloud Storage object in order to generate a notification event.
 func (p *CloudStorageSourceDeleteProbe) Forward(ctx context.Context, event cloudevents.Event) error {
 	// Create the receiver channel
-	channelID := channelID(fmt.Sprint(event.Extensions()[utils.ProbeEventTargetPathExtension]), event.ID())
-	cleanupFunc, err := p.receivedEvents.CreateReceiverChannel(channelID)
+	channelID, cleanupFunc, err := p.createReceiverChannel(event)
 	if err != nil {
-		return fmt.Errorf("Failed to create receiver channel: %v", err)
+		return err
 	}
 	defer cleanupFunc()
 
@@ -209,6 +444,283 @@ func (p *CloudStorageSourceDeleteProbe) Forward(ctx context.Context, event cloud
 	return p.receivedEvents.WaitOnReceiverChannel(ctx, channelID)
 }
 
+// Validate checks that the event names its bucket and its source objects.
+func (p *CloudStorageSourceComposeProbe) Validate(event cloudevents.Event) error {
+	if err := p.CloudStorageSourceProbe.Validate(event); err != nil {
+		return err
+	}
+	if err := requireExtensions(event, "CloudStorageSource compose", sourcesExtension); err != nil {
+		return err
+	}
+	for _, source := range strings.Split(fmt.Sprint(event.Extensions()[sourcesExtension]), ",") {
+		if strings.TrimSpace(source) != "" {
+			return nil
+		}
+	}
+	return fmt.Errorf("CloudStorageSource compose probe event has no source objects in '%s' extension", sourcesExtension)
+}
+
+// Forward composes Cloud Storage objects into a destination object in order to
+// generate a notification event.
+func (p *CloudStorageSourceComposeProbe) Forward(ctx context.Context, event cloudevents.Event) error {
+	// Create the receiver channel
+	channelID, cleanupFunc, err := p.createReceiverChannel(event)
+	if err != nil {
+		return err
+	}
+	defer cleanupFunc()
+
+	// The probe composes the source objects into an object named after the event.
+	bucket, ok := event.Extensions()[bucketExtension]
+	if !ok {
+		return fmt.Errorf("CloudStorageSource probe event has no '%s' extension", bucketExtension)
+	}
+	sources, ok := event.Extensions()[sourcesExtension]
+	if !ok {
+		return fmt.Errorf("CloudStorageSource compose probe event has no '%s' extension", sourcesExtension)
+	}
+	bucketHandle := p.storageClient.Bucket(fmt.Sprint(bucket))
+	var srcs []*storage.ObjectHandle
+	for _, source := range strings.Split(fmt.Sprint(sources), ",") {
+		if source = strings.TrimSpace(source); source != "" {
+			srcs = append(srcs, bucketHandle.Object(source))
+		}
+	}
+	if len(srcs) == 0 {
+		return fmt.Errorf("CloudStorageSource compose probe event has no source objects in '%s' extension", sourcesExtension)
+	}
+	objectID := event.ID()[len(event.Type())+1:]
+	object := bucketHandle.Object(objectID)
+	logging.FromContext(ctx).Infow("Composing objects in cloud storage bucket", zap.String("object", objectID), zap.String("bucket", fmt.Sprint(bucket)), zap.String("sources", fmt.Sprint(sources)))
+	if _, err := object.ComposerFrom(srcs...).Run(ctx); err != nil {
+		return fmt.Errorf("Failed to compose objects: %v", err)
+	}
+
+	return p.receivedEvents.WaitOnReceiverChannel(ctx, channelID)
+}
+
+// prefixProbeObjects returns the names of the objects which the prefix probe
+// writes inside and outside of the filtered prefix.
+func prefixProbeObjects(event cloudevents.Event) (string, string, error) {
+	prefix := fmt.Sprint(event.Extensions()[prefixExtension])
+	if prefix == "" {
+		return "", "", fmt.Errorf("CloudStorageSource prefix probe event has an empty '%s' extension", prefixExtension)
+	}
+	objectID := event.ID()[len(event.Type())+1:]
+	inside, outside := prefix+objectID, outsidePrefixObjectPrefix+objectID
+	if strings.HasPrefix(outside, prefix) {
+		return "", "", fmt.Errorf("CloudStorageSource prefix probe cannot write object %s outside of prefix %s", outside, prefix)
+	}
+	return inside, outside, nil
+}
+
+// Validate checks that the event names its bucket and a prefix outside of
+// which the probe can write an object.
+func (p *CloudStorageSourcePrefixProbe) Validate(event cloudevents.Event) error {
+	if err := requireExtensions(event, "CloudStorageSource prefix", bucketExtension, prefixExtension); err != nil {
+		return err
+	}
+	_, _, err := prefixProbeObjects(event)
+	return err
+}
+
+// Forward writes two objects to Cloud Storage, one inside of a prefix and one
+// outside of it, in order to check that only the notification event of the
+// former is delivered. The probe lasts until it times out, unless the
+// notification event of the object outside of the prefix is delivered before.
+func (p *CloudStorageSourcePrefixProbe) Forward(ctx context.Context, event cloudevents.Event) error {
+	if err := p.Validate(event); err != nil {
+		return err
+	}
+	inside, outside, _ := prefixProbeObjects(event)
+
+	// Create the receiver channels of the objects' notification events, which
+	// are received as those of created objects.
+	targetPath := fmt.Sprint(event.Extensions()[utils.ProbeEventTargetPathExtension])
+	insideChannelID := channelID(targetPath, fmt.Sprintf("%s-%s", CloudStorageSourceCreateProbeEventType, inside))
+	outsideChannelID := channelID(targetPath, fmt.Sprintf("%s-%s", CloudStorageSourceCreateProbeEventType, outside))
+	for _, channelID := range []string{insideChannelID, outsideChannelID} {
+		cleanupFunc, err := p.receivedEvents.CreateReceiverChannel(channelID)
+		if err != nil {
+			return fmt.Errorf("Failed to create receiver channel: %v", err)
+		}
+		defer cleanupFunc()
+	}
+	waitCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	insideReceived := make(chan error, 1)
+	outsideReceived := make(chan error, 1)
+	go func() {
+		insideReceived <- p.receivedEvents.WaitOnReceiverChannel(waitCtx, insideChannelID)
+	}()
+	go func() {
+		outsideReceived <- p.receivedEvents.WaitOnReceiverChannel(waitCtx, outsideChannelID)
+	}()
+
+	bucket := fmt.Sprint(event.Extensions()[bucketExtension])
+	bucketHandle := p.storageClient.Bucket(bucket)
+	for _, objectID := range []string{inside, outside} {
+		logging.FromContext(ctx).Infow("Writing object to cloud storage bucket", zap.String("object", objectID), zap.String("bucket", bucket))
+		if err := bucketHandle.Object(objectID).NewWriter(ctx).Close(); err != nil {
+			return fmt.Errorf("Failed to close storage writer for object finalizing: %v", err)
+		}
+	}
+
+	// The notification event of the object outside of the prefix must not be
+	// delivered until the probe times out.
+	select {
+	case err := <-insideReceived:
+		if err != nil {
+			return err
+		}
+	case err := <-outsideReceived:
+		if err == nil {
+			return fmt.Errorf("Received notification event of object %s outside of prefix", outside)
+		}
+		return err
+	}
+	if err := <-outsideReceived; err == nil {
+		return fmt.Errorf("Received notification event of object %s outside of prefix", outside)
+	}
+	return nil
+}
+
+// Forward writes an object to Cloud Storage with a resumable upload, as large
+// objects are written, in order to generate a notification event. The
+// notification event is received as that of a created object, once the upload
+// completes.
+func (p *CloudStorageSourceResumableProbe) Forward(ctx context.Context, event cloudevents.Event) error {
+	// Create the receiver channel of the created object's notification event
+	objectID := event.ID()[len(event.Type())+1:]
+	createEvent := event.Clone()
+	createEvent.SetType(CloudStorageSourceCreateProbeEventType)
+	createEvent.SetID(fmt.Sprintf("%s-%s", CloudStorageSourceCreateProbeEventType, objectID))
+	channelID, cleanupFunc, err := p.createReceiverChannel(createEvent)
+	if err != nil {
+		return err
+	}
+	defer cleanupFunc()
+
+	// The probe uploads the object in two chunks to a given Cloud Storage bucket.
+	bucket, ok := event.Extensions()[bucketExtension]
+	if !ok {
+		return fmt.Errorf("CloudStorageSource probe event has no '%s' extension", bucketExtension)
+	}
+	object := p.storageClient.Bucket(fmt.Sprint(bucket)).Object(objectID)
+	logging.FromContext(ctx).Infow("Uploading object to cloud storage bucket", zap.String("object", objectID), zap.String("bucket", fmt.Sprint(bucket)))
+	w := object.NewWriter(ctx)
+	w.ChunkSize = resumableUploadChunkSize
+	if _, err := w.Write(make([]byte, resumableUploadChunkSize+1)); err != nil {
+		w.Close()
+		return fmt.Errorf("Failed to write object with resumable upload: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("Failed to close storage writer for resumable upload completion: %v", err)
+	}
+
+	return p.receivedEvents.WaitOnReceiverChannel(ctx, channelID)
+}
+
+// trimObjectGeneration strips the '#<generation>' suffix from the object name
+// of a Cloud Storage event subject, if there is one.
+func trimObjectGeneration(object string) string {
+	i := strings.LastIndex(object, "#")
+	if i < 0 {
+		return object
+	}
+	if _, err := strconv.ParseInt(object[i+1:], 10, 64); err != nil {
+		return object
+	}
+	return object[:i]
+}
+
+// cloudStorageForwardType returns the type of the forward probe which caused a
+// Cloud Storage notification event. The eventType of the notification, when
+// carried by the 'storageeventtype' extension, takes precedence over the
+// CloudEvent type.
+func cloudStorageForwardType(event cloudevents.Event) (string, error) {
+	eventType := event.Type()
+	if notificationType, ok := event.Extensions()[schemasv1.StorageEventTypeExtension]; ok {
+		if eventType, ok = schemasv1.CloudStorageEventType(fmt.Sprint(notificationType)); !ok {
+			return "", fmt.Errorf("Unrecognized Cloud Storage notification event type: %v", notificationType)
+		}
+	}
+	switch eventType {
+	case schemasv1.CloudStorageObjectFinalizedEventType:
+		// Composite objects are told apart from created objects by their
+		// number of components.
+		if len(event.Data()) > 0 {
+			var data storageObjectData
+			if err := event.DataAs(&data); err != nil {
+				return "", fmt.Errorf("Failed to parse Cloud Storage event data: %v", err)
+			}
+			if data.ComponentCount > 0 {
+				return CloudStorageSourceComposeProbeEventType, nil
+			}
+		}
+		return CloudStorageSourceCreateProbeEventType, nil
+	case schemasv1.CloudStorageObjectMetadataUpdatedEventType:
+		return CloudStorageSourceUpdateMetadataProbeEventType, nil
+	case schemasv1.CloudStorageObjectArchivedEventType:
+		return CloudStorageSourceArchiveProbeEventType, nil
+	case schemasv1.CloudStorageObjectDeletedEventType:
+		return CloudStorageSourceDeleteProbeEventType, nil
+	default:
+		return "", fmt.Errorf("Unrecognized Cloud Storage event type: %s", eventType)
+	}
+}
+
+// checkMetadata checks that a notification event carries the metadata which
+// the forward probe of a receiver channel expects, if any.
+func (p *CloudStorageSourceProbe) checkMetadata(channelID string, event cloudevents.Event) error {
+	p.wantMetadataMu.Lock()
+	want, ok := p.wantMetadata[channelID]
+	p.wantMetadataMu.Unlock()
+	if !ok {
+		return nil
+	}
+	var data storageObjectData
+	if len(event.Data()) > 0 {
+		if err := event.DataAs(&data); err != nil {
+			return fmt.Errorf("Failed to parse Cloud Storage event data: %v", err)
+		}
+	}
+	got, ok := data.Metadata[want.key]
+	if !ok {
+		return fmt.Errorf("Cloud Storage event data has no metadata key '%s'", want.key)
+	}
+	if got != want.value {
+		return fmt.Errorf("Cloud Storage event data has metadata key '%s' with value %q, want %q", want.key, got, want.value)
+	}
+	return nil
+}
+
+// checkChannelPayloadFormat checks that the data of a notification event
+// conforms to the payload format which the forward probe of a receiver channel
+// expects, if any.
+func (p *CloudStorageSourceProbe) checkChannelPayloadFormat(channelID string, event cloudevents.Event) error {
+	p.payloadFormatsMu.Lock()
+	format, ok := p.payloadFormats[channelID]
+	p.payloadFormatsMu.Unlock()
+	if !ok {
+		return nil
+	}
+	return checkPayloadFormat(format, event)
+}
+
+// signalReceiverChannel signals the receiver channel of a forward probe, or
+// fails it when the notification event does not carry the metadata or the
+// payload format which the probe expects.
+func (p *CloudStorageSourceProbe) signalReceiverChannel(channelID string, event cloudevents.Event) error {
+	if err := p.checkMetadata(channelID, event); err != nil {
+		return p.receivedEvents.FailReceiverChannel(channelID, err)
+	}
+	if err := p.checkChannelPayloadFormat(channelID, event); err != nil {
+		return p.receivedEvents.FailReceiverChannel(channelID, err)
+	}
+	return p.receivedEvents.SignalReceiverChannel(channelID)
+}
+
 // Receive closes the receiver channel associated with the Cloud Storage notification event.
 func (p *CloudStorageSourceProbe) Receive(ctx context.Context, event cloudevents.Event) error {
 	// The original event is written as an identifiable object to a bucket.
@@ -223,29 +735,43 @@ func (p *CloudStorageSourceProbe) Receive(ctx context.Context, event cloudevents
 	//     time: 2020-09-14T17:18:40.984Z
 	//     dataschema: https://raw.githubusercontent.com/googleapis/google-cloudevents/master/proto/google/events/cloud/storage/v1/data.proto
 	//     datacontenttype: application/json
+	//   Extensions,
+	//     storageeventtype: OBJECT_FINALIZE
 	//   Data,
 	//     { ... }
-	var eventID string
-	if _, err := fmt.Sscanf(event.Subject(), "objects/%s", &eventID); err != nil {
-		return fmt.Errorf("Failed to extract probe event ID from Cloud Storage event subject: %v", err)
+	//
+	// The subjects of the events about a specific generation of an object, such
+	// as deleted events, end with the generation:
+	//     subject: objects/cloudstoragesource-probe-delete-fc2638d1-fcae-4889-9fa1-14a08cb05fc4#1600103920984245
+	//
+	// Subjects which do not name the object after the probe event are matched
+	// against the 'subjectpattern' extensions of the forward probes instead.
+	forwardType, err := cloudStorageForwardType(event)
+	if err != nil {
+		return err
 	}
-	var forwardType string
-	switch event.Type() {
-	case schemasv1.CloudStorageObjectFinalizedEventType:
-		forwardType = CloudStorageSourceCreateProbeEventType
-	case schemasv1.CloudStorageObjectMetadataUpdatedEventType:
-		forwardType = CloudStorageSourceUpdateMetadataProbeEventType
-	case schemasv1.CloudStorageObjectArchivedEventType:
-		forwardType = CloudStorageSourceArchiveProbeEventType
-	case schemasv1.CloudStorageObjectDeletedEventType:
-		forwardType = CloudStorageSourceDeleteProbeEventType
-	default:
-		return fmt.Errorf("Unrecognized Cloud Storage event type: %s", event.Type())
+	receiverPath := fmt.Sprint(event.Extensions()[utils.ProbeEventReceiverPathExtension])
+	var eventID string
+	_, err = fmt.Sscanf(event.Subject(), "objects/%s", &eventID)
+	if err == nil {
+		eventID = fmt.Sprintf("%s-%s", forwardType, trimObjectGeneration(eventID))
+		err = p.signalReceiverChannel(channelID(receiverPath, eventID), event)
+		if err == nil {
+			ctx = utils.WithProbeIDLogger(ctx, eventID)
+		}
+	} else {
+		err = fmt.Errorf("Failed to extract probe event ID from Cloud Storage event subject: %v", err)
 	}
-	eventID = fmt.Sprintf("%s-%s", forwardType, eventID)
-	channelID := channelID(fmt.Sprint(event.Extensions()[utils.ProbeEventReceiverPathExtension]), eventID)
-	if err := p.receivedEvents.SignalReceiverChannel(channelID); err != nil {
-		return err
+	if err != nil {
+		channelIDs := p.matchSubjectPatterns(forwardType, receiverPath, event.Subject())
+		if len(channelIDs) == 0 {
+			return err
+		}
+		for _, channelID := range channelIDs {
+			if err := p.signalReceiverChannel(channelID, event); err != nil {
+				return err
+			}
+		}
 	}
 	logging.FromContext(ctx).Info("Successfully received CloudStorageSource probe event")
 	return nil
@@ -0,0 +1,82 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	schemasv1 "github.com/google/knative-gcp/pkg/schemas/v1"
+)
+
+const (
+	// payloadFormatExtension is the CloudEvent extension containing the
+	// payload format to which the data of the notification event of a
+	// CloudStorageSource probe must conform, either 'v1' or 'legacy'.
+	payloadFormatExtension = "payloadformat"
+
+	// v1PayloadFormat is the payload format of the Cloud Storage events of the
+	// google-cloudevents v1 schema, whose data is a StorageObjectData.
+	v1PayloadFormat = "v1"
+
+	// legacyPayloadFormat is the payload format of the Cloud Storage
+	// notifications of the JSON API, whose data is an object resource.
+	legacyPayloadFormat = "legacy"
+)
+
+// payloadFormatFields are the top-level fields which the data of a Cloud
+// Storage notification event must have in each payload format.
+var payloadFormatFields = map[string][]string{
+	v1PayloadFormat:     {"bucket", "name", "generation", "metageneration", "timeCreated", "updated"},
+	legacyPayloadFormat: {"kind", "id", "selfLink", "bucket", "name"},
+}
+
+// payloadFormat returns the payload format held in the payloadformat
+// extension of a probe event, or an empty string if it has none.
+func payloadFormat(event cloudevents.Event) (string, error) {
+	value, ok := event.Extensions()[payloadFormatExtension]
+	if !ok {
+		return "", nil
+	}
+	format := fmt.Sprint(value)
+	if _, ok := payloadFormatFields[format]; !ok {
+		return "", fmt.Errorf("invalid %s extension %q, want either %q or %q", payloadFormatExtension, format, v1PayloadFormat, legacyPayloadFormat)
+	}
+	return format, nil
+}
+
+// checkPayloadFormat checks that the data of a Cloud Storage notification
+// event conforms to a payload format. The v1 data is first validated as a
+// storage object.
+func checkPayloadFormat(format string, event cloudevents.Event) error {
+	if format == v1PayloadFormat {
+		if err := schemasv1.ValidateCloudStorageEventData(event.Data()); err != nil {
+			return fmt.Errorf("Cloud Storage event data does not conform to the %s payload format: %v", format, err)
+		}
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(event.Data(), &fields); err != nil {
+		return fmt.Errorf("Failed to parse Cloud Storage event data: %v", err)
+	}
+	for _, field := range payloadFormatFields[format] {
+		if _, ok := fields[field]; !ok {
+			return fmt.Errorf("Cloud Storage event data has no '%s' field of the %s payload format", field, format)
+		}
+	}
+	return nil
+}
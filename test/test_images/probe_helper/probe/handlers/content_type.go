@@ -0,0 +1,68 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handlers
+
+import (
+	"fmt"
+	"mime"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+)
+
+// The expectcontenttype extension holds the content type, e.g. 'text/plain',
+// with which a probe event is forwarded and which its datacontenttype
+// attribute must hold once delivered back for the probe to succeed.
+const expectContentTypeExtension = "expectcontenttype"
+
+// expectedContentType returns the content type held in the expectcontenttype
+// extension of an event, if any.
+func expectedContentType(event cloudevents.Event) (string, bool, error) {
+	value, ok := event.Extensions()[expectContentTypeExtension]
+	if !ok {
+		return "", false, nil
+	}
+	contentType := fmt.Sprint(value)
+	if _, _, err := mime.ParseMediaType(contentType); err != nil {
+		return "", false, fmt.Errorf("invalid %s extension %q: %v", expectContentTypeExtension, contentType, err)
+	}
+	return contentType, true, nil
+}
+
+// withExpectedContentType returns a copy of a probe event whose
+// datacontenttype attribute is set to its expected content type, if any.
+func withExpectedContentType(event cloudevents.Event) cloudevents.Event {
+	contentType, ok, err := expectedContentType(event)
+	if err != nil || !ok {
+		return event
+	}
+	event = event.Clone()
+	event.SetDataContentType(contentType)
+	return event
+}
+
+// checkContentType checks that an event is delivered back with the content
+// type which it is expected to hold.
+func checkContentType(event cloudevents.Event) error {
+	want, ok, err := expectedContentType(event)
+	if err != nil || !ok {
+		return err
+	}
+	if got := event.DataContentType(); got != want {
+		return fmt.Errorf("event was delivered with content type %q, want %q", got, want)
+	}
+	return nil
+}
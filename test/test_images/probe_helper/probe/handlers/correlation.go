@@ -0,0 +1,87 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+)
+
+const (
+	// The correlateby extension selects how the events delivered back to the
+	// receiver are correlated with the probe event which was forwarded.
+	correlateByExtension = "correlateby"
+
+	// IDCorrelation correlates the events on their ID, which is the default.
+	IDCorrelation = "id"
+	// DataHashCorrelation correlates the events on a hash of their type and
+	// data, which tolerates their ID being rewritten on delivery.
+	DataHashCorrelation = "datahash"
+
+	// The idprefixstrip extension holds the prefix which the broker prepends
+	// to the IDs of the events it delivers, e.g. the ID of the subscriber. The
+	// prefix is stripped from the IDs of the received events before they are
+	// correlated on their ID.
+	idPrefixStripExtension = "idprefixstrip"
+)
+
+// validateCorrelation checks the correlateby and idprefixstrip extensions of a
+// probe event.
+func validateCorrelation(event cloudevents.Event) error {
+	if prefix, ok := event.Extensions()[idPrefixStripExtension]; ok && fmt.Sprint(prefix) == "" {
+		return fmt.Errorf("invalid %s extension, want a non-empty prefix", idPrefixStripExtension)
+	}
+	_, err := correlationKey(event)
+	return err
+}
+
+// correlationKey returns the key on which an event is correlated according to
+// its correlateby extension. The extension is delivered back along with the
+// event, so the forward and receive handlers agree on the key.
+func correlationKey(event cloudevents.Event) (string, error) {
+	mode, ok := event.Extensions()[correlateByExtension]
+	if !ok {
+		return event.ID(), nil
+	}
+	switch fmt.Sprint(mode) {
+	case IDCorrelation:
+		return event.ID(), nil
+	case DataHashCorrelation:
+		hash := sha256.New()
+		hash.Write([]byte(event.Type()))
+		hash.Write([]byte{0})
+		hash.Write(event.Data())
+		return hex.EncodeToString(hash.Sum(nil)), nil
+	default:
+		return "", fmt.Errorf("unsupported %s extension %q", correlateByExtension, mode)
+	}
+}
+
+// receivedCorrelationKey returns the key on which a received event is
+// correlated, once the prefix of its idprefixstrip extension is stripped from
+// its ID.
+func receivedCorrelationKey(event cloudevents.Event) (string, error) {
+	if prefix, ok := event.Extensions()[idPrefixStripExtension]; ok {
+		event = event.Clone()
+		event.SetID(strings.TrimPrefix(event.ID(), fmt.Sprint(prefix)))
+	}
+	return correlationKey(event)
+}
@@ -18,11 +18,13 @@ package handlers
 
 import (
 	"context"
+	"fmt"
 
 	cloudevents "github.com/cloudevents/sdk-go/v2"
 	"knative.dev/pkg/logging"
 
 	schemasv1 "github.com/google/knative-gcp/pkg/schemas/v1"
+	"github.com/google/knative-gcp/test/test_images/probe_helper/utils"
 	sources "knative.dev/eventing/pkg/apis/sources"
 	sourcesv1beta1 "knative.dev/eventing/pkg/apis/sources/v1beta1"
 )
@@ -33,27 +35,39 @@ type EventTypeProbe struct {
 	receive map[string]Interface
 }
 
-func NewEventTypeHandler(brokerE2EDeliveryProbe *BrokerE2EDeliveryProbe, cloudPubSubSourceProbe *CloudPubSubSourceProbe,
+func NewEventTypeHandler(brokerE2EDeliveryProbe *BrokerE2EDeliveryProbe, brokerDLQProbe *BrokerDLQProbe, brokerRejectProbe *BrokerRejectProbe, channelE2EDeliveryProbe *ChannelE2EDeliveryProbe, cloudPubSubSourceProbe *CloudPubSubSourceProbe,
 	cloudStorageSourceCreateProbe *CloudStorageSourceCreateProbe, cloudStorageSourceUpdateMetadataProbe *CloudStorageSourceUpdateMetadataProbe,
-	cloudStorageSourceArchiveProbe *CloudStorageSourceArchiveProbe, cloudStorageSourceDeleteProbe *CloudStorageSourceDeleteProbe,
-	cloudAuditLogsSourceProbe *CloudAuditLogsSourceProbe, apiServerSourceCreateProbe *ApiServerSourceCreateProbe, apiServerSourceUpdateProbe *ApiServerSourceUpdateProbe, apiServerSourceDeleteProbe *ApiServerSourceDeleteProbe, cloudSchedulerSourceProbe *CloudSchedulerSourceProbe, pingSourceProbe *PingSourceProbe) *EventTypeProbe {
+	cloudStorageSourceArchiveProbe *CloudStorageSourceArchiveProbe, cloudStorageSourceDeleteProbe *CloudStorageSourceDeleteProbe, cloudStorageSourceComposeProbe *CloudStorageSourceComposeProbe, cloudStorageSourcePrefixProbe *CloudStorageSourcePrefixProbe, cloudStorageSourceResumableProbe *CloudStorageSourceResumableProbe,
+	cloudAuditLogsSourceProbe *CloudAuditLogsSourceProbe, cloudAuditLogsSourceDeleteProbe *CloudAuditLogsSourceDeleteProbe, apiServerSourceCreateProbe *ApiServerSourceCreateProbe, apiServerSourceUpdateProbe *ApiServerSourceUpdateProbe, apiServerSourceDeleteProbe *ApiServerSourceDeleteProbe, cloudSchedulerSourceProbe *CloudSchedulerSourceProbe, cloudSchedulerSourceRateProbe *CloudSchedulerSourceRateProbe, pingSourceProbe *PingSourceProbe, pubSubRoundtripProbe *PubSubRoundtripProbe, orderingProbe *OrderingProbe) *EventTypeProbe {
 	// Set the forward and receiver probe handlers now that they are initialized.
 	forwardHandlers := map[string]Interface{
 		BrokerE2EDeliveryProbeEventType:                brokerE2EDeliveryProbe,
+		BrokerDLQProbeEventType:                        brokerDLQProbe,
+		BrokerRejectProbeEventType:                     brokerRejectProbe,
+		ChannelE2EDeliveryProbeEventType:               channelE2EDeliveryProbe,
 		CloudPubSubSourceProbeEventType:                cloudPubSubSourceProbe,
 		CloudStorageSourceCreateProbeEventType:         cloudStorageSourceCreateProbe,
 		CloudStorageSourceUpdateMetadataProbeEventType: cloudStorageSourceUpdateMetadataProbe,
 		CloudStorageSourceArchiveProbeEventType:        cloudStorageSourceArchiveProbe,
 		CloudStorageSourceDeleteProbeEventType:         cloudStorageSourceDeleteProbe,
+		CloudStorageSourceComposeProbeEventType:        cloudStorageSourceComposeProbe,
+		CloudStorageSourcePrefixProbeEventType:         cloudStorageSourcePrefixProbe,
+		CloudStorageSourceResumableProbeEventType:      cloudStorageSourceResumableProbe,
 		CloudAuditLogsSourceProbeEventType:             cloudAuditLogsSourceProbe,
+		CloudAuditLogsSourceDeleteProbeEventType:       cloudAuditLogsSourceDeleteProbe,
 		ApiServerSourceCreateProbeEventType:            apiServerSourceCreateProbe,
 		ApiServerSourceUpdateProbeEventType:            apiServerSourceUpdateProbe,
 		ApiServerSourceDeleteProbeEventType:            apiServerSourceDeleteProbe,
 		CloudSchedulerSourceProbeEventType:             cloudSchedulerSourceProbe,
+		CloudSchedulerSourceRateProbeEventType:         cloudSchedulerSourceRateProbe,
 		PingSourceProbeEventType:                       pingSourceProbe,
+		PubSubRoundtripProbeEventType:                  pubSubRoundtripProbe,
+		OrderingProbeEventType:                         orderingProbe,
 	}
 	receiveHandlers := map[string]Interface{
 		BrokerE2EDeliveryProbeEventType:                      brokerE2EDeliveryProbe,
+		BrokerDLQProbeEventType:                              brokerDLQProbe,
+		ChannelE2EDeliveryProbeEventType:                     channelE2EDeliveryProbe,
 		schemasv1.CloudPubSubMessagePublishedEventType:       cloudPubSubSourceProbe,
 		schemasv1.CloudStorageObjectFinalizedEventType:       cloudStorageSourceCreateProbe,
 		schemasv1.CloudStorageObjectMetadataUpdatedEventType: cloudStorageSourceUpdateMetadataProbe,
@@ -72,16 +86,140 @@ func NewEventTypeHandler(brokerE2EDeliveryProbe *BrokerE2EDeliveryProbe, cloudPu
 	}
 }
 
+// receiveSources maps the types of the received events to the names of the
+// sources which deliver them.
+var receiveSources = map[string]string{
+	BrokerE2EDeliveryProbeEventType:                      "broker",
+	BrokerDLQProbeEventType:                              "broker",
+	ChannelE2EDeliveryProbeEventType:                     "channel",
+	schemasv1.CloudPubSubMessagePublishedEventType:       "cloudpubsubsource",
+	schemasv1.CloudStorageObjectFinalizedEventType:       "cloudstoragesource",
+	schemasv1.CloudStorageObjectMetadataUpdatedEventType: "cloudstoragesource",
+	schemasv1.CloudStorageObjectArchivedEventType:        "cloudstoragesource",
+	schemasv1.CloudStorageObjectDeletedEventType:         "cloudstoragesource",
+	schemasv1.CloudAuditLogsLogWrittenEventType:          "cloudauditlogssource",
+	sources.ApiServerSourceAddEventType:                  "apiserversource",
+	sources.ApiServerSourceUpdateEventType:               "apiserversource",
+	sources.ApiServerSourceDeleteEventType:               "apiserversource",
+	schemasv1.CloudSchedulerJobExecutedEventType:         "cloudschedulersource",
+	sourcesv1beta1.PingSourceEventType:                   "pingsource",
+}
+
+// ReceiveSource returns the name of the source which delivers the received
+// events of a given type, e.g. 'cloudstoragesource'.
+func ReceiveSource(eventType string) (string, bool) {
+	source, ok := receiveSources[eventType]
+	return source, ok
+}
+
+// forwardSources maps the types of the forward probes whose events are
+// delivered back to the receiver by a source to the names of their sources.
+var forwardSources = map[string]string{
+	CloudPubSubSourceProbeEventType:                "cloudpubsubsource",
+	OrderingProbeEventType:                         "cloudpubsubsource",
+	CloudStorageSourceCreateProbeEventType:         "cloudstoragesource",
+	CloudStorageSourceUpdateMetadataProbeEventType: "cloudstoragesource",
+	CloudStorageSourceArchiveProbeEventType:        "cloudstoragesource",
+	CloudStorageSourceDeleteProbeEventType:         "cloudstoragesource",
+	CloudStorageSourceComposeProbeEventType:        "cloudstoragesource",
+	CloudStorageSourcePrefixProbeEventType:         "cloudstoragesource",
+	CloudStorageSourceResumableProbeEventType:      "cloudstoragesource",
+	CloudAuditLogsSourceProbeEventType:             "cloudauditlogssource",
+	CloudAuditLogsSourceDeleteProbeEventType:       "cloudauditlogssource",
+	ApiServerSourceCreateProbeEventType:            "apiserversource",
+	ApiServerSourceUpdateProbeEventType:            "apiserversource",
+	ApiServerSourceDeleteProbeEventType:            "apiserversource",
+	CloudSchedulerSourceProbeEventType:             "cloudschedulersource",
+	CloudSchedulerSourceRateProbeEventType:         "cloudschedulersource",
+	PingSourceProbeEventType:                       "pingsource",
+}
+
+// ForwardSource returns the name of the source which delivers back the events
+// of the forward probes of a given type, e.g. 'cloudstoragesource'. The
+// broker and channel probes, whose events are not delivered by a source, have
+// none.
+func ForwardSource(probeType string) (string, bool) {
+	source, ok := forwardSources[probeType]
+	return source, ok
+}
+
+// IsForwardSource returns whether a source, as named by ReceiveSource,
+// delivers back the events of forward probes.
+func IsForwardSource(source string) bool {
+	for _, s := range forwardSources {
+		if s == source {
+			return true
+		}
+	}
+	return false
+}
+
+// MetricsTarget returns the target of a probe event which labels the metrics
+// of its result, i.e. the namespace and broker of the broker e2e delivery
+// probes and the topic of the CloudPubSubSource and ordering probes.
+func MetricsTarget(event cloudevents.Event) utils.ProbeTarget {
+	switch event.Type() {
+	case BrokerE2EDeliveryProbeEventType:
+		target := utils.ProbeTarget{Broker: defaultBroker}
+		if namespace, ok := event.Extensions()[namespaceExtension]; ok {
+			target.Namespace = fmt.Sprint(namespace)
+		}
+		if broker, ok := event.Extensions()[brokerExtension]; ok {
+			target.Broker = fmt.Sprint(broker)
+		}
+		return target
+	case CloudPubSubSourceProbeEventType, OrderingProbeEventType:
+		if topic, ok := event.Extensions()[topicExtension]; ok {
+			return utils.ProbeTarget{Topic: fmt.Sprint(topic)}
+		}
+	}
+	return utils.ProbeTarget{}
+}
+
 func (p *EventTypeProbe) Forward(ctx context.Context, event cloudevents.Event) error {
 	// Retrieve the probe handler based on the event type
 	inner, ok := p.forward[event.Type()]
 	if !ok {
 		logging.FromContext(ctx).Warnw("Probe forwarding failed, unrecognized forward probe type")
-		return cloudevents.ResultNACK
+		return fmt.Errorf("%w '%s'", ErrUnrecognizedProbeType, event.Type())
 	}
 	return inner.Forward(ctx, event)
 }
 
+// Recognizes returns whether a forward probe handler is registered for a
+// given probe type.
+func (p *EventTypeProbe) Recognizes(eventType string) bool {
+	_, ok := p.forward[eventType]
+	return ok
+}
+
+// Validate checks that the forward probe type is recognized, and that the
+// event is well-formed if its probe handler can tell.
+func (p *EventTypeProbe) Validate(event cloudevents.Event) error {
+	inner, ok := p.forward[event.Type()]
+	if !ok {
+		return fmt.Errorf("%w '%s'", ErrUnrecognizedProbeType, event.Type())
+	}
+	if v, ok := inner.(Validator); ok {
+		return v.Validate(event)
+	}
+	return nil
+}
+
+// Warmup primes the forward probes of a given type, if their probe handler
+// can.
+func (p *EventTypeProbe) Warmup(ctx context.Context, event cloudevents.Event) error {
+	inner, ok := p.forward[event.Type()]
+	if !ok {
+		return fmt.Errorf("%w '%s'", ErrUnrecognizedProbeType, event.Type())
+	}
+	w, ok := inner.(Warmer)
+	if !ok {
+		return fmt.Errorf("%w for probe type '%s'", ErrWarmupUnsupported, event.Type())
+	}
+	return w.Warmup(ctx, event)
+}
+
 func (p *EventTypeProbe) Receive(ctx context.Context, event cloudevents.Event) error {
 	// Retrieve the probe handler based on the event type
 	inner, ok := p.receive[event.Type()]
@@ -0,0 +1,42 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handlers
+
+import (
+	"fmt"
+	"strconv"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+)
+
+// The expectcount extension holds the number of matching events which a probe
+// waits to receive before it succeeds, which is 1 by default.
+const expectCountExtension = "expectcount"
+
+// expectCount returns the number of matching events which a probe waits to
+// receive, held in its expectcount extension.
+func expectCount(event cloudevents.Event) (int, error) {
+	value, ok := event.Extensions()[expectCountExtension]
+	if !ok {
+		return 1, nil
+	}
+	count, err := strconv.Atoi(fmt.Sprint(value))
+	if err != nil || count < 1 {
+		return 0, fmt.Errorf("invalid %s extension %q, want a positive integer", expectCountExtension, value)
+	}
+	return count, nil
+}
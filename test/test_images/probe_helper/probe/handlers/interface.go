@@ -18,9 +18,11 @@ package handlers
 
 import (
 	"context"
+	"errors"
 	"fmt"
 
 	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/cloudevents/sdk-go/v2/protocol"
 )
 
 /*
@@ -44,9 +46,62 @@ type Interface interface {
 	Receive(context.Context, cloudevents.Event) error
 }
 
+// Validator is implemented by the probe handlers which can check that a
+// forward probe event is well-formed without forwarding it, e.g. in dry-run
+// mode.
+type Validator interface {
+	// Validate checks the extensions of a forward probe event.
+	Validate(cloudevents.Event) error
+}
+
+// Warmer is implemented by the probe handlers which can prime the forward
+// connection and the source path of their probes before they are run.
+type Warmer interface {
+	// Warmup sends a forward probe event without waiting for it to be
+	// delivered back, and returns once its target accepted it.
+	Warmup(context.Context, cloudevents.Event) error
+}
+
+// TypeRecognizer is implemented by the probe handlers which can tell whether
+// they handle the forward probes of a given type, so that the probes of other
+// types are rejected before anything is allocated for them.
+type TypeRecognizer interface {
+	// Recognizes returns whether the forward probes of a type are handled.
+	Recognizes(eventType string) bool
+}
+
+// requireExtensions checks that a probe event has the given extensions.
+func requireExtensions(event cloudevents.Event, probe string, extensions ...string) error {
+	for _, extension := range extensions {
+		if _, ok := event.Extensions()[extension]; !ok {
+			return fmt.Errorf("%w: %s probe event has no '%s' extension", ErrMissingExtension, probe, extension)
+		}
+	}
+	return nil
+}
+
+var (
+	// ErrUnrecognizedProbeType is returned when no probe handler is registered
+	// for the type of a probe event.
+	ErrUnrecognizedProbeType = errors.New("unrecognized probe type")
+
+	// ErrWarmupUnsupported is returned by Warmup when the probe handler of a
+	// warmup probe cannot prime its probes.
+	ErrWarmupUnsupported = errors.New("warmup unsupported")
+
+	// ErrMissingExtension is returned by Validate when a probe event lacks an
+	// extension which its probe requires.
+	ErrMissingExtension = errors.New("missing extension")
+)
+
+// ErrRedeliver is returned by Receive when the probe event is rejected on
+// purpose, so that it is redelivered by its sender.
+var ErrRedeliver = errors.New("probe event rejected for redelivery")
+
 func channelID(prefix, eventID string) string {
 	return fmt.Sprintf("%s/%s", prefix, eventID)
 }
 
 type CeForwardClient cloudevents.Client
+type CeForwardSender protocol.Sender
 type CeReceiveClient cloudevents.Client
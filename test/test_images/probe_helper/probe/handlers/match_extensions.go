@@ -0,0 +1,100 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handlers
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+)
+
+const (
+	// The matchextensions extension holds a comma-separated list of the
+	// extensions of a probe event whose values must be delivered back intact
+	// for the probe to succeed.
+	matchExtensionsExtension = "matchextensions"
+
+	// The matchvalues extension holds the values of the matched extensions as
+	// they were forwarded, URL-encoded, so that the receiver can compare them
+	// with the values delivered back.
+	matchValuesExtension = "matchvalues"
+)
+
+// matchExtensions returns the names of the extensions listed in the
+// matchextensions extension of an event.
+func matchExtensions(event cloudevents.Event) []string {
+	value, ok := event.Extensions()[matchExtensionsExtension]
+	if !ok {
+		return nil
+	}
+	var names []string
+	for _, name := range strings.Split(fmt.Sprint(value), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// validateMatchExtensions checks that a probe event carries every extension
+// which it lists in its matchextensions extension.
+func validateMatchExtensions(event cloudevents.Event, probe string) error {
+	return requireExtensions(event, probe, matchExtensions(event)...)
+}
+
+// withMatchValues returns a copy of a probe event carrying the forwarded
+// values of its matched extensions in its matchvalues extension.
+func withMatchValues(event cloudevents.Event) cloudevents.Event {
+	names := matchExtensions(event)
+	if len(names) == 0 {
+		return event
+	}
+	values := url.Values{}
+	for _, name := range names {
+		values.Set(name, fmt.Sprint(event.Extensions()[name]))
+	}
+	event = event.Clone()
+	event.SetExtension(matchValuesExtension, values.Encode())
+	return event
+}
+
+// checkMatchValues checks that the matched extensions of an event are
+// delivered back with the values with which they were forwarded, and names
+// the first extension whose value does not match.
+func checkMatchValues(event cloudevents.Event) error {
+	value, ok := event.Extensions()[matchValuesExtension]
+	if !ok {
+		return nil
+	}
+	values, err := url.ParseQuery(fmt.Sprint(value))
+	if err != nil {
+		return fmt.Errorf("invalid %s extension: %v", matchValuesExtension, err)
+	}
+	for _, name := range matchExtensions(event) {
+		want := values.Get(name)
+		got, ok := event.Extensions()[name]
+		if !ok {
+			return fmt.Errorf("matched extension '%s' was not delivered, want %q", name, want)
+		}
+		if fmt.Sprint(got) != want {
+			return fmt.Errorf("matched extension '%s' was delivered as %q, want %q", name, got, want)
+		}
+	}
+	return nil
+}
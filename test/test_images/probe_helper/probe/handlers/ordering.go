@@ -0,0 +1,239 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"cloud.google.com/go/pubsub"
+	cepubsub "github.com/cloudevents/sdk-go/protocol/pubsub/v2"
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/cloudevents/sdk-go/v2/binding"
+	"go.uber.org/zap"
+	"knative.dev/pkg/logging"
+
+	"github.com/google/knative-gcp/test/test_images/probe_helper/utils"
+)
+
+const (
+	// OrderingProbeEventType is the CloudEvent type of forward ordering
+	// probes.
+	OrderingProbeEventType = "ordering-probe"
+
+	// The sequencelength extension holds the number of events which an
+	// ordering probe publishes, which is 3 by default.
+	sequenceLengthExtension = "sequencelength"
+	defaultSequenceLength   = 3
+	maxSequenceLength       = 100
+
+	// The seq and orderingkey extensions of the events published by an
+	// ordering probe hold their position in the sequence and the ordering key
+	// of the sequence, which the Pub/Sub message attributes carry back to the
+	// receiver.
+	seqExtension         = "seq"
+	orderingKeyExtension = "orderingkey"
+)
+
+func NewOrderingProbe(cloudPubSubSourceProbe *CloudPubSubSourceProbe, pubsubClient *pubsub.Client) *OrderingProbe {
+	return &OrderingProbe{
+		CloudPubSubSourceProbe: cloudPubSubSourceProbe,
+		pubsubClient:           pubsubClient,
+	}
+}
+
+// OrderingProbe is the probe handler for probe requests in the ordering
+// probe. The probe publishes a sequence of events with the same ordering key
+// to a topic, and succeeds once a CloudPubSubSource of an ordered subscription
+// of the topic delivers all of them in the order in which they were
+// published. The delivered events are received by the CloudPubSubSource
+// probe, which records their order of arrival.
+type OrderingProbe struct {
+	*CloudPubSubSourceProbe
+
+	// The pubsub client used to publish the messages with their ordering key
+	pubsubClient *pubsub.Client
+}
+
+// sequenceLength returns the number of events which an ordering probe
+// publishes, held in its sequencelength extension.
+func sequenceLength(event cloudevents.Event) (int, error) {
+	value, ok := event.Extensions()[sequenceLengthExtension]
+	if !ok {
+		return defaultSequenceLength, nil
+	}
+	length, err := strconv.Atoi(fmt.Sprint(value))
+	if err != nil || length < 1 || length > maxSequenceLength {
+		return 0, fmt.Errorf("invalid %s extension %q, want an integer between 1 and %d", sequenceLengthExtension, value, maxSequenceLength)
+	}
+	return length, nil
+}
+
+// Validate checks that the event names the topic to publish to, and that its
+// sequence length is valid.
+func (p *OrderingProbe) Validate(event cloudevents.Event) error {
+	if err := requireExtensions(event, "Ordering", topicExtension); err != nil {
+		return err
+	}
+	_, err := sequenceLength(event)
+	return err
+}
+
+// Forward publishes the sequence of events of an ordering probe to a topic,
+// with the ID of the probe as their ordering key, and waits for all of them to
+// be received in order.
+func (p *OrderingProbe) Forward(ctx context.Context, event cloudevents.Event) error {
+	length, err := sequenceLength(event)
+	if err != nil {
+		return err
+	}
+	topic, ok := event.Extensions()[topicExtension]
+	if !ok {
+		return fmt.Errorf("Ordering probe event has no '%s' extension", topicExtension)
+	}
+	orderingKey := event.ID()
+
+	// Create the receiver channel, and track the arrivals of the sequence
+	channelID := channelID(fmt.Sprint(event.Extensions()[utils.ProbeEventTargetPathExtension]), orderingKey)
+	cleanupFunc, err := p.receivedEvents.CreateReceiverChannel(channelID)
+	if err != nil {
+		return fmt.Errorf("Failed to create receiver channel: %v", err)
+	}
+	defer cleanupFunc()
+	defer p.sequences.track(orderingKey, length)()
+
+	// The probe publishes the sequence of events as messages with the same
+	// ordering key to a given Pub/Sub topic.
+	logging.FromContext(ctx).Infow("Publishing ordered messages to pubsub topic", zap.String("topic", fmt.Sprint(topic)), zap.Int("sequenceLength", length))
+	t := p.pubsubClient.Topic(fmt.Sprint(topic))
+	t.EnableMessageOrdering = true
+	defer t.Stop()
+	results := make([]*pubsub.PublishResult, 0, length)
+	for seq := 0; seq < length; seq++ {
+		seqEvent := event.Clone()
+		seqEvent.SetID(fmt.Sprintf("%s-%d", event.ID(), seq))
+		seqEvent.SetExtension(seqExtension, seq)
+		seqEvent.SetExtension(orderingKeyExtension, orderingKey)
+		msg := &pubsub.Message{OrderingKey: orderingKey}
+		if err := cepubsub.WritePubSubMessage(ctx, binding.ToMessage(&seqEvent), msg); err != nil {
+			return fmt.Errorf("Failed to write event %d of the sequence as a pubsub message: %v", seq, err)
+		}
+		results = append(results, t.Publish(ctx, msg))
+	}
+	for seq, res := range results {
+		if _, err := res.Get(ctx); err != nil {
+			return fmt.Errorf("Failed to publish message %d of the sequence to pubsub topic '%s': %v", seq, topic, err)
+		}
+	}
+
+	return p.receivedEvents.WaitOnReceiverChannel(ctx, channelID)
+}
+
+// orderedSequence is the order of arrival of the events of a sequence.
+type orderedSequence struct {
+	// The number of events of the sequence
+	length int
+	// The positions of the events of the sequence in their order of arrival
+	arrivals []int
+}
+
+// inOrder returns whether the events of the sequence arrived in order so far.
+func (s *orderedSequence) inOrder() bool {
+	for i, seq := range s.arrivals {
+		if seq != i {
+			return false
+		}
+	}
+	return true
+}
+
+// orderedSequences is a synchronized map of the sequences of the ordering
+// probes in flight, keyed by their ordering key.
+type orderedSequences struct {
+	mu        sync.Mutex
+	sequences map[string]*orderedSequence
+}
+
+// track starts recording the order of arrival of the events of the sequence
+// with a given ordering key, and returns the function which stops it.
+func (s *orderedSequences) track(orderingKey string, length int) func() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sequences[orderingKey] = &orderedSequence{length: length}
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		delete(s.sequences, orderingKey)
+	}
+}
+
+// arrive records the arrival of the event at a given position of the sequence
+// with a given ordering key, and returns the positions of the events of the
+// sequence in their order of arrival so far. The redeliveries of the events
+// which already arrived are ignored.
+func (s *orderedSequences) arrive(orderingKey string, seq int) (orderedSequence, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sequence, ok := s.sequences[orderingKey]
+	if !ok {
+		return orderedSequence{}, fmt.Errorf("%w for ordering key: %s", utils.ErrNotTracked, orderingKey)
+	}
+	redelivered := false
+	for _, arrived := range sequence.arrivals {
+		if arrived == seq {
+			redelivered = true
+		}
+	}
+	if !redelivered {
+		sequence.arrivals = append(sequence.arrivals, seq)
+	}
+	return orderedSequence{length: sequence.length, arrivals: append([]int(nil), sequence.arrivals...)}, nil
+}
+
+// receiveOrdered records the arrival of an event of the sequence of an
+// ordering probe, held in the attributes of the Pub/Sub message delivering it.
+// The probe fails as soon as an event arrives out of order, and succeeds once
+// all the events of the sequence arrived in order.
+func (p *CloudPubSubSourceProbe) receiveOrdered(ctx context.Context, receiverPath string, attributes map[string]string) error {
+	orderingKey := attributes["ce-"+orderingKeyExtension]
+	seq, err := strconv.Atoi(attributes["ce-"+seqExtension])
+	if err != nil {
+		return fmt.Errorf("Failed to read the position in the sequence of ordered message: %v", err)
+	}
+	sequence, err := p.sequences.arrive(orderingKey, seq)
+	if err != nil {
+		return err
+	}
+	channelID := channelID(receiverPath, orderingKey)
+	if !sequence.inOrder() {
+		want := make([]int, len(sequence.arrivals))
+		for i := range want {
+			want[i] = i
+		}
+		return p.receivedEvents.FailReceiverChannel(channelID, fmt.Errorf("received the events of ordering key %s in the order %v, want %v", orderingKey, sequence.arrivals, want))
+	}
+	if len(sequence.arrivals) < sequence.length {
+		return nil
+	}
+	logging.FromContext(utils.WithProbeIDLogger(ctx, orderingKey)).Info("Successfully received ordering probe events in order")
+	return p.receivedEvents.SignalReceiverChannel(channelID)
+}
@@ -0,0 +1,193 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"sync"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/google/knative-gcp/test/test_images/probe_helper/utils"
+	"k8s.io/apimachinery/pkg/util/clock"
+)
+
+const (
+	// toleranceExtension is the CloudEvent extension containing the fraction
+	// of the period by which the delay of a periodic source may exceed its
+	// period.
+	toleranceExtension = "tolerance"
+
+	// ticksExtension is the CloudEvent extension containing the number of
+	// consecutive ticks within tolerance which a periodic source probe waits
+	// on before succeeding.
+	ticksExtension = "ticks"
+
+	// jitterExtension is the CloudEvent extension containing the window within
+	// which a periodic source probe delays its first check, so that concurrent
+	// probes with the same period do not check the ticks in lockstep.
+	jitterExtension = "jitter"
+)
+
+// periodCheck is how the delays between the ticks of a periodic source are
+// checked.
+type periodCheck struct {
+	// period is the expected delay between two ticks.
+	period time.Duration
+	// tolerance is the fraction of the period by which a delay may exceed it.
+	tolerance float64
+	// ticks is the number of consecutive ticks which must be observed.
+	ticks int
+	// jitter is the delay of the first check, within the jitter window.
+	jitter time.Duration
+}
+
+// parsePeriodCheck parses the period check of a periodic source probe event
+// from its period, 'tolerance', 'ticks' and 'jitter' extensions.
+func parsePeriodCheck(event cloudevents.Event, probe, periodExtension string) (periodCheck, error) {
+	check := periodCheck{ticks: 1}
+	period, ok := event.Extensions()[periodExtension]
+	if !ok {
+		return check, fmt.Errorf("%w: %s probe event has no '%s' extension", ErrMissingExtension, probe, periodExtension)
+	}
+	var err error
+	if check.period, err = time.ParseDuration(fmt.Sprint(period)); err != nil {
+		return check, fmt.Errorf("failed to parse %s probe period: %v", probe, err)
+	}
+	if tolerance, ok := event.Extensions()[toleranceExtension]; ok {
+		if check.tolerance, err = strconv.ParseFloat(fmt.Sprint(tolerance), 64); err != nil || check.tolerance < 0 {
+			return check, fmt.Errorf("invalid %s probe tolerance %v, it must be a non-negative fraction", probe, tolerance)
+		}
+	}
+	if ticks, ok := event.Extensions()[ticksExtension]; ok {
+		if check.ticks, err = strconv.Atoi(fmt.Sprint(ticks)); err != nil || check.ticks < 1 {
+			return check, fmt.Errorf("invalid %s probe ticks %v, it must be a positive integer", probe, ticks)
+		}
+	}
+	if jitter, ok := event.Extensions()[jitterExtension]; ok {
+		window, err := time.ParseDuration(fmt.Sprint(jitter))
+		if err != nil || window < 0 || window > check.period {
+			return check, fmt.Errorf("invalid %s probe jitter %v, it must be a non-negative duration not exceeding the period", probe, jitter)
+		}
+		check.jitter = probeJitter(event.ID(), window)
+	}
+	return check, nil
+}
+
+// probeJitter returns the jitter of a probe within a given window. The jitter
+// is pseudo-random yet derived from the probe ID, so that the probes are
+// spread over the window while each of them has a reproducible jitter.
+func probeJitter(probeID string, window time.Duration) time.Duration {
+	if window <= 0 {
+		return 0
+	}
+	h := fnv.New64a()
+	h.Write([]byte(probeID))
+	return time.Duration(h.Sum64() % uint64(window))
+}
+
+// maxDelay returns the delay after which a tick is missed.
+func (c periodCheck) maxDelay() time.Duration {
+	return c.period + time.Duration(c.tolerance*float64(c.period))
+}
+
+// tickWaiters notifies the probes waiting on the next tick in a given scope.
+// Its zero value is ready to use.
+type tickWaiters struct {
+	sync.Mutex
+	next map[string]chan struct{}
+}
+
+// wait returns a channel which is closed on the next tick in a given scope.
+func (w *tickWaiters) wait(timestampID string) <-chan struct{} {
+	w.Lock()
+	defer w.Unlock()
+
+	if w.next == nil {
+		w.next = map[string]chan struct{}{}
+	}
+	next, ok := w.next[timestampID]
+	if !ok {
+		next = make(chan struct{})
+		w.next[timestampID] = next
+	}
+	return next
+}
+
+// tick notifies the probes waiting on the next tick in a given scope.
+func (w *tickWaiters) tick(timestampID string) {
+	w.Lock()
+	defer w.Unlock()
+
+	if next, ok := w.next[timestampID]; ok {
+		close(next)
+		delete(w.next, timestampID)
+	}
+}
+
+// checkTicks checks that the consecutive ticks recorded by a periodic source
+// probe in a given scope are within the tolerance of their period. It waits
+// until the number of ticks of the check has been observed, the first of
+// which is the latest recorded tick once the jitter of the check has elapsed.
+// The delay of the first tick may exceed its period by the jitter as well. The
+// delays are measured and waited on with a given clock.
+func checkTicks(ctx context.Context, clock clock.Clock, times *utils.SyncTimesMap, waiters *tickWaiters, timestampID, source string, check periodCheck) error {
+	if check.jitter > 0 {
+		timer := clock.NewTimer(check.jitter)
+		select {
+		case <-timer.C():
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+	for observed := 1; ; observed++ {
+		maxDelay := check.maxDelay()
+		if observed == 1 {
+			maxDelay += check.jitter
+		}
+		// Wait on the next tick before reading the latest one, so that no
+		// tick is missed in between.
+		times.RLock()
+		latest, ok := times.Times[timestampID]
+		next := waiters.wait(timestampID)
+		times.RUnlock()
+		if !ok {
+			return fmt.Errorf("no %s tick observed", source)
+		}
+		delay := clock.Since(latest)
+		if delay > maxDelay {
+			return fmt.Errorf("%s probe delay %s exceeds period %s with tolerance %v", source, delay, check.period, check.tolerance)
+		}
+		if observed >= check.ticks {
+			return nil
+		}
+		timer := clock.NewTimer(maxDelay - delay)
+		select {
+		case <-next:
+			timer.Stop()
+		case <-timer.C():
+			return fmt.Errorf("%s probe missed tick %d of %d within period %s with tolerance %v", source, observed+1, check.ticks, check.period, check.tolerance)
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
@@ -19,11 +19,14 @@ package handlers
 import (
 	"context"
 	"fmt"
+	"path"
 	"time"
 
 	cloudevents "github.com/cloudevents/sdk-go/v2"
+	schemasv1 "github.com/google/knative-gcp/pkg/schemas/v1"
 	"github.com/google/knative-gcp/test/test_images/probe_helper/utils"
 	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/util/clock"
 	"knative.dev/pkg/logging"
 )
 
@@ -33,14 +36,38 @@ const (
 	PingSourceProbeEventType = "pingsource-probe"
 
 	pingSourcePeriodExtension = "period"
+	pingSourceNameExtension   = "pingsource"
 )
 
-func NewPingSourceProbe(staleDuration time.Duration) *PingSourceProbe {
+// pingSourceTimestampID returns the key of the time of the latest tick of a
+// PingSource in a given scope. Ticks of any PingSource are keyed by the scope.
+func pingSourceTimestampID(scope string, subject string) string {
+	if subject == "" {
+		return scope
+	}
+	return scope + "/" + subject
+}
+
+// pingSourceEventSubject returns the subject identifying the PingSource of a
+// tick event. Ticks which have no subject are identified by their source,
+// e.g. /apis/v1/namespaces/default/pingsources/test-ping-source.
+func pingSourceEventSubject(event cloudevents.Event) string {
+	if subject := event.Subject(); subject != "" {
+		return subject
+	}
+	if source := event.Source(); path.Base(path.Dir(source)) == "pingsources" {
+		return schemasv1.PingSourceEventSubject(path.Base(source))
+	}
+	return ""
+}
+
+func NewPingSourceProbe(staleDuration time.Duration, clock clock.Clock) *PingSourceProbe {
 	return &PingSourceProbe{
 		EventTimes: utils.SyncTimesMap{
 			Times: map[string]time.Time{},
 		},
 		StaleDuration: staleDuration,
+		clock:         clock,
 	}
 }
 
@@ -53,33 +80,39 @@ type PingSourceProbe struct {
 	// StaleDuration is the duration after which entries in the EventTimes map are
 	// considered stale and should be cleaned up in the liveness probe.
 	StaleDuration time.Duration
+
+	// The clock with which the ticks are timed
+	clock clock.Clock
+
+	// The probes waiting on the next PingSource ticks
+	waiters tickWaiters
+}
+
+// Validate checks that the event holds a valid PingSource period, tolerance,
+// number of ticks and jitter.
+func (p *PingSourceProbe) Validate(event cloudevents.Event) error {
+	_, err := parsePeriodCheck(event, "PingSource", pingSourcePeriodExtension)
+	return err
 }
 
 // Forward tests the delay between the current time and the latest recorded
-// PingSource tick in a given scope.
+// PingSource tick in a given scope, and between the consecutive ticks which
+// follow if it is to wait on several of them.
 func (p *PingSourceProbe) Forward(ctx context.Context, event cloudevents.Event) error {
-	period, ok := event.Extensions()[pingSourcePeriodExtension]
-	if !ok {
-		return fmt.Errorf("PingSourceProbe event has no '%s' extension", pingSourcePeriodExtension)
-	}
-	periodDuration, err := time.ParseDuration(fmt.Sprint(period))
+	check, err := parsePeriodCheck(event, "PingSource", pingSourcePeriodExtension)
 	if err != nil {
-		return fmt.Errorf("failed to parse PingSource probe period and time.Duration: " + fmt.Sprint(period))
+		return err
 	}
 
-	p.EventTimes.RLock()
-	defer p.EventTimes.RUnlock()
-
-	logging.FromContext(ctx).Info("Checking last observed PingSource tick")
-	timestampID := fmt.Sprint(event.Extensions()[utils.ProbeEventTargetPathExtension])
-	pingSourceTime, ok := p.EventTimes.Times[timestampID]
-	if !ok {
-		return fmt.Errorf("no PingSource tick observed")
+	// The probe waits on the ticks of a specific PingSource if one is given.
+	var subject string
+	if name, ok := event.Extensions()[pingSourceNameExtension]; ok {
+		subject = schemasv1.PingSourceEventSubject(fmt.Sprint(name))
 	}
-	if delay := time.Now().Sub(pingSourceTime); delay.Nanoseconds() > periodDuration.Nanoseconds() {
-		return fmt.Errorf("PingSource probe delay %s exceeds period %s", delay, periodDuration)
-	}
-	return nil
+
+	logging.FromContext(ctx).Infow("Checking last observed PingSource tick", zap.String("subject", subject), zap.Int("ticks", check.ticks))
+	timestampID := pingSourceTimestampID(fmt.Sprint(event.Extensions()[utils.ProbeEventTargetPathExtension]), subject)
+	return checkTicks(ctx, p.clock, &p.EventTimes, &p.waiters, timestampID, "PingSource", check)
 }
 
 // Receive refreshes the latest timestamp for a PingSource tick in a given scope.
@@ -91,6 +124,7 @@ func (p *PingSourceProbe) Receive(ctx context.Context, event cloudevents.Event)
 	//     specversion: 1.0
 	//     type: dev.knative.sources.ping
 	//     source: /apis/v1/namespaces/default/pingsources/test-ping-source-9af24c86-8ba9-4688-80d0-e527678a6a63
+	//     subject: pingsources/test-ping-source-9af24c86-8ba9-4688-80d0-e527678a6a63
 	//     id: 1533039115503825
 	//     time: 2020-09-15T20:12:00.14Z
 	//     datacontenttype: application/json
@@ -99,8 +133,14 @@ func (p *PingSourceProbe) Receive(ctx context.Context, event cloudevents.Event)
 	p.EventTimes.Lock()
 	defer p.EventTimes.Unlock()
 
-	timestampID := fmt.Sprint(event.Extensions()[utils.ProbeEventReceiverPathExtension])
-	p.EventTimes.Times[timestampID] = time.Now()
+	scope := fmt.Sprint(event.Extensions()[utils.ProbeEventReceiverPathExtension])
+	now := p.clock.Now()
+	p.EventTimes.Times[pingSourceTimestampID(scope, "")] = now
+	p.waiters.tick(pingSourceTimestampID(scope, ""))
+	if subject := pingSourceEventSubject(event); subject != "" {
+		p.EventTimes.Times[pingSourceTimestampID(scope, subject)] = now
+		p.waiters.tick(pingSourceTimestampID(scope, subject))
+	}
 	logging.FromContext(ctx).Info("Successfully received PingSource probe event")
 	return nil
 }
@@ -113,7 +153,7 @@ func (p *PingSourceProbe) CleanupStalePingSourceTimes() utils.ActionFunc {
 		defer p.EventTimes.Unlock()
 
 		for timestampID, pingSourceTime := range p.EventTimes.Times {
-			if delay := time.Now().Sub(pingSourceTime); delay.Nanoseconds() > p.StaleDuration.Nanoseconds() {
+			if delay := p.clock.Since(pingSourceTime); delay.Nanoseconds() > p.StaleDuration.Nanoseconds() {
 				logging.FromContext(ctx).Infow("Deleting stale PingSource time", zap.String("timestampID", timestampID), zap.Duration("delay", delay))
 				delete(p.EventTimes.Times, timestampID)
 			}
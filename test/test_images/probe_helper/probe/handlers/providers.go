@@ -25,21 +25,30 @@ import (
 var HandlerSet wire.ProviderSet = wire.NewSet(
 	wire.Bind(new(Interface), new(*EventTypeProbe)),
 	NewEventTypeHandler,
-	utils.NewSyncReceivedEvents,
 	NewBrokerE2EDeliveryProbe,
+	NewBrokerDLQProbe,
+	NewBrokerRejectProbe,
+	NewChannelE2EDeliveryProbe,
 	NewCloudAuditLogsSourceProbe,
+	wire.Struct(new(CloudAuditLogsSourceDeleteProbe), "*"),
 	NewApiServerSourceProbe,
 	wire.Struct(new(ApiServerSourceCreateProbe), "*"),
 	wire.Struct(new(ApiServerSourceUpdateProbe), "*"),
 	wire.Struct(new(ApiServerSourceDeleteProbe), "*"),
 	NewCloudPubSubSourceProbe,
 	NewCloudSchedulerSourceProbe,
+	wire.Struct(new(CloudSchedulerSourceRateProbe), "*"),
 	NewPingSourceProbe,
+	NewPubSubRoundtripProbe,
+	NewOrderingProbe,
 	NewCloudStorageSourceProbe,
 	wire.Struct(new(CloudStorageSourceCreateProbe), "*"),
 	wire.Struct(new(CloudStorageSourceDeleteProbe), "*"),
 	wire.Struct(new(CloudStorageSourceArchiveProbe), "*"),
 	wire.Struct(new(CloudStorageSourceUpdateMetadataProbe), "*"),
+	wire.Struct(new(CloudStorageSourceComposeProbe), "*"),
+	wire.Struct(new(CloudStorageSourcePrefixProbe), "*"),
+	wire.Struct(new(CloudStorageSourceResumableProbe), "*"),
 	NewLivenessChecker,
 )
 
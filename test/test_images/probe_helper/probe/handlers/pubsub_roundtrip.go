@@ -0,0 +1,109 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"cloud.google.com/go/pubsub"
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"go.uber.org/zap"
+	"knative.dev/pkg/logging"
+)
+
+const (
+	// PubSubRoundtripProbeEventType is the CloudEvent type of forward Pub/Sub
+	// roundtrip probes.
+	PubSubRoundtripProbeEventType = "pubsub-roundtrip-probe"
+
+	// probeIDAttribute is the attribute of the messages published by the
+	// Pub/Sub roundtrip probes which holds the ID of their probe.
+	probeIDAttribute = "probeid"
+)
+
+func NewPubSubRoundtripProbe(pubsubClient *pubsub.Client) *PubSubRoundtripProbe {
+	return &PubSubRoundtripProbe{
+		pubsubClient: pubsubClient,
+	}
+}
+
+// PubSubRoundtripProbe is the probe handler for probe requests in the Pub/Sub
+// roundtrip probe, which publishes a message to a topic and pulls it back from
+// a subscription of the topic without going through any source.
+type PubSubRoundtripProbe struct {
+	// The pubsub client used to publish and pull the messages
+	pubsubClient *pubsub.Client
+}
+
+// Validate checks that the event names the topic to publish to and the
+// subscription to pull from.
+func (p *PubSubRoundtripProbe) Validate(event cloudevents.Event) error {
+	return requireExtensions(event, "Pub/Sub roundtrip", topicExtension, subscriptionExtension)
+}
+
+// Forward publishes a message carrying the ID of the probe to a topic, and
+// waits for it to be pulled from a subscription. The messages of other probes
+// are nacked so that their own probes can pull them.
+func (p *PubSubRoundtripProbe) Forward(ctx context.Context, event cloudevents.Event) error {
+	topic := fmt.Sprint(event.Extensions()[topicExtension])
+	subscription := fmt.Sprint(event.Extensions()[subscriptionExtension])
+
+	// The probe publishes a message to the topic.
+	logging.FromContext(ctx).Infow("Publishing message to pubsub topic", zap.String("topic", topic))
+	t := p.pubsubClient.Topic(topic)
+	defer t.Stop()
+	res := t.Publish(ctx, &pubsub.Message{
+		Data:       event.Data(),
+		Attributes: map[string]string{probeIDAttribute: event.ID()},
+	})
+	if _, err := res.Get(ctx); err != nil {
+		return fmt.Errorf("Failed to publish message to pubsub topic '%s': %v", topic, err)
+	}
+
+	// The probe waits for the message to be pulled from the subscription.
+	receiveCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var once sync.Once
+	received := false
+	err := p.pubsubClient.Subscription(subscription).Receive(receiveCtx, func(_ context.Context, msg *pubsub.Message) {
+		if msg.Attributes[probeIDAttribute] != event.ID() {
+			msg.Nack()
+			return
+		}
+		msg.Ack()
+		once.Do(func() {
+			received = true
+			cancel()
+		})
+	})
+	if received {
+		logging.FromContext(ctx).Info("Successfully received Pub/Sub roundtrip probe message")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to receive from pubsub subscription '%s': %v", subscription, err)
+	}
+	return fmt.Errorf("Timed out waiting on pubsub subscription '%s' to receive the probe message: %v", subscription, ctx.Err())
+}
+
+// Receive rejects the events delivered to the receiver, since the messages of
+// Pub/Sub roundtrip probes are pulled by the forwarder instead.
+func (p *PubSubRoundtripProbe) Receive(ctx context.Context, event cloudevents.Event) error {
+	return fmt.Errorf("Pub/Sub roundtrip probe messages are not delivered to the receiver")
+}
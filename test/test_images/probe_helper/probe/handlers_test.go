@@ -0,0 +1,262 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package probe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+
+	logtest "knative.dev/pkg/logging/testing"
+
+	schemasv1 "github.com/google/knative-gcp/pkg/schemas/v1"
+	"github.com/google/knative-gcp/test/test_images/probe_helper/probe/handlers"
+	"github.com/google/knative-gcp/test/test_images/probe_helper/utils"
+	sourcesv1beta1 "knative.dev/eventing/pkg/apis/sources/v1beta1"
+
+	"k8s.io/apimachinery/pkg/util/clock"
+)
+
+func TestCloudStorageSourceSubjectPattern(t *testing.T) {
+	cases := []struct {
+		name    string
+		pattern string
+		subject string
+		wantErr bool
+	}{{
+		name:    "matching subject",
+		pattern: `^objects/archive/[0-9]+#[0-9]+$`,
+		subject: "objects/archive/1234567890#1600103920984245",
+	}, {
+		name:    "non-matching subject",
+		pattern: `^objects/archive/[0-9]+#[0-9]+$`,
+		subject: "objects/backup/1234567890#1600103920984245",
+		wantErr: true,
+	}, {
+		name:    "invalid pattern",
+		pattern: `objects/(archive`,
+		subject: "objects/archive/1234567890#1600103920984245",
+		wantErr: true,
+	}}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			storageClient, gotRequest, closeStorage := testStorageClient(ctx, t)
+			defer closeStorage()
+			go func() {
+				for {
+					select {
+					case <-gotRequest:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}()
+
+			probe := &handlers.CloudStorageSourceCreateProbe{CloudStorageSourceProbe: handlers.NewCloudStorageSourceProbe(storageClient, utils.NewInMemoryCorrelationStore(clock.RealClock{}))}
+			event := probeEvent("cloudstoragesource-probe-create",
+				withProbeExtension("bucket", "test-bucket"),
+				withProbeExtension("subjectpattern", tc.pattern))
+			forwardErr := make(chan error, 1)
+			go func() {
+				forwardErr <- probe.Forward(ctx, *event)
+			}()
+
+			// Deliver the notification until either it is received or the
+			// forward probe returns.
+			notification := cloudevents.NewEvent()
+			notification.SetID("1529343217463053")
+			notification.SetSource("//storage.googleapis.com/projects/_/buckets/test-bucket")
+			notification.SetType(schemasv1.CloudStorageObjectFinalizedEventType)
+			notification.SetSubject(tc.subject)
+			notification.SetExtension(utils.ProbeEventReceiverPathExtension, "/test-namespace")
+			ticker := time.NewTicker(10 * time.Millisecond)
+			defer ticker.Stop()
+			var err error
+			received := false
+		deliver:
+			for {
+				select {
+				case err = <-forwardErr:
+					break deliver
+				case <-ticker.C:
+					if !received {
+						received = probe.Receive(ctx, notification) == nil
+					}
+				}
+			}
+			if gotErr := err != nil; gotErr != tc.wantErr {
+				t.Errorf("Forward() got error %v, want error %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestPingSourceProbeFakeClock(t *testing.T) {
+	ctx := logtest.TestContextWithLogger(t)
+	fakeClock := clock.NewFakeClock(time.Now())
+	probe := handlers.NewPingSourceProbe(time.Hour, fakeClock)
+
+	tick := cloudevents.NewEvent()
+	tick.SetID("1234567890")
+	tick.SetSource("/apis/v1/namespaces/default/pingsources/" + testPingSource)
+	tick.SetType(sourcesv1beta1.PingSourceEventType)
+	tick.SetExtension(utils.ProbeEventReceiverPathExtension, "/"+testTargetReceiverPath)
+	receiveTick := func() {
+		t.Helper()
+		if err := probe.Receive(ctx, tick); err != nil {
+			t.Fatal("Failed to receive PingSource tick:", err)
+		}
+	}
+	// forward forwards a probe of a one minute period once the fake clock is
+	// waited on, if it is to wait on the next ticks.
+	forward := func(ticks string) <-chan error {
+		result := make(chan error, 1)
+		go func() {
+			result <- probe.Forward(ctx, *probeEvent("pingsource-probe", withProbeExtension("period", "1m"), withProbeExtension("ticks", ticks)))
+		}()
+		if ticks != "1" {
+			for !fakeClock.HasWaiters() {
+				time.Sleep(time.Millisecond)
+			}
+		}
+		return result
+	}
+
+	receiveTick()
+	fakeClock.Step(30 * time.Second)
+	if err := <-forward("1"); err != nil {
+		t.Errorf("probe within its period got error: %v", err)
+	}
+	fakeClock.Step(time.Minute)
+	if err := <-forward("1"); err == nil || !strings.Contains(err.Error(), "exceeds period") {
+		t.Errorf("probe past its period got error %v, want the delay to exceed the period", err)
+	}
+
+	// The probe waits on the next tick, which is received within the period.
+	receiveTick()
+	result := forward("2")
+	fakeClock.Step(30 * time.Second)
+	receiveTick()
+	if err := <-result; err != nil {
+		t.Errorf("probe of two ticks within their period got error: %v", err)
+	}
+
+	// The probe gives up on the next tick once the period elapses.
+	result = forward("2")
+	fakeClock.Step(time.Minute + time.Second)
+	if err := <-result; err == nil || !strings.Contains(err.Error(), "missed tick") {
+		t.Errorf("probe of a missed tick got error %v, want the tick to be missed", err)
+	}
+}
+
+func TestOrderingProbe(t *testing.T) {
+	ctx := logtest.TestContextWithLogger(t)
+	pubsubClient, closePubsub := testPubsubClient(ctx, t, testProjectID)
+	defer closePubsub()
+	// The messages published to the topic are not delivered by any source,
+	// so that the test delivers them in the order of each case instead.
+	if _, err := pubsubClient.CreateTopic(ctx, testOrderingTopicID); err != nil {
+		t.Fatalf("Failed to create ordering test topic: %v", err)
+	}
+
+	cases := []struct {
+		name string
+		// The positions in the sequence of the delivered events, in their
+		// order of delivery
+		deliveries []int
+		wantErr    string
+	}{{
+		name:       "in order",
+		deliveries: []int{0, 1, 2},
+	}, {
+		name:       "redelivered in order",
+		deliveries: []int{0, 0, 1, 2},
+	}, {
+		name:       "out of order",
+		deliveries: []int{0, 2},
+		wantErr:    "in the order [0 2], want [0 1]",
+	}}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cloudPubSubSourceProbe := handlers.NewCloudPubSubSourceProbe(nil, utils.NewInMemoryCorrelationStore(clock.RealClock{}))
+			orderingProbe := handlers.NewOrderingProbe(cloudPubSubSourceProbe, pubsubClient)
+
+			event := probeEvent("ordering-probe", withProbeExtension("topic", testOrderingTopicID), withProbeExtension("sequencelength", "3"))
+			ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+			defer cancel()
+			forwarded := make(chan error, 1)
+			go func() {
+				forwarded <- orderingProbe.Forward(ctx, *event)
+			}()
+
+			// The events are delivered once the probe tracks the sequence.
+			for i, seq := range tc.deliveries {
+				for {
+					err := orderingProbe.Receive(ctx, orderedTestEvent(t, event.ID(), seq))
+					if err == nil {
+						break
+					}
+					if i > 0 || !errors.Is(err, utils.ErrNotTracked) {
+						t.Fatalf("Failed to receive event %d of the sequence: %v", seq, err)
+					}
+					time.Sleep(10 * time.Millisecond)
+				}
+			}
+
+			err := <-forwarded
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Errorf("ordering probe got error %v, want none", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("ordering probe got error %v, want it to contain %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+// orderedTestEvent returns the event with which a CloudPubSubSource delivers
+// the message at a given position of the sequence of an ordering probe.
+func orderedTestEvent(t *testing.T, orderingKey string, seq int) cloudevents.Event {
+	event := cloudevents.NewEvent()
+	event.SetID(fmt.Sprintf("message-%d", seq))
+	event.SetSource("test-source")
+	event.SetType(schemasv1.CloudPubSubMessagePublishedEventType)
+	event.SetExtension(utils.ProbeEventReceiverPathExtension, "/"+testTargetReceiverPath)
+	if err := event.SetData(cloudevents.ApplicationJSON, schemasv1.PushMessage{
+		Subscription: testOrderingSubscriptionID,
+		Message: &schemasv1.PubSubMessage{
+			Attributes: map[string]string{
+				"ce-id":          fmt.Sprintf("%s-%d", orderingKey, seq),
+				"ce-seq":         fmt.Sprint(seq),
+				"ce-orderingkey": orderingKey,
+			},
+		},
+	}); err != nil {
+		t.Fatal("Failed to set data of delivered event:", err)
+	}
+	return event
+}
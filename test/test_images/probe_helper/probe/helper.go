@@ -18,11 +18,21 @@ package probe
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
 	"time"
 
 	cloudevents "github.com/cloudevents/sdk-go/v2"
+	cehttp "github.com/cloudevents/sdk-go/v2/protocol/http"
+	"go.uber.org/multierr"
 	"go.uber.org/zap"
+	"golang.org/x/sync/semaphore"
+	"k8s.io/apimachinery/pkg/util/clock"
 
 	"knative.dev/pkg/logging"
 
@@ -30,12 +40,37 @@ import (
 	"github.com/google/knative-gcp/test/test_images/probe_helper/utils"
 )
 
-// withProbeTimeout returns a context with a timeout specified from the 'timeout'
-// extension of a given CloudEvent, defaulting to a certain value if not specified,
+const (
+	// metricsPath is the path along which the probe metrics are served.
+	metricsPath = "/metrics"
+
+	// debugProbesPath is the path along which the in-flight probes are listed.
+	debugProbesPath = "/debug/probes"
+	// debugRecentPath is the path along which the results of the last
+	// completed probes are listed.
+	debugRecentPath = "/debug/recent"
+	// debugBackendsPath is the path along which the outcome of the last check
+	// of the backends is listed.
+	debugBackendsPath = "/debug/backends"
+
+	// drainPollPeriod is the period at which the in-flight probes are checked
+	// while draining.
+	drainPollPeriod = 100 * time.Millisecond
+
+	// The components of the probe helper which must start before it is ready.
+	forwarderComponent = "forwarder"
+	receiverComponent  = "receiver"
+)
+
+// withProbeTimeout returns a context with a timeout specified from the timeout
+// override of the event's probe type, or otherwise from the 'timeout' extension
+// of a given CloudEvent, defaulting to a certain value if neither is specified,
 // and capped to a maximum.
 func (ph *Helper) withProbeTimeout(ctx context.Context, event cloudevents.Event) (context.Context, context.CancelFunc) {
 	timeout := ph.env.DefaultTimeoutDuration
-	if _, ok := event.Extensions()[utils.ProbeEventTimeoutExtension]; ok {
+	if perTypeTimeout, ok := ph.env.PerTypeTimeoutDuration[event.Type()]; ok {
+		timeout = perTypeTimeout
+	} else if _, ok := event.Extensions()[utils.ProbeEventTimeoutExtension]; ok {
 		customTimeoutExtension := fmt.Sprint(event.Extensions()[utils.ProbeEventTimeoutExtension])
 		if customTimeout, err := time.ParseDuration(customTimeoutExtension); err != nil {
 			logging.FromContext(ctx).Warnw("Failed to parse custom timeout extension duration", zap.String("timeout", customTimeoutExtension), zap.Error(err))
@@ -71,31 +106,237 @@ type cloudEventsFunc func(cloudevents.Event) cloudevents.Result
 // through the specified probe port listener.
 func (ph *Helper) forwardFromProbe(ctx context.Context) cloudEventsFunc {
 	return func(event cloudevents.Event) cloudevents.Result {
-		// Attach important metadata about the event to the logging context.
+		// Attach important metadata about the event to the logging context,
+		// and tag the log lines of the probe with its ID, which the receiver
+		// also tags the log lines of its matching events with.
 		ctx := withProbeEventLoggingContext(ctx, event)
+		ctx = utils.WithProbeIDLogger(ctx, event.ID())
 		// Scope this to debug level log to avoid log clutter in case of unintended probe requests.
 		logging.FromContext(ctx).Debugw("Received probe request")
 
+		// Abort the probe once its request is cancelled or past its deadline
+		ctx, cancel := ph.withProbeRequestContext(ctx, &event)
+		defer cancel()
+
 		// Refresh the forward probe liveness time
-		ph.lastForwardEventTime.SetNow()
+		start := ph.clock.Now()
+		ph.lastForwardEventTime.Set(start)
+
+		// Stop accepting probes once the probe helper is draining
+		if ph.isDraining() {
+			logging.FromContext(ctx).Debugw("Probe forwarding failed, probe helper is draining")
+			return cloudevents.NewHTTPResult(http.StatusServiceUnavailable, "probe helper is draining")
+		}
+
+		// Reject the probes of unrecognized types before anything is allocated
+		// for them
+		if !ph.isProbeTypeRecognized(event.Type()) {
+			logging.FromContext(ctx).Debugw("Probe forwarding failed, unrecognized probe type")
+			return ph.failProbe(ctx, event, start, newFailureResult(UnrecognizedProbeTypeReason, "%v '%s'", handlers.ErrUnrecognizedProbeType, event.Type()))
+		}
+
+		// Reject the probes of disabled types rather than letting them time out
+		if !ph.isProbeTypeEnabled(event.Type()) {
+			logging.FromContext(ctx).Debugw("Probe forwarding failed, probe type disabled")
+			return ph.failProbe(ctx, event, start, newFailureResult(ProbeTypeDisabledReason, "probe type %s is disabled", event.Type()))
+		}
+
+		// Bound the number of distinct probe types which are handled
+		if !ph.probeTypes.admit(event.Type()) {
+			logging.FromContext(ctx).Warnw("Probe forwarding failed, too many distinct probe types", zap.Int("maxProbeTypes", ph.env.MaxProbeTypes))
+			return ph.failProbe(ctx, event, start, newFailureResult(TooManyProbeTypesReason, "too many distinct probe types, at most %d are handled", ph.env.MaxProbeTypes))
+		}
+
+		// Reject the extensions which would be confused with the attributes of
+		// the event on the way to the receiver
+		if err := checkReservedExtensions(event); err != nil {
+			logging.FromContext(ctx).Debugw("Probe forwarding failed, reserved extension name", zap.Error(err))
+			return ph.failProbe(ctx, event, start, newFailureResult(ReservedExtensionReason, "%v", err))
+		}
 
 		// Ensure there is a targetpath CloudEvent extension
-		if _, ok := event.Extensions()[utils.ProbeEventTargetPathExtension]; !ok {
+		targetPath, ok := event.Extensions()[utils.ProbeEventTargetPathExtension]
+		if !ok {
 			logging.FromContext(ctx).Debugf("Probe forwarding failed, forward probe event missing '%s' extension", utils.ProbeEventTargetPathExtension)
-			return cloudevents.ResultNACK
+			return ph.failProbe(ctx, event, start, newFailureResult(MissingExtensionReason, "probe event has no '%s' extension", utils.ProbeEventTargetPathExtension))
+		}
+		// The event must be delivered back along a path served by the receiver
+		if err := validateTargetPath(ph.env.ReceiverPathPrefix, fmt.Sprint(targetPath)); err != nil {
+			logging.FromContext(ctx).Debugw("Probe forwarding failed, invalid target path", zap.Error(err))
+			return ph.failProbe(ctx, event, start, newFailureResult(InvalidTargetPathReason, "%v", err))
+		}
+		// The events of the source probes are expected along the path of
+		// their source if each source has its own path
+		if source, ok := handlers.ForwardSource(event.Type()); ok && ph.env.SourceReceiverPaths {
+			sourcePath := sourceReceiverPath(fmt.Sprint(targetPath), source)
+			if err := validateTargetPath(ph.env.ReceiverPathPrefix, sourcePath); err != nil {
+				logging.FromContext(ctx).Debugw("Probe forwarding failed, invalid source path", zap.Error(err))
+				return ph.failProbe(ctx, event, start, newFailureResult(InvalidTargetPathReason, "%v", err))
+			}
+			logging.FromContext(ctx).Debugw("Expecting probe events along source path", zap.String("sourcePath", sourcePath))
 		}
 
-		// Add timeout to the context
-		ctx, cancel := ph.withProbeTimeout(ctx, event)
-		defer cancel()
+		// Generate the requested payload and reject payloads over the maximum
+		// size before forwarding them
+		event, err := withGeneratedPayload(event)
+		if err != nil {
+			logging.FromContext(ctx).Debugw("Probe forwarding failed, invalid payload size", zap.Error(err))
+			return ph.failProbe(ctx, event, start, newFailureResult(InvalidExtensionReason, "%v", err))
+		}
+		if err := ph.checkPayloadSize(event); err != nil {
+			logging.FromContext(ctx).Debugw("Probe forwarding failed, payload too large", zap.Error(err))
+			return ph.failProbe(ctx, event, start, newFailureResult(PayloadTooLargeReason, "%v", err))
+		}
 
-		// Forward the probe event. This call is likely to be blocking.
-		if err := ph.probeHandler.Forward(ctx, event); err != nil {
-			logging.FromContext(ctx).Debugw("Probe forwarding failed", zap.Error(err))
-			return cloudevents.ResultNACK
+		// Only validate the probe event in dry-run mode
+		if ph.isDryRun(event) {
+			if err := ph.validateProbe(event); err != nil {
+				logging.FromContext(ctx).Debugw("Probe validation failed", zap.Error(err))
+				return newFailureResult(validationFailureReason(err), "%v", err)
+			}
+			return cloudevents.ResultACK
 		}
-		return cloudevents.ResultACK
+
+		// Stamp the probe event with its forward time, against which the time
+		// of the events delivered back is checked
+		if ph.env.MaxEventTimeSkew > 0 && event.Time().IsZero() {
+			event.SetTime(start)
+		}
+
+		// Only prime the forward connection and the source path of warmup
+		// probes, without tracking them
+		if utils.IsWarmupProbeEvent(event) {
+			return ph.warmupProbe(ctx, event)
+		}
+
+		// Submissions with the idempotency key of a probe in flight share its
+		// forward and result instead of starting another one.
+		key, ok := event.Extensions()[idempotencyKeyExtension]
+		if !ok {
+			return ph.forwardProbe(ctx, event, start)
+		}
+		probe, forward := ph.idempotentProbes.attach(fmt.Sprint(key))
+		if !forward {
+			logging.FromContext(ctx).Debugw("Attaching probe request to the in-flight probe with the same idempotency key")
+			select {
+			case <-probe.done:
+				return probe.result
+			case <-ctx.Done():
+				return newFailureResult(ForwardFailedReason, "%v", ctx.Err())
+			}
+		}
+		result := ph.forwardProbe(ctx, event, start)
+		ph.idempotentProbes.complete(fmt.Sprint(key), probe, result)
+		return result
+	}
+}
+
+// forwardProbe forwards a probe request and reports its result once it is
+// known.
+func (ph *Helper) forwardProbe(ctx context.Context, event cloudevents.Event, start time.Time) cloudevents.Result {
+	// Reject the probe rather than queueing it if too many probes are in flight
+	if ph.probeSemaphore != nil {
+		if !ph.probeSemaphore.TryAcquire(1) {
+			logging.FromContext(ctx).Warnw("Probe forwarding failed, too many in-flight probes", zap.Int("maxConcurrentProbes", ph.env.MaxConcurrentProbes))
+			return ph.failProbe(ctx, event, start, newFailureResult(TooManyInFlightProbesReason, "too many in-flight probes"))
+		}
+		defer ph.probeSemaphore.Release(1)
+	}
+
+	// Reject malformed probe events before forwarding them
+	if v, ok := ph.probeHandler.(handlers.Validator); ok {
+		if err := v.Validate(event); err != nil {
+			logging.FromContext(ctx).Debugw("Probe forwarding failed, invalid probe event", zap.Error(err))
+			return ph.failProbe(ctx, event, start, newFailureResult(validationFailureReason(err), "%v", err))
+		}
+	}
+
+	// Add timeout to the context
+	ctx, cancel := ph.withProbeTimeout(ctx, event)
+	defer cancel()
+	// Retry the events sent by the probe according to its retry policy,
+	// within the retry budget shared by the probes
+	ctx = ph.withRetryPolicy(ctx, event)
+	ctx, retries, releaseRetries := ph.withRetryBudget(ctx)
+	defer releaseRetries()
+
+	// Track the probe until its forward returns, and abandon the forward once
+	// the janitor evicts the probe
+	deadline, _ := ctx.Deadline()
+	evicted := make(chan struct{})
+	untrack := ph.inFlightProbes.Add(utils.InFlightProbe{
+		ID:           event.ID(),
+		Type:         event.Type(),
+		ReceivedTime: start,
+		Deadline:     deadline,
+	}, func() { close(evicted) })
+
+	// Trace the probe until its result is known
+	ctx, span := ph.startForwardSpan(ctx, &event)
+
+	// Forward the probe event. This call is likely to be blocking.
+	forwarded := make(chan error, 1)
+	go func() {
+		err := ph.probeHandler.Forward(ctx, event)
+		untrack()
+		forwarded <- err
+	}()
+	var err error
+	select {
+	case err = <-forwarded:
+	case <-evicted:
+		err = fmt.Errorf("%w: probe evicted %s past its deadline", context.DeadlineExceeded, ph.env.EvictionGracePeriod)
+	}
+	endSpan(span, err)
+	if err != nil {
+		reason := forwardFailureReason(ctx, err)
+		if retries.isExhausted() {
+			reason = RetryBudgetExhaustedReason
+		}
+		logging.FromContext(ctx).Debugw("Probe forwarding failed", zap.String("reason", string(reason)), zap.Error(err))
+		return ph.failProbe(ctx, event, start, newFailureResult(reason, "%v", err))
+	}
+	ph.reportProbeResult(ctx, event, nil, start)
+	return cloudevents.ResultACK
+}
+
+// failProbe reports the failure of a probe, which it returns as the result of
+// the probe.
+func (ph *Helper) failProbe(ctx context.Context, event cloudevents.Event, start time.Time, failure *FailureResult) cloudevents.Result {
+	ph.reportProbeResult(ctx, event, failure, start)
+	return failure
+}
+
+// reportProbeResult records the metrics of a completed probe, which failed if
+// it has a failure result, logs its result, keeps it among the recent results
+// and sends it to the result sink. The latency of failed probes is not
+// recorded in the metrics. The logger of the context already tags the log line
+// with the ID of the probe.
+func (ph *Helper) reportProbeResult(ctx context.Context, event cloudevents.Event, failure *FailureResult, start time.Time) {
+	latency := ph.clock.Since(start)
+	result, reason := utils.ProbeResultACK, ""
+	if failure != nil {
+		result, reason = utils.ProbeResultNACK, string(failure.Reason)
+	}
+	probeType := ph.metricsProbeType(event.Type())
+	if result == utils.ProbeResultACK {
+		ph.metrics.ReportProbeLatency(probeType, latency)
 	}
+	ph.metrics.ReportProbeResult(probeType, result, handlers.MetricsTarget(event))
+	ph.recentResults.Add(utils.ProbeResult{
+		ID:            event.ID(),
+		Type:          event.Type(),
+		Result:        result,
+		Reason:        reason,
+		LatencyMs:     latency.Milliseconds(),
+		CompletedTime: ph.clock.Now(),
+	})
+	ph.sendProbeResult(ctx, event, result, latency)
+	logging.FromContext(ctx).Infow("Probe completed",
+		zap.String("probe_type", event.Type()),
+		zap.String("result", result),
+		zap.Int64("latency_ms", latency.Milliseconds()),
+	)
 }
 
 // receiveEvent is the base receiver probe request handler which is called
@@ -109,7 +350,7 @@ func (ph *Helper) receiveEvent(ctx context.Context) cloudEventsFunc {
 		logging.FromContext(ctx).Debugw("Received event")
 
 		// Refresh the receiver probe liveness time
-		ph.lastReceiverEventTime.SetNow()
+		ph.lastReceiverEventTime.Set(ph.clock.Now())
 
 		// Ensure there is a receiverpath CloudEvent extension
 		if _, ok := event.Extensions()[utils.ProbeEventReceiverPathExtension]; !ok {
@@ -117,8 +358,50 @@ func (ph *Helper) receiveEvent(ctx context.Context) cloudEventsFunc {
 			return cloudevents.ResultACK
 		}
 
+		// Drop the events sent by warmup probes, which nothing waits on
+		if utils.IsWarmupProbeEvent(event) {
+			logging.FromContext(ctx).Debugw("Probe receiver dropped warmup probe event")
+			return cloudevents.ResultACK
+		}
+
+		// Reject the events which exceed the maximum payload size
+		if err := ph.checkPayloadSize(event); err != nil {
+			logging.FromContext(ctx).Debugw("Probe receiver rejected event", zap.Error(err))
+			return cehttp.NewResult(http.StatusRequestEntityTooLarge, "%v", err)
+		}
+
+		// Route the events delivered by the sources along their own paths
+		if ph.env.SourceReceiverPaths {
+			routed, err := routeSourceEvent(event)
+			if err != nil {
+				return ph.handleUnmatchedEvent(ctx, err)
+			}
+			event = routed
+		}
+
+		// Reject the replayed events of older probes with the same ID
+		if err := ph.checkEventStaleness(event); err != nil {
+			return ph.handleUnmatchedEvent(ctx, err)
+		}
+
 		// Receive the probe event
-		if err := ph.probeHandler.Receive(ctx, event); err != nil {
+		ctx, span := ph.startReceiveSpan(ctx, event)
+		err := ph.probeHandler.Receive(ctx, event)
+		endSpan(span, err)
+		if err == nil {
+			ph.recordSourceEvent(event)
+		}
+		if errors.Is(err, handlers.ErrRedeliver) {
+			// Reject the event with a retriable status for its sender to
+			// redeliver it.
+			logging.FromContext(ctx).Debugw("Probe receiver rejected event for redelivery")
+			return cehttp.NewResult(http.StatusServiceUnavailable, "%v", err)
+		}
+		if errors.Is(err, utils.ErrNotTracked) {
+			// The event matches no probe in flight.
+			return ph.handleUnmatchedEvent(ctx, err)
+		}
+		if err != nil {
 			logging.FromContext(ctx).Debugw("Probe receiver failed", zap.Error(err))
 			return cloudevents.ResultACK
 		}
@@ -129,31 +412,268 @@ func (ph *Helper) receiveEvent(ctx context.Context) cloudEventsFunc {
 // CheckLastEventTimes returns an actionFunc which checks the delay between the
 // current time and last processed event times from the forward and receiver
 // clients. This handler is used by the liveness checker to declare the liveness
-// status of the probe helper.
+// status of the probe helper, which is starting until the receiver client
+// receives its first event.
 func (ph *Helper) CheckLastEventTimes() func(ctx context.Context) error {
 	return func(ctx context.Context) error {
+		if ph.runsReceiver() && ph.lastReceiverEventTime.Get().IsZero() {
+			return utils.NewLivenessError(utils.LivenessStartingReason, "receiver has not received any event yet: %w", utils.ErrStarting)
+		}
 		// If either of the forward or receiver clients are not processing events, something is wrong
-		now := time.Now()
-		if delay := now.Sub(ph.lastForwardEventTime.Get()); delay > ph.env.LivenessStaleDuration {
-			return fmt.Errorf("forward delay %s exceeds staleness threshold %s", delay, ph.env.LivenessStaleDuration)
+		// Only the clients which run in the role of the probe helper are checked.
+		now := ph.clock.Now()
+		if delay := now.Sub(ph.lastForwardEventTime.Get()); ph.runsForwarder() && delay > ph.env.LivenessStaleDuration {
+			return utils.NewLivenessError(utils.LivenessStaleForwardReason, "forward delay %s exceeds staleness threshold %s", delay, ph.env.LivenessStaleDuration)
 		}
-		if delay := now.Sub(ph.lastReceiverEventTime.Get()); delay > ph.env.LivenessStaleDuration {
-			return fmt.Errorf("receiver delay %s exceeds staleness threshold %s", delay, ph.env.LivenessStaleDuration)
+		if delay := now.Sub(ph.lastReceiverEventTime.Get()); ph.runsReceiver() && delay > ph.env.LivenessStaleDuration {
+			return utils.NewLivenessError(utils.LivenessStaleReceiveReason, "receiver delay %s exceeds staleness threshold %s", delay, ph.env.LivenessStaleDuration)
 		}
 		return nil
 	}
 }
 
-// Run starts the probe forwarder and receiver. This function should be called
-// after Initialize.
+// recordSourceEvent refreshes the last successful receive time of the source
+// which delivered an event.
+func (ph *Helper) recordSourceEvent(event cloudevents.Event) {
+	source, ok := handlers.ReceiveSource(event.Type())
+	if !ok {
+		return
+	}
+	if latency, ok := utils.SourceEmitLatency(event); ok {
+		ph.metrics.ReportSourceEmitLatency(source, latency)
+	}
+	ph.lastSourceEventTimes.Lock()
+	defer ph.lastSourceEventTimes.Unlock()
+	ph.lastSourceEventTimes.Times[source] = ph.clock.Now()
+}
+
+// CheckSourceEventTimes returns an actionFunc which checks the delay between
+// the current time and the last successful receive time of each source with a
+// staleness threshold in SOURCE_STALE_DURATIONS, so that the liveness check
+// fails if any of them stops delivering events.
+func (ph *Helper) CheckSourceEventTimes() func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		ph.lastSourceEventTimes.RLock()
+		defer ph.lastSourceEventTimes.RUnlock()
+
+		sources := make([]string, 0, len(ph.env.SourceStaleDurations))
+		for source := range ph.env.SourceStaleDurations {
+			sources = append(sources, source)
+		}
+		sort.Strings(sources)
+		now := ph.clock.Now()
+		var err error
+		for _, source := range sources {
+			threshold := ph.env.SourceStaleDurations[source]
+			if delay := now.Sub(ph.lastSourceEventTimes.Times[source]); delay > threshold {
+				err = multierr.Append(err, utils.NewLivenessError(utils.LivenessSourceDownReason, "source %s delay %s exceeds staleness threshold %s", source, delay, threshold))
+			}
+		}
+		return err
+	}
+}
+
+// CheckNotDraining returns an actionFunc which fails the liveness check once
+// the probe helper starts draining, so that no more probes are routed to it.
+func (ph *Helper) CheckNotDraining() func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		if ph.isDraining() {
+			return utils.NewLivenessError(utils.LivenessDrainingReason, "probe helper is draining")
+		}
+		return nil
+	}
+}
+
+func (ph *Helper) isDraining() bool {
+	select {
+	case <-ph.drainStarted:
+		return true
+	default:
+		return false
+	}
+}
+
+// drain stops accepting new probes and waits for the in-flight probes to
+// complete, for at most DRAIN_TIMEOUT.
+func (ph *Helper) drain(ctx context.Context) {
+	close(ph.drainStarted)
+	if ph.inFlightProbes.Len() == 0 {
+		return
+	}
+	logging.FromContext(ctx).Infow("Draining in-flight probes", zap.Int("inFlightProbes", ph.inFlightProbes.Len()), zap.Duration("drainTimeout", ph.env.DrainTimeout))
+	timeout := ph.clock.After(ph.env.DrainTimeout)
+	ticker := ph.clock.NewTicker(drainPollPeriod)
+	defer ticker.Stop()
+	for ph.inFlightProbes.Len() > 0 {
+		select {
+		case <-timeout:
+			logging.FromContext(ctx).Warnw("Drain timeout exceeded, dropping in-flight probes", zap.Int("inFlightProbes", ph.inFlightProbes.Len()))
+			return
+		case <-ticker.C():
+		}
+	}
+}
+
+// Run starts the probe forwarder and receiver, or only one of them depending on
+// the role of the probe helper. This function should be called after
+// Initialize. Once the context is done, the in-flight probes are drained
+// before the forwarder and receiver are shut down.
 func (ph *Helper) Run(ctx context.Context) {
+	// Serve the metrics on a dedicated port if one is configured
+	if ph.env.MetricsPort != 0 {
+		go ph.runMetricsServer(ctx)
+	}
+	// Serve the liveness and readiness checks in plaintext on a dedicated port
+	// if the receiver client serves TLS or does not run at all
+	if (ph.receiverTLS != nil && !ph.env.TLSHealthEndpoints) || !ph.runsReceiver() {
+		go ph.runHealthServer(ctx)
+	}
+
+	// The clients keep serving while the in-flight probes are drained, so they
+	// run on a context which is only cancelled once draining is done.
+	serveCtx, cancelServe := context.WithCancel(withoutCancel(ctx))
+	defer cancelServe()
+	defer ph.closeCorrelationStore(ctx)
+	go func() {
+		select {
+		case <-ctx.Done():
+			ph.drain(serveCtx)
+			cancelServe()
+		case <-serveCtx.Done():
+		}
+	}()
+
+	// Check the connectivity to the backends until the probe helper stops
+	go ph.backendChecker.Run(ctx)
+
+	// Evict the in-flight probes which outlive their timeout
+	if ph.env.JanitorPeriod > 0 {
+		go ph.runJanitor(serveCtx)
+	}
+
 	// Start a goroutine to receive the probe request event and forward it appropriately
-	logging.FromContext(ctx).Infow("Starting event forwarder client...")
-	go ph.ceForwardClient.StartReceiver(ctx, ph.forwardFromProbe(ctx))
+	if ph.runsForwarder() {
+		logging.FromContext(ctx).Infow("Starting event forwarder client...", zap.String("probeProtocol", ph.env.ProbeProtocol))
+		warnInsecureForwardTLS(ctx, ph.env)
+		if ph.env.ProbeProtocol == GRPCProbeProtocol {
+			go ph.runProbeGRPCServer(serveCtx)
+		} else {
+			go ph.ceForwardClient.StartReceiver(serveCtx, respondWithFailureReason(ph.forwardFromProbe(serveCtx), ph.eventIdentity))
+		}
+		ph.readinessChecker.SetReady(forwarderComponent)
+	}
 
 	// Receive the event and return the result back to the probe
+	if !ph.runsReceiver() {
+		<-serveCtx.Done()
+		return
+	}
 	logging.FromContext(ctx).Infow("Starting event receiver client...")
-	ph.ceReceiveClient.StartReceiver(ctx, ph.receiveEvent(ctx))
+	ph.readinessChecker.SetReady(receiverComponent)
+	ph.ceReceiveClient.StartReceiver(serveCtx, ph.receiveEvent(serveCtx))
+}
+
+// closeCorrelationStore releases the connections of the correlation store, if
+// it holds any.
+func (ph *Helper) closeCorrelationStore(ctx context.Context) {
+	closer, ok := ph.correlationStore.(io.Closer)
+	if !ok {
+		return
+	}
+	if err := closer.Close(); err != nil {
+		logging.FromContext(ctx).Warnw("Failed to close correlation store", zap.Error(err))
+	}
+}
+
+// runJanitor periodically evicts the in-flight probes which are still tracked
+// long after they timed out, abandoning their forwards, and the receiver
+// channels which outlive them, until the context is done.
+func (ph *Helper) runJanitor(ctx context.Context) {
+	ticker := ph.clock.NewTicker(ph.env.JanitorPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C():
+			for _, probe := range ph.inFlightProbes.EvictExpired(now, ph.env.EvictionGracePeriod) {
+				logging.FromContext(ctx).Warnw("Evicted expired in-flight probe", zap.String("id", probe.ID), zap.String("type", probe.Type), zap.Time("deadline", probe.Deadline))
+			}
+			// The receiver channels outlive the probes which never clean
+			// them up, unless the store expires them on its own
+			if store, ok := ph.correlationStore.(utils.ExpirableCorrelationStore); ok {
+				for _, key := range store.EvictExpired(now, ph.env.MaxTimeoutDuration+ph.env.EvictionGracePeriod) {
+					logging.FromContext(ctx).Warnw("Evicted expired receiver channel", zap.String("key", key))
+				}
+			}
+		}
+	}
+}
+
+// detachedContext is a context which carries the values of its parent but is
+// never cancelled.
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+func (c detachedContext) Value(key interface{}) interface{} {
+	return c.parent.Value(key)
+}
+
+// withoutCancel returns a context which carries the values of a given context
+// but is not cancelled along with it.
+func withoutCancel(ctx context.Context) context.Context {
+	return detachedContext{parent: ctx}
+}
+
+// runMetricsServer serves the probe metrics on port METRICS_PORT until the
+// context is done. The metrics are served in plaintext unless
+// TLS_HEALTH_ENDPOINTS is set, in which case they are served with the TLS
+// config of the receiver client.
+func (ph *Helper) runMetricsServer(ctx context.Context) {
+	mux := http.NewServeMux()
+	mux.Handle(metricsPath, ph.metrics.Handler())
+	srv := &http.Server{
+		Addr:    fmt.Sprintf(":%d", ph.env.MetricsPort),
+		Handler: mux,
+	}
+	if ph.env.TLSHealthEndpoints {
+		srv.TLSConfig = ph.receiverTLS
+	}
+	ph.serve(ctx, "metrics", srv)
+}
+
+// runHealthServer serves the GET requests made to the receiver client, such as
+// liveness and readiness checks, in plaintext on port HEALTH_PORT until the
+// context is done.
+func (ph *Helper) runHealthServer(ctx context.Context) {
+	srv := &http.Server{
+		Addr:    fmt.Sprintf(":%d", ph.env.HealthPort),
+		Handler: ph.receiverMux,
+	}
+	ph.serve(ctx, "health", srv)
+}
+
+// serve runs a server until the context is done, with TLS if the server has a
+// TLS config.
+func (ph *Helper) serve(ctx context.Context, name string, srv *http.Server) {
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+	logging.FromContext(ctx).Infow("Starting "+name+" server...", zap.String("addr", srv.Addr), zap.Bool("tls", srv.TLSConfig != nil))
+	var err error
+	if srv.TLSConfig != nil {
+		err = srv.ListenAndServeTLS("", "")
+	} else {
+		err = srv.ListenAndServe()
+	}
+	if err != nil && err != http.ErrServerClosed {
+		logging.FromContext(ctx).Errorw(strings.Title(name)+" server failed", zap.Error(err))
+	}
 }
 
 // Helper is the main probe helper object which contains the metadata and clients
@@ -169,13 +689,71 @@ type Helper struct {
 	// The liveness checker invoked in the liveness probe
 	livenessChecker *utils.LivenessChecker
 
+	// The readiness checker invoked in the readiness probe
+	readinessChecker *utils.ReadinessChecker
+
+	// The metrics recorded for each forward probe request
+	metrics *utils.ProbeMetrics
+
+	// The probes which are waiting on their result
+	inFlightProbes *utils.InFlightProbes
+
+	// The contexts of the requests which submitted the probes being forwarded
+	probeRequests *utils.ProbeRequests
+
+	// The multiplexer which serves the GET requests made to the receiver
+	// client, such as liveness and readiness checks
+	receiverMux *http.ServeMux
+
+	// The TLS config with which the receiver client serves, if any
+	receiverTLS *tls.Config
+
+	// The listener of the gRPC server accepting the probe requests, if any
+	probeGRPCListener ProbeGRPCListener
+
+	// The store in which the probes waiting on their events are tracked
+	correlationStore utils.CorrelationStore
+
+	// The client sending the results of the completed probes to the result sink, if any
+	resultSinkClient cloudevents.Client
+
+	// The channel which is closed once the probe helper starts draining
+	drainStarted chan struct{}
+
+	// The semaphore limiting the number of in-flight probes, if any
+	probeSemaphore *semaphore.Weighted
+
+	// The probes in flight with an idempotency key
+	idempotentProbes idempotentProbes
+
+	// The distinct probe types which are handled
+	probeTypes probeTypes
+
+	// The results of the last completed probes
+	recentResults *utils.RecentResults
+
+	// The retry budget shared by the probes, if any
+	retryBudget *retryBudget
+
+	// The identity of the result and failure events emitted by the probe helper
+	eventIdentity eventIdentity
+
 	probeHandler handlers.Interface
 
+	// The clock with which the liveness of the clients and sources is timed
+	clock clock.Clock
+
+	// The checker of the connectivity to the backends, e.g. Pub/Sub
+	backendChecker *utils.BackendChecker
+
 	// lastForwardEventTime is the timestamp of the last event processed by the forward client.
 	lastForwardEventTime utils.SyncTime
 
 	// lastReceiverEventTime is the timestamp of the last event processed by the receiver client.
 	lastReceiverEventTime utils.SyncTime
+
+	// lastSourceEventTimes are the timestamps of the last events successfully received from each source.
+	lastSourceEventTimes utils.SyncTimesMap
 }
 
 type EnvConfig struct {
@@ -185,6 +763,171 @@ type EnvConfig struct {
 	// Environment variable containing the default timeout duration to wait for an event to be delivered, if no custom timeout is specified
 	DefaultTimeoutDuration time.Duration `envconfig:"DEFAULT_TIMEOUT_DURATION" default:"2m"`
 
+	// Environment variable containing the maximum tolerated staleness durations of the events successfully received from specific sources, which fail the liveness check if any of them is exceeded, e.g. 'cloudstoragesource:10m,cloudpubsubsource:5m'
+	SourceStaleDurations map[string]time.Duration `envconfig:"SOURCE_STALE_DURATIONS"`
+
 	// Environment variable containing the maximum timeout duration to wait for an event to be delivered
 	MaxTimeoutDuration time.Duration `envconfig:"MAX_TIMEOUT_DURATION" default:"30m"`
+
+	// Environment variable containing the timeout durations of specific probe types, which take precedence over the timeout extension, e.g. 'cloudstoragesource-probe-create:5m,cloudpubsubsource-probe:1m'
+	PerTypeTimeoutDuration map[string]time.Duration `envconfig:"PER_TYPE_TIMEOUT_DURATION"`
+
+	// Environment variable containing whether the probe events are only validated rather than forwarded, which can also be requested per probe event through the 'dryrun' extension
+	DryRun bool `envconfig:"DRY_RUN" default:"false"`
+
+	// Environment variable containing the retry policies of the events sent by specific probe types, overriding the default retry policy, e.g. 'broker-e2e-delivery-probe:exponential/100ms/5,channel-e2e-delivery-probe:constant/1s/3'
+	RetryPolicies map[string]RetryPolicy `envconfig:"RETRY_POLICIES"`
+
+	// Environment variable containing the retry policy of the events sent by the probe types without a retry policy of their own, e.g. 'constant/500ms/3'. If unset, the retries of the probe helper context apply.
+	DefaultRetryPolicy RetryPolicy `envconfig:"DEFAULT_RETRY_POLICY"`
+
+	// Environment variable containing the bucket boundaries, in seconds, of the probe latency histogram
+	LatencyBuckets []float64 `envconfig:"PROBE_LATENCY_BUCKETS" default:"0.1,0.25,0.5,1,2.5,5,10,30,60,120,300"`
+
+	// Environment variable containing the maximum size in bytes of the data of the probe events, which is enforced both when forwarding and receiving them. If unset, the size of the probe events is unlimited.
+	MaxProbePayloadBytes int `envconfig:"MAX_PROBE_PAYLOAD_BYTES" default:"0"`
+
+	// Environment variable containing the brokers, named as '<namespace>/<broker>', whose broker e2e delivery probe results are labeled with their namespace and broker, e.g. 'default/default,probe/other'. The results of the probes of other brokers are labeled as 'other', which bounds the cardinality of the metrics.
+	MetricsBrokerAllowlist []string `envconfig:"METRICS_BROKER_ALLOWLIST"`
+
+	// Environment variable containing the topics whose CloudPubSubSource probe results are labeled with their topic. The results of the probes of other topics are labeled as 'other'.
+	MetricsTopicAllowlist []string `envconfig:"METRICS_TOPIC_ALLOWLIST"`
+
+	// Environment variable containing the port which serves the probe metrics. If unset, the metrics are served by the receiver client.
+	MetricsPort int `envconfig:"METRICS_PORT" default:"0"`
+
+	// Environment variable containing the maximum number of probes which may be in flight at once, beyond which the probe requests are rejected with HTTP status 429 and a Retry-After header. If unset, the number of in-flight probes is unlimited.
+	MaxConcurrentProbes int `envconfig:"MAX_CONCURRENT_PROBES" default:"0"`
+
+	// Environment variable containing the maximum number of probe events in a batch, beyond which the batch is rejected with HTTP status 413. If 0, every batch is rejected.
+	MaxBatchSize int `envconfig:"MAX_BATCH_SIZE" default:"100"`
+
+	// Environment variable containing the maximum number of probes of a batch which are submitted at once. If 0, the probes of a batch are submitted one at a time.
+	BatchConcurrency int `envconfig:"BATCH_CONCURRENCY" default:"10"`
+
+	// Environment variable containing the maximum duration to wait for in-flight probes to complete when shutting down
+	DrainTimeout time.Duration `envconfig:"DRAIN_TIMEOUT" default:"20s"`
+
+	// Environment variable containing whether spans are recorded for the probe round trips
+	TracingEnabled bool `envconfig:"TRACING_ENABLED" default:"false"`
+
+	// Environment variable containing the encoding of the probe helper logs, either 'json' or 'console'
+	LogFormat string `envconfig:"LOG_FORMAT" default:"json"`
+
+	// Environment variable containing the period at which in-flight probes which outlive their timeout are evicted
+	JanitorPeriod time.Duration `envconfig:"JANITOR_PERIOD" default:"1m"`
+
+	// Environment variable containing how long in-flight probes are kept past their timeout before being evicted
+	EvictionGracePeriod time.Duration `envconfig:"EVICTION_GRACE_PERIOD" default:"1m"`
+
+	// Environment variable containing the content mode, either 'binary' or 'structured', in which events are expected to be delivered to the receiver client
+	ReceiverContentMode string `envconfig:"RECEIVER_CONTENT_MODE" default:"binary"`
+
+	// Environment variable containing the path prefix under which the receiver client accepts events. The targetpath extension of the probe events must lie under it.
+	ReceiverPathPrefix string `envconfig:"RECEIVER_PATH_PREFIX" default:"/"`
+
+	// Environment variable containing whether each source delivers its events along its own path, i.e. the target path followed by the name of the source such as '/some-path/cloudpubsubsource'. The events of a source delivered along another path match no probe.
+	SourceReceiverPaths bool `envconfig:"SOURCE_RECEIVER_PATHS" default:"false"`
+
+	// Environment variable containing the content mode, either 'binary' or 'structured', in which the forward client sends events
+	ForwardContentMode string `envconfig:"FORWARD_CONTENT_MODE" default:"binary"`
+
+	// Environment variable containing the interval at which the CloudAuditLogsSource probes poll the existence of the Pub/Sub topics they delete
+	AuditLogsPollInterval time.Duration `envconfig:"AUDIT_LOGS_POLL_INTERVAL" default:"1s"`
+
+	// Environment variable containing the protocol, either 'http' or 'grpc', over which the probe requests are accepted on port PROBE_PORT
+	ProbeProtocol string `envconfig:"PROBE_PROTOCOL" default:"http"`
+
+	// Environment variable containing the path of the certificate which the forward client presents to the targets of the probes
+	ForwardClientCertFile string `envconfig:"FORWARD_CLIENT_CERT_FILE"`
+
+	// Environment variable containing the path of the private key of the forward client certificate
+	ForwardClientKeyFile string `envconfig:"FORWARD_CLIENT_KEY_FILE" redact:"true"`
+
+	// Environment variable containing the path of the CA bundle with which the forward client verifies the targets of the probes. If unset, the system roots are used.
+	ForwardCABundleFile string `envconfig:"FORWARD_CA_BUNDLE_FILE"`
+
+	// Environment variable containing whether the forward client skips the verification of the certificates of the targets of the probes, e.g. of a broker ingress with a self-signed certificate. It is insecure, only meant for development clusters, and warned about on startup.
+	InsecureSkipTLSVerify bool `envconfig:"INSECURE_SKIP_TLS_VERIFY" default:"false"`
+
+	// Environment variable containing the maximum number of idle connections which the forward client keeps open to each target. If unset, the default of the Go HTTP transport applies.
+	ForwardMaxIdleConns int `envconfig:"FORWARD_MAX_IDLE_CONNS" default:"0"`
+
+	// Environment variable containing how long the idle connections of the forward client are kept open. If unset, the default of the Go HTTP transport applies.
+	ForwardIdleConnTimeout time.Duration `envconfig:"FORWARD_IDLE_CONN_TIMEOUT" default:"0"`
+
+	// Environment variable containing whether the forward client opens a new connection for every event rather than keeping connections alive
+	ForwardDisableKeepAlives bool `envconfig:"FORWARD_DISABLE_KEEP_ALIVES" default:"false"`
+
+	// Environment variable containing whether the forward client sends events to plaintext targets over HTTP/2 with prior knowledge, multiplexed on a single connection to which the idle connection settings do not apply. TLS targets negotiate HTTP/2 regardless.
+	ForwardForceHTTP2 bool `envconfig:"FORWARD_FORCE_HTTP2" default:"false"`
+
+	// Environment variable containing the path of the certificate with which the receiver client serves TLS. If unset, the receiver client serves plaintext.
+	ReceiverCertFile string `envconfig:"RECEIVER_CERT_FILE"`
+
+	// Environment variable containing the path of the private key of the receiver certificate
+	ReceiverKeyFile string `envconfig:"RECEIVER_KEY_FILE" redact:"true"`
+
+	// Environment variable containing the path of the CA bundle with which the receiver client verifies client certificates. If set, client certificates are required.
+	ReceiverClientCABundleFile string `envconfig:"RECEIVER_CLIENT_CA_BUNDLE_FILE"`
+
+	// Environment variable containing whether the liveness, readiness and metrics endpoints are served with TLS along with the receiver client. If unset, they are served in plaintext on port HEALTH_PORT and METRICS_PORT.
+	TLSHealthEndpoints bool `envconfig:"TLS_HEALTH_ENDPOINTS" default:"false"`
+
+	// Environment variable containing the port which serves the liveness and readiness checks in plaintext when the receiver client serves TLS or does not run
+	HealthPort int `envconfig:"HEALTH_PORT" default:"8081"`
+
+	// Environment variable containing the store in which the probes waiting on their events are tracked, either 'memory' or 'redis'. Probe helpers sharing a Redis store correlate the events forwarded and received by each other.
+	CorrelationStore string `envconfig:"CORRELATION_STORE" default:"memory"`
+
+	// Environment variable containing the address of the Redis server backing the Redis correlation store, e.g. 'redis.events-system-probe.svc.cluster.local:6379'
+	RedisAddress string `envconfig:"REDIS_ADDRESS"`
+
+	// Environment variable containing the interval at which the probes tracked in the Redis correlation store are polled for their result
+	RedisPollInterval time.Duration `envconfig:"REDIS_POLL_INTERVAL" default:"100ms"`
+
+	// Environment variable containing the URL of the sink to which an event of type 'com.google.knative-gcp.probe.result' is sent for every completed probe. If unset, no result events are sent.
+	ResultSink string `envconfig:"RESULT_SINK"`
+
+	// Environment variable containing the probe types which are enabled, e.g. 'broker-e2e-delivery-probe,pingsource-probe'. The probes of other types are rejected, e.g. when their source is not deployed. If unset, every probe type is enabled.
+	EnabledProbes []string `envconfig:"ENABLED_PROBES"`
+
+	// Environment variable containing the maximum number of distinct probe types which are handled, beyond which the probes of other types are rejected. If 0, the number of probe types is not limited.
+	MaxProbeTypes int `envconfig:"MAX_PROBE_TYPES" default:"100"`
+
+	// Environment variable containing the number of the last completed probes whose results are listed along the '/debug/recent' path of the receiver, most recent first
+	RecentResultsSize int `envconfig:"RECENT_RESULTS_SIZE" default:"100"`
+
+	// Environment variable containing the number of retries which the probes may make in a burst when forwarding their events, beyond which a probe fails fast instead of retrying, so that the probes do not overwhelm a degraded target. If 0, the retries are not limited.
+	RetryBudgetSize int `envconfig:"RETRY_BUDGET_SIZE" default:"0"`
+
+	// Environment variable containing the number of retries per second by which the retry budget is refilled
+	RetryBudgetRefillRate float64 `envconfig:"RETRY_BUDGET_REFILL_RATE" default:"1"`
+
+	// Environment variable containing the ID which identifies the probe helper instance when several of them report to a shared sink. It is the source of the result and failure events which the probe helper emits, and the prefix of their IDs. If unset, their source is 'probe-helper' and their IDs are not prefixed.
+	ProbeSourceID string `envconfig:"PROBE_SOURCE_ID"`
+
+	// Environment variable containing the role of the probe helper, either 'combined', 'forwarder' or 'receiver', which controls whether it runs the forward client, the receiver client or both
+	Role string `envconfig:"ROLE" default:"combined"`
+
+	// Environment variable containing the policy for the received events which match no probe in flight, either 'ack' to drop them, 'nack' to reject them for their sender to redeliver them, or 'log' to drop them with a warning
+	UnmatchedEventPolicy string `envconfig:"UNMATCHED_EVENT_POLICY" default:"ack"`
+
+	// Environment variable containing the maximum skew by which the time of a received event may precede the forward time of the probe in flight with its ID, beyond which the event is deemed a replay of an older probe and treated as unmatched. If 0, the time of the received events is not checked.
+	MaxEventTimeSkew time.Duration `envconfig:"MAX_EVENT_TIME_SKEW" default:"0"`
+
+	// Environment variable containing the backends whose connectivity is periodically checked and listed along the '/debug/backends' path of the receiver, e.g. 'pubsub'. If unset, no backend is checked.
+	BackendChecks []string `envconfig:"BACKEND_CHECKS"`
+
+	// Environment variable containing the interval between the checks of the backends
+	BackendCheckInterval time.Duration `envconfig:"BACKEND_CHECK_INTERVAL" default:"1m"`
+
+	// Environment variable containing whether the liveness check fails once the last check of any of the backends failed
+	BackendLiveness bool `envconfig:"BACKEND_LIVENESS" default:"false"`
+
+	// Environment variable containing the path of the credentials file with which the Pub/Sub and Storage clients authenticate. If unset, they authenticate with the Application Default Credentials.
+	CredentialsFile string `envconfig:"CREDENTIALS_FILE"`
+
+	// Environment variable containing the email of the service account which the Pub/Sub and Storage clients impersonate, e.g. 'probe-helper@my-project.iam.gserviceaccount.com'. The credentials with which they authenticate must be allowed to create tokens for it.
+	ImpersonateServiceAccount string `envconfig:"IMPERSONATE_SERVICE_ACCOUNT"`
 }
@@ -27,18 +27,33 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"regexp"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"cloud.google.com/go/pubsub"
 	"cloud.google.com/go/pubsub/pstest"
 	"cloud.google.com/go/storage"
+	"github.com/alicebob/miniredis/v2"
 	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/cloudevents/sdk-go/v2/binding"
+	cecontext "github.com/cloudevents/sdk-go/v2/context"
 	"github.com/cloudevents/sdk-go/v2/protocol"
+	cehttp "github.com/cloudevents/sdk-go/v2/protocol/http"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/kelseyhightower/envconfig"
+	"go.opencensus.io/trace"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 	"golang.org/x/sync/errgroup"
 	"google.golang.org/api/option"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	grpcstatus "google.golang.org/grpc/status"
 
 	"knative.dev/pkg/logging"
@@ -47,38 +62,99 @@ import (
 	. "github.com/google/knative-gcp/pkg/pubsub/adapter/context"
 	"github.com/google/knative-gcp/pkg/pubsub/adapter/converters"
 	schemasv1 "github.com/google/knative-gcp/pkg/schemas/v1"
+	"github.com/google/knative-gcp/test/test_images/probe_helper/cegrpc"
+	"github.com/google/knative-gcp/test/test_images/probe_helper/probe/handlers"
+	"github.com/google/knative-gcp/test/test_images/probe_helper/utils"
 	sources "knative.dev/eventing/pkg/apis/sources"
+	sourcesv1 "knative.dev/eventing/pkg/apis/sources/v1"
 	sourcesv1beta1 "knative.dev/eventing/pkg/apis/sources/v1beta1"
 
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/fake"
 	"k8s.io/client-go/tools/cache"
-
-	corev1 "k8s.io/api/core/v1"
 )
 
 const (
 	// the fake namespace used in the Broker E2E delivery probe
 	testNamespace = "test-namespace"
+	// the fake broker, other than the default broker, used in the Broker E2E delivery probe
+	testOtherBroker = "other"
+	// the fake broker which rewrites the IDs of the events it delivers
+	testIDRewritingBroker = "id-rewriting"
+	// the fake broker which prepends a subscriber prefix to the IDs of the
+	// events it delivers
+	testIDPrefixingBroker = "id-prefixing"
+	// the prefix prepended by the ID prefixing broker
+	testIDPrefix = "subscriber-1-"
+	// the fake broker which rewrites an extension of the events it delivers
+	testExtensionRewritingBroker = "extension-rewriting"
+	// the fake broker which rewrites the content type of the events it
+	// delivers
+	testContentTypeRewritingBroker = "content-type-rewriting"
+	// the content type set by the content type rewriting broker
+	testRewrittenContentType = "application/octet-stream"
+	// the fake broker which accepts malformed events
+	testLenientBroker = "lenient"
+	// the fake broker whose ingress accepts events with 202 Accepted
+	testAcceptingBroker = "accepting"
+	// the extension rewritten by the extension rewriting broker
+	testRewrittenExtension = "bucketid"
+	// the number of workers which loop the events of the test sources back to
+	// the probe helper receiver
+	testLoopbackPoolSize = 8
+	// the number of times the test Broker attempts to deliver a Broker DLQ
+	// probe event before sending it to the dead letter sink
+	testBrokerDeliveryAttempts = 3
+	// the fake channel used in the Channel E2E delivery probe
+	testChannel = "test-channel"
 	// the fake project ID used by the test resources
 	testProjectID = "test-project-id"
 	// the fake pubsub topic ID used in the test CloudPubSubSource
 	testTopicID = "cloudpubsubsource-topic"
 	// the fake pubsub subscription ID used in the test CloudPubSubSource
 	testSubscriptionID = "cre-src-test-subscription-id"
+	// the fake pubsub subscription ID used in another test CloudPubSubSource
+	// of the same topic
+	testOtherSubscriptionID = "cre-src-test-other-subscription-id"
+	// the fake pubsub topic and subscription IDs used in the Pub/Sub
+	// roundtrip probes
+	testRoundtripTopicID        = "pubsub-roundtrip-topic"
+	testRoundtripSubscriptionID = "pubsub-roundtrip-subscription"
+	// the fake pubsub topic and ordered subscription IDs used in the test
+	// CloudPubSubSource of the ordering probes
+	testOrderingTopicID        = "ordering-topic"
+	testOrderingSubscriptionID = "cre-src-test-ordering-subscription-id"
 	// the fake Cloud Storage bucket ID used in the test CloudStorageSource
 	testStorageBucket = "cloudstoragesource-bucket"
+	// the fake Cloud Storage bucket ID whose notifications are not filtered to
+	// the object name prefix of the test CloudStorageSource
+	testUnfilteredStorageBucket = "cloudstoragesource-unfiltered-bucket"
+	// the fake Cloud Storage bucket ID whose notifications the test
+	// CloudStorageSource emits in the legacy payload format, unfiltered
+	testLegacyStorageBucket = "cloudstoragesource-legacy-bucket"
+	// the object name prefix to which the test CloudStorageSource filters the
+	// notifications
+	testStoragePrefix = "probe-prefix/"
+	// the metadata keys which the test CloudStorageSource respectively leaves
+	// out of and alters in the data of the metadata updated events
+	testStorageDroppedMetadataKey = "dropped-key"
+	testStorageAlteredMetadataKey = "altered-key"
 	// the fake pod name used in the test ApiServerSource
-	testPodName = "apiserversource-test-pod"
+	testPodName       = "apiserversource-test-pod"
+	testConfigMapName = "apiserversource-test-configmap"
 )
 
 var (
 	testStorageUploadRequest      = "/upload/storage/v1/b/cloudstoragesource-bucket/o?alt=json&name=1234567890&prettyPrint=false&projection=full&uploadType=multipart"
 	testStorageRequest            = "/b/cloudstoragesource-bucket/o/1234567890?alt=json&prettyPrint=false&projection=full"
 	testStorageGenerationRequest  = "/b/cloudstoragesource-bucket/o/1234567890?alt=json&generation=0&prettyPrint=false"
+	testStorageComposePath        = "/b/cloudstoragesource-bucket/o/1234567890/compose"
+	testStorageUploadPathPattern  = regexp.MustCompile(`^/upload/storage/v1/b/([^/]+)/o$`)
 	testStorageCreateBody         = `{"bucket":"cloudstoragesource-bucket","name":"1234567890"}`
-	testStorageUpdateMetadataBody = `{"bucket":"cloudstoragesource-bucket","metadata":{"some-key":"Metadata updated!"}}`
+	testStorageUpdateMetadataBody = `{"bucket":"cloudstoragesource-bucket","metadata":{`
 	testStorageArchiveBody        = `{"bucket":"cloudstoragesource-bucket","name":"1234567890","storageClass":"ARCHIVE"}`
 
 	testPodCreateRequest = fmt.Sprintf("/api/v1/namespaces/%s/pods", testNamespace)
@@ -86,44 +162,242 @@ var (
 	testPodCreateBody    = fmt.Sprintf(`{"metadata":{"name":"%s.1234567890","namespace":"%s","creationTimestamp":null},"spec":{"containers":[{"name":"busybox","image":"busybox","resources":{},"imagePullPolicy":"IfNotPresent"}],"restartPolicy":"Never"},"status":{}}`, testPodName, testNamespace)
 	testPodUpdateBody    = fmt.Sprintf(`{"metadata":{"name":"%s.1234567890","namespace":"%s","creationTimestamp":null},"spec":{"containers":[{"name":"busybox","image":"alpine","resources":{},"imagePullPolicy":"IfNotPresent"}],"restartPolicy":"Never"},"status":{}}`, testPodName, testNamespace)
 
+	testConfigMapCreateRequest = fmt.Sprintf("/api/v1/namespaces/%s/configmaps", testNamespace)
+	testConfigMapModifyRequest = fmt.Sprintf("/api/v1/namespaces/%s/configmaps/%s", testNamespace, testConfigMapName)
+	testConfigMapCreateBody    = fmt.Sprintf(`{"metadata":{"name":"%s.1234567890","namespace":"%s","creationTimestamp":null},"data":{"probe":"created"}}`, testConfigMapName, testNamespace)
+	testConfigMapUpdateBody    = fmt.Sprintf(`{"metadata":{"name":"%s.1234567890","namespace":"%s","creationTimestamp":null},"data":{"probe":"updated"}}`, testConfigMapName, testNamespace)
+
 	testTargetReceiverPath = "test-namespace"
+
+	// the fake brokers served by the test Broker
+	testBrokers = []string{"default", testOtherBroker, testIDRewritingBroker, testIDPrefixingBroker, testExtensionRewritingBroker, testContentTypeRewritingBroker, testLenientBroker, testAcceptingBroker}
+
+	// the fake scheduler jobs which tick in the test CloudSchedulerSource
+	testSchedulerJobs = []string{
+		"projects/test-project-id/locations/us-central1/jobs/test-cloud-scheduler-job",
+		"projects/test-project-id/locations/us-central1/jobs/other-cloud-scheduler-job",
+	}
+
+	// the names of the test PingSources, and the periods at which they tick
+	testPingSource        = "test-ping-source"
+	testSlowPingSource    = "test-slow-ping-source"
+	testPingSourcePeriods = map[string]time.Duration{
+		testPingSource:     100 * time.Millisecond,
+		testSlowPingSource: 300 * time.Millisecond,
+	}
 )
 
 // A helper function that starts a test Broker which receives events forwarded by
-// the probe helper and delivers the events back to the probe helper receiver.
-func runTestBroker(ctx context.Context, group *errgroup.Group, probeReceiverURL string) string {
+// the probe helper and delivers the events back to the probe helper receiver in
+// the given content mode. It returns the template of the test Broker ingress.
+func runTestBroker(ctx context.Context, group *errgroup.Group, contentMode, probeReceiverURL string) string {
 	brokerListener, err := GetFreePortListener()
 	if err != nil {
 		logging.FromContext(ctx).Fatalf("Failed to get free broker port listener: %v", err)
 	}
 	brokerPort := brokerListener.Addr().(*net.TCPAddr).Port
+	// The test Broker only accepts events sent to one of the test brokers, and
+	// rejects the binary mode events without a type unless they are sent to
+	// the lenient broker. The accepting broker accepts events with 202.
+	rejectUnknownBrokers := cloudevents.WithMiddleware(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			for _, broker := range testBrokers {
+				if req.URL.Path == fmt.Sprintf("/%s/%s", testNamespace, broker) {
+					if req.Header.Get("Ce-Specversion") != "" && req.Header.Get("Ce-Type") == "" {
+						if broker == testLenientBroker {
+							rw.WriteHeader(http.StatusAccepted)
+						} else {
+							rw.WriteHeader(http.StatusBadRequest)
+						}
+						return
+					}
+					if broker == testAcceptingBroker {
+						rw = acceptedResponseWriter{rw}
+					}
+					next.ServeHTTP(rw, req)
+					return
+				}
+			}
+			rw.WriteHeader(http.StatusNotFound)
+		})
+	})
 	bp, err := cloudevents.NewHTTP(
 		cloudevents.WithListener(brokerListener),
 		cloudevents.WithTarget(probeReceiverURL),
-		cloudevents.WithPath(fmt.Sprintf("/%s/default", testNamespace)),
+		rejectUnknownBrokers,
 	)
 	if err != nil {
 		logging.FromContext(ctx).Fatalf("Failed to create http protocol of the test Broker: %v", err)
 	}
-	bc, err := cloudevents.NewClient(bp)
+	clientOpts, err := contentModeClientOptions(contentMode)
+	if err != nil {
+		logging.FromContext(ctx).Fatalf("Failed to get the test Broker client options: %v", err)
+	}
+	bc, err := cloudevents.NewClient(bp, clientOpts...)
 	if err != nil {
 		logging.FromContext(ctx).Fatalf("Failed to create the test Broker client: %v", err)
 	}
 	group.Go(func() error {
 		bc.StartReceiver(ctx, func(event cloudevents.Event) {
+			if event.Type() == handlers.BrokerDLQProbeEventType {
+				deliverWithDeadLetter(ctx, bc, event, probeReceiverURL+"/dlq")
+				return
+			}
+			if event.Extensions()["broker"] == testIDRewritingBroker {
+				event.SetID(event.ID() + "-rewritten")
+			}
+			if event.Extensions()["broker"] == testIDPrefixingBroker {
+				event.SetID(testIDPrefix + event.ID())
+			}
+			if event.Extensions()["broker"] == testExtensionRewritingBroker {
+				event.SetExtension(testRewrittenExtension, "rewritten")
+			}
+			// The content type of the events is preserved, unless they are
+			// sent to the content type rewriting broker.
+			if event.Extensions()["broker"] == testContentTypeRewritingBroker {
+				event.SetDataContentType(testRewrittenContentType)
+			}
 			if res := bc.Send(ctx, event); !cloudevents.IsACK(res) {
 				logging.FromContext(ctx).Warnf("Failed to send CloudEvent from the test Broker: %v", res)
 			}
 		})
 		return nil
 	})
-	return fmt.Sprintf("http://localhost:%d", brokerPort)
+	return fmt.Sprintf("http://localhost:%d/{{.Namespace}}/{{.Broker}}", brokerPort)
+}
+
+// acceptedResponseWriter answers the requests which succeed with 202 Accepted.
+type acceptedResponseWriter struct {
+	http.ResponseWriter
+}
+
+func (w acceptedResponseWriter) WriteHeader(statusCode int) {
+	if statusCode/100 == 2 {
+		statusCode = http.StatusAccepted
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+// A helper function that starts a test Channel which receives events forwarded
+// by the probe helper and delivers the events back to the probe helper receiver
+// like a Subscription. It returns the template of the test Channel ingress.
+func runTestChannel(ctx context.Context, group *errgroup.Group, contentMode, probeReceiverURL string) handlers.ChannelIngressTemplate {
+	channelListener, err := GetFreePortListener()
+	if err != nil {
+		logging.FromContext(ctx).Fatalf("Failed to get free channel port listener: %v", err)
+	}
+	channelPort := channelListener.Addr().(*net.TCPAddr).Port
+	// The test Channel only accepts events sent to the test channel.
+	rejectUnknownChannels := cloudevents.WithMiddleware(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			if req.URL.Path != fmt.Sprintf("/%s/%s", testNamespace, testChannel) {
+				rw.WriteHeader(http.StatusNotFound)
+				return
+			}
+			next.ServeHTTP(rw, req)
+		})
+	})
+	cp, err := cloudevents.NewHTTP(
+		cloudevents.WithListener(channelListener),
+		cloudevents.WithTarget(probeReceiverURL),
+		rejectUnknownChannels,
+	)
+	if err != nil {
+		logging.FromContext(ctx).Fatalf("Failed to create http protocol of the test Channel: %v", err)
+	}
+	clientOpts, err := contentModeClientOptions(contentMode)
+	if err != nil {
+		logging.FromContext(ctx).Fatalf("Failed to get the test Channel client options: %v", err)
+	}
+	cc, err := cloudevents.NewClient(cp, clientOpts...)
+	if err != nil {
+		logging.FromContext(ctx).Fatalf("Failed to create the test Channel client: %v", err)
+	}
+	group.Go(func() error {
+		cc.StartReceiver(ctx, func(event cloudevents.Event) {
+			if res := cc.Send(ctx, event); !cloudevents.IsACK(res) {
+				logging.FromContext(ctx).Warnf("Failed to send CloudEvent from the test Channel: %v", res)
+			}
+		})
+		return nil
+	})
+	return handlers.ChannelIngressTemplate(fmt.Sprintf("http://localhost:%d/{{.Namespace}}/{{.Channel}}", channelPort))
+}
+
+// deliverWithDeadLetter delivers an event from the test Broker like a Trigger
+// with a dead letter sink, by retrying it a fixed number of times before
+// sending it to the dead letter sink.
+func deliverWithDeadLetter(ctx context.Context, bc cloudevents.Client, event cloudevents.Event, deadLetterSinkURL string) {
+	// The retries are made by hand to control their number.
+	ctx = cecontext.WithRetryParams(ctx, &cecontext.RetryParams{Strategy: cecontext.BackoffStrategyNone})
+	for attempt := 0; attempt < testBrokerDeliveryAttempts; attempt++ {
+		if res := bc.Send(ctx, event); cloudevents.IsACK(res) {
+			return
+		}
+	}
+	if res := bc.Send(cecontext.WithTarget(ctx, deadLetterSinkURL), event); !cloudevents.IsACK(res) {
+		logging.FromContext(ctx).Warnf("Failed to send CloudEvent to the test dead letter sink: %v", res)
+	}
+}
+
+// loopbackPool is a bounded pool of workers shared by the test sources, which
+// send their events back to the probe helper receiver. The events of the
+// sources are sent concurrently, without a goroutine per event.
+type loopbackPool struct {
+	sends chan loopbackSend
+}
+
+// loopbackSend is an event queued in the loopback pool, along with its
+// description which is logged if it cannot be sent.
+type loopbackSend struct {
+	event       cloudevents.Event
+	description string
+}
+
+// A helper function that starts a loopback pool with a given number of workers
+// which send the events queued in it to the probe helper receiver, until the
+// context is cancelled.
+func runLoopbackPool(ctx context.Context, group *errgroup.Group, size int, probeReceiverURL string) *loopbackPool {
+	cp, err := cloudevents.NewHTTP(cloudevents.WithTarget(probeReceiverURL))
+	if err != nil {
+		logging.FromContext(ctx).Fatalf("Failed to create http protocol of the test loopback pool, %v", err)
+	}
+	c, err := cloudevents.NewClient(cp)
+	if err != nil {
+		logging.FromContext(ctx).Fatalf("Failed to create the test loopback pool client, %v", err)
+	}
+	pool := &loopbackPool{sends: make(chan loopbackSend)}
+	for i := 0; i < size; i++ {
+		group.Go(func() error {
+			for {
+				select {
+				case <-ctx.Done():
+					return nil
+				case send := <-pool.sends:
+					if res := c.Send(ctx, send.event); !cloudevents.IsACK(res) {
+						logging.FromContext(ctx).Warnf("Failed to send %s: %v", send.description, res)
+					}
+				}
+			}
+		})
+	}
+	return pool
+}
+
+// send queues an event for the next idle worker of the loopback pool to send
+// it, and blocks while all the workers are busy. The event is dropped if the
+// context is cancelled first.
+func (p *loopbackPool) send(ctx context.Context, event cloudevents.Event, description string) {
+	select {
+	case p.sends <- loopbackSend{event: event, description: description}:
+	case <-ctx.Done():
+	}
 }
 
 // A helper function that starts a test CloudPubSubSource which watches a pubsub
 // Subscription for messages and delivers them as CloudEvents to the probe
 // helper receiver.
-func runTestCloudPubSubSource(ctx context.Context, group *errgroup.Group, sub *pubsub.Subscription, probeReceiverURL string) {
+func runTestCloudPubSubSource(ctx context.Context, group *errgroup.Group, readiness *utils.ReadinessChecker, supervisor *utils.ReceiveSupervisor, sub *pubsub.Subscription, receiveErrors <-chan error, probeReceiverURL string) {
 	converter := converters.NewPubSubConverter()
 	cp, err := cloudevents.NewHTTP(cloudevents.WithTarget(probeReceiverURL))
 	if err != nil {
@@ -135,18 +409,45 @@ func runTestCloudPubSubSource(ctx context.Context, group *errgroup.Group, sub *p
 	}
 	msgHandler := func(ctx context.Context, msg *pubsub.Message) {
 		event, err := converter.Convert(ctx, msg, converters.CloudPubSub)
-		if err != nil {
-			logging.FromContext(ctx).Warnf("Could not convert message to CloudEvent: %v", err)
+		switch {
+		case errors.Is(err, converters.ErrUnsupportedConverter):
+			// Redelivering the message cannot help, so drop it.
+			logging.FromContext(ctx).Warnf("Dropping message which cannot be converted to CloudEvent: %v", err)
+			msg.Ack()
+			return
+		case err != nil:
+			logging.FromContext(ctx).Infof("Could not convert message to CloudEvent, retrying: %v", err)
+			msg.Nack()
+			return
 		}
 		if res := c.Send(ctx, *event); !cloudevents.IsACK(res) {
 			logging.FromContext(ctx).Warnf("Failed to send CloudEvent from the test CloudPubSubSource: %v", err)
 		}
 	}
+	// The subscription receiver fails with the errors injected by the tests,
+	// and is restarted by the supervisor like any unexpected failure.
+	receive := func(ctx context.Context) error {
+		receiveCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		received := make(chan error, 1)
+		go func() {
+			received <- sub.Receive(receiveCtx, msgHandler)
+		}()
+		select {
+		case err := <-receiveErrors:
+			cancel()
+			<-received
+			return err
+		case err := <-received:
+			return err
+		}
+	}
+	component := "cloudpubsubsource/" + sub.ID()
+	readiness.Register(component)
 	group.Go(func() error {
-		if err := sub.Receive(ctx, msgHandler); err != nil {
-			if _, ok := grpcstatus.FromError(err); !ok {
-				logging.FromContext(ctx).Warnf("Could not receive from subscription: %v", err)
-			}
+		readiness.SetReady(component)
+		if err := supervisor.Run(ctx, component, receive); err != nil {
+			logging.FromContext(ctx).Warnf("Could not receive from subscription: %v", err)
 		}
 		return nil
 	})
@@ -155,17 +456,11 @@ func runTestCloudPubSubSource(ctx context.Context, group *errgroup.Group, sub *p
 // A helper function that starts a test CloudAuditLogsSource which watches
 // periodically for a change of state in the existence of pubsub topics and
 // forwards the appropriate events to the probe helper receiver.
-func runTestCloudAuditLogsSource(ctx context.Context, group *errgroup.Group, pubsubClient *pubsub.Client, probeReceiverURL string) {
-	cp, err := cloudevents.NewHTTP(cloudevents.WithTarget(probeReceiverURL))
-	if err != nil {
-		logging.FromContext(ctx).Fatalf("Failed to create http protocol of the test CloudAuditLogsSource, %v", err)
-	}
-	c, err := cloudevents.NewClient(cp)
-	if err != nil {
-		logging.FromContext(ctx).Fatalf("Failed to create the test CloudAuditLogsSource client, %v", err)
-	}
-	topicCreated := false
-	ticker := time.NewTicker(100 * time.Millisecond)
+func runTestCloudAuditLogsSource(ctx context.Context, group *errgroup.Group, pubsubClient *pubsub.Client, pollInterval time.Duration, loopback *loopbackPool) {
+	// Emit the audit log of the creation or deletion of the topic whenever it
+	// is observed to start or stop existing.
+	topicExists := false
+	ticker := time.NewTicker(pollInterval)
 	group.Go(func() error {
 		for {
 			select {
@@ -175,19 +470,23 @@ func runTestCloudAuditLogsSource(ctx context.Context, group *errgroup.Group, pub
 				exists, err := pubsubClient.Topic("cloudauditlogssource-probe-1234567890").Exists(ctx)
 				if err != nil {
 					logging.FromContext(ctx).Warnf("Failed to determine existence of test pubsub topic: %v", err)
+					continue
 				}
-				if exists && !topicCreated {
-					createTopicEvent := cloudevents.NewEvent()
-					createTopicEvent.SetID("1234567890")
-					createTopicEvent.SetSubject(schemasv1.CloudAuditLogsEventSubject("pubsub.googleapis.com", "projects/test-project-id/topics/cloudauditlogssource-probe-1234567890"))
-					createTopicEvent.SetType(schemasv1.CloudAuditLogsLogWrittenEventType)
-					createTopicEvent.SetSource(schemasv1.CloudAuditLogsEventSource("projects/test-project-id", "activity"))
-					createTopicEvent.SetExtension("methodname", "google.pubsub.v1.Publisher.CreateTopic")
-					if res := c.Send(ctx, createTopicEvent); !cloudevents.IsACK(res) {
-						logging.FromContext(ctx).Warnf("Failed to send topic created CloudEvent from the test CloudAuditLogsSource: %v", res)
-					}
-					topicCreated = true
+				if exists == topicExists {
+					continue
+				}
+				methodName := "google.pubsub.v1.Publisher.CreateTopic"
+				if !exists {
+					methodName = "google.pubsub.v1.Publisher.DeleteTopic"
 				}
+				topicEvent := cloudevents.NewEvent()
+				topicEvent.SetID("1234567890")
+				topicEvent.SetSubject(schemasv1.CloudAuditLogsEventSubject("pubsub.googleapis.com", "projects/test-project-id/topics/cloudauditlogssource-probe-1234567890"))
+				topicEvent.SetType(schemasv1.CloudAuditLogsLogWrittenEventType)
+				topicEvent.SetSource(schemasv1.CloudAuditLogsEventSource("projects/test-project-id", "activity"))
+				topicEvent.SetExtension("methodname", methodName)
+				loopback.send(ctx, topicEvent, fmt.Sprintf("topic %s CloudEvent from the test CloudAuditLogsSource", methodName))
+				topicExists = exists
 			}
 		}
 	})
@@ -195,17 +494,12 @@ func runTestCloudAuditLogsSource(ctx context.Context, group *errgroup.Group, pub
 
 // A helper function that starts a test ApiServerSource which intercepts
 // Kubernetes API requests and forwards the appropriate notifications as
-// CloudEvents to the probe helper receiver.
-func runTestApiServerSource(ctx context.Context, group *errgroup.Group, gotRequest chan *http.Request, probeReceiverURL string) {
-	cp, err := cloudevents.NewHTTP(cloudevents.WithTarget(probeReceiverURL))
-	if err != nil {
-		logging.FromContext(ctx).Fatalf("Failed to create http protocol of the test ApiServerSource, %v", err)
-	}
-	c, err := cloudevents.NewClient(cp)
-	if err != nil {
-		logging.FromContext(ctx).Fatalf("Failed to create the test ApiServerSource client, %v", err)
-	}
+// CloudEvents to the probe helper receiver. The events carry the full
+// resources as data only while the event mode is 'Resource'.
+func runTestApiServerSource(ctx context.Context, group *errgroup.Group, readiness *utils.ReadinessChecker, gotRequest chan *http.Request, eventMode *atomic.Value, loopback *loopbackPool) {
+	readiness.Register("apiserversource")
 	group.Go(func() error {
+		readiness.SetReady("apiserversource")
 		for {
 			select {
 			case <-ctx.Done():
@@ -219,9 +513,9 @@ func runTestApiServerSource(ctx context.Context, group *errgroup.Group, gotReque
 				method := req.Method
 				url := req.URL.String()
 				finalizeEvent := cloudevents.NewEvent()
-				finalizeEvent.SetID("1234567890")
 				finalizeEvent.SetSubject(fmt.Sprintf("/apis/v1/namespaces/%s/events/apiserversource.1234567890", testNamespace))
 				finalizeEvent.SetSource("https://0.0.0.0:443")
+				finalizeEvent.SetExtension("kind", "Pod")
 				finalizeEvent.SetExtension("name", fmt.Sprintf("%s.%s", testPodName, "1234567890"))
 				if method == "POST" && url == testPodCreateRequest && strings.Contains(body, testPodCreateBody) {
 					// This request indicates the client's intent to create a new pod.
@@ -233,8 +527,28 @@ func runTestApiServerSource(ctx context.Context, group *errgroup.Group, gotReque
 					// This request indicates the client's intent to delete a pod.
 					finalizeEvent.SetType(sources.ApiServerSourceDeleteEventType)
 				}
-				if res := c.Send(ctx, finalizeEvent); !cloudevents.IsACK(res) {
-					logging.FromContext(ctx).Warnf("Failed to send object finalized CloudEvent from the test ApiServerSource: %v", res)
+				if strings.HasPrefix(url, testConfigMapCreateRequest) {
+					finalizeEvent.SetExtension("kind", "ConfigMap")
+					finalizeEvent.SetExtension("name", fmt.Sprintf("%s.%s", testConfigMapName, "1234567890"))
+				}
+				if method == "POST" && url == testConfigMapCreateRequest && strings.Contains(body, testConfigMapCreateBody) {
+					// This request indicates the client's intent to create a new config map.
+					finalizeEvent.SetType(sources.ApiServerSourceAddEventType)
+				} else if method == "PUT" && url == testConfigMapModifyRequest && strings.Contains(body, testConfigMapUpdateBody) {
+					// This request indicates the client's intent to update a config map.
+					finalizeEvent.SetType(sources.ApiServerSourceUpdateEventType)
+				} else if method == "DELETE" && url == testConfigMapModifyRequest {
+					// This request indicates the client's intent to delete a config map.
+					finalizeEvent.SetType(sources.ApiServerSourceDeleteEventType)
+				}
+				// Every notification has a distinct ID, and is delivered twice like
+				// an event delivered at least once.
+				finalizeEvent.SetID(fmt.Sprintf("%s.%s", finalizeEvent.Type(), "1234567890"))
+				if eventMode.Load() == sourcesv1.ResourceMode {
+					finalizeEvent.SetData(cloudevents.ApplicationJSON, bodyBytes)
+				}
+				for i := 0; i < 2; i++ {
+					loopback.send(ctx, finalizeEvent, "object finalized CloudEvent from the test ApiServerSource")
 				}
 			}
 		}
@@ -244,16 +558,10 @@ func runTestApiServerSource(ctx context.Context, group *errgroup.Group, gotReque
 // A helper function that starts a test CloudStorageSource which intercepts
 // Cloud Storage HTTP requests and forwards the appropriate notifications as
 // CloudEvents to the probe helper receiver.
-func runTestCloudStorageSource(ctx context.Context, group *errgroup.Group, gotRequest chan *http.Request, probeReceiverURL string) {
-	cp, err := cloudevents.NewHTTP(cloudevents.WithTarget(probeReceiverURL))
-	if err != nil {
-		logging.FromContext(ctx).Fatalf("Failed to create http protocol of the test CloudStorageSource, %v", err)
-	}
-	c, err := cloudevents.NewClient(cp)
-	if err != nil {
-		logging.FromContext(ctx).Fatalf("Failed to create the test CloudStorageSource client, %v", err)
-	}
+func runTestCloudStorageSource(ctx context.Context, group *errgroup.Group, readiness *utils.ReadinessChecker, gotRequest chan *http.Request, loopback *loopbackPool) {
+	readiness.Register("cloudstoragesource")
 	group.Go(func() error {
+		readiness.SetReady("cloudstoragesource")
 		for {
 			select {
 			case <-ctx.Done():
@@ -273,19 +581,30 @@ func runTestCloudStorageSource(ctx context.Context, group *errgroup.Group, gotRe
 					finalizeEvent.SetSubject(schemasv1.CloudStorageEventSubject("1234567890"))
 					finalizeEvent.SetType(schemasv1.CloudStorageObjectFinalizedEventType)
 					finalizeEvent.SetSource(schemasv1.CloudStorageEventSource(testStorageBucket))
-					if res := c.Send(ctx, finalizeEvent); !cloudevents.IsACK(res) {
-						logging.FromContext(ctx).Warnf("Failed to send object finalized CloudEvent from the test CloudStorageSource: %v", res)
-					}
+					finalizeEvent.SetExtension(schemasv1.StorageEventTypeExtension, schemasv1.CloudStorageObjectFinalizeNotificationType)
+					finalizeEvent.SetData(cloudevents.ApplicationJSON, testStorageObjectData(testStorageBucket, "1234567890"))
+					loopback.send(ctx, finalizeEvent, "object finalized CloudEvent from the test CloudStorageSource")
 				} else if method == "PATCH" && url == testStorageRequest && strings.Contains(body, testStorageUpdateMetadataBody) {
-					// This request indicates the client's intent to update the object's metadata.
+					// This request indicates the client's intent to update the object's metadata,
+					// which the event data carries.
+					var attrs struct {
+						Metadata map[string]string `json:"metadata"`
+					}
+					if err := json.Unmarshal(bodyBytes, &attrs); err != nil {
+						logging.FromContext(ctx).Warnf("Failed to parse object metadata in test CloudStorageSource, %v", err)
+					}
+					delete(attrs.Metadata, testStorageDroppedMetadataKey)
+					if value, ok := attrs.Metadata[testStorageAlteredMetadataKey]; ok {
+						attrs.Metadata[testStorageAlteredMetadataKey] = value + " (altered)"
+					}
 					updateMetadataEvent := cloudevents.NewEvent()
 					updateMetadataEvent.SetID("1234567890")
 					updateMetadataEvent.SetSubject(schemasv1.CloudStorageEventSubject("1234567890"))
 					updateMetadataEvent.SetType(schemasv1.CloudStorageObjectMetadataUpdatedEventType)
 					updateMetadataEvent.SetSource(schemasv1.CloudStorageEventSource(testStorageBucket))
-					if res := c.Send(ctx, updateMetadataEvent); !cloudevents.IsACK(res) {
-						logging.FromContext(ctx).Warnf("Failed to send object metadata updated CloudEvent from the test CloudStorageSource: %v", res)
-					}
+					updateMetadataEvent.SetExtension(schemasv1.StorageEventTypeExtension, schemasv1.CloudStorageObjectMetadataUpdateNotificationType)
+					updateMetadataEvent.SetData(cloudevents.ApplicationJSON, map[string]interface{}{"metadata": attrs.Metadata})
+					loopback.send(ctx, updateMetadataEvent, "object metadata updated CloudEvent from the test CloudStorageSource")
 				} else if method == "POST" && url == testStorageUploadRequest && strings.Contains(body, testStorageArchiveBody) {
 					// This request indicates the client's intent to archive the object.
 					archivedEvent := cloudevents.NewEvent()
@@ -293,82 +612,141 @@ func runTestCloudStorageSource(ctx context.Context, group *errgroup.Group, gotRe
 					archivedEvent.SetSubject(schemasv1.CloudStorageEventSubject("1234567890"))
 					archivedEvent.SetType(schemasv1.CloudStorageObjectArchivedEventType)
 					archivedEvent.SetSource(schemasv1.CloudStorageEventSource(testStorageBucket))
-					if res := c.Send(ctx, archivedEvent); !cloudevents.IsACK(res) {
-						logging.FromContext(ctx).Warnf("Failed to send object archived CloudEvent from the test CloudStorageSource: %v", res)
-					}
+					archivedEvent.SetExtension(schemasv1.StorageEventTypeExtension, schemasv1.CloudStorageObjectArchiveNotificationType)
+					loopback.send(ctx, archivedEvent, "object archived CloudEvent from the test CloudStorageSource")
 				} else if method == "DELETE" && url == testStorageGenerationRequest {
 					// This request indicates the client's intent to delete the object.
 					deletedEvent := cloudevents.NewEvent()
 					deletedEvent.SetID("1234567890")
-					deletedEvent.SetSubject(schemasv1.CloudStorageEventSubject("1234567890"))
+					// The generation of the object is part of the request.
+					deletedEvent.SetSubject(schemasv1.CloudStorageEventSubjectWithGeneration("1234567890", 0))
 					deletedEvent.SetType(schemasv1.CloudStorageObjectDeletedEventType)
 					deletedEvent.SetSource(schemasv1.CloudStorageEventSource(testStorageBucket))
-					if res := c.Send(ctx, deletedEvent); !cloudevents.IsACK(res) {
-						logging.FromContext(ctx).Warnf("Failed to send object deleted CloudEvent from the test CloudStorageSource: %v", res)
+					deletedEvent.SetExtension(schemasv1.StorageEventTypeExtension, schemasv1.CloudStorageObjectDeleteNotificationType)
+					loopback.send(ctx, deletedEvent, "object deleted CloudEvent from the test CloudStorageSource")
+				} else if method == "POST" && req.URL.Path == testStorageComposePath {
+					// This request indicates the client's intent to compose objects into the destination object.
+					composedEvent := cloudevents.NewEvent()
+					composedEvent.SetID("1234567890")
+					composedEvent.SetSubject(schemasv1.CloudStorageEventSubject("1234567890"))
+					composedEvent.SetType(schemasv1.CloudStorageObjectFinalizedEventType)
+					composedEvent.SetSource(schemasv1.CloudStorageEventSource(testStorageBucket))
+					composedEvent.SetExtension(schemasv1.StorageEventTypeExtension, schemasv1.CloudStorageObjectFinalizeNotificationType)
+					composedEvent.SetData(cloudevents.ApplicationJSON, map[string]interface{}{"componentCount": 2})
+					loopback.send(ctx, composedEvent, "object composed CloudEvent from the test CloudStorageSource")
+				} else if match := testStorageUploadPathPattern.FindStringSubmatch(req.URL.Path); method == "POST" && match != nil && req.URL.Query().Get("uploadType") == "resumable" {
+					// This request either initiates a resumable upload
+					// session or uploads a chunk of the object to it. Only
+					// the last chunk completes the upload, in which case the
+					// object is notified as created, under the same
+					// conditions as another object.
+					bucket, object := match[1], req.URL.Query().Get("upload_id")
+					if object == "" || strings.HasSuffix(req.Header.Get("Content-Range"), "/*") {
+						continue
+					}
+					if bucket != testUnfilteredStorageBucket && bucket != testLegacyStorageBucket && !strings.HasPrefix(object, testStoragePrefix) {
+						continue
+					}
+					finalizeEvent := cloudevents.NewEvent()
+					finalizeEvent.SetID("1234567890")
+					finalizeEvent.SetSubject(schemasv1.CloudStorageEventSubject(object))
+					finalizeEvent.SetType(schemasv1.CloudStorageObjectFinalizedEventType)
+					finalizeEvent.SetSource(schemasv1.CloudStorageEventSource(bucket))
+					finalizeEvent.SetExtension(schemasv1.StorageEventTypeExtension, schemasv1.CloudStorageObjectFinalizeNotificationType)
+					finalizeEvent.SetData(cloudevents.ApplicationJSON, testStorageObjectData(bucket, object))
+					loopback.send(ctx, finalizeEvent, "object finalized CloudEvent from the test CloudStorageSource")
+				} else if match := testStorageUploadPathPattern.FindStringSubmatch(req.URL.Path); method == "POST" && match != nil {
+					// This request indicates the client's intent to create
+					// another object, which is only notified if it is under
+					// the prefix, unless the bucket is not filtered.
+					bucket, object := match[1], req.URL.Query().Get("name")
+					if bucket != testUnfilteredStorageBucket && bucket != testLegacyStorageBucket && !strings.HasPrefix(object, testStoragePrefix) {
+						continue
 					}
+					finalizeEvent := cloudevents.NewEvent()
+					finalizeEvent.SetID("1234567890")
+					finalizeEvent.SetSubject(schemasv1.CloudStorageEventSubject(object))
+					finalizeEvent.SetType(schemasv1.CloudStorageObjectFinalizedEventType)
+					finalizeEvent.SetSource(schemasv1.CloudStorageEventSource(bucket))
+					finalizeEvent.SetExtension(schemasv1.StorageEventTypeExtension, schemasv1.CloudStorageObjectFinalizeNotificationType)
+					finalizeEvent.SetData(cloudevents.ApplicationJSON, testStorageObjectData(bucket, object))
+					loopback.send(ctx, finalizeEvent, "object finalized CloudEvent from the test CloudStorageSource")
 				}
 			}
 		}
 	})
 }
 
+// A helper function that returns the data of the object finalized events of
+// the test CloudStorageSource, in the payload format of the bucket of the
+// object: the legacy JSON API object resource for the legacy bucket, and the
+// v1 StorageObjectData otherwise.
+func testStorageObjectData(bucket, object string) map[string]interface{} {
+	if bucket == testLegacyStorageBucket {
+		return map[string]interface{}{
+			"kind":       "storage#object",
+			"id":         fmt.Sprintf("%s/%s/0", bucket, object),
+			"selfLink":   fmt.Sprintf("https://www.googleapis.com/storage/v1/b/%s/o/%s", bucket, object),
+			"bucket":     bucket,
+			"name":       object,
+			"generation": "0",
+		}
+	}
+	return map[string]interface{}{
+		"bucket":         bucket,
+		"name":           object,
+		"generation":     "0",
+		"metageneration": "1",
+		"timeCreated":    "2021-01-01T00:00:00Z",
+		"updated":        "2021-01-01T00:00:00Z",
+	}
+}
+
 // A helper function that starts a test CloudSchedulerSource which ticks
 // periodically and sends the appropriate event notifications to the probe
 // helper receiver.
-func runTestCloudSchedulerSource(ctx context.Context, group *errgroup.Group, period time.Duration, probeReceiverURL string) {
-	cp, err := cloudevents.NewHTTP(cloudevents.WithTarget(probeReceiverURL))
-	if err != nil {
-		logging.FromContext(ctx).Fatalf("Failed to create http protocol of the test CloudSchedulerSource, %v", err)
-	}
-	c, err := cloudevents.NewClient(cp)
-	if err != nil {
-		logging.FromContext(ctx).Fatalf("Failed to create the test CloudSchedulerSource client, %v", err)
-	}
+func runTestCloudSchedulerSource(ctx context.Context, group *errgroup.Group, period time.Duration, loopback *loopbackPool) {
 	ticker := time.NewTicker(period)
 	group.Go(func() error {
 		for {
 			select {
 			case <-ctx.Done():
 				return nil
-			case <-ticker.C:
-				executedEvent := cloudevents.NewEvent()
-				executedEvent.SetID("1234567890")
-				executedEvent.SetType(schemasv1.CloudSchedulerJobExecutedEventType)
-				executedEvent.SetSource(schemasv1.CloudSchedulerEventSource("test-cloud-scheduler-source"))
-				if res := c.Send(ctx, executedEvent); !cloudevents.IsACK(res) {
-					logging.FromContext(ctx).Warnf("Failed to send job executed CloudEvent from the test CloudSchedulerSource: %v", res)
+			case tick := <-ticker.C:
+				for _, jobName := range testSchedulerJobs {
+					executedEvent := cloudevents.NewEvent()
+					executedEvent.SetID("1234567890")
+					executedEvent.SetType(schemasv1.CloudSchedulerJobExecutedEventType)
+					executedEvent.SetSource(schemasv1.CloudSchedulerEventSource(jobName))
+					executedEvent.SetSubject(schemasv1.CloudSchedulerEventSubject(jobName))
+					executedEvent.SetExtension(utils.ProbeEventTriggerTimeExtension, tick)
+					executedEvent.SetTime(time.Now())
+					loopback.send(ctx, executedEvent, "job executed CloudEvent from the test CloudSchedulerSource")
 				}
 			}
 		}
 	})
 }
 
-// A helper function that starts a test PingSource which ticks
+// A helper function that starts a test PingSource with a given name which ticks
 // periodically and sends the appropriate event notifications to the probe
 // helper receiver.
-func runTestPingSource(ctx context.Context, group *errgroup.Group, period time.Duration, probeReceiverURL string) {
-	cp, err := cloudevents.NewHTTP(cloudevents.WithTarget(probeReceiverURL))
-	if err != nil {
-		logging.FromContext(ctx).Fatalf("Failed to create http protocol of the test PingSource, %v", err)
-	}
-	c, err := cloudevents.NewClient(cp)
-	if err != nil {
-		logging.FromContext(ctx).Fatalf("Failed to create the test PingSource client, %v", err)
-	}
+func runTestPingSource(ctx context.Context, group *errgroup.Group, name string, period time.Duration, loopback *loopbackPool) {
 	ticker := time.NewTicker(period)
 	group.Go(func() error {
 		for {
 			select {
 			case <-ctx.Done():
 				return nil
-			case <-ticker.C:
+			case tick := <-ticker.C:
 				executedEvent := cloudevents.NewEvent()
 				executedEvent.SetID("1234567890")
 				executedEvent.SetType(sourcesv1beta1.PingSourceEventType)
-				executedEvent.SetSource(sourcesv1beta1.PingSourceSource(testNamespace, "test-ping-source"))
-				if res := c.Send(ctx, executedEvent); !cloudevents.IsACK(res) {
-					logging.FromContext(ctx).Warnf("Failed to send job executed CloudEvent from the test PingSource: %v", res)
-				}
+				executedEvent.SetSource(sourcesv1beta1.PingSourceSource(testNamespace, name))
+				executedEvent.SetSubject(schemasv1.PingSourceEventSubject(name))
+				executedEvent.SetExtension(utils.ProbeEventTriggerTimeExtension, tick)
+				executedEvent.SetTime(time.Now())
+				loopback.send(ctx, executedEvent, "job executed CloudEvent from the test PingSource")
 			}
 		}
 	})
@@ -382,6 +760,24 @@ func withProbeExtension(key, value string) probeEventOption {
 	}
 }
 
+func withoutProbeExtension(key string) probeEventOption {
+	return func(event *cloudevents.Event) {
+		event.SetExtension(key, nil)
+	}
+}
+
+func withProbeID(id string) probeEventOption {
+	return func(event *cloudevents.Event) {
+		event.SetID(id)
+	}
+}
+
+func withProbeData(data string) probeEventOption {
+	return func(event *cloudevents.Event) {
+		event.SetData(cloudevents.TextPlain, data)
+	}
+}
+
 func withProbeTimeout(timeout time.Duration) probeEventOption {
 	return withProbeExtension("timeout", timeout.String())
 }
@@ -426,6 +822,17 @@ func testStorageClient(ctx context.Context, t *testing.T) (*storage.Client, chan
 			logging.FromContext(ctx).Fatal("Test Cloud Storage server could not read request body.")
 		}
 		r.Body = ioutil.NopCloser(bytes.NewBuffer(body))
+		// The resumable upload sessions are located at the upload path with
+		// the name of the uploaded object as their ID, and every chunk but
+		// the last one is acknowledged as incomplete.
+		query := r.URL.Query()
+		if query.Get("uploadType") == "resumable" {
+			if query.Get("upload_id") == "" {
+				w.Header().Set("Location", fmt.Sprintf("http://%s%s?uploadType=resumable&upload_id=%s", r.Host, r.URL.Path, url.QueryEscape(query.Get("name"))))
+			} else if strings.HasSuffix(r.Header.Get("Content-Range"), "/*") {
+				w.Header().Set("X-Http-Status-Code-Override", "308")
+			}
+		}
 		gotRequest <- r
 		w.Write([]byte("{}"))
 	}))
@@ -436,40 +843,27 @@ func testStorageClient(ctx context.Context, t *testing.T) (*storage.Client, chan
 	return c, gotRequest, srv.Close
 }
 
-func testK8sClient(ctx context.Context, t *testing.T) (kubernetes.Interface, chan *http.Request, func()) {
-	gotRequest := make(chan *http.Request, 1)
-	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// The test Kubernetes API server forwards the client's generated HTTP requests.
-		body, err := ioutil.ReadAll(r.Body)
-		if err != nil {
-			logging.FromContext(ctx).Fatal("Test Kubernetes API server could not read request body.")
-		}
-		r.Body = ioutil.NopCloser(bytes.NewBuffer(body))
-		gotRequest <- r
-		w.Write([]byte("{}"))
-	}))
-	fakeK8sClientset := fake.NewSimpleClientset()
-	informers := informers.NewSharedInformerFactory(fakeK8sClientset, 0)
-	podInformer := informers.Core().V1().Pods().Informer()
-	podInformer.AddEventHandler(&cache.ResourceEventHandlerFuncs{
+// forwardTestK8sRequests sends the HTTP requests which the Kubernetes client
+// would have made to create, update and delete the objects observed by an
+// informer to the test Kubernetes API server.
+func forwardTestK8sRequests(t *testing.T, informer cache.SharedIndexInformer, createURL, modifyURL string) {
+	informer.AddEventHandler(&cache.ResourceEventHandlerFuncs{
 		AddFunc: func(obj interface{}) {
-			pod := obj.(*corev1.Pod)
-			json, err := json.Marshal(pod)
+			json, err := json.Marshal(obj)
 			if err != nil {
 				t.Fatalf("Failed to create test k8s client: %v", err)
 			}
 			httpClient := &http.Client{
 				Timeout: time.Second * 10,
 			}
-			httpClient.Post(fmt.Sprintf("%s%s", srv.URL, testPodCreateRequest), "application/json", bytes.NewBuffer(json))
+			httpClient.Post(createURL, "application/json", bytes.NewBuffer(json))
 		},
 		UpdateFunc: func(oldObj interface{}, obj interface{}) {
-			pod := obj.(*corev1.Pod)
-			json, err := json.Marshal(pod)
+			json, err := json.Marshal(obj)
 			if err != nil {
 				t.Fatalf("Failed to create test k8s client: %v", err)
 			}
-			reqURL, err := url.Parse(fmt.Sprintf("%s%s", srv.URL, testPodModifyRequest))
+			reqURL, err := url.Parse(modifyURL)
 			if err != nil {
 				t.Fatalf("Failed to create test k8s client: %v", err)
 			}
@@ -486,7 +880,11 @@ func testK8sClient(ctx context.Context, t *testing.T) (kubernetes.Interface, cha
 			})
 		},
 		DeleteFunc: func(obj interface{}) {
-			reqURL, err := url.Parse(fmt.Sprintf("%s%s", srv.URL, testPodModifyRequest))
+			json, err := json.Marshal(obj)
+			if err != nil {
+				t.Fatalf("Failed to create test k8s client: %v", err)
+			}
+			reqURL, err := url.Parse(modifyURL)
 			if err != nil {
 				t.Fatalf("Failed to create test k8s client: %v", err)
 			}
@@ -496,9 +894,28 @@ func testK8sClient(ctx context.Context, t *testing.T) (kubernetes.Interface, cha
 			httpClient.Do(&http.Request{
 				Method: "DELETE",
 				URL:    reqURL,
+				Body:   ioutil.NopCloser(bytes.NewReader(json)),
 			})
 		},
 	})
+}
+
+func testK8sClient(ctx context.Context, t *testing.T) (kubernetes.Interface, chan *http.Request, func()) {
+	gotRequest := make(chan *http.Request, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The test Kubernetes API server forwards the client's generated HTTP requests.
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			logging.FromContext(ctx).Fatal("Test Kubernetes API server could not read request body.")
+		}
+		r.Body = ioutil.NopCloser(bytes.NewBuffer(body))
+		gotRequest <- r
+		w.Write([]byte("{}"))
+	}))
+	fakeK8sClientset := fake.NewSimpleClientset()
+	informers := informers.NewSharedInformerFactory(fakeK8sClientset, 0)
+	forwardTestK8sRequests(t, informers.Core().V1().Pods().Informer(), srv.URL+testPodCreateRequest, srv.URL+testPodModifyRequest)
+	forwardTestK8sRequests(t, informers.Core().V1().ConfigMaps().Informer(), srv.URL+testConfigMapCreateRequest, srv.URL+testConfigMapModifyRequest)
 	informers.Start(ctx.Done())
 	return fakeK8sClientset, gotRequest, srv.Close
 }
@@ -542,171 +959,4577 @@ func TestProbeHelper(t *testing.T) {
 			},
 		},
 	}, {
-		name: "Broker E2E delivery probe default broker",
+		name: "Broker E2E delivery probe expected status",
 		steps: []eventAndResult{
 			{
-				event:      probeEvent("broker-e2e-delivery-probe", withProbeExtension("namespace", testNamespace)),
+				event:      probeEvent("broker-e2e-delivery-probe", withProbeExtension("namespace", testNamespace), withProbeExtension("broker", testAcceptingBroker), withProbeExtension("expectstatus", "202")),
+				wantResult: cloudevents.ResultACK,
+			},
+			// The probe does not wait for its event to be delivered back,
+			// which it never is along another target path.
+			{
+				event:      probeEvent("broker-e2e-delivery-probe", withProbeExtension("namespace", testNamespace), withProbeExtension("broker", testAcceptingBroker), withProbeExtension("expectstatus", "202"), withProbeExtension("targetpath", "/elsewhere"), withProbeTimeout(time.Second)),
 				wantResult: cloudevents.ResultACK,
 			},
 		},
 	}, {
-		name: "Broker E2E delivery probe missing namespace",
+		name: "Broker E2E delivery probe mismatched expected status",
 		steps: []eventAndResult{
 			{
-				event:      probeEvent("broker-e2e-delivery-probe"),
+				event:      probeEvent("broker-e2e-delivery-probe", withProbeExtension("namespace", testNamespace), withProbeExtension("broker", testAcceptingBroker), withProbeExtension("expectstatus", "200")),
 				wantResult: cloudevents.ResultNACK,
 			},
-		},
-	}, {
-		name: "Broker E2E delivery probe wrong broker name",
-		steps: []eventAndResult{
 			{
-				event:      probeEvent("broker-e2e-delivery-probe", withProbeExtension("namespace", testNamespace), withProbeExtension("broker", "wrongbroker")),
+				event:      probeEvent("broker-e2e-delivery-probe", withProbeExtension("namespace", testNamespace), withProbeExtension("expectstatus", "202")),
 				wantResult: cloudevents.ResultNACK,
 			},
-		},
-	}, {
-		name: "CloudPubSubSource probe",
-		steps: []eventAndResult{
 			{
-				event:      probeEvent("cloudpubsubsource-probe", withProbeExtension("topic", "cloudpubsubsource-topic")),
-				wantResult: cloudevents.ResultACK,
+				event:      probeEvent("broker-e2e-delivery-probe", withProbeExtension("namespace", testNamespace), withProbeExtension("broker", "unknown"), withProbeExtension("expectstatus", "202")),
+				wantResult: cloudevents.ResultNACK,
 			},
 		},
 	}, {
-		name: "CloudPubSubSource probe missing topic",
+		name: "Broker E2E delivery probe invalid expected status",
 		steps: []eventAndResult{
 			{
-				event:      probeEvent("cloudpubsubsource-probe"),
+				event:      probeEvent("broker-e2e-delivery-probe", withProbeExtension("namespace", testNamespace), withProbeExtension("expectstatus", "accepted")),
 				wantResult: cloudevents.ResultNACK,
 			},
 		},
 	}, {
-		name: "CloudStorageSource probe",
+		name: "Broker E2E delivery probe default broker",
 		steps: []eventAndResult{
 			{
-				event:      probeEvent("cloudstoragesource-probe-create", withProbeExtension("bucket", testStorageBucket)),
+				event:      probeEvent("broker-e2e-delivery-probe", withProbeExtension("namespace", testNamespace)),
 				wantResult: cloudevents.ResultACK,
 			},
+		},
+	}, {
+		name: "Broker E2E delivery probe other broker",
+		steps: []eventAndResult{
 			{
-				event:      probeEvent("cloudstoragesource-probe-update-metadata", withProbeExtension("bucket", testStorageBucket)),
+				event:      probeEvent("broker-e2e-delivery-probe", withProbeExtension("namespace", testNamespace), withProbeExtension("broker", testOtherBroker)),
 				wantResult: cloudevents.ResultACK,
 			},
+		},
+	}, {
+		name: "Broker E2E delivery probe correlated by data hash",
+		steps: []eventAndResult{
 			{
-				event:      probeEvent("cloudstoragesource-probe-archive", withProbeExtension("bucket", testStorageBucket)),
+				event:      probeEvent("broker-e2e-delivery-probe", withProbeExtension("namespace", testNamespace), withProbeExtension("correlateby", "datahash"), withProbeData("data-hash-probe")),
 				wantResult: cloudevents.ResultACK,
 			},
+		},
+	}, {
+		name: "Broker E2E delivery probe rewriting IDs correlated by data hash",
+		steps: []eventAndResult{
 			{
-				event:      probeEvent("cloudstoragesource-probe-delete", withProbeExtension("bucket", testStorageBucket)),
+				event:      probeEvent("broker-e2e-delivery-probe", withProbeExtension("namespace", testNamespace), withProbeExtension("broker", testIDRewritingBroker), withProbeExtension("correlateby", "datahash"), withProbeData("id-rewriting-probe")),
 				wantResult: cloudevents.ResultACK,
 			},
 		},
 	}, {
-		name: "CloudStorageSource probe missing bucket",
+		name: "Broker E2E delivery probe rewriting IDs correlated by ID",
 		steps: []eventAndResult{
 			{
-				event:      probeEvent("cloudstoragesource-probe-create"),
+				event:      probeEvent("broker-e2e-delivery-probe", withProbeExtension("namespace", testNamespace), withProbeExtension("broker", testIDRewritingBroker), withProbeData("id-rewriting-probe"), withProbeTimeout(time.Second)),
 				wantResult: cloudevents.ResultNACK,
 			},
 		},
 	}, {
-		name: "CloudAuditLogsSource probe",
+		name: "Broker E2E delivery probe prefixing IDs with prefix stripping",
 		steps: []eventAndResult{
 			{
-				event:      probeEvent("cloudauditlogssource-probe"),
+				event:      probeEvent("broker-e2e-delivery-probe", withProbeExtension("namespace", testNamespace), withProbeExtension("broker", testIDPrefixingBroker), withProbeExtension("idprefixstrip", testIDPrefix)),
 				wantResult: cloudevents.ResultACK,
 			},
 		},
 	}, {
-		name: "ApiServerSource probe",
+		name: "Broker E2E delivery probe prefixing IDs without prefix stripping",
 		steps: []eventAndResult{
 			{
-				event:      probeEvent("apiserversource-probe-create"),
-				wantResult: cloudevents.ResultACK,
+				event:      probeEvent("broker-e2e-delivery-probe", withProbeExtension("namespace", testNamespace), withProbeExtension("broker", testIDPrefixingBroker), withProbeTimeout(time.Second)),
+				wantResult: cloudevents.ResultNACK,
 			},
+		},
+	}, {
+		name: "Broker E2E delivery probe empty stripped ID prefix",
+		steps: []eventAndResult{
 			{
-				event:      probeEvent("apiserversource-probe-update"),
-				wantResult: cloudevents.ResultACK,
+				event:      probeEvent("broker-e2e-delivery-probe", withProbeExtension("namespace", testNamespace), withProbeExtension("idprefixstrip", "")),
+				wantResult: cloudevents.ResultNACK,
 			},
+		},
+	}, {
+		name: "Broker E2E delivery probe unsupported correlation",
+		steps: []eventAndResult{
 			{
-				event:      probeEvent("apiserversource-probe-delete"),
-				wantResult: cloudevents.ResultACK,
+				event:      probeEvent("broker-e2e-delivery-probe", withProbeExtension("namespace", testNamespace), withProbeExtension("correlateby", "subject")),
+				wantResult: cloudevents.ResultNACK,
 			},
 		},
 	}, {
-		name: "CloudSchedulerSource probe",
+		name: "Broker E2E delivery probe missing namespace",
 		steps: []eventAndResult{
 			{
-				event:      probeEvent("cloudschedulersource-probe", withProbeExtension("period", "200ms")),
-				wantResult: cloudevents.ResultACK,
+				event:      probeEvent("broker-e2e-delivery-probe"),
+				wantResult: cloudevents.ResultNACK,
 			},
 		},
 	}, {
-		name: "CloudSchedulerSource delay exceeds period",
+		name: "Broker E2E delivery probe wrong broker name",
 		steps: []eventAndResult{
 			{
-				event:      probeEvent("cloudschedulersource-probe", withProbeExtension("period", "0s")),
+				event:      probeEvent("broker-e2e-delivery-probe", withProbeExtension("namespace", testNamespace), withProbeExtension("broker", "wrongbroker")),
 				wantResult: cloudevents.ResultNACK,
 			},
 		},
 	}, {
-		name: "PingSource probe",
+		name: "Broker DLQ probe",
 		steps: []eventAndResult{
 			{
-				event:      probeEvent("pingsource-probe", withProbeExtension("period", "200ms")),
+				event:      probeEvent("broker-dlq-probe", withProbeExtension("namespace", testNamespace)),
 				wantResult: cloudevents.ResultACK,
 			},
 		},
 	}, {
-		name: "PingSource delay exceeds period",
+		name: "Broker DLQ probe rejected fewer times than the delivery attempts",
 		steps: []eventAndResult{
 			{
-				event:      probeEvent("pingsource-probe", withProbeExtension("period", "0s")),
+				event:      probeEvent("broker-dlq-probe", withProbeExtension("namespace", testNamespace), withProbeExtension("nacks", "1")),
 				wantResult: cloudevents.ResultNACK,
 			},
 		},
 	}, {
-		name: "PingSource missing period extension",
+		name: "Broker DLQ probe rejected as many times as the delivery attempts",
 		steps: []eventAndResult{
 			{
-				event:      probeEvent("pingsource-probe"),
+				event:      probeEvent("broker-dlq-probe", withProbeExtension("namespace", testNamespace), withProbeExtension("nacks", "3")),
+				wantResult: cloudevents.ResultACK,
+			},
+		},
+	}, {
+		name: "Broker DLQ probe delivered on first attempt",
+		steps: []eventAndResult{
+			{
+				event:      probeEvent("broker-dlq-probe", withProbeExtension("namespace", testNamespace), withProbeExtension("nacks", "0")),
+				wantResult: cloudevents.ResultNACK,
+			},
+		},
+	}, {
+		name: "Broker DLQ probe invalid nacks",
+		steps: []eventAndResult{
+			{
+				event:      probeEvent("broker-dlq-probe", withProbeExtension("namespace", testNamespace), withProbeExtension("nacks", "-1")),
+				wantResult: cloudevents.ResultNACK,
+			},
+		},
+	}, {
+		name: "Broker DLQ probe missing namespace",
+		steps: []eventAndResult{
+			{
+				event:      probeEvent("broker-dlq-probe"),
+				wantResult: cloudevents.ResultNACK,
+			},
+		},
+	}, {
+		name: "Channel E2E delivery probe",
+		steps: []eventAndResult{
+			{
+				event:      probeEvent("channel-e2e-delivery-probe", withProbeExtension("namespace", testNamespace), withProbeExtension("channel", testChannel)),
+				wantResult: cloudevents.ResultACK,
+			},
+		},
+	}, {
+		name: "Channel E2E delivery probe missing namespace",
+		steps: []eventAndResult{
+			{
+				event:      probeEvent("channel-e2e-delivery-probe", withProbeExtension("channel", testChannel)),
+				wantResult: cloudevents.ResultNACK,
+			},
+		},
+	}, {
+		name: "Channel E2E delivery probe missing channel",
+		steps: []eventAndResult{
+			{
+				event:      probeEvent("channel-e2e-delivery-probe", withProbeExtension("namespace", testNamespace)),
+				wantResult: cloudevents.ResultNACK,
+			},
+		},
+	}, {
+		name: "Channel E2E delivery probe wrong channel name",
+		steps: []eventAndResult{
+			{
+				event:      probeEvent("channel-e2e-delivery-probe", withProbeExtension("namespace", testNamespace), withProbeExtension("channel", "wrongchannel")),
+				wantResult: cloudevents.ResultNACK,
+			},
+		},
+	}, {
+		name: "CloudPubSubSource probe",
+		steps: []eventAndResult{
+			{
+				event:      probeEvent("cloudpubsubsource-probe", withProbeExtension("topic", "cloudpubsubsource-topic")),
+				wantResult: cloudevents.ResultACK,
+			},
+		},
+	}, {
+		name: "CloudPubSubSource probe through a named subscription",
+		steps: []eventAndResult{
+			{
+				event:      probeEvent("cloudpubsubsource-probe", withProbeExtension("topic", "cloudpubsubsource-topic"), withProbeExtension("subscription", testSubscriptionID)),
+				wantResult: cloudevents.ResultACK,
+			},
+			{
+				event:      probeEvent("cloudpubsubsource-probe", withProbeExtension("topic", "cloudpubsubsource-topic"), withProbeExtension("subscription", testOtherSubscriptionID), withProbeID("cloudpubsubsource-probe-other")),
+				wantResult: cloudevents.ResultACK,
+			},
+		},
+	}, {
+		name: "CloudPubSubSource probe through a missing subscription",
+		steps: []eventAndResult{
+			{
+				event:      probeEvent("cloudpubsubsource-probe", withProbeExtension("topic", "cloudpubsubsource-topic"), withProbeExtension("subscription", "missing-subscription"), withProbeTimeout(time.Second)),
+				wantResult: cloudevents.ResultNACK,
+			},
+		},
+	}, {
+		name: "Pub/Sub roundtrip probe",
+		steps: []eventAndResult{
+			{
+				event:      probeEvent("pubsub-roundtrip-probe", withProbeExtension("topic", testRoundtripTopicID), withProbeExtension("subscription", testRoundtripSubscriptionID)),
+				wantResult: cloudevents.ResultACK,
+			},
+		},
+	}, {
+		name: "Ordering probe",
+		steps: []eventAndResult{
+			{
+				// The test Pub/Sub server delivers the messages of an ordering
+				// key in any order, so only a sequence of a single event is
+				// certain to be delivered in order.
+				event:      probeEvent("ordering-probe", withProbeExtension("topic", testOrderingTopicID), withProbeExtension("sequencelength", "1")),
+				wantResult: cloudevents.ResultACK,
+			},
+		},
+	}, {
+		name: "Ordering probe invalid sequence length",
+		steps: []eventAndResult{
+			{
+				event:      probeEvent("ordering-probe", withProbeExtension("topic", testOrderingTopicID), withProbeExtension("sequencelength", "0")),
+				wantResult: cloudevents.ResultNACK,
+			},
+			{
+				event:      probeEvent("ordering-probe", withProbeExtension("topic", testOrderingTopicID), withProbeExtension("sequencelength", "1000")),
+				wantResult: cloudevents.ResultNACK,
+			},
+		},
+	}, {
+		name: "Ordering probe missing topic",
+		steps: []eventAndResult{
+			{
+				event:      probeEvent("ordering-probe"),
+				wantResult: cloudevents.ResultNACK,
+			},
+		},
+	}, {
+		name: "Pub/Sub roundtrip probe missing extensions",
+		steps: []eventAndResult{
+			{
+				event:      probeEvent("pubsub-roundtrip-probe", withProbeExtension("subscription", testRoundtripSubscriptionID)),
+				wantResult: cloudevents.ResultNACK,
+			},
+			{
+				event:      probeEvent("pubsub-roundtrip-probe", withProbeExtension("topic", testRoundtripTopicID)),
+				wantResult: cloudevents.ResultNACK,
+			},
+		},
+	}, {
+		name: "Pub/Sub roundtrip probe through a missing subscription",
+		steps: []eventAndResult{
+			{
+				event:      probeEvent("pubsub-roundtrip-probe", withProbeExtension("topic", testRoundtripTopicID), withProbeExtension("subscription", "missing-subscription"), withProbeTimeout(time.Second), withProbeID("pubsub-roundtrip-probe-missing-subscription")),
+				wantResult: cloudevents.ResultNACK,
+			},
+		},
+	}, {
+		name: "Pub/Sub roundtrip probe times out when the subscription is of another topic",
+		steps: []eventAndResult{
+			{
+				event:      probeEvent("pubsub-roundtrip-probe", withProbeExtension("topic", testTopicID), withProbeExtension("subscription", testRoundtripSubscriptionID), withProbeTimeout(time.Second), withProbeID("pubsub-roundtrip-probe-other-topic")),
+				wantResult: cloudevents.ResultNACK,
+			},
+		},
+	}, {
+		name: "CloudPubSubSource probe missing topic",
+		steps: []eventAndResult{
+			{
+				event:      probeEvent("cloudpubsubsource-probe"),
+				wantResult: cloudevents.ResultNACK,
+			},
+		},
+	}, {
+		name: "CloudStorageSource probe",
+		steps: []eventAndResult{
+			{
+				event:      probeEvent("cloudstoragesource-probe-create", withProbeExtension("bucket", testStorageBucket)),
+				wantResult: cloudevents.ResultACK,
+			},
+			{
+				event:      probeEvent("cloudstoragesource-probe-update-metadata", withProbeExtension("bucket", testStorageBucket)),
+				wantResult: cloudevents.ResultACK,
+			},
+			{
+				event:      probeEvent("cloudstoragesource-probe-archive", withProbeExtension("bucket", testStorageBucket)),
+				wantResult: cloudevents.ResultACK,
+			},
+			{
+				event:      probeEvent("cloudstoragesource-probe-delete", withProbeExtension("bucket", testStorageBucket)),
+				wantResult: cloudevents.ResultACK,
+			},
+		},
+	}, {
+		name: "CloudStorageSource update-metadata probe of a specific metadata key",
+		steps: []eventAndResult{
+			{
+				event:      probeEvent("cloudstoragesource-probe-update-metadata", withProbeExtension("bucket", testStorageBucket), withProbeExtension("metadatakey", "probe-key"), withProbeExtension("metadatavalue", "probe-value")),
+				wantResult: cloudevents.ResultACK,
+			},
+		},
+	}, {
+		name: "CloudStorageSource update-metadata probe of an altered metadata key",
+		steps: []eventAndResult{
+			{
+				event:      probeEvent("cloudstoragesource-probe-update-metadata", withProbeExtension("bucket", testStorageBucket), withProbeExtension("metadatakey", testStorageAlteredMetadataKey), withProbeExtension("metadatavalue", "probe-value")),
+				wantResult: cloudevents.ResultNACK,
+			},
+		},
+	}, {
+		name: "CloudStorageSource update-metadata probe of a dropped metadata key",
+		steps: []eventAndResult{
+			{
+				event:      probeEvent("cloudstoragesource-probe-update-metadata", withProbeExtension("bucket", testStorageBucket), withProbeExtension("metadatakey", testStorageDroppedMetadataKey), withProbeExtension("metadatavalue", "probe-value")),
+				wantResult: cloudevents.ResultNACK,
+			},
+		},
+	}, {
+		name: "CloudStorageSource update-metadata probe missing metadata value",
+		steps: []eventAndResult{
+			{
+				event:      probeEvent("cloudstoragesource-probe-update-metadata", withProbeExtension("bucket", testStorageBucket), withProbeExtension("metadatakey", "probe-key")),
+				wantResult: cloudevents.ResultNACK,
+			},
+		},
+	}, {
+		name: "CloudStorageSource probe missing bucket",
+		steps: []eventAndResult{
+			{
+				event:      probeEvent("cloudstoragesource-probe-create"),
+				wantResult: cloudevents.ResultNACK,
+			},
+		},
+	}, {
+		name: "CloudStorageSource compose probe",
+		steps: []eventAndResult{
+			{
+				event:      probeEvent("cloudstoragesource-probe-compose", withProbeExtension("bucket", testStorageBucket), withProbeExtension("sources", "source-1,source-2")),
+				wantResult: cloudevents.ResultACK,
+			},
+		},
+	}, {
+		name: "CloudStorageSource compose probe missing sources",
+		steps: []eventAndResult{
+			{
+				event:      probeEvent("cloudstoragesource-probe-compose", withProbeExtension("bucket", testStorageBucket)),
+				wantResult: cloudevents.ResultNACK,
+			},
+		},
+	}, {
+		name: "CloudStorageSource prefix probe",
+		steps: []eventAndResult{
+			{
+				event:      probeEvent("cloudstoragesource-probe-prefix", withProbeExtension("bucket", testStorageBucket), withProbeExtension("prefix", testStoragePrefix), withProbeTimeout(500*time.Millisecond)),
+				wantResult: cloudevents.ResultACK,
+			},
+		},
+	}, {
+		name: "CloudStorageSource prefix probe of unfiltered bucket",
+		steps: []eventAndResult{
+			{
+				event:      probeEvent("cloudstoragesource-probe-prefix", withProbeExtension("bucket", testUnfilteredStorageBucket), withProbeExtension("prefix", testStoragePrefix), withProbeTimeout(500*time.Millisecond)),
+				wantResult: cloudevents.ResultNACK,
+			},
+		},
+	}, {
+		name: "CloudStorageSource prefix probe missing prefix",
+		steps: []eventAndResult{
+			{
+				event:      probeEvent("cloudstoragesource-probe-prefix", withProbeExtension("bucket", testStorageBucket)),
+				wantResult: cloudevents.ResultNACK,
+			},
+		},
+	}, {
+		name: "CloudStorageSource resumable probe",
+		steps: []eventAndResult{
+			{
+				event:      probeEvent("cloudstoragesource-probe-resumable", withProbeExtension("bucket", testUnfilteredStorageBucket)),
+				wantResult: cloudevents.ResultACK,
+			},
+		},
+	}, {
+		name: "CloudStorageSource resumable probe of the legacy payload format",
+		steps: []eventAndResult{
+			{
+				event:      probeEvent("cloudstoragesource-probe-resumable", withProbeExtension("bucket", testLegacyStorageBucket), withProbeExtension("payloadformat", "legacy")),
+				wantResult: cloudevents.ResultACK,
+			},
+		},
+	}, {
+		name: "CloudStorageSource resumable probe without finalized event",
+		steps: []eventAndResult{
+			{
+				event:      probeEvent("cloudstoragesource-probe-resumable", withProbeExtension("bucket", testStorageBucket), withProbeTimeout(500*time.Millisecond)),
+				wantResult: cloudevents.ResultNACK,
+			},
+		},
+	}, {
+		name: "CloudStorageSource resumable probe missing bucket",
+		steps: []eventAndResult{
+			{
+				event:      probeEvent("cloudstoragesource-probe-resumable"),
+				wantResult: cloudevents.ResultNACK,
+			},
+		},
+	}, {
+		name: "CloudAuditLogsSource probe",
+		steps: []eventAndResult{
+			{
+				event:      probeEvent("cloudauditlogssource-probe"),
+				wantResult: cloudevents.ResultACK,
+			},
+			{
+				event:      probeEvent("cloudauditlogssource-probe-delete"),
+				wantResult: cloudevents.ResultACK,
+			},
+			{
+				// The deletion of a topic unknown to the test source is never logged.
+				event:      probeEvent("cloudauditlogssource-probe-delete", withProbeID("cloudauditlogssource-probe-delete-0987654321"), withProbeTimeout(time.Second)),
 				wantResult: cloudevents.ResultNACK,
 			},
 		},
 	}, {
-		name: "PingSource has invalid period format",
-		steps: []eventAndResult{
-			{
-				event:      probeEvent("pingsource-probe", withProbeExtension("period", "100ps")),
-				wantResult: cloudevents.ResultNACK,
-			},
-		},
+		name: "ApiServerSource probe",
+		steps: []eventAndResult{
+			{
+				event:      probeEvent("apiserversource-probe-create"),
+				wantResult: cloudevents.ResultACK,
+			},
+			{
+				event:      probeEvent("apiserversource-probe-update"),
+				wantResult: cloudevents.ResultACK,
+			},
+			{
+				event:      probeEvent("apiserversource-probe-delete"),
+				wantResult: cloudevents.ResultACK,
+			},
+		},
+	}, {
+		name: "ApiServerSource ConfigMap probe",
+		steps: []eventAndResult{
+			{
+				event:      probeEvent("apiserversource-probe-create", withProbeExtension("resource", "configmaps")),
+				wantResult: cloudevents.ResultACK,
+			},
+			{
+				event:      probeEvent("apiserversource-probe-update", withProbeExtension("resource", "configmaps")),
+				wantResult: cloudevents.ResultACK,
+			},
+			{
+				event:      probeEvent("apiserversource-probe-delete", withProbeExtension("resource", "configmaps")),
+				wantResult: cloudevents.ResultACK,
+			},
+			{
+				event:      probeEvent("apiserversource-probe-create", withProbeExtension("resource", "secrets")),
+				wantResult: cloudevents.ResultNACK,
+			},
+		},
+	}, {
+		name: "CloudSchedulerSource probe",
+		steps: []eventAndResult{
+			{
+				event:      probeEvent("cloudschedulersource-probe", withProbeExtension("period", "200ms")),
+				wantResult: cloudevents.ResultACK,
+			},
+		},
+	}, {
+		name: "CloudSchedulerSource probe of specific jobs",
+		steps: []eventAndResult{
+			{
+				event:      probeEvent("cloudschedulersource-probe", withProbeExtension("period", "200ms"), withProbeExtension("job", testSchedulerJobs[0])),
+				wantResult: cloudevents.ResultACK,
+			},
+			{
+				event:      probeEvent("cloudschedulersource-probe", withProbeExtension("period", "200ms"), withProbeExtension("job", testSchedulerJobs[1])),
+				wantResult: cloudevents.ResultACK,
+			},
+		},
+	}, {
+		name: "CloudSchedulerSource probe of unknown job",
+		steps: []eventAndResult{
+			{
+				event:      probeEvent("cloudschedulersource-probe", withProbeExtension("period", "200ms"), withProbeExtension("job", "projects/test-project-id/locations/us-central1/jobs/unknown")),
+				wantResult: cloudevents.ResultNACK,
+			},
+		},
+	}, {
+		name: "CloudSchedulerSource delay exceeds period",
+		steps: []eventAndResult{
+			{
+				event:      probeEvent("cloudschedulersource-probe", withProbeExtension("period", "0s")),
+				wantResult: cloudevents.ResultNACK,
+			},
+		},
+	}, {
+		name: "CloudSchedulerSource consecutive ticks within tolerance",
+		steps: []eventAndResult{
+			{
+				event:      probeEvent("cloudschedulersource-probe", withProbeExtension("period", "100ms"), withProbeExtension("tolerance", "1"), withProbeExtension("ticks", "3"), withProbeExtension("job", testSchedulerJobs[0])),
+				wantResult: cloudevents.ResultACK,
+			},
+		},
+	}, {
+		name: "CloudSchedulerSource delay exceeds tolerance",
+		steps: []eventAndResult{
+			{
+				event:      probeEvent("cloudschedulersource-probe", withProbeExtension("period", "0s"), withProbeExtension("tolerance", "10")),
+				wantResult: cloudevents.ResultNACK,
+			},
+		},
+	}, {
+		name: "CloudSchedulerSource rate probe",
+		steps: []eventAndResult{
+			{
+				event:      probeEvent("cloudschedulersource-rate-probe", withProbeExtension("period", "100ms"), withProbeExtension("window", "500ms"), withProbeExtension("job", testSchedulerJobs[0])),
+				wantResult: cloudevents.ResultACK,
+			},
+		},
+	}, {
+		name: "CloudSchedulerSource rate probe too few ticks",
+		steps: []eventAndResult{
+			{
+				event:      probeEvent("cloudschedulersource-rate-probe", withProbeExtension("period", "50ms"), withProbeExtension("window", "500ms"), withProbeExtension("job", testSchedulerJobs[0])),
+				wantResult: cloudevents.ResultNACK,
+			},
+		},
+	}, {
+		name: "CloudSchedulerSource rate probe too many ticks",
+		steps: []eventAndResult{
+			{
+				event:      probeEvent("cloudschedulersource-rate-probe", withProbeExtension("period", "200ms"), withProbeExtension("window", "500ms"), withProbeExtension("job", testSchedulerJobs[0])),
+				wantResult: cloudevents.ResultNACK,
+			},
+			{
+				// Without a job, the ticks of all the jobs are counted.
+				event:      probeEvent("cloudschedulersource-rate-probe", withProbeExtension("period", "100ms"), withProbeExtension("window", "500ms")),
+				wantResult: cloudevents.ResultNACK,
+			},
+		},
+	}, {
+		name: "CloudSchedulerSource rate probe invalid window",
+		steps: []eventAndResult{
+			{
+				event:      probeEvent("cloudschedulersource-rate-probe", withProbeExtension("period", "100ms")),
+				wantResult: cloudevents.ResultNACK,
+			},
+			{
+				event:      probeEvent("cloudschedulersource-rate-probe", withProbeExtension("period", "100ms"), withProbeExtension("window", "50ms")),
+				wantResult: cloudevents.ResultNACK,
+			},
+		},
+	}, {
+		name: "PingSource probe",
+		steps: []eventAndResult{
+			{
+				event:      probeEvent("pingsource-probe", withProbeExtension("period", "200ms")),
+				wantResult: cloudevents.ResultACK,
+			},
+		},
+	}, {
+		name: "PingSource delay exceeds period",
+		steps: []eventAndResult{
+			{
+				event:      probeEvent("pingsource-probe", withProbeExtension("period", "0s")),
+				wantResult: cloudevents.ResultNACK,
+			},
+		},
+	}, {
+		name: "PingSource delay within tolerance",
+		steps: []eventAndResult{
+			{
+				event:      probeEvent("pingsource-probe", withProbeExtension("period", "50ms"), withProbeExtension("tolerance", "3")),
+				wantResult: cloudevents.ResultACK,
+			},
+		},
+	}, {
+		name: "PingSource delay exceeds tolerance",
+		steps: []eventAndResult{
+			{
+				event:      probeEvent("pingsource-probe", withProbeExtension("period", "0s"), withProbeExtension("tolerance", "10")),
+				wantResult: cloudevents.ResultNACK,
+			},
+		},
+	}, {
+		name: "PingSource consecutive ticks within tolerance",
+		steps: []eventAndResult{
+			{
+				event:      probeEvent("pingsource-probe", withProbeExtension("period", "100ms"), withProbeExtension("tolerance", "1"), withProbeExtension("ticks", "3")),
+				wantResult: cloudevents.ResultACK,
+			},
+		},
+	}, {
+		name: "PingSource consecutive ticks exceed tolerance",
+		steps: []eventAndResult{
+			{
+				event:      probeEvent("pingsource-probe", withProbeExtension("period", "1ms"), withProbeExtension("tolerance", "0.5"), withProbeExtension("ticks", "3")),
+				wantResult: cloudevents.ResultNACK,
+			},
+		},
+	}, {
+		name: "PingSource has invalid tolerance",
+		steps: []eventAndResult{
+			{
+				event:      probeEvent("pingsource-probe", withProbeExtension("period", "200ms"), withProbeExtension("tolerance", "-1")),
+				wantResult: cloudevents.ResultNACK,
+			},
+			{
+				event:      probeEvent("pingsource-probe", withProbeExtension("period", "200ms"), withProbeExtension("ticks", "0")),
+				wantResult: cloudevents.ResultNACK,
+			},
+		},
+	}, {
+		name: "PingSource missing period extension",
+		steps: []eventAndResult{
+			{
+				event:      probeEvent("pingsource-probe"),
+				wantResult: cloudevents.ResultNACK,
+			},
+		},
+	}, {
+		name: "PingSource has invalid period format",
+		steps: []eventAndResult{
+			{
+				event:      probeEvent("pingsource-probe", withProbeExtension("period", "100ps")),
+				wantResult: cloudevents.ResultNACK,
+			},
+		},
+	}, {
+		name: "Unrecognized probe event type",
+		steps: []eventAndResult{
+			{
+				event:      probeEvent("unrecognized-probe-type"),
+				wantResult: cloudevents.ResultNACK,
+			},
+		},
+	}, {
+		name: "Custom timeout",
+		steps: []eventAndResult{
+			{
+				event:      probeEvent("broker-e2e-delivery-probe", withProbeExtension("namespace", "test-namespace"), withProbeTimeout(0)),
+				wantResult: cloudevents.ResultNACK,
+			},
+		},
+	}}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, step := range tc.steps {
+				if result := c.Send(ctx, *step.event); !errors.Is(result, step.wantResult) {
+					t.Fatalf("wanted result %+v, got %+v", step.wantResult, result)
+				}
+			}
+		})
+	}
+	// Cancel gracefully to avoid logger panic if parent goroutine terminates.
+	phr.cleanup()
+	cancel()
+	if err := group.Wait(); err != nil {
+		t.Fatalf("Error in probe helper fake sources: %v", err)
+	}
+}
+
+func TestProbeHelperCloudStorageSameObject(t *testing.T) {
+	ctx := logtest.TestContextWithLogger(t)
+	ctx = WithProjectKey(ctx, testProjectID)
+	ctx = WithTopicKey(ctx, testTopicID)
+	ctx = WithSubscriptionKey(ctx, testSubscriptionID)
+	group, ctx := errgroup.WithContext(ctx)
+	ctx, cancel := context.WithCancel(ctx)
+
+	phr := makeProbeHelper(ctx, t, group)
+	go phr.probeHelper.Run(ctx)
+
+	p, err := cloudevents.NewHTTP(cloudevents.WithTarget(phr.probeURL))
+	if err != nil {
+		t.Fatal("Failed to create HTTP protocol of the testing client:" + err.Error())
+	}
+	c, err := cloudevents.NewClient(p)
+	if err != nil {
+		t.Fatal("Failed to create testing client:" + err.Error())
+	}
+
+	// The create and update metadata probes are about the same object, so the
+	// events they cause share their subject, and are in flight together.
+	events := []*cloudevents.Event{
+		probeEvent("cloudstoragesource-probe-create", withProbeExtension("bucket", testStorageBucket)),
+		probeEvent("cloudstoragesource-probe-update-metadata", withProbeExtension("bucket", testStorageBucket)),
+	}
+	results := make(chan error, len(events))
+	for _, event := range events {
+		go func(event cloudevents.Event) {
+			results <- c.Send(ctx, event)
+		}(*event)
+	}
+	for range events {
+		if result := <-results; !cloudevents.IsACK(result) {
+			t.Errorf("wanted result %+v, got %+v", cloudevents.ResultACK, result)
+		}
+	}
+
+	phr.cleanup()
+	cancel()
+	if err := group.Wait(); err != nil {
+		t.Fatalf("Error in probe helper fake sources: %v", err)
+	}
+}
+
+func TestWithProbeTimeout(t *testing.T) {
+	ph := &Helper{
+		env: EnvConfig{
+			DefaultTimeoutDuration: 2 * time.Minute,
+			MaxTimeoutDuration:     30 * time.Minute,
+			PerTypeTimeoutDuration: map[string]time.Duration{
+				"cloudstoragesource-probe-create": 5 * time.Minute,
+				"cloudpubsubsource-probe":         time.Hour,
+			},
+		},
+	}
+	cases := []struct {
+		name        string
+		event       *cloudevents.Event
+		wantTimeout time.Duration
+	}{{
+		name:        "default timeout",
+		event:       probeEvent("broker-e2e-delivery-probe"),
+		wantTimeout: 2 * time.Minute,
+	}, {
+		name:        "custom timeout extension",
+		event:       probeEvent("broker-e2e-delivery-probe", withProbeTimeout(time.Minute)),
+		wantTimeout: time.Minute,
+	}, {
+		name:        "custom timeout extension clamped to maximum",
+		event:       probeEvent("broker-e2e-delivery-probe", withProbeTimeout(time.Hour)),
+		wantTimeout: 30 * time.Minute,
+	}, {
+		name:        "per type timeout",
+		event:       probeEvent("cloudstoragesource-probe-create"),
+		wantTimeout: 5 * time.Minute,
+	}, {
+		name:        "per type timeout takes precedence over extension",
+		event:       probeEvent("cloudstoragesource-probe-create", withProbeTimeout(time.Minute)),
+		wantTimeout: 5 * time.Minute,
+	}, {
+		name:        "per type timeout clamped to maximum",
+		event:       probeEvent("cloudpubsubsource-probe"),
+		wantTimeout: 30 * time.Minute,
+	}, {
+		name:        "unknown type falls through to default",
+		event:       probeEvent("unrecognized-probe-type"),
+		wantTimeout: 2 * time.Minute,
+	}}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := logtest.TestContextWithLogger(t)
+			start := time.Now()
+			ctx, cancel := ph.withProbeTimeout(ctx, *tc.event)
+			defer cancel()
+			deadline, ok := ctx.Deadline()
+			if !ok {
+				t.Fatal("probe context has no deadline")
+			}
+			if got := deadline.Sub(start); got < tc.wantTimeout || got > tc.wantTimeout+time.Second {
+				t.Errorf("probe timeout got=%s, want=%s", got, tc.wantTimeout)
+			}
+		})
+	}
+}
+
+type makeProbeHelperReturn struct {
+	probeHelper       *Helper
+	probeURL          string
+	livenessCheckURL  string
+	readinessCheckURL string
+	metricsURL        string
+	readiness         *utils.ReadinessChecker
+	// apiServerSourceEventMode is the event mode of the test ApiServerSource.
+	apiServerSourceEventMode *atomic.Value
+	// pubsubReceiveErrors injects errors into the subscription receiver of
+	// the test CloudPubSubSource.
+	pubsubReceiveErrors chan<- error
+	cleanup             func()
+}
+
+func makeProbeHelper(ctx context.Context, t *testing.T, group *errgroup.Group, envOpts ...func(*EnvConfig)) makeProbeHelperReturn {
+	env := EnvConfig{
+		LivenessStaleDuration:  time.Second,
+		DefaultTimeoutDuration: 2 * time.Minute,
+		MaxTimeoutDuration:     30 * time.Minute,
+		AuditLogsPollInterval:  100 * time.Millisecond,
+		MaxBatchSize:           100,
+		BatchConcurrency:       10,
+	}
+	for _, opt := range envOpts {
+		opt(&env)
+	}
+
+	// Set up ports for testing the probe helper.
+	receiverListener, err := GetFreePortListener()
+	if err != nil {
+		t.Fatalf("Failed to get free receiver port listener: %v", err)
+	}
+	receiverPort := receiverListener.Addr().(*net.TCPAddr).Port
+	receiverURL := fmt.Sprintf("http://localhost:%d/%s", receiverPort, testTargetReceiverPath)
+	probeListener, err := GetFreePortListener()
+	if err != nil {
+		t.Fatalf("Failed to get free probe port listener: %v", err)
+	}
+	probePort := probeListener.Addr().(*net.TCPAddr).Port
+	probeURL := fmt.Sprintf("http://localhost:%d", probePort)
+	livenessCheckURL := fmt.Sprintf("http://localhost:%d/healthz", receiverPort)
+	readinessCheckURL := fmt.Sprintf("http://localhost:%d/readyz", receiverPort)
+	metricsURL := fmt.Sprintf("http://localhost:%d/metrics", receiverPort)
+	readiness := utils.NewReadinessChecker()
+
+	// Set up the resources for testing the CloudPubSubSource.
+	pubsubClient, closePubsub := testPubsubClient(ctx, t, testProjectID)
+	topic, err := pubsubClient.CreateTopic(ctx, testTopicID)
+	if err != nil {
+		t.Fatalf("Failed to create test topic: %v", err)
+	}
+	sub, err := pubsubClient.CreateSubscription(ctx, testSubscriptionID, pubsub.SubscriptionConfig{
+		Topic: topic,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create test subscription: %v", err)
+	}
+	// Run the test CloudPubSubSource, whose subscription receiver is restarted
+	// when it fails.
+	supervisor := utils.NewReceiveSupervisor(wait.Backoff{Duration: 200 * time.Millisecond, Factor: 2, Steps: 3})
+	pubsubReceiveErrors := make(chan error)
+	runTestCloudPubSubSource(ctx, group, readiness, supervisor, sub, pubsubReceiveErrors, receiverURL)
+	// Run another test CloudPubSubSource of the same topic, which delivers
+	// the messages through its own subscription.
+	otherSub, err := pubsubClient.CreateSubscription(ctx, testOtherSubscriptionID, pubsub.SubscriptionConfig{
+		Topic: topic,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create other test subscription: %v", err)
+	}
+	runTestCloudPubSubSource(WithSubscriptionKey(ctx, testOtherSubscriptionID), group, readiness, supervisor, otherSub, nil, receiverURL)
+
+	// Run a test CloudPubSubSource of an ordered subscription for testing the
+	// ordering probes.
+	orderingTopic, err := pubsubClient.CreateTopic(ctx, testOrderingTopicID)
+	if err != nil {
+		t.Fatalf("Failed to create ordering test topic: %v", err)
+	}
+	orderingSub, err := pubsubClient.CreateSubscription(ctx, testOrderingSubscriptionID, pubsub.SubscriptionConfig{
+		Topic:                 orderingTopic,
+		EnableMessageOrdering: true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create ordering test subscription: %v", err)
+	}
+	runTestCloudPubSubSource(WithSubscriptionKey(ctx, testOrderingSubscriptionID), group, readiness, supervisor, orderingSub, nil, receiverURL)
+
+	// Set up the resources for testing the Pub/Sub roundtrip probes.
+	roundtripTopic, err := pubsubClient.CreateTopic(ctx, testRoundtripTopicID)
+	if err != nil {
+		t.Fatalf("Failed to create roundtrip test topic: %v", err)
+	}
+	if _, err := pubsubClient.CreateSubscription(ctx, testRoundtripSubscriptionID, pubsub.SubscriptionConfig{
+		Topic: roundtripTopic,
+	}); err != nil {
+		t.Fatalf("Failed to create roundtrip test subscription: %v", err)
+	}
+
+	// Run the pool of workers shared by the test sources below, which loops
+	// their events back to the probe helper receiver.
+	loopback := runLoopbackPool(ctx, group, testLoopbackPoolSize, receiverURL)
+
+	// Set up resources for testing the CloudStorageSource.
+	storageClient, gotCloudStorageRequest, closeStorage := testStorageClient(ctx, t)
+	// Run the test CloudStorageSource.
+	runTestCloudStorageSource(ctx, group, readiness, gotCloudStorageRequest, loopback)
+
+	// Run the test CloudSchedulerSource.
+	runTestCloudSchedulerSource(ctx, group, 100*time.Millisecond, loopback)
+
+	// Run the test PingSources.
+	for name, period := range testPingSourcePeriods {
+		runTestPingSource(ctx, group, name, period, loopback)
+	}
+
+	// Run the test CloudAuditLogsSource.
+	runTestCloudAuditLogsSource(ctx, group, pubsubClient, env.AuditLogsPollInterval, loopback)
+
+	// Run the test ApiServerSource.
+	k8sClient, gotK8sAPIRequest, closeK8sAPIServer := testK8sClient(ctx, t)
+	apiServerSourceEventMode := &atomic.Value{}
+	apiServerSourceEventMode.Store(sourcesv1.ReferenceMode)
+	runTestApiServerSource(ctx, group, readiness, gotK8sAPIRequest, apiServerSourceEventMode, loopback)
+
+	// Run the test Broker for testing Broker E2E delivery.
+	brokerIngressTemplate := runTestBroker(ctx, group, env.ReceiverContentMode, receiverURL)
+	channelIngressTemplate := runTestChannel(ctx, group, env.ReceiverContentMode, receiverURL)
+	// Create the probe helper and initialize it.
+	ph, err := InitializeTestProbeHelper(ctx, brokerIngressTemplate, channelIngressTemplate, testProjectID, time.Second, env, probeListener, receiverListener, readiness, storageClient, pubsubClient, k8sClient, clock.RealClock{})
+	if err != nil {
+		t.Fatal("Failed to create probe helper:", err)
+	}
+	ph.livenessChecker.AddActionFunc(supervisor.CheckReceiving())
+	return makeProbeHelperReturn{
+		probeHelper:       ph,
+		probeURL:          probeURL,
+		livenessCheckURL:  livenessCheckURL,
+		readinessCheckURL: readinessCheckURL,
+		metricsURL:        metricsURL,
+		readiness:         readiness,
+
+		apiServerSourceEventMode: apiServerSourceEventMode,
+		pubsubReceiveErrors:      pubsubReceiveErrors,
+		cleanup: func() {
+			closeStorage()
+			closePubsub()
+			closeK8sAPIServer()
+		},
+	}
+}
+
+func assertLivenessCheckResult(t *testing.T, url string, ok bool) {
+	assertCheckResult(t, "liveness", url, ok)
+}
+
+// assertLivenessCheckState checks the state reported on the first line of the
+// body of a liveness check response, which only succeeds when it is ok.
+func assertLivenessCheckState(t *testing.T, url string, want string) {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal("Failed to execute liveness check:", err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal("Failed to read liveness check response:", err)
+	}
+	var response utils.LivenessResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		t.Fatalf("Failed to unmarshal liveness check response %q: %v", body, err)
+	}
+	if response.State != want {
+		t.Errorf("liveness check state got=%q, want=%q (body: %q)", response.State, want, body)
+	}
+	if got, wantOK := resp.StatusCode == http.StatusOK, want == utils.LivenessOK; got != wantOK {
+		t.Errorf("liveness check status code got=%d, want ok=%v", resp.StatusCode, wantOK)
+	}
+}
+
+func assertReadinessCheckResult(t *testing.T, url string, ok bool) {
+	assertCheckResult(t, "readiness", url, ok)
+}
+
+func assertCheckResult(t *testing.T, check, url string, ok bool) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatalf("Failed to create %s check request: %v", check, err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Logf("Failed to execute %s check: %v", check, err)
+		if ok {
+			t.Errorf("%s check result ok got=%v, want=%v", check, !ok, ok)
+		}
+		return
+	}
+	if ok != (resp.StatusCode == http.StatusOK) {
+		t.Logf("Got %s check status code: %d", check, resp.StatusCode)
+		t.Errorf("%s check result ok got=%v, want=%v", check, !ok, ok)
+	}
+}
+
+// GetFreePortListener opens a listener on a free port.
+func GetFreePortListener() (net.Listener, error) {
+	addr, err := net.ResolveTCPAddr("tcp", "localhost:0")
+	if err != nil {
+		return nil, err
+	}
+	return net.ListenTCP("tcp", addr)
+}
+
+func TestProbeHelperInvalidAuditLogsPollInterval(t *testing.T) {
+	for _, interval := range []time.Duration{0, -time.Second} {
+		t.Run(interval.String(), func(t *testing.T) {
+			ctx := logtest.TestContextWithLogger(t)
+			pubsubClient, closePubsub := testPubsubClient(ctx, t, testProjectID)
+			defer closePubsub()
+			forwardListener, err := GetFreePortListener()
+			if err != nil {
+				t.Fatal("Failed to get free forward port listener:", err)
+			}
+			defer forwardListener.Close()
+			receiveListener, err := GetFreePortListener()
+			if err != nil {
+				t.Fatal("Failed to get free receiver port listener:", err)
+			}
+			defer receiveListener.Close()
+
+			env := EnvConfig{AuditLogsPollInterval: interval}
+			_, err = InitializeTestProbeHelper(ctx, "http://localhost", "http://localhost", testProjectID, time.Second, env, forwardListener, receiveListener, utils.NewReadinessChecker(), nil, pubsubClient, nil, clock.RealClock{})
+			if err == nil || !strings.Contains(err.Error(), "invalid audit logs poll interval") {
+				t.Errorf("initialization error got=%v, want invalid audit logs poll interval", err)
+			}
+		})
+	}
+}
+
+func TestProbeHelperApiServerSourceEventMode(t *testing.T) {
+	ctx := logtest.TestContextWithLogger(t)
+	group, ctx := errgroup.WithContext(ctx)
+	ctx, cancel := context.WithCancel(ctx)
+
+	phr := makeProbeHelper(ctx, t, group)
+	go phr.probeHelper.Run(ctx)
+
+	p, err := cloudevents.NewHTTP(cloudevents.WithTarget(phr.probeURL))
+	if err != nil {
+		t.Fatal("Failed to create HTTP protocol of the testing client:" + err.Error())
+	}
+	c, err := cloudevents.NewClient(p)
+	if err != nil {
+		t.Fatal("Failed to create testing client:" + err.Error())
+	}
+
+	// The steps share the test pod, which is created, updated, deleted,
+	// created again and deleted again.
+	steps := []struct {
+		name       string
+		sourceMode string
+		event      *cloudevents.Event
+		wantResult protocol.Result
+	}{{
+		name:       "unsupported event mode",
+		sourceMode: sourcesv1.ResourceMode,
+		event:      probeEvent("apiserversource-probe-create", withProbeExtension("eventmode", "Bogus")),
+		wantResult: cloudevents.ResultNACK,
+	}, {
+		name:       "resource mode",
+		sourceMode: sourcesv1.ResourceMode,
+		event:      probeEvent("apiserversource-probe-create", withProbeExtension("eventmode", sourcesv1.ResourceMode)),
+		wantResult: cloudevents.ResultACK,
+	}, {
+		name:       "reference mode",
+		sourceMode: sourcesv1.ReferenceMode,
+		event:      probeEvent("apiserversource-probe-update", withProbeExtension("eventmode", sourcesv1.ReferenceMode)),
+		wantResult: cloudevents.ResultACK,
+	}, {
+		name:       "resource mode expected, reference mode delivered",
+		sourceMode: sourcesv1.ReferenceMode,
+		event:      probeEvent("apiserversource-probe-delete", withProbeExtension("eventmode", sourcesv1.ResourceMode)),
+		wantResult: cloudevents.ResultNACK,
+	}, {
+		name:       "resource mode expected on create, reference mode delivered",
+		sourceMode: sourcesv1.ReferenceMode,
+		event:      probeEvent("apiserversource-probe-create", withProbeExtension("eventmode", sourcesv1.ResourceMode)),
+		wantResult: cloudevents.ResultNACK,
+	}, {
+		name:       "reference mode expected, resource mode delivered",
+		sourceMode: sourcesv1.ResourceMode,
+		event:      probeEvent("apiserversource-probe-delete", withProbeExtension("eventmode", sourcesv1.ReferenceMode)),
+		wantResult: cloudevents.ResultNACK,
+	}}
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			phr.apiServerSourceEventMode.Store(step.sourceMode)
+			if result := c.Send(ctx, *step.event); !errors.Is(result, step.wantResult) {
+				t.Fatalf("wanted result %+v, got %+v", step.wantResult, result)
+			}
+		})
+	}
+
+	// Cancel gracefully to avoid logger panic if parent goroutine terminates.
+	phr.cleanup()
+	cancel()
+	if err := group.Wait(); err != nil {
+		t.Fatalf("Error in probe helper fake sources: %v", err)
+	}
+}
+
+func TestProbeHelperApiServerSourceExpectCount(t *testing.T) {
+	ctx := logtest.TestContextWithLogger(t)
+	group, ctx := errgroup.WithContext(ctx)
+	ctx, cancel := context.WithCancel(ctx)
+
+	phr := makeProbeHelper(ctx, t, group)
+	go phr.probeHelper.Run(ctx)
+
+	p, err := cloudevents.NewHTTP(cloudevents.WithTarget(phr.probeURL))
+	if err != nil {
+		t.Fatal("Failed to create HTTP protocol of the testing client:" + err.Error())
+	}
+	c, err := cloudevents.NewClient(p)
+	if err != nil {
+		t.Fatal("Failed to create testing client:" + err.Error())
+	}
+
+	// A probe with an invalid expected count is rejected.
+	if result := c.Send(ctx, *probeEvent("apiserversource-probe-create", withProbeExtension("expectcount", "0"))); !cloudevents.IsNACK(result) {
+		t.Errorf("invalid expectcount got result %+v, want NACK", result)
+	}
+
+	// The create probe expecting three events waits for the add, update and
+	// delete events of the test pod, which the update and delete probes
+	// generate.
+	createResult := make(chan protocol.Result, 1)
+	go func() {
+		createResult <- c.Send(ctx, *probeEvent("apiserversource-probe-create", withProbeExtension("expectcount", "3")))
+	}()
+	time.Sleep(500 * time.Millisecond)
+	for _, eventType := range []string{"apiserversource-probe-update", "apiserversource-probe-delete"} {
+		if result := c.Send(ctx, *probeEvent(eventType)); !cloudevents.IsACK(result) {
+			t.Fatalf("%s probe got result %+v, want ACK", eventType, result)
+		}
+	}
+	if result := <-createResult; !cloudevents.IsACK(result) {
+		t.Errorf("create probe expecting 3 events got result %+v, want ACK", result)
+	}
+
+	// The create probe expecting two events times out after only receiving
+	// the add event, which counts once although it is delivered twice.
+	if result := c.Send(ctx, *probeEvent("apiserversource-probe-create", withProbeExtension("expectcount", "2"), withProbeTimeout(time.Second))); !cloudevents.IsNACK(result) {
+		t.Errorf("create probe expecting 2 events got result %+v, want NACK", result)
+	}
+
+	// Cancel gracefully to avoid logger panic if parent goroutine terminates.
+	phr.cleanup()
+	cancel()
+	if err := group.Wait(); err != nil {
+		t.Fatalf("Error in probe helper fake sources: %v", err)
+	}
+}
+
+func TestProbeHelperDryRun(t *testing.T) {
+	cases := []struct {
+		name       string
+		event      *cloudevents.Event
+		wantResult protocol.Result
+	}{{
+		name:       "well-formed probe",
+		event:      probeEvent("broker-e2e-delivery-probe", withProbeExtension("namespace", testNamespace)),
+		wantResult: cloudevents.ResultACK,
+	}, {
+		// The topic does not exist, so the probe would fail if it was forwarded.
+		name:       "well-formed probe of a missing topic",
+		event:      probeEvent("cloudpubsubsource-probe", withProbeExtension("topic", "missing-topic")),
+		wantResult: cloudevents.ResultACK,
+	}, {
+		name:       "invalid subject pattern",
+		event:      probeEvent("cloudstoragesource-probe-create", withProbeExtension("bucket", "test-bucket"), withProbeExtension("subjectpattern", "objects/(")),
+		wantResult: cloudevents.ResultNACK,
+	}, {
+		name:       "missing extension",
+		event:      probeEvent("broker-e2e-delivery-probe"),
+		wantResult: cloudevents.ResultNACK,
+	}, {
+		name:       "invalid extension",
+		event:      probeEvent("pingsource-probe", withProbeExtension("period", "often")),
+		wantResult: cloudevents.ResultNACK,
+	}, {
+		name:       "unrecognized type",
+		event:      probeEvent("unrecognized-probe"),
+		wantResult: cloudevents.ResultNACK,
+	}, {
+		name:       "timeout exceeding the maximum",
+		event:      probeEvent("broker-e2e-delivery-probe", withProbeExtension("namespace", testNamespace), withProbeTimeout(time.Hour)),
+		wantResult: cloudevents.ResultNACK,
+	}}
+	for _, mode := range []string{"env", "extension"} {
+		t.Run(mode, func(t *testing.T) {
+			ctx := logtest.TestContextWithLogger(t)
+			ctx = WithProjectKey(ctx, testProjectID)
+			ctx = WithTopicKey(ctx, testTopicID)
+			ctx = WithSubscriptionKey(ctx, testSubscriptionID)
+			group, ctx := errgroup.WithContext(ctx)
+			ctx, cancel := context.WithCancel(ctx)
+
+			phr := makeProbeHelper(ctx, t, group, func(env *EnvConfig) {
+				env.DryRun = mode == "env"
+			})
+			go phr.probeHelper.Run(ctx)
+
+			p, err := cloudevents.NewHTTP(cloudevents.WithTarget(phr.probeURL))
+			if err != nil {
+				t.Fatal("Failed to create HTTP protocol of the testing client:" + err.Error())
+			}
+			c, err := cloudevents.NewClient(p)
+			if err != nil {
+				t.Fatal("Failed to create testing client:" + err.Error())
+			}
+			for _, tc := range cases {
+				t.Run(tc.name, func(t *testing.T) {
+					event := tc.event.Clone()
+					if mode == "extension" {
+						event.SetExtension("dryrun", "true")
+					}
+					if result := c.Send(ctx, event); !errors.Is(result, tc.wantResult) {
+						t.Errorf("wanted result %+v, got %+v", tc.wantResult, result)
+					}
+				})
+			}
+
+			// Cancel gracefully to avoid logger panic if parent goroutine terminates.
+			phr.cleanup()
+			cancel()
+			if err := group.Wait(); err != nil {
+				t.Fatalf("Error in probe helper fake sources: %v", err)
+			}
+		})
+	}
+}
+
+func TestProbeHelperWarmup(t *testing.T) {
+	ctx := logtest.TestContextWithLogger(t)
+	ctx = WithProjectKey(ctx, testProjectID)
+	ctx = WithTopicKey(ctx, testTopicID)
+	ctx = WithSubscriptionKey(ctx, testSubscriptionID)
+	group, ctx := errgroup.WithContext(ctx)
+	ctx, cancel := context.WithCancel(ctx)
+
+	// The delivered events matching no probe would be rejected, were the
+	// warmup probe events not dropped.
+	phr := makeProbeHelper(ctx, t, group, func(env *EnvConfig) {
+		env.UnmatchedEventPolicy = "nack"
+	})
+	go phr.probeHelper.Run(ctx)
+
+	p, err := cloudevents.NewHTTP(cloudevents.WithTarget(phr.probeURL))
+	if err != nil {
+		t.Fatal("Failed to create HTTP protocol of the testing client:" + err.Error())
+	}
+	c, err := cloudevents.NewClient(p)
+	if err != nil {
+		t.Fatal("Failed to create testing client:" + err.Error())
+	}
+
+	cases := []struct {
+		name       string
+		event      *cloudevents.Event
+		wantReason FailureReason
+	}{{
+		name:  "broker e2e delivery probe",
+		event: probeEvent("broker-e2e-delivery-probe", withProbeExtension("namespace", testNamespace)),
+	}, {
+		name:  "CloudPubSubSource probe",
+		event: probeEvent("cloudpubsubsource-probe", withProbeExtension("topic", testTopicID)),
+	}, {
+		name:       "missing extension",
+		event:      probeEvent("broker-e2e-delivery-probe"),
+		wantReason: MissingExtensionReason,
+	}, {
+		name:       "unsupported probe type",
+		event:      probeEvent("pingsource-probe", withProbeExtension("period", "1s")),
+		wantReason: WarmupUnsupportedReason,
+	}}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			event := tc.event.Clone()
+			event.SetExtension("warmup", "true")
+			response, result := c.Request(ctx, event)
+			if tc.wantReason == "" {
+				if !cloudevents.IsACK(result) {
+					t.Errorf("wanted ACK for warmup probe, got %+v", result)
+				}
+				return
+			}
+			if !errors.Is(result, cloudevents.ResultNACK) {
+				t.Fatalf("wanted result %+v, got %+v", cloudevents.ResultNACK, result)
+			}
+			if response == nil {
+				t.Fatal("got no failure response event")
+			}
+			if got := response.Extensions()["failurereason"]; got != string(tc.wantReason) {
+				t.Errorf("failurereason extension got=%v, want=%s", got, tc.wantReason)
+			}
+		})
+	}
+
+	// The warmup probes leave nothing behind them.
+	if probes := phr.probeHelper.inFlightProbes.List(); len(probes) != 0 {
+		t.Errorf("in-flight probes got=%+v, want none", probes)
+	}
+
+	// Cancel gracefully to avoid logger panic if parent goroutine terminates.
+	phr.cleanup()
+	cancel()
+	if err := group.Wait(); err != nil {
+		t.Fatalf("Error in probe helper fake sources: %v", err)
+	}
+}
+
+// warmingProbeHandler is a blocking probe handler which also warms up its
+// probes.
+type warmingProbeHandler struct {
+	blockingProbeHandler
+	warmedUp chan cloudevents.Event
+}
+
+func (h *warmingProbeHandler) Warmup(ctx context.Context, event cloudevents.Event) error {
+	h.warmedUp <- event
+	return nil
+}
+
+func TestProbeHelperWarmupNotTracked(t *testing.T) {
+	ctx := logtest.TestContextWithLogger(t)
+	env := EnvConfig{
+		DefaultTimeoutDuration: time.Minute,
+		MaxTimeoutDuration:     time.Minute,
+	}
+	handler := &warmingProbeHandler{
+		blockingProbeHandler: blockingProbeHandler{
+			started: make(chan struct{}, 1),
+			release: make(chan struct{}),
+		},
+		warmedUp: make(chan cloudevents.Event, 1),
+	}
+	probeMetrics, err := utils.NewProbeMetrics(nil)
+	if err != nil {
+		t.Fatal("Failed to create probe metrics:", err)
+	}
+	inFlightProbes := utils.NewInFlightProbes()
+	ph, err := NewHelper(env, handler, nil, nil, &utils.LivenessChecker{}, utils.NewReadinessChecker(), probeMetrics, inFlightProbes, http.NewServeMux(), nil, nil, utils.NewInMemoryCorrelationStore(clock.RealClock{}), clock.RealClock{}, &utils.BackendChecker{}, &utils.ProbeRequests{})
+	if err != nil {
+		t.Fatal("Failed to create probe helper:", err)
+	}
+
+	// The warmup probe is ACKed once its event is sent, without waiting on
+	// its loopback.
+	if result := ph.forwardFromProbe(ctx)(*probeEvent("broker-e2e-delivery-probe", withProbeExtension("warmup", "true"))); !cloudevents.IsACK(result) {
+		t.Fatalf("wanted ACK for warmup probe, got %+v", result)
+	}
+	if got := (<-handler.warmedUp).ID(); got != "broker-e2e-delivery-probe-1234567890" {
+		t.Errorf("warmed up probe got id=%s, want id=broker-e2e-delivery-probe-1234567890", got)
+	}
+	if len(handler.started) != 0 {
+		t.Error("warmup probe was forwarded")
+	}
+	if probes := inFlightProbes.List(); len(probes) != 0 {
+		t.Errorf("in-flight probes got=%+v, want none", probes)
+	}
+	if results := ph.recentResults.List(); len(results) != 0 {
+		t.Errorf("recent results got=%+v, want none", results)
+	}
+}
+
+func TestProbeHelperFailureReason(t *testing.T) {
+	cases := []struct {
+		name       string
+		event      *cloudevents.Event
+		wantReason FailureReason
+	}{{
+		name:       "missing targetpath extension",
+		event:      probeEvent("broker-e2e-delivery-probe", withProbeExtension("namespace", testNamespace), withoutProbeExtension("targetpath")),
+		wantReason: MissingExtensionReason,
+	}, {
+		name:       "unclean targetpath",
+		event:      probeEvent("broker-e2e-delivery-probe", withProbeExtension("namespace", testNamespace), withProbeExtension("targetpath", "/"+testTargetReceiverPath+"/../other-namespace")),
+		wantReason: InvalidTargetPathReason,
+	}, {
+		name:       "missing probe extension",
+		event:      probeEvent("broker-e2e-delivery-probe"),
+		wantReason: MissingExtensionReason,
+	}, {
+		name:       "invalid probe extension",
+		event:      probeEvent("pingsource-probe", withProbeExtension("period", "often")),
+		wantReason: InvalidExtensionReason,
+	}, {
+		name:       "reserved extension name",
+		event:      probeEvent("broker-e2e-delivery-probe", withProbeExtension("namespace", testNamespace), withProbeExtension("schemaurl", "https://example.com/schema")),
+		wantReason: ReservedExtensionReason,
+	}, {
+		name:       "unrecognized type",
+		event:      probeEvent("unrecognized-probe"),
+		wantReason: UnrecognizedProbeTypeReason,
+	}, {
+		name:       "timeout",
+		event:      probeEvent("broker-e2e-delivery-probe", withProbeExtension("namespace", testNamespace), withProbeTimeout(0)),
+		wantReason: TimeoutReason,
+	}}
+	ctx := logtest.TestContextWithLogger(t)
+	ctx = WithProjectKey(ctx, testProjectID)
+	ctx = WithTopicKey(ctx, testTopicID)
+	ctx = WithSubscriptionKey(ctx, testSubscriptionID)
+	group, ctx := errgroup.WithContext(ctx)
+	ctx, cancel := context.WithCancel(ctx)
+
+	phr := makeProbeHelper(ctx, t, group)
+	go phr.probeHelper.Run(ctx)
+
+	p, err := cloudevents.NewHTTP(cloudevents.WithTarget(phr.probeURL))
+	if err != nil {
+		t.Fatal("Failed to create HTTP protocol of the testing client:" + err.Error())
+	}
+	c, err := cloudevents.NewClient(p)
+	if err != nil {
+		t.Fatal("Failed to create testing client:" + err.Error())
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			response, result := c.Request(ctx, *tc.event)
+			if !errors.Is(result, cloudevents.ResultNACK) {
+				t.Fatalf("wanted result %+v, got %+v", cloudevents.ResultNACK, result)
+			}
+			if response == nil {
+				t.Fatal("got no failure response event")
+			}
+			if got := response.Extensions()["failurereason"]; got != string(tc.wantReason) {
+				t.Errorf("failurereason extension got=%v, want=%s", got, tc.wantReason)
+			}
+			var failure FailureResult
+			if err := response.DataAs(&failure); err != nil {
+				t.Fatal("Failed to parse failure response data:", err)
+			}
+			if failure.Reason != tc.wantReason || failure.Message == "" {
+				t.Errorf("failure response data got=%+v, want reason %s and a message", failure, tc.wantReason)
+			}
+		})
+	}
+
+	// Cancel gracefully to avoid logger panic if parent goroutine terminates.
+	phr.cleanup()
+	cancel()
+	if err := group.Wait(); err != nil {
+		t.Fatalf("Error in probe helper fake sources: %v", err)
+	}
+}
+
+func TestProbeHelperMatchExtensions(t *testing.T) {
+	cases := []struct {
+		name        string
+		event       *cloudevents.Event
+		wantResult  protocol.Result
+		wantMessage string
+	}{{
+		name:       "matched extension delivered intact",
+		event:      probeEvent("broker-e2e-delivery-probe", withProbeExtension("namespace", testNamespace), withProbeExtension(testRewrittenExtension, "probe-bucket"), withProbeExtension("matchextensions", "namespace")),
+		wantResult: cloudevents.ResultACK,
+	}, {
+		name:       "rewritten extension not matched",
+		event:      probeEvent("broker-e2e-delivery-probe", withProbeExtension("namespace", testNamespace), withProbeExtension("broker", testExtensionRewritingBroker), withProbeExtension(testRewrittenExtension, "probe-bucket"), withProbeExtension("matchextensions", "namespace")),
+		wantResult: cloudevents.ResultACK,
+	}, {
+		name:        "rewritten extension matched",
+		event:       probeEvent("broker-e2e-delivery-probe", withProbeExtension("namespace", testNamespace), withProbeExtension("broker", testExtensionRewritingBroker), withProbeExtension(testRewrittenExtension, "probe-bucket"), withProbeExtension("matchextensions", "namespace,"+testRewrittenExtension)),
+		wantResult:  cloudevents.ResultNACK,
+		wantMessage: testRewrittenExtension,
+	}, {
+		name:        "matched extension missing",
+		event:       probeEvent("broker-e2e-delivery-probe", withProbeExtension("namespace", testNamespace), withProbeExtension("matchextensions", testRewrittenExtension)),
+		wantResult:  cloudevents.ResultNACK,
+		wantMessage: testRewrittenExtension,
+	}, {
+		name:       "expected JSON content type delivered intact",
+		event:      probeEvent("broker-e2e-delivery-probe", withProbeExtension("namespace", testNamespace), withProbeExtension("expectcontenttype", "application/json")),
+		wantResult: cloudevents.ResultACK,
+	}, {
+		name:       "expected text content type delivered intact",
+		event:      probeEvent("broker-e2e-delivery-probe", withProbeExtension("namespace", testNamespace), withProbeExtension("expectcontenttype", "text/plain")),
+		wantResult: cloudevents.ResultACK,
+	}, {
+		name:        "expected content type rewritten",
+		event:       probeEvent("broker-e2e-delivery-probe", withProbeExtension("namespace", testNamespace), withProbeExtension("broker", testContentTypeRewritingBroker), withProbeExtension("expectcontenttype", "text/plain")),
+		wantResult:  cloudevents.ResultNACK,
+		wantMessage: testRewrittenContentType,
+	}, {
+		name:        "invalid expected content type",
+		event:       probeEvent("broker-e2e-delivery-probe", withProbeExtension("namespace", testNamespace), withProbeExtension("expectcontenttype", "text/")),
+		wantResult:  cloudevents.ResultNACK,
+		wantMessage: "expectcontenttype",
+	}, {
+		name:       "v1 storage payload format",
+		event:      probeEvent("cloudstoragesource-probe-create", withProbeExtension("bucket", testStorageBucket), withProbeExtension("payloadformat", "v1")),
+		wantResult: cloudevents.ResultACK,
+	}, {
+		name:       "legacy storage payload format",
+		event:      probeEvent("cloudstoragesource-probe-create", withProbeExtension("bucket", testLegacyStorageBucket), withProbeExtension("payloadformat", "legacy")),
+		wantResult: cloudevents.ResultACK,
+	}, {
+		name:        "v1 storage payload format mismatch",
+		event:       probeEvent("cloudstoragesource-probe-create", withProbeExtension("bucket", testLegacyStorageBucket), withProbeExtension("payloadformat", "v1")),
+		wantResult:  cloudevents.ResultNACK,
+		wantMessage: "metageneration",
+	}, {
+		name:        "legacy storage payload format mismatch",
+		event:       probeEvent("cloudstoragesource-probe-create", withProbeExtension("bucket", testStorageBucket), withProbeExtension("payloadformat", "legacy")),
+		wantResult:  cloudevents.ResultNACK,
+		wantMessage: "kind",
+	}, {
+		name:        "invalid storage payload format",
+		event:       probeEvent("cloudstoragesource-probe-create", withProbeExtension("bucket", testStorageBucket), withProbeExtension("payloadformat", "v2")),
+		wantResult:  cloudevents.ResultNACK,
+		wantMessage: "payloadformat",
+	}}
+	ctx := logtest.TestContextWithLogger(t)
+	ctx = WithProjectKey(ctx, testProjectID)
+	ctx = WithTopicKey(ctx, testTopicID)
+	ctx = WithSubscriptionKey(ctx, testSubscriptionID)
+	group, ctx := errgroup.WithContext(ctx)
+	ctx, cancel := context.WithCancel(ctx)
+
+	phr := makeProbeHelper(ctx, t, group)
+	go phr.probeHelper.Run(ctx)
+
+	p, err := cloudevents.NewHTTP(cloudevents.WithTarget(phr.probeURL))
+	if err != nil {
+		t.Fatal("Failed to create HTTP protocol of the testing client:" + err.Error())
+	}
+	c, err := cloudevents.NewClient(p)
+	if err != nil {
+		t.Fatal("Failed to create testing client:" + err.Error())
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			response, result := c.Request(ctx, *tc.event)
+			if !errors.Is(result, tc.wantResult) {
+				t.Fatalf("wanted result %+v, got %+v", tc.wantResult, result)
+			}
+			if tc.wantMessage == "" {
+				return
+			}
+			if response == nil {
+				t.Fatal("got no failure response event")
+			}
+			var failure FailureResult
+			if err := response.DataAs(&failure); err != nil {
+				t.Fatal("Failed to parse failure response data:", err)
+			}
+			if !strings.Contains(failure.Message, tc.wantMessage) {
+				t.Errorf("failure message got=%q, want it to name %q", failure.Message, tc.wantMessage)
+			}
+		})
+	}
+
+	// Cancel gracefully to avoid logger panic if parent goroutine terminates.
+	phr.cleanup()
+	cancel()
+	if err := group.Wait(); err != nil {
+		t.Fatalf("Error in probe helper fake sources: %v", err)
+	}
+}
+
+func TestProbeHelperBrokerReject(t *testing.T) {
+	cases := []struct {
+		name        string
+		event       *cloudevents.Event
+		wantResult  protocol.Result
+		wantMessage string
+	}{{
+		name:       "malformed event rejected",
+		event:      probeEvent("broker-reject-probe", withProbeExtension("namespace", testNamespace)),
+		wantResult: cloudevents.ResultACK,
+	}, {
+		name:        "malformed event accepted",
+		event:       probeEvent("broker-reject-probe", withProbeExtension("namespace", testNamespace), withProbeExtension("broker", testLenientBroker)),
+		wantResult:  cloudevents.ResultNACK,
+		wantMessage: "accepted malformed event",
+	}, {
+		name:        "unknown broker",
+		event:       probeEvent("broker-reject-probe", withProbeExtension("namespace", testNamespace), withProbeExtension("broker", "unknown")),
+		wantResult:  cloudevents.ResultNACK,
+		wantMessage: "status 404",
+	}, {
+		name:        "no namespace",
+		event:       probeEvent("broker-reject-probe"),
+		wantResult:  cloudevents.ResultNACK,
+		wantMessage: "namespace",
+	}}
+	ctx := logtest.TestContextWithLogger(t)
+	ctx = WithProjectKey(ctx, testProjectID)
+	ctx = WithTopicKey(ctx, testTopicID)
+	ctx = WithSubscriptionKey(ctx, testSubscriptionID)
+	group, ctx := errgroup.WithContext(ctx)
+	ctx, cancel := context.WithCancel(ctx)
+
+	phr := makeProbeHelper(ctx, t, group)
+	go phr.probeHelper.Run(ctx)
+
+	p, err := cloudevents.NewHTTP(cloudevents.WithTarget(phr.probeURL))
+	if err != nil {
+		t.Fatal("Failed to create HTTP protocol of the testing client:" + err.Error())
+	}
+	c, err := cloudevents.NewClient(p)
+	if err != nil {
+		t.Fatal("Failed to create testing client:" + err.Error())
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			response, result := c.Request(ctx, *tc.event)
+			if !errors.Is(result, tc.wantResult) {
+				t.Fatalf("wanted result %+v, got %+v", tc.wantResult, result)
+			}
+			if tc.wantMessage == "" {
+				return
+			}
+			if response == nil {
+				t.Fatal("got no failure response event")
+			}
+			var failure FailureResult
+			if err := response.DataAs(&failure); err != nil {
+				t.Fatal("Failed to parse failure response data:", err)
+			}
+			if !strings.Contains(failure.Message, tc.wantMessage) {
+				t.Errorf("failure message got=%q, want it to contain %q", failure.Message, tc.wantMessage)
+			}
+		})
+	}
+
+	// Cancel gracefully to avoid logger panic if parent goroutine terminates.
+	phr.cleanup()
+	cancel()
+	if err := group.Wait(); err != nil {
+		t.Fatalf("Error in probe helper fake sources: %v", err)
+	}
+}
+
+func TestProbeHelperEnabledProbes(t *testing.T) {
+	ctx := logtest.TestContextWithLogger(t)
+	ctx = WithProjectKey(ctx, testProjectID)
+	ctx = WithTopicKey(ctx, testTopicID)
+	ctx = WithSubscriptionKey(ctx, testSubscriptionID)
+	group, ctx := errgroup.WithContext(ctx)
+	ctx, cancel := context.WithCancel(ctx)
+
+	// The CloudSchedulerSource probe is disabled, as if no CloudSchedulerSource
+	// was deployed.
+	phr := makeProbeHelper(ctx, t, group, func(env *EnvConfig) {
+		env.EnabledProbes = []string{"broker-e2e-delivery-probe", "pingsource-probe"}
+	})
+	go phr.probeHelper.Run(ctx)
+
+	p, err := cloudevents.NewHTTP(cloudevents.WithTarget(phr.probeURL))
+	if err != nil {
+		t.Fatal("Failed to create HTTP protocol of the testing client:" + err.Error())
+	}
+	c, err := cloudevents.NewClient(p)
+	if err != nil {
+		t.Fatal("Failed to create testing client:" + err.Error())
+	}
+
+	// The probes of a disabled type are rejected without waiting on their
+	// timeout.
+	start := time.Now()
+	response, result := c.Request(ctx, *probeEvent("cloudschedulersource-probe", withProbeExtension("period", "1s"), withProbeTimeout(time.Minute)))
+	if !errors.Is(result, cloudevents.ResultNACK) {
+		t.Fatalf("wanted result %+v, got %+v", cloudevents.ResultNACK, result)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("disabled probe was rejected after %s, want an immediate NACK", elapsed)
+	}
+	if response == nil {
+		t.Fatal("got no failure response event")
+	}
+	if got := response.Extensions()["failurereason"]; got != string(ProbeTypeDisabledReason) {
+		t.Errorf("failurereason extension got=%v, want=%s", got, ProbeTypeDisabledReason)
+	}
+
+	// The probes of an enabled type are still forwarded.
+	if result := c.Send(ctx, *probeEvent("broker-e2e-delivery-probe", withProbeExtension("namespace", testNamespace))); !cloudevents.IsACK(result) {
+		t.Errorf("wanted ACK for enabled probe, got %+v", result)
+	}
+
+	// Cancel gracefully to avoid logger panic if parent goroutine terminates.
+	phr.cleanup()
+	cancel()
+	if err := group.Wait(); err != nil {
+		t.Fatalf("Error in probe helper fake sources: %v", err)
+	}
+}
+
+func TestProbeHelperMaxProbePayloadBytes(t *testing.T) {
+	cases := []struct {
+		name       string
+		event      *cloudevents.Event
+		wantResult protocol.Result
+		wantReason FailureReason
+	}{{
+		name:       "generated payload at the maximum",
+		event:      probeEvent("broker-e2e-delivery-probe", withProbeExtension("namespace", testNamespace), withProbeExtension("payloadbytes", "1024")),
+		wantResult: cloudevents.ResultACK,
+	}, {
+		name:       "generated payload over the maximum",
+		event:      probeEvent("broker-e2e-delivery-probe", withProbeExtension("namespace", testNamespace), withProbeExtension("payloadbytes", "1025")),
+		wantResult: cloudevents.ResultNACK,
+		wantReason: PayloadTooLargeReason,
+	}, {
+		name:       "empty generated payload",
+		event:      probeEvent("broker-e2e-delivery-probe", withProbeExtension("namespace", testNamespace), withProbeExtension("payloadbytes", "0")),
+		wantResult: cloudevents.ResultACK,
+	}, {
+		name:       "negative payload size",
+		event:      probeEvent("broker-e2e-delivery-probe", withProbeExtension("namespace", testNamespace), withProbeExtension("payloadbytes", "-1")),
+		wantResult: cloudevents.ResultNACK,
+		wantReason: InvalidExtensionReason,
+	}, {
+		name:       "invalid payload size",
+		event:      probeEvent("broker-e2e-delivery-probe", withProbeExtension("namespace", testNamespace), withProbeExtension("payloadbytes", "large")),
+		wantResult: cloudevents.ResultNACK,
+		wantReason: InvalidExtensionReason,
+	}}
+	ctx := logtest.TestContextWithLogger(t)
+	ctx = WithProjectKey(ctx, testProjectID)
+	ctx = WithTopicKey(ctx, testTopicID)
+	ctx = WithSubscriptionKey(ctx, testSubscriptionID)
+	group, ctx := errgroup.WithContext(ctx)
+	ctx, cancel := context.WithCancel(ctx)
+
+	phr := makeProbeHelper(ctx, t, group, func(env *EnvConfig) {
+		env.MaxProbePayloadBytes = 1024
+	})
+	go phr.probeHelper.Run(ctx)
+
+	p, err := cloudevents.NewHTTP(cloudevents.WithTarget(phr.probeURL))
+	if err != nil {
+		t.Fatal("Failed to create HTTP protocol of the testing client:" + err.Error())
+	}
+	c, err := cloudevents.NewClient(p)
+	if err != nil {
+		t.Fatal("Failed to create testing client:" + err.Error())
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			response, result := c.Request(ctx, *tc.event)
+			if !errors.Is(result, tc.wantResult) {
+				t.Fatalf("wanted result %+v, got %+v", tc.wantResult, result)
+			}
+			if tc.wantReason == "" {
+				return
+			}
+			if response == nil {
+				t.Fatal("got no failure response event")
+			}
+			if got := response.Extensions()["failurereason"]; got != string(tc.wantReason) {
+				t.Errorf("failurereason extension got=%v, want=%s", got, tc.wantReason)
+			}
+		})
+	}
+
+	// Cancel gracefully to avoid logger panic if parent goroutine terminates.
+	phr.cleanup()
+	cancel()
+	if err := group.Wait(); err != nil {
+		t.Fatalf("Error in probe helper fake sources: %v", err)
+	}
+}
+
+func TestReceiveEventMaxProbePayloadBytes(t *testing.T) {
+	ctx := logtest.TestContextWithLogger(t)
+	ph := &Helper{env: EnvConfig{MaxProbePayloadBytes: 4}, clock: clock.RealClock{}}
+
+	event := cloudevents.NewEvent()
+	event.SetID("oversized-loopback")
+	event.SetSource("probe")
+	event.SetType("broker-e2e-delivery-probe")
+	event.SetExtension("receiverpath", "/"+testTargetReceiverPath)
+	if err := event.SetData(cloudevents.TextPlain, []byte("xxxxx")); err != nil {
+		t.Fatal("Failed to set event data:", err)
+	}
+	result := ph.receiveEvent(ctx)(event)
+	var httpResult *cehttp.Result
+	if !errors.As(result, &httpResult) || httpResult.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("wanted status %d, got %+v", http.StatusRequestEntityTooLarge, result)
+	}
+}
+
+func TestProbeHelperResultSink(t *testing.T) {
+	// The result sink captures the result events it receives.
+	resultEvents := make(chan cloudevents.Event, 2)
+	sink := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		event, err := binding.ToEvent(req.Context(), cehttp.NewMessageFromHttpRequest(req))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		resultEvents <- *event
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer sink.Close()
+
+	ctx := logtest.TestContextWithLogger(t)
+	ctx = WithProjectKey(ctx, testProjectID)
+	ctx = WithTopicKey(ctx, testTopicID)
+	ctx = WithSubscriptionKey(ctx, testSubscriptionID)
+	group, ctx := errgroup.WithContext(ctx)
+	ctx, cancel := context.WithCancel(ctx)
+
+	phr := makeProbeHelper(ctx, t, group, func(env *EnvConfig) {
+		env.ResultSink = sink.URL
+	})
+	go phr.probeHelper.Run(ctx)
+
+	p, err := cloudevents.NewHTTP(cloudevents.WithTarget(phr.probeURL))
+	if err != nil {
+		t.Fatal("Failed to create HTTP protocol of the testing client:" + err.Error())
+	}
+	c, err := cloudevents.NewClient(p)
+	if err != nil {
+		t.Fatal("Failed to create testing client:" + err.Error())
+	}
+	cases := []struct {
+		name       string
+		event      *cloudevents.Event
+		wantResult string
+	}{{
+		name:       "ACK",
+		event:      probeEvent("broker-e2e-delivery-probe", withProbeExtension("namespace", testNamespace)),
+		wantResult: utils.ProbeResultACK,
+	}, {
+		name:       "NACK",
+		event:      probeEvent("broker-e2e-delivery-probe", withProbeID("broker-e2e-delivery-probe-nack"), withProbeExtension("namespace", testNamespace), withProbeExtension("broker", "wrongbroker")),
+		wantResult: utils.ProbeResultNACK,
+	}}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c.Send(ctx, *tc.event)
+			var resultEvent cloudevents.Event
+			select {
+			case resultEvent = <-resultEvents:
+			case <-time.After(5 * time.Second):
+				t.Fatal("Timed out waiting for the result event")
+			}
+			if resultEvent.Type() != ResultEventType {
+				t.Errorf("result event type got=%s, want=%s", resultEvent.Type(), ResultEventType)
+			}
+			extensions := resultEvent.Extensions()
+			if got := fmt.Sprint(extensions["probetype"]); got != tc.event.Type() {
+				t.Errorf("probetype extension got=%s, want=%s", got, tc.event.Type())
+			}
+			if got := fmt.Sprint(extensions["probeid"]); got != tc.event.ID() {
+				t.Errorf("probeid extension got=%s, want=%s", got, tc.event.ID())
+			}
+			if got := fmt.Sprint(extensions["result"]); got != tc.wantResult {
+				t.Errorf("result extension got=%s, want=%s", got, tc.wantResult)
+			}
+			if _, ok := extensions["latencyms"]; !ok {
+				t.Error("result event has no latencyms extension")
+			}
+		})
+	}
+
+	// Cancel gracefully to avoid logger panic if parent goroutine terminates.
+	phr.cleanup()
+	cancel()
+	if err := group.Wait(); err != nil {
+		t.Fatalf("Error in probe helper fake sources: %v", err)
+	}
+}
+
+func TestProbeHelperUnreachableResultSink(t *testing.T) {
+	ctx := logtest.TestContextWithLogger(t)
+	ctx = WithProjectKey(ctx, testProjectID)
+	ctx = WithTopicKey(ctx, testTopicID)
+	ctx = WithSubscriptionKey(ctx, testSubscriptionID)
+	group, ctx := errgroup.WithContext(ctx)
+	ctx, cancel := context.WithCancel(ctx)
+
+	// The result sink refuses connections.
+	sinkListener, err := GetFreePortListener()
+	if err != nil {
+		t.Fatal("Failed to get free result sink port listener:", err)
+	}
+	sinkURL := fmt.Sprintf("http://localhost:%d", sinkListener.Addr().(*net.TCPAddr).Port)
+	sinkListener.Close()
+
+	phr := makeProbeHelper(ctx, t, group, func(env *EnvConfig) {
+		env.ResultSink = sinkURL
+	})
+	go phr.probeHelper.Run(ctx)
+
+	p, err := cloudevents.NewHTTP(cloudevents.WithTarget(phr.probeURL))
+	if err != nil {
+		t.Fatal("Failed to create HTTP protocol of the testing client:" + err.Error())
+	}
+	c, err := cloudevents.NewClient(p)
+	if err != nil {
+		t.Fatal("Failed to create testing client:" + err.Error())
+	}
+	// The probe succeeds regardless of the result sink.
+	event := probeEvent("broker-e2e-delivery-probe", withProbeExtension("namespace", testNamespace))
+	if result := c.Send(ctx, *event); !cloudevents.IsACK(result) {
+		t.Errorf("wanted result %+v, got %+v", cloudevents.ResultACK, result)
+	}
+
+	// Cancel gracefully to avoid logger panic if parent goroutine terminates.
+	phr.cleanup()
+	cancel()
+	if err := group.Wait(); err != nil {
+		t.Fatalf("Error in probe helper fake sources: %v", err)
+	}
+}
+
+func TestProbeHelperProbeSourceID(t *testing.T) {
+	const probeSourceID = "probe-helper-us-east1"
+
+	// The result sink captures the result events it receives.
+	resultEvents := make(chan cloudevents.Event, 2)
+	sink := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		event, err := binding.ToEvent(req.Context(), cehttp.NewMessageFromHttpRequest(req))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		resultEvents <- *event
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer sink.Close()
+
+	ctx := logtest.TestContextWithLogger(t)
+	ctx = WithProjectKey(ctx, testProjectID)
+	ctx = WithTopicKey(ctx, testTopicID)
+	ctx = WithSubscriptionKey(ctx, testSubscriptionID)
+	group, ctx := errgroup.WithContext(ctx)
+	ctx, cancel := context.WithCancel(ctx)
+
+	phr := makeProbeHelper(ctx, t, group, func(env *EnvConfig) {
+		env.ResultSink = sink.URL
+		env.ProbeSourceID = probeSourceID
+	})
+	go phr.probeHelper.Run(ctx)
+
+	p, err := cloudevents.NewHTTP(cloudevents.WithTarget(phr.probeURL))
+	if err != nil {
+		t.Fatal("Failed to create HTTP protocol of the testing client:" + err.Error())
+	}
+	c, err := cloudevents.NewClient(p)
+	if err != nil {
+		t.Fatal("Failed to create testing client:" + err.Error())
+	}
+	cases := []struct {
+		name         string
+		event        *cloudevents.Event
+		wantResult   protocol.Result
+		wantResponse bool
+	}{{
+		name:       "ACK",
+		event:      probeEvent("broker-e2e-delivery-probe", withProbeExtension("namespace", testNamespace)),
+		wantResult: cloudevents.ResultACK,
+	}, {
+		name:         "NACK",
+		event:        probeEvent("broker-e2e-delivery-probe", withProbeID("broker-e2e-delivery-probe-nack")),
+		wantResult:   cloudevents.ResultNACK,
+		wantResponse: true,
+	}}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			// The probe events are correlated as usual.
+			response, result := c.Request(ctx, *tc.event)
+			if !errors.Is(result, tc.wantResult) {
+				t.Fatalf("wanted result %+v, got %+v", tc.wantResult, result)
+			}
+			if tc.wantResponse {
+				if response == nil {
+					t.Fatal("got no failure response event")
+				}
+				if response.Source() != probeSourceID {
+					t.Errorf("failure response source got=%s, want=%s", response.Source(), probeSourceID)
+				}
+				if want := probeSourceID + "/" + tc.event.ID(); response.ID() != want {
+					t.Errorf("failure response ID got=%s, want=%s", response.ID(), want)
+				}
+			}
+
+			var resultEvent cloudevents.Event
+			select {
+			case resultEvent = <-resultEvents:
+			case <-time.After(5 * time.Second):
+				t.Fatal("Timed out waiting for the result event")
+			}
+			if resultEvent.Source() != probeSourceID {
+				t.Errorf("result event source got=%s, want=%s", resultEvent.Source(), probeSourceID)
+			}
+			if !strings.HasPrefix(resultEvent.ID(), probeSourceID+"/") {
+				t.Errorf("result event ID got=%s, want it prefixed with %s/", resultEvent.ID(), probeSourceID)
+			}
+			if got := fmt.Sprint(resultEvent.Extensions()["probeid"]); got != tc.event.ID() {
+				t.Errorf("probeid extension got=%s, want=%s", got, tc.event.ID())
+			}
+		})
+	}
+
+	// Cancel gracefully to avoid logger panic if parent goroutine terminates.
+	phr.cleanup()
+	cancel()
+	if err := group.Wait(); err != nil {
+		t.Fatalf("Error in probe helper fake sources: %v", err)
+	}
+}
+
+func TestProbeHelperConcurrentPingSources(t *testing.T) {
+	ctx := logtest.TestContextWithLogger(t)
+	ctx = WithProjectKey(ctx, testProjectID)
+	ctx = WithTopicKey(ctx, testTopicID)
+	ctx = WithSubscriptionKey(ctx, testSubscriptionID)
+	group, ctx := errgroup.WithContext(ctx)
+	ctx, cancel := context.WithCancel(ctx)
+
+	phr := makeProbeHelper(ctx, t, group)
+	go phr.probeHelper.Run(ctx)
+
+	p, err := cloudevents.NewHTTP(cloudevents.WithTarget(phr.probeURL))
+	if err != nil {
+		t.Fatal("Failed to create HTTP protocol of the testing client:" + err.Error())
+	}
+	c, err := cloudevents.NewClient(p)
+	if err != nil {
+		t.Fatal("Failed to create testing client:" + err.Error())
+	}
+
+	// Wait on the first tick of the slow PingSource.
+	time.Sleep(2 * testPingSourcePeriods[testSlowPingSource])
+
+	// Each probe waits on consecutive ticks of its own PingSource, so that the
+	// slow PingSource would be healthy at the period of the other one if their
+	// ticks were correlated.
+	cases := []struct {
+		name       string
+		event      *cloudevents.Event
+		wantResult protocol.Result
+	}{{
+		name:       "PingSource",
+		event:      probeEvent("pingsource-probe", withProbeExtension("pingsource", testPingSource), withProbeExtension("period", "100ms"), withProbeExtension("tolerance", "1"), withProbeExtension("ticks", "3")),
+		wantResult: cloudevents.ResultACK,
+	}, {
+		name:       "slow PingSource",
+		event:      probeEvent("pingsource-probe", withProbeExtension("pingsource", testSlowPingSource), withProbeExtension("period", "300ms"), withProbeExtension("tolerance", "1"), withProbeExtension("ticks", "2")),
+		wantResult: cloudevents.ResultACK,
+	}, {
+		name:       "slow PingSource at the period of the other",
+		event:      probeEvent("pingsource-probe", withProbeExtension("pingsource", testSlowPingSource), withProbeExtension("period", "100ms"), withProbeExtension("tolerance", "1"), withProbeExtension("ticks", "3")),
+		wantResult: cloudevents.ResultNACK,
+	}, {
+		name:       "unknown PingSource",
+		event:      probeEvent("pingsource-probe", withProbeExtension("pingsource", "unknown-ping-source"), withProbeExtension("period", "300ms")),
+		wantResult: cloudevents.ResultNACK,
+	}}
+	var wg sync.WaitGroup
+	for _, tc := range cases {
+		tc := tc
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if result := c.Send(ctx, *tc.event); !errors.Is(result, tc.wantResult) {
+				t.Errorf("%s: wanted result %+v, got %+v", tc.name, tc.wantResult, result)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Cancel gracefully to avoid logger panic if parent goroutine terminates.
+	phr.cleanup()
+	cancel()
+	if err := group.Wait(); err != nil {
+		t.Fatalf("Error in probe helper fake sources: %v", err)
+	}
+}
+
+func TestProbeHelperPingSourceJitter(t *testing.T) {
+	ctx := logtest.TestContextWithLogger(t)
+	group, ctx := errgroup.WithContext(ctx)
+	ctx, cancel := context.WithCancel(ctx)
+
+	phr := makeProbeHelper(ctx, t, group)
+	go phr.probeHelper.Run(ctx)
+
+	p, err := cloudevents.NewHTTP(cloudevents.WithTarget(phr.probeURL))
+	if err != nil {
+		t.Fatal("Failed to create HTTP protocol of the testing client:" + err.Error())
+	}
+	c, err := cloudevents.NewClient(p)
+	if err != nil {
+		t.Fatal("Failed to create testing client:" + err.Error())
+	}
+
+	// Wait on the first tick of the PingSource.
+	time.Sleep(2 * testPingSourcePeriods[testPingSource])
+
+	// A jitter window exceeding the period is rejected.
+	if result := c.Send(ctx, *probeEvent("pingsource-probe", withProbeExtension("pingsource", testPingSource), withProbeExtension("period", "300ms"), withProbeExtension("jitter", "1s"))); !cloudevents.IsNACK(result) {
+		t.Errorf("jitter exceeding the period got result %+v, want NACK", result)
+	}
+
+	// The probes with the same period check the ticks after their own jitter,
+	// rather than all at once.
+	ids := []string{"pingsource-probe-first", "pingsource-probe-second"}
+	checked := make([]time.Time, len(ids))
+	var wg sync.WaitGroup
+	for i, id := range ids {
+		i, event := i, probeEvent("pingsource-probe", withProbeID(id), withProbeExtension("pingsource", testPingSource), withProbeExtension("period", "300ms"), withProbeExtension("jitter", "300ms"))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if result := c.Send(ctx, *event); !cloudevents.IsACK(result) {
+				t.Errorf("%s: wanted ACK, got %+v", event.ID(), result)
+			}
+			checked[i] = time.Now()
+		}()
+	}
+	wg.Wait()
+	if d := checked[0].Sub(checked[1]); d > -50*time.Millisecond && d < 50*time.Millisecond {
+		t.Errorf("probes with jitter checked %s apart, want them spread over the jitter window", d)
+	}
+
+	// Cancel gracefully to avoid logger panic if parent goroutine terminates.
+	phr.cleanup()
+	cancel()
+	if err := group.Wait(); err != nil {
+		t.Fatalf("Error in probe helper fake sources: %v", err)
+	}
+}
+
+func TestProbeHelperStatus(t *testing.T) {
+	ctx := logtest.TestContextWithLogger(t)
+	group, ctx := errgroup.WithContext(ctx)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	phr := makeProbeHelper(ctx, t, group, func(env *EnvConfig) {
+		env.RecentResultsSize = 5
+	})
+	go phr.probeHelper.Run(ctx)
+	baseURL := strings.TrimSuffix(phr.livenessCheckURL, "/healthz")
+
+	p, err := cloudevents.NewHTTP(cloudevents.WithTarget(phr.probeURL))
+	if err != nil {
+		t.Fatal("Failed to create HTTP protocol of the testing client:" + err.Error())
+	}
+	c, err := cloudevents.NewClient(p)
+	if err != nil {
+		t.Fatal("Failed to create testing client:" + err.Error())
+	}
+	if result := c.Send(ctx, *probeEvent("broker-e2e-delivery-probe", withProbeExtension("namespace", testNamespace), withProbeID("status-probe"))); !cloudevents.IsACK(result) {
+		t.Fatalf("wanted ACK, got %+v", result)
+	}
+
+	// The status of the healthy probe helper lists its probe.
+	status, err := FetchStatus(ctx, http.DefaultClient, baseURL)
+	if err != nil {
+		t.Fatal("Failed to fetch probe helper status:", err)
+	}
+	var out bytes.Buffer
+	if err := status.Print(&out, 5); err != nil {
+		t.Fatal("Failed to print probe helper status:", err)
+	}
+	lines := strings.Split(out.String(), "\n")
+	if lines[0] != "healthy: yes" {
+		t.Errorf("health line got=%q, want=%q", lines[0], "healthy: yes")
+	}
+	if !strings.HasPrefix(lines[1], "latency: p50=") {
+		t.Errorf("latency line got=%q, want the latency percentiles", lines[1])
+	}
+	if !strings.Contains(out.String(), "ACK  broker-e2e-delivery-probe status-probe") {
+		t.Errorf("status got=%q, want it to list the ACKed probe", out.String())
+	}
+
+	// Once the forward client goes stale, the status names the reason.
+	time.Sleep(2 * phr.probeHelper.env.LivenessStaleDuration)
+	if status, err = FetchStatus(ctx, http.DefaultClient, baseURL); err != nil {
+		t.Fatal("Failed to fetch probe helper status:", err)
+	}
+	out.Reset()
+	if err := status.Print(&out, 5); err != nil {
+		t.Fatal("Failed to print probe helper status:", err)
+	}
+	if want := "healthy: no (stale, stale_forward: "; !strings.HasPrefix(out.String(), want) {
+		t.Errorf("status got=%q, want it to start with %q", out.String(), want)
+	}
+
+	// Cancel gracefully to avoid logger panic if parent goroutine terminates.
+	phr.cleanup()
+	cancel()
+	if err := group.Wait(); err != nil {
+		t.Fatalf("Error in probe helper fake sources: %v", err)
+	}
+}
+
+func TestProbeHelperLiveness(t *testing.T) {
+	ctx := logtest.TestContextWithLogger(t)
+	group, ctx := errgroup.WithContext(ctx)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	phr := makeProbeHelper(ctx, t, group)
+	go phr.probeHelper.Run(ctx)
+
+	// Make sure the liveness checker is up.
+	time.Sleep(500 * time.Millisecond)
+	assertLivenessCheckResult(t, phr.livenessCheckURL, true)
+
+	// Guarantee that it has been long enough that the stale duration has been reached. This will cause
+	// the liveness checker to fail.
+	time.Sleep(2 * phr.probeHelper.env.LivenessStaleDuration)
+	assertLivenessCheckResult(t, phr.livenessCheckURL, false)
+
+	// Cancel gracefully to avoid logger panic if parent goroutine terminates.
+	phr.cleanup()
+	cancel()
+	if err := group.Wait(); err != nil {
+		t.Fatalf("Error in probe helper fake sources: %v", err)
+	}
+}
+
+func TestProbeHelperCloudPubSubSourceRestart(t *testing.T) {
+	ctx := logtest.TestContextWithLogger(t)
+	ctx = WithProjectKey(ctx, testProjectID)
+	ctx = WithTopicKey(ctx, testTopicID)
+	ctx = WithSubscriptionKey(ctx, testSubscriptionID)
+	group, ctx := errgroup.WithContext(ctx)
+	ctx, cancel := context.WithCancel(ctx)
+
+	phr := makeProbeHelper(ctx, t, group)
+	go phr.probeHelper.Run(ctx)
+
+	// reportsReceiveLoop returns whether the liveness check reports the
+	// subscription receiver of the test CloudPubSubSource as not running.
+	reportsReceiveLoop := func() bool {
+		resp, err := http.Get(phr.livenessCheckURL)
+		if err != nil {
+			t.Fatal("Failed to execute liveness check:", err)
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal("Failed to read liveness check response:", err)
+		}
+		return strings.Contains(string(body), "receive loop cloudpubsubsource/"+testSubscriptionID)
+	}
+	waitFor := func(want bool) {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); reportsReceiveLoop() != want; {
+			if time.Now().After(deadline) {
+				t.Fatalf("liveness check reporting the receive loop got=%v, want=%v", !want, want)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	if reportsReceiveLoop() {
+		t.Fatal("liveness check reports the receive loop before it fails")
+	}
+	// The receive loop is reported until it is restarted after its backoff.
+	phr.pubsubReceiveErrors <- errors.New("transient receive error")
+	waitFor(true)
+	waitFor(false)
+
+	// The restarted receive loop keeps delivering the probe events.
+	p, err := cloudevents.NewHTTP(cloudevents.WithTarget(phr.probeURL))
+	if err != nil {
+		t.Fatal("Failed to create HTTP protocol of the testing client:" + err.Error())
+	}
+	c, err := cloudevents.NewClient(p)
+	if err != nil {
+		t.Fatal("Failed to create testing client:" + err.Error())
+	}
+	event := probeEvent("cloudpubsubsource-probe", withProbeExtension("topic", testTopicID), withProbeExtension("subscription", testSubscriptionID))
+	if result := c.Send(ctx, *event); !cloudevents.IsACK(result) {
+		t.Fatalf("wanted result %+v, got %+v", cloudevents.ResultACK, result)
+	}
+
+	// Cancel gracefully to avoid logger panic if parent goroutine terminates.
+	phr.cleanup()
+	cancel()
+	if err := group.Wait(); err != nil {
+		t.Fatalf("Error in probe helper fake sources: %v", err)
+	}
+}
+
+func TestProbeHelperLivenessStates(t *testing.T) {
+	ctx := logtest.TestContextWithLogger(t)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// The probe helper runs without any source, so that its receiver only
+	// receives the events sent by the test.
+	env := EnvConfig{
+		LivenessStaleDuration:  500 * time.Millisecond,
+		DefaultTimeoutDuration: time.Minute,
+		MaxTimeoutDuration:     time.Minute,
+	}
+	receiverListener, err := GetFreePortListener()
+	if err != nil {
+		t.Fatalf("Failed to get free receiver port listener: %v", err)
+	}
+	receiverPort := receiverListener.Addr().(*net.TCPAddr).Port
+	livenessCheckURL := fmt.Sprintf("http://localhost:%d/healthz", receiverPort)
+	probeListener, err := GetFreePortListener()
+	if err != nil {
+		t.Fatalf("Failed to get free probe port listener: %v", err)
+	}
+	probeMetrics, err := utils.NewProbeMetrics(nil)
+	if err != nil {
+		t.Fatal("Failed to create probe metrics:", err)
+	}
+	livenessChecker := &utils.LivenessChecker{}
+	readinessChecker := utils.NewReadinessChecker()
+	inFlightProbes := utils.NewInFlightProbes()
+	mux := NewReceiverMux(ctx, livenessChecker, readinessChecker, inFlightProbes)
+	forwardClient, err := NewCeForwardClient(env, NewTestCeForwardClientOptions(probeListener), probeMetrics, &utils.ProbeRequests{})
+	if err != nil {
+		t.Fatal("Failed to create forward client:", err)
+	}
+	receiveClient, err := NewCeReceiverClient(ctx, env, mux, NewTestCeReceiverClientOptions(receiverListener, nil))
+	if err != nil {
+		t.Fatal("Failed to create receiver client:", err)
+	}
+	ph, err := NewHelper(env, &blockingProbeHandler{}, forwardClient, receiveClient, livenessChecker, readinessChecker, probeMetrics, inFlightProbes, mux, nil, nil, utils.NewInMemoryCorrelationStore(clock.RealClock{}), clock.RealClock{}, &utils.BackendChecker{}, &utils.ProbeRequests{})
+	if err != nil {
+		t.Fatal("Failed to create probe helper:", err)
+	}
+	go ph.Run(ctx)
+	time.Sleep(100 * time.Millisecond)
+
+	// The probe helper is starting until its receiver receives an event.
+	assertLivenessCheckState(t, livenessCheckURL, utils.LivenessStarting)
+
+	p, err := cloudevents.NewHTTP(cloudevents.WithTarget(fmt.Sprintf("http://localhost:%d/%s", receiverPort, testTargetReceiverPath)))
+	if err != nil {
+		t.Fatal("Failed to create HTTP protocol of the testing client:" + err.Error())
+	}
+	c, err := cloudevents.NewClient(p)
+	if err != nil {
+		t.Fatal("Failed to create testing client:" + err.Error())
+	}
+	if result := c.Send(ctx, *probeEvent("broker-e2e-delivery-probe")); !cloudevents.IsACK(result) {
+		t.Fatalf("Failed to send event to the receiver: %+v", result)
+	}
+	assertLivenessCheckState(t, livenessCheckURL, utils.LivenessOK)
+
+	// The probe helper goes stale once it processes no more events.
+	time.Sleep(2 * env.LivenessStaleDuration)
+	assertLivenessCheckState(t, livenessCheckURL, utils.LivenessStale)
+}
+
+func TestProbeHelperReadiness(t *testing.T) {
+	ctx := logtest.TestContextWithLogger(t)
+	group, ctx := errgroup.WithContext(ctx)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	phr := makeProbeHelper(ctx, t, group)
+	// Register a source which has not started yet.
+	phr.readiness.Register("late-source")
+	go phr.probeHelper.Run(ctx)
+
+	// Make sure the readiness checker is up.
+	time.Sleep(500 * time.Millisecond)
+	assertReadinessCheckResult(t, phr.readinessCheckURL, false)
+
+	// Once every source has started, the probe helper is ready.
+	phr.readiness.SetReady("late-source")
+	assertReadinessCheckResult(t, phr.readinessCheckURL, true)
+
+	// Cancel gracefully to avoid logger panic if parent goroutine terminates.
+	phr.cleanup()
+	cancel()
+	if err := group.Wait(); err != nil {
+		t.Fatalf("Error in probe helper fake sources: %v", err)
+	}
+}
+
+// assertServed checks whether an HTTP endpoint responds within a short timeout.
+func assertServed(t *testing.T, url string, served bool) {
+	t.Helper()
+	client := &http.Client{Timeout: 500 * time.Millisecond}
+	resp, err := client.Get(url)
+	if err == nil {
+		resp.Body.Close()
+	}
+	if got := err == nil; got != served {
+		t.Errorf("%s served got=%v, want=%v (error: %v)", url, got, served, err)
+	}
+}
+
+func TestProbeHelperRoles(t *testing.T) {
+	cases := []struct {
+		role           string
+		wantForwarder  bool
+		wantReceiver   bool
+		wantHealthPort bool
+	}{{
+		role:          CombinedRole,
+		wantForwarder: true,
+		wantReceiver:  true,
+	}, {
+		role:           ForwarderRole,
+		wantForwarder:  true,
+		wantHealthPort: true,
+	}, {
+		role:         ReceiverRole,
+		wantReceiver: true,
+	}}
+	for _, tc := range cases {
+		t.Run(tc.role, func(t *testing.T) {
+			ctx := logtest.TestContextWithLogger(t)
+			ctx = WithProjectKey(ctx, testProjectID)
+			ctx = WithTopicKey(ctx, testTopicID)
+			ctx = WithSubscriptionKey(ctx, testSubscriptionID)
+			group, ctx := errgroup.WithContext(ctx)
+			ctx, cancel := context.WithCancel(ctx)
+
+			healthListener, err := GetFreePortListener()
+			if err != nil {
+				t.Fatal("Failed to get free health port listener:", err)
+			}
+			healthPort := healthListener.Addr().(*net.TCPAddr).Port
+			healthListener.Close()
+
+			phr := makeProbeHelper(ctx, t, group, func(env *EnvConfig) {
+				env.Role = tc.role
+				env.HealthPort = healthPort
+			})
+			go phr.probeHelper.Run(ctx)
+			// Make sure the clients are up.
+			time.Sleep(500 * time.Millisecond)
+
+			// The forwarder accepts the probe requests.
+			if tc.wantForwarder {
+				p, err := cloudevents.NewHTTP(cloudevents.WithTarget(phr.probeURL))
+				if err != nil {
+					t.Fatal("Failed to create HTTP protocol of the testing client:" + err.Error())
+				}
+				c, err := cloudevents.NewClient(p)
+				if err != nil {
+					t.Fatal("Failed to create testing client:" + err.Error())
+				}
+				event := probeEvent("broker-e2e-delivery-probe", withProbeExtension("namespace", testNamespace), withProbeExtension("dryrun", "true"))
+				if result := c.Send(ctx, *event); !cloudevents.IsACK(result) {
+					t.Errorf("wanted result %+v, got %+v", cloudevents.ResultACK, result)
+				}
+			} else {
+				assertServed(t, phr.probeURL, false)
+			}
+
+			// The receiver serves the liveness and readiness checks, which
+			// are otherwise served on the health port.
+			assertServed(t, phr.livenessCheckURL, tc.wantReceiver)
+			if tc.wantReceiver {
+				assertLivenessCheckResult(t, phr.livenessCheckURL, true)
+				assertReadinessCheckResult(t, phr.readinessCheckURL, true)
+			}
+			healthURL := fmt.Sprintf("http://localhost:%d", healthPort)
+			assertServed(t, healthURL+"/healthz", tc.wantHealthPort)
+			if tc.wantHealthPort {
+				assertLivenessCheckResult(t, healthURL+"/healthz", true)
+				assertReadinessCheckResult(t, healthURL+"/readyz", true)
+			}
+
+			// Cancel gracefully to avoid logger panic if parent goroutine terminates.
+			phr.cleanup()
+			cancel()
+			if err := group.Wait(); err != nil {
+				t.Fatalf("Error in probe helper fake sources: %v", err)
+			}
+		})
+	}
+}
+
+func TestProbeHelperInvalidRole(t *testing.T) {
+	probeMetrics, err := utils.NewProbeMetrics(nil)
+	if err != nil {
+		t.Fatal("Failed to create probe metrics:", err)
+	}
+	if _, err := NewHelper(EnvConfig{Role: "sender"}, &blockingProbeHandler{}, nil, nil, &utils.LivenessChecker{}, utils.NewReadinessChecker(), probeMetrics, utils.NewInFlightProbes(), http.NewServeMux(), nil, nil, utils.NewInMemoryCorrelationStore(clock.RealClock{}), clock.RealClock{}, &utils.BackendChecker{}, &utils.ProbeRequests{}); err == nil {
+		t.Error("NewHelper got no error for an unsupported role, want error")
+	}
+}
+
+// unmatchedProbeHandler is a probe handler which receives events matching no
+// probe in flight.
+type unmatchedProbeHandler struct {
+	receivedEvents utils.ReceivedEvents
+}
+
+func (h *unmatchedProbeHandler) Forward(ctx context.Context, event cloudevents.Event) error {
+	return nil
+}
+
+func (h *unmatchedProbeHandler) Receive(ctx context.Context, event cloudevents.Event) error {
+	return h.receivedEvents.SignalReceiverChannel(event.ID())
+}
+
+func TestProbeHelperUnmatchedEventPolicy(t *testing.T) {
+	probeMetrics, err := utils.NewProbeMetrics(nil)
+	if err != nil {
+		t.Fatal("Failed to create probe metrics:", err)
+	}
+	cases := []struct {
+		name        string
+		policy      string
+		wantStatus  int
+		wantWarning bool
+	}{{
+		name:       "default policy",
+		wantStatus: http.StatusOK,
+	}, {
+		name:       "ack policy",
+		policy:     UnmatchedEventACK,
+		wantStatus: http.StatusOK,
+	}, {
+		name:       "nack policy",
+		policy:     UnmatchedEventNACK,
+		wantStatus: http.StatusServiceUnavailable,
+	}, {
+		name:        "log policy",
+		policy:      UnmatchedEventLog,
+		wantStatus:  http.StatusOK,
+		wantWarning: true,
+	}}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Capture the warnings of the probe helper.
+			logs := &syncBuffer{}
+			logger := zap.New(zapcore.NewCore(zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()), zapcore.AddSync(logs), zapcore.WarnLevel))
+			ctx := logging.WithLogger(context.Background(), logger.Sugar())
+
+			handler := &unmatchedProbeHandler{receivedEvents: utils.NewSyncReceivedEvents(utils.NewInMemoryCorrelationStore(clock.RealClock{}), "test")}
+			ph, err := NewHelper(EnvConfig{UnmatchedEventPolicy: tc.policy}, handler, nil, nil, &utils.LivenessChecker{}, utils.NewReadinessChecker(), probeMetrics, utils.NewInFlightProbes(), http.NewServeMux(), nil, nil, utils.NewInMemoryCorrelationStore(clock.RealClock{}), clock.RealClock{}, &utils.BackendChecker{}, &utils.ProbeRequests{})
+			if err != nil {
+				t.Fatal("Failed to create probe helper:", err)
+			}
+			event := probeEvent("broker-e2e-delivery-probe")
+			event.SetExtension(utils.ProbeEventReceiverPathExtension, "/"+testTargetReceiverPath)
+			result := ph.receiveEvent(ctx)(*event)
+			if tc.wantStatus == http.StatusOK {
+				if !cloudevents.IsACK(result) {
+					t.Fatalf("wanted ACK, got %+v", result)
+				}
+			} else {
+				var httpResult *cehttp.Result
+				if !cloudevents.ResultAs(result, &httpResult) || httpResult.StatusCode != tc.wantStatus {
+					t.Fatalf("wanted result with status %d, got %+v", tc.wantStatus, result)
+				}
+			}
+			if gotWarning := strings.Contains(logs.String(), "Probe receiver received unmatched event"); gotWarning != tc.wantWarning {
+				t.Errorf("unmatched event warning logged got=%t, want=%t", gotWarning, tc.wantWarning)
+			}
+		})
+	}
+
+	if _, err := NewHelper(EnvConfig{UnmatchedEventPolicy: "drop"}, &blockingProbeHandler{}, nil, nil, &utils.LivenessChecker{}, utils.NewReadinessChecker(), probeMetrics, utils.NewInFlightProbes(), http.NewServeMux(), nil, nil, utils.NewInMemoryCorrelationStore(clock.RealClock{}), clock.RealClock{}, &utils.BackendChecker{}, &utils.ProbeRequests{}); err == nil {
+		t.Error("NewHelper got no error for an unsupported unmatched event policy, want error")
+	}
+}
+
+func TestProbeHelperBatch(t *testing.T) {
+	ctx := logtest.TestContextWithLogger(t)
+	group, ctx := errgroup.WithContext(ctx)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	phr := makeProbeHelper(ctx, t, group)
+	go phr.probeHelper.Run(ctx)
+
+	// The probe events are submitted in a single request, one of them missing
+	// its namespace extension.
+	batch, err := json.Marshal([]*cloudevents.Event{
+		probeEvent("broker-e2e-delivery-probe", withProbeExtension("namespace", testNamespace), withProbeID("broker-e2e-delivery-probe-batch-1")),
+		probeEvent("broker-e2e-delivery-probe", withProbeID("broker-e2e-delivery-probe-batch-2")),
+		probeEvent("broker-e2e-delivery-probe", withProbeExtension("namespace", testNamespace), withProbeExtension("broker", testOtherBroker), withProbeID("broker-e2e-delivery-probe-batch-3")),
+	})
+	if err != nil {
+		t.Fatal("Failed to marshal batch of probe events:", err)
+	}
+	resp, err := http.Post(phr.probeURL, cloudevents.ApplicationCloudEventsBatchJSON, bytes.NewReader(batch))
+	if err != nil {
+		t.Fatal("Failed to send batch of probe events:", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("batch status code got=%d, want=%d", resp.StatusCode, http.StatusOK)
+	}
+	var got []BatchResult
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatal("Failed to decode batch results:", err)
+	}
+	want := []BatchResult{{
+		ID:         "broker-e2e-delivery-probe-batch-1",
+		Result:     utils.ProbeResultACK,
+		StatusCode: http.StatusOK,
+	}, {
+		ID:         "broker-e2e-delivery-probe-batch-2",
+		Result:     utils.ProbeResultNACK,
+		StatusCode: http.StatusInternalServerError,
+		Failure:    &FailureResult{Reason: MissingExtensionReason},
+	}, {
+		ID:         "broker-e2e-delivery-probe-batch-3",
+		Result:     utils.ProbeResultACK,
+		StatusCode: http.StatusOK,
+	}}
+	if diff := cmp.Diff(want, got, cmpopts.IgnoreFields(FailureResult{}, "Message")); diff != "" {
+		t.Errorf("batch results (-want,+got): %s", diff)
+	}
+
+	// Cancel gracefully to avoid logger panic if parent goroutine terminates.
+	phr.cleanup()
+	cancel()
+	if err := group.Wait(); err != nil {
+		t.Fatalf("Error in probe helper fake sources: %v", err)
+	}
+}
+
+func TestProbeHelperBatchTooLarge(t *testing.T) {
+	ctx := logtest.TestContextWithLogger(t)
+	group, ctx := errgroup.WithContext(ctx)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	phr := makeProbeHelper(ctx, t, group, func(env *EnvConfig) {
+		env.MaxBatchSize = 2
+	})
+	go phr.probeHelper.Run(ctx)
+
+	// The batch is rejected as a whole.
+	batch, err := json.Marshal([]*cloudevents.Event{
+		probeEvent("broker-e2e-delivery-probe", withProbeExtension("namespace", testNamespace), withProbeID("broker-e2e-delivery-probe-batch-1")),
+		probeEvent("broker-e2e-delivery-probe", withProbeExtension("namespace", testNamespace), withProbeID("broker-e2e-delivery-probe-batch-2")),
+		probeEvent("broker-e2e-delivery-probe", withProbeExtension("namespace", testNamespace), withProbeID("broker-e2e-delivery-probe-batch-3")),
+	})
+	if err != nil {
+		t.Fatal("Failed to marshal batch of probe events:", err)
+	}
+	resp, err := http.Post(phr.probeURL, cloudevents.ApplicationCloudEventsBatchJSON, bytes.NewReader(batch))
+	if err != nil {
+		t.Fatal("Failed to send batch of probe events:", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("batch status code got=%d, want=%d", resp.StatusCode, http.StatusRequestEntityTooLarge)
+	}
+
+	// Cancel gracefully to avoid logger panic if parent goroutine terminates.
+	phr.cleanup()
+	cancel()
+	if err := group.Wait(); err != nil {
+		t.Fatalf("Error in probe helper fake sources: %v", err)
+	}
+}
+
+func TestProbeHelperMetrics(t *testing.T) {
+	ctx := logtest.TestContextWithLogger(t)
+	ctx = WithProjectKey(ctx, testProjectID)
+	ctx = WithTopicKey(ctx, testTopicID)
+	ctx = WithSubscriptionKey(ctx, testSubscriptionID)
+	group, ctx := errgroup.WithContext(ctx)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Only the default broker and the topic of the test CloudPubSubSource
+	// label the probe results with their target.
+	phr := makeProbeHelper(ctx, t, group, func(env *EnvConfig) {
+		env.MetricsBrokerAllowlist = []string{testNamespace + "/default"}
+		env.MetricsTopicAllowlist = []string{testTopicID}
+	})
+	go phr.probeHelper.Run(ctx)
+
+	// Create a testing client from which to send probe events to the probe helper.
+	p, err := cloudevents.NewHTTP(cloudevents.WithTarget(phr.probeURL))
+	if err != nil {
+		t.Fatal("Failed to create HTTP protocol of the testing client:" + err.Error())
+	}
+	c, err := cloudevents.NewClient(p)
+	if err != nil {
+		t.Fatal("Failed to create testing client:" + err.Error())
+	}
+
+	steps := []eventAndResult{
+		{
+			event:      probeEvent("broker-e2e-delivery-probe", withProbeExtension("namespace", testNamespace)),
+			wantResult: cloudevents.ResultACK,
+		},
+		{
+			event:      probeEvent("broker-e2e-delivery-probe", withProbeExtension("namespace", testNamespace), withProbeExtension("broker", testLenientBroker), withProbeID("broker-e2e-delivery-probe-lenient")),
+			wantResult: cloudevents.ResultACK,
+		},
+		{
+			event:      probeEvent("cloudpubsubsource-probe", withProbeExtension("topic", testTopicID)),
+			wantResult: cloudevents.ResultACK,
+		},
+		{
+			event:      probeEvent("unrecognized-probe-type"),
+			wantResult: cloudevents.ResultNACK,
+		},
+	}
+	for _, step := range steps {
+		if result := c.Send(ctx, *step.event); !errors.Is(result, step.wantResult) {
+			t.Fatalf("wanted result %+v, got %+v", step.wantResult, result)
+		}
+	}
+
+	resp, err := http.Get(phr.metricsURL)
+	if err != nil {
+		t.Fatal("Failed to scrape probe metrics:", err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal("Failed to read probe metrics:", err)
+	}
+	for _, want := range []string{
+		`probe_latency_seconds_count{type="broker-e2e-delivery-probe"} 2`,
+		fmt.Sprintf(`probe_result_total{broker="default",namespace=%q,result="ACK",topic="",type="broker-e2e-delivery-probe"} 1`, testNamespace),
+		// The unlisted broker falls into the other bucket.
+		fmt.Sprintf(`probe_result_total{broker="other",namespace=%q,result="ACK",topic="",type="broker-e2e-delivery-probe"} 1`, testNamespace),
+		fmt.Sprintf(`probe_result_total{broker="",namespace="",result="ACK",topic=%q,type="cloudpubsubsource-probe"} 1`, testTopicID),
+		// The unrecognized probe type falls into the other bucket.
+		`probe_result_total{broker="",namespace="",result="NACK",topic="",type="other"} 1`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("probe metrics missing %q, got:\n%s", want, body)
+		}
+	}
+
+	// Cancel gracefully to avoid logger panic if parent goroutine terminates.
+	phr.cleanup()
+	cancel()
+	if err := group.Wait(); err != nil {
+		t.Fatalf("Error in probe helper fake sources: %v", err)
+	}
+}
+
+func TestProbeHelperSourceEmitLatency(t *testing.T) {
+	ctx := logtest.TestContextWithLogger(t)
+	group, ctx := errgroup.WithContext(ctx)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	phr := makeProbeHelper(ctx, t, group)
+	go phr.probeHelper.Run(ctx)
+
+	p, err := cloudevents.NewHTTP(cloudevents.WithTarget(phr.probeURL))
+	if err != nil {
+		t.Fatal("Failed to create HTTP protocol of the testing client:" + err.Error())
+	}
+	c, err := cloudevents.NewClient(p)
+	if err != nil {
+		t.Fatal("Failed to create testing client:" + err.Error())
+	}
+
+	// Wait on the first tick of the PingSource.
+	time.Sleep(2 * testPingSourcePeriods[testPingSource])
+
+	if result := c.Send(ctx, *probeEvent("pingsource-probe", withProbeExtension("pingsource", testPingSource), withProbeExtension("period", "300ms"))); !cloudevents.IsACK(result) {
+		t.Fatalf("PingSource probe got result %+v, want ACK", result)
+	}
+
+	resp, err := http.Get(phr.metricsURL)
+	if err != nil {
+		t.Fatal("Failed to scrape probe metrics:", err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal("Failed to read probe metrics:", err)
+	}
+	// The test PingSource stamps its events with the time of their tick, which
+	// the end-to-end probe latency does not distinguish.
+	// Its latency is bucketed at a finer grain than the probe latency.
+	for _, want := range []string{
+		`source_emit_latency_seconds_count{source="pingsource"}`,
+		`source_emit_latency_seconds_bucket{source="pingsource",le="0.001"}`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("probe metrics missing %q, got:\n%s", want, body)
+		}
+	}
+
+	// Cancel gracefully to avoid logger panic if parent goroutine terminates.
+	phr.cleanup()
+	cancel()
+	if err := group.Wait(); err != nil {
+		t.Fatalf("Error in probe helper fake sources: %v", err)
+	}
+}
+
+func TestProbeHelperContentMode(t *testing.T) {
+	cases := []struct {
+		name                string
+		forwardContentMode  string
+		receiverContentMode string
+	}{{
+		name:                "binary",
+		forwardContentMode:  BinaryContentMode,
+		receiverContentMode: BinaryContentMode,
+	}, {
+		name:                "structured",
+		forwardContentMode:  StructuredContentMode,
+		receiverContentMode: StructuredContentMode,
+	}, {
+		name:                "binary forward and structured receiver",
+		forwardContentMode:  BinaryContentMode,
+		receiverContentMode: StructuredContentMode,
+	}}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := logtest.TestContextWithLogger(t)
+			group, ctx := errgroup.WithContext(ctx)
+			ctx, cancel := context.WithCancel(ctx)
+			defer cancel()
+
+			phr := makeProbeHelper(ctx, t, group, func(env *EnvConfig) {
+				env.ForwardContentMode = tc.forwardContentMode
+				env.ReceiverContentMode = tc.receiverContentMode
+			})
+			go phr.probeHelper.Run(ctx)
+
+			// Create a testing client from which to send probe events to the probe helper.
+			p, err := cloudevents.NewHTTP(cloudevents.WithTarget(phr.probeURL))
+			if err != nil {
+				t.Fatal("Failed to create HTTP protocol of the testing client:" + err.Error())
+			}
+			c, err := cloudevents.NewClient(p)
+			if err != nil {
+				t.Fatal("Failed to create testing client:" + err.Error())
+			}
+
+			// The probe only succeeds if the event delivered by the test Broker is
+			// correlated by ID with the forwarded event.
+			event := probeEvent("broker-e2e-delivery-probe", withProbeExtension("namespace", testNamespace))
+			if result := c.Send(ctx, *event); !errors.Is(result, cloudevents.ResultACK) {
+				t.Fatalf("wanted result %+v, got %+v", cloudevents.ResultACK, result)
+			}
+
+			// Cancel gracefully to avoid logger panic if parent goroutine terminates.
+			phr.cleanup()
+			cancel()
+			if err := group.Wait(); err != nil {
+				t.Fatalf("Error in probe helper fake sources: %v", err)
+			}
+		})
+	}
+}
+
+func TestProbeHelperGRPC(t *testing.T) {
+	ctx := logtest.TestContextWithLogger(t)
+	group, ctx := errgroup.WithContext(ctx)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	httpPHR := makeProbeHelper(ctx, t, group)
+	go httpPHR.probeHelper.Run(ctx)
+	grpcPHR := makeProbeHelper(ctx, t, group, func(env *EnvConfig) {
+		env.ProbeProtocol = GRPCProbeProtocol
+	})
+	go grpcPHR.probeHelper.Run(ctx)
+
+	// Create the testing clients from which to send probe events to the probe helpers.
+	p, err := cloudevents.NewHTTP(cloudevents.WithTarget(httpPHR.probeURL))
+	if err != nil {
+		t.Fatal("Failed to create HTTP protocol of the testing client:" + err.Error())
+	}
+	c, err := cloudevents.NewClient(p)
+	if err != nil {
+		t.Fatal("Failed to create testing client:" + err.Error())
+	}
+	conn, err := grpc.DialContext(ctx, strings.TrimPrefix(grpcPHR.probeURL, "http://"), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal("Failed to dial the gRPC probe helper:", err)
+	}
+	defer conn.Close()
+	grpcClient := cegrpc.NewCloudEventServiceClient(conn)
+
+	cases := []struct {
+		name    string
+		event   *cloudevents.Event
+		wantACK bool
+	}{{
+		name:    "Broker E2E delivery probe",
+		event:   probeEvent("broker-e2e-delivery-probe", withProbeExtension("namespace", testNamespace)),
+		wantACK: true,
+	}, {
+		name:    "Broker E2E delivery probe missing namespace",
+		event:   probeEvent("broker-e2e-delivery-probe"),
+		wantACK: false,
+	}, {
+		name:    "missing target path",
+		event:   probeEvent("broker-e2e-delivery-probe", withProbeExtension("namespace", testNamespace), withoutProbeExtension("targetpath")),
+		wantACK: false,
+	}, {
+		name:    "unknown probe type",
+		event:   probeEvent("unknown-probe"),
+		wantACK: false,
+	}}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			httpACK := cloudevents.IsACK(c.Send(ctx, *tc.event))
+			pb, err := cegrpc.FromEvent(*tc.event)
+			if err != nil {
+				t.Fatal("Failed to convert the probe event to the protobuf format:", err)
+			}
+			_, err = grpcClient.Publish(ctx, &cegrpc.PublishRequest{Event: pb}, grpc.WaitForReady(true))
+			grpcACK := err == nil
+			if httpACK != tc.wantACK || grpcACK != tc.wantACK {
+				t.Errorf("ACK got HTTP=%v gRPC=%v (gRPC error %v), want %v", httpACK, grpcACK, err, tc.wantACK)
+			}
+		})
+	}
+
+	// An event which cannot be converted is rejected as an invalid argument.
+	_, err = grpcClient.Publish(ctx, &cegrpc.PublishRequest{})
+	if got := grpcstatus.Code(err); got != codes.InvalidArgument {
+		t.Errorf("publish without event got code %v, want %v", got, codes.InvalidArgument)
+	}
+
+	// The attributes of the protobuf format carry the extensions named after
+	// the reserved CloudEvent attributes, which are rejected.
+	pb, err := cegrpc.FromEvent(*probeEvent("broker-e2e-delivery-probe", withProbeExtension("namespace", testNamespace), withProbeExtension("id", "other-id")))
+	if err != nil {
+		t.Fatal("Failed to convert the probe event to the protobuf format:", err)
+	}
+	_, err = grpcClient.Publish(ctx, &cegrpc.PublishRequest{Event: pb}, grpc.WaitForReady(true))
+	if err == nil || !strings.Contains(err.Error(), string(ReservedExtensionReason)) {
+		t.Errorf("publish with reserved extension name got error %v, want %s", err, ReservedExtensionReason)
+	}
+
+	// Cancel gracefully to avoid logger panic if parent goroutine terminates.
+	httpPHR.cleanup()
+	grpcPHR.cleanup()
+	cancel()
+	if err := group.Wait(); err != nil {
+		t.Fatalf("Error in probe helper fake sources: %v", err)
+	}
+}
+
+func TestProbeHelperReceiverPathPrefix(t *testing.T) {
+	ctx := logtest.TestContextWithLogger(t)
+	group, ctx := errgroup.WithContext(ctx)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	phr := makeProbeHelper(ctx, t, group, func(env *EnvConfig) {
+		env.ReceiverPathPrefix = "/" + testTargetReceiverPath
+	})
+	go phr.probeHelper.Run(ctx)
+
+	// Create a testing client from which to send probe events to the probe helper.
+	p, err := cloudevents.NewHTTP(cloudevents.WithTarget(phr.probeURL))
+	if err != nil {
+		t.Fatal("Failed to create HTTP protocol of the testing client:" + err.Error())
+	}
+	c, err := cloudevents.NewClient(p)
+	if err != nil {
+		t.Fatal("Failed to create testing client:" + err.Error())
+	}
+
+	cases := []struct {
+		name       string
+		event      *cloudevents.Event
+		wantResult protocol.Result
+	}{{
+		name:       "valid target path",
+		event:      probeEvent("broker-e2e-delivery-probe", withProbeExtension("namespace", testNamespace)),
+		wantResult: cloudevents.ResultACK,
+	}, {
+		name:       "missing target path",
+		event:      probeEvent("broker-e2e-delivery-probe", withProbeExtension("namespace", testNamespace), withoutProbeExtension("targetpath")),
+		wantResult: cloudevents.ResultNACK,
+	}, {
+		name:       "target path outside of the prefix",
+		event:      probeEvent("broker-e2e-delivery-probe", withProbeExtension("namespace", testNamespace), withProbeExtension("targetpath", "/other-namespace")),
+		wantResult: cloudevents.ResultNACK,
+	}, {
+		name:       "target path escaping the prefix",
+		event:      probeEvent("broker-e2e-delivery-probe", withProbeExtension("namespace", testNamespace), withProbeExtension("targetpath", "/"+testTargetReceiverPath+"/../other-namespace")),
+		wantResult: cloudevents.ResultNACK,
+	}, {
+		name:       "target path with dot segments",
+		event:      probeEvent("broker-e2e-delivery-probe", withProbeExtension("namespace", testNamespace), withProbeExtension("targetpath", "/"+testTargetReceiverPath+"/./..")),
+		wantResult: cloudevents.ResultNACK,
+	}}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if result := c.Send(ctx, *tc.event); !errors.Is(result, tc.wantResult) {
+				t.Fatalf("wanted result %+v, got %+v", tc.wantResult, result)
+			}
+		})
+	}
+
+	// Cancel gracefully to avoid logger panic if parent goroutine terminates.
+	phr.cleanup()
+	cancel()
+	if err := group.Wait(); err != nil {
+		t.Fatalf("Error in probe helper fake sources: %v", err)
+	}
+}
+
+// blockingProbeHandler is a probe handler whose forward probes block until
+// they are released or time out, unless they are stuck and ignore timeouts.
+type blockingProbeHandler struct {
+	started chan struct{}
+	release chan struct{}
+	stuck   bool
+}
+
+func (h *blockingProbeHandler) Forward(ctx context.Context, event cloudevents.Event) error {
+	h.started <- struct{}{}
+	done := ctx.Done()
+	if h.stuck {
+		done = nil
+	}
+	select {
+	case <-h.release:
+		return nil
+	case <-done:
+		return ctx.Err()
+	}
+}
+
+func (h *blockingProbeHandler) Receive(ctx context.Context, event cloudevents.Event) error {
+	return nil
+}
+
+// receivingProbeHandler is a blocking probe handler which records the events
+// it receives.
+type receivingProbeHandler struct {
+	blockingProbeHandler
+	received chan cloudevents.Event
+}
+
+func (h *receivingProbeHandler) Receive(ctx context.Context, event cloudevents.Event) error {
+	h.received <- event
+	return nil
+}
+
+func TestProbeHelperStaleEvent(t *testing.T) {
+	ctx := logtest.TestContextWithLogger(t)
+	env := EnvConfig{
+		DefaultTimeoutDuration: time.Minute,
+		MaxTimeoutDuration:     time.Minute,
+		UnmatchedEventPolicy:   UnmatchedEventNACK,
+		MaxEventTimeSkew:       time.Minute,
+	}
+	handler := &receivingProbeHandler{
+		blockingProbeHandler: blockingProbeHandler{
+			started: make(chan struct{}, 1),
+			release: make(chan struct{}),
+		},
+		received: make(chan cloudevents.Event, 2),
+	}
+	probeMetrics, err := utils.NewProbeMetrics(nil)
+	if err != nil {
+		t.Fatal("Failed to create probe metrics:", err)
+	}
+	ph, err := NewHelper(env, handler, nil, nil, &utils.LivenessChecker{}, utils.NewReadinessChecker(), probeMetrics, utils.NewInFlightProbes(), http.NewServeMux(), nil, nil, utils.NewInMemoryCorrelationStore(clock.RealClock{}), clock.RealClock{}, &utils.BackendChecker{}, &utils.ProbeRequests{})
+	if err != nil {
+		t.Fatal("Failed to create probe helper:", err)
+	}
+
+	// Start a probe which waits on its event.
+	result := make(chan cloudevents.Result, 1)
+	go func() {
+		result <- ph.forwardFromProbe(ctx)(*probeEvent("broker-e2e-delivery-probe"))
+	}()
+	<-handler.started
+	receive := ph.receiveEvent(ctx)
+
+	// An event with the ID of the probe sent by a prior run is rejected as
+	// unmatched, without reaching the probe handler.
+	replayed := probeEvent("broker-e2e-delivery-probe", withProbeExtension("receiverpath", "/"+testTargetReceiverPath))
+	replayed.SetTime(time.Now().Add(-time.Hour))
+	var httpResult *cehttp.Result
+	if res := receive(*replayed); !cloudevents.ResultAs(res, &httpResult) || httpResult.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("wanted status code %d for replayed event, got %+v", http.StatusServiceUnavailable, res)
+	}
+	if len(handler.received) != 0 {
+		t.Error("replayed event was received by the probe handler")
+	}
+
+	// The event of the probe itself is received.
+	current := probeEvent("broker-e2e-delivery-probe", withProbeExtension("receiverpath", "/"+testTargetReceiverPath))
+	if res := receive(*current); !cloudevents.IsACK(res) {
+		t.Errorf("wanted ACK for current event, got %+v", res)
+	}
+	if len(handler.received) != 1 {
+		t.Error("current event was not received by the probe handler")
+	}
+
+	close(handler.release)
+	if res := <-result; !cloudevents.IsACK(res) {
+		t.Errorf("wanted ACK, got %+v", res)
+	}
+}
+
+func TestProbeHelperInvalidMaxEventTimeSkew(t *testing.T) {
+	probeMetrics, err := utils.NewProbeMetrics(nil)
+	if err != nil {
+		t.Fatal("Failed to create probe metrics:", err)
+	}
+	if _, err := NewHelper(EnvConfig{MaxEventTimeSkew: -time.Second}, &blockingProbeHandler{}, nil, nil, &utils.LivenessChecker{}, utils.NewReadinessChecker(), probeMetrics, utils.NewInFlightProbes(), http.NewServeMux(), nil, nil, utils.NewInMemoryCorrelationStore(clock.RealClock{}), clock.RealClock{}, &utils.BackendChecker{}, &utils.ProbeRequests{}); err == nil {
+		t.Error("wanted an error for a negative max event time skew")
+	}
+}
+
+func TestProbeHelperSourceReceiverPaths(t *testing.T) {
+	ctx := logtest.TestContextWithLogger(t)
+	env := EnvConfig{
+		DefaultTimeoutDuration: time.Minute,
+		MaxTimeoutDuration:     time.Minute,
+		UnmatchedEventPolicy:   UnmatchedEventNACK,
+		SourceReceiverPaths:    true,
+	}
+	handler := &receivingProbeHandler{
+		received: make(chan cloudevents.Event, 2),
+	}
+	probeMetrics, err := utils.NewProbeMetrics(nil)
+	if err != nil {
+		t.Fatal("Failed to create probe metrics:", err)
+	}
+	ph, err := NewHelper(env, handler, nil, nil, &utils.LivenessChecker{}, utils.NewReadinessChecker(), probeMetrics, utils.NewInFlightProbes(), http.NewServeMux(), nil, nil, utils.NewInMemoryCorrelationStore(clock.RealClock{}), clock.RealClock{}, &utils.BackendChecker{}, &utils.ProbeRequests{})
+	if err != nil {
+		t.Fatal("Failed to create probe helper:", err)
+	}
+	receive := ph.receiveEvent(ctx)
+
+	// A CloudStorageSource event delivered along the CloudPubSubSource path is
+	// rejected as unmatched, without reaching the probe handler.
+	misrouted := probeEvent(schemasv1.CloudStorageObjectFinalizedEventType, withProbeExtension("receiverpath", "/"+testTargetReceiverPath+"/cloudpubsubsource"))
+	var httpResult *cehttp.Result
+	if res := receive(*misrouted); !cloudevents.ResultAs(res, &httpResult) || httpResult.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("wanted status code %d for event delivered along the path of another source, got %+v", http.StatusServiceUnavailable, res)
+	}
+	// So is one delivered along no source path at all.
+	unrouted := probeEvent(schemasv1.CloudStorageObjectFinalizedEventType, withProbeExtension("receiverpath", "/"+testTargetReceiverPath))
+	if res := receive(*unrouted); !cloudevents.ResultAs(res, &httpResult) || httpResult.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("wanted status code %d for event delivered along no source path, got %+v", http.StatusServiceUnavailable, res)
+	}
+	if len(handler.received) != 0 {
+		t.Fatal("misrouted events were received by the probe handler")
+	}
+
+	// The event delivered along the CloudStorageSource path is received with
+	// the target path of its probe as its receiver path.
+	routed := probeEvent(schemasv1.CloudStorageObjectFinalizedEventType, withProbeExtension("receiverpath", "/"+testTargetReceiverPath+"/cloudstoragesource"))
+	if res := receive(*routed); !cloudevents.IsACK(res) {
+		t.Errorf("wanted ACK for event delivered along its source path, got %+v", res)
+	}
+	if len(handler.received) != 1 {
+		t.Fatal("event delivered along its source path was not received by the probe handler")
+	}
+	if got, want := (<-handler.received).Extensions()["receiverpath"], "/"+testTargetReceiverPath; got != want {
+		t.Errorf("wanted receiver path %q, got %q", want, got)
+	}
+
+	// The events which are not delivered by a source are left as they are.
+	brokerEvent := probeEvent("broker-e2e-delivery-probe", withProbeExtension("receiverpath", "/"+testTargetReceiverPath))
+	if res := receive(*brokerEvent); !cloudevents.IsACK(res) {
+		t.Errorf("wanted ACK for broker event, got %+v", res)
+	}
+	if len(handler.received) != 1 {
+		t.Error("broker event was not received by the probe handler")
+	}
+}
+
+func TestProbeHelperMaxConcurrentProbes(t *testing.T) {
+	ctx := logtest.TestContextWithLogger(t)
+	const maxConcurrentProbes = 2
+	env := EnvConfig{
+		DefaultTimeoutDuration: time.Minute,
+		MaxTimeoutDuration:     time.Minute,
+		MaxConcurrentProbes:    maxConcurrentProbes,
+	}
+	handler := &blockingProbeHandler{
+		started: make(chan struct{}, 2*maxConcurrentProbes),
+		release: make(chan struct{}),
+	}
+	probeMetrics, err := utils.NewProbeMetrics(nil)
+	if err != nil {
+		t.Fatal("Failed to create probe metrics:", err)
+	}
+	ph, err := NewHelper(env, handler, nil, nil, &utils.LivenessChecker{}, utils.NewReadinessChecker(), probeMetrics, utils.NewInFlightProbes(), http.NewServeMux(), nil, nil, utils.NewInMemoryCorrelationStore(clock.RealClock{}), clock.RealClock{}, &utils.BackendChecker{}, &utils.ProbeRequests{})
+	if err != nil {
+		t.Fatal("Failed to create probe helper:", err)
+	}
+	forward := ph.forwardFromProbe(ctx)
+
+	// Fill up the in-flight probes.
+	results := make(chan cloudevents.Result, maxConcurrentProbes)
+	for i := 0; i < maxConcurrentProbes; i++ {
+		event := probeEvent("broker-e2e-delivery-probe")
+		event.SetID(fmt.Sprintf("broker-e2e-delivery-probe-%d", i))
+		go func() {
+			results <- forward(*event)
+		}()
+	}
+	for i := 0; i < maxConcurrentProbes; i++ {
+		<-handler.started
+	}
+
+	// Probes beyond the limit are rejected without being forwarded.
+	for i := 0; i < maxConcurrentProbes; i++ {
+		if result := forward(*probeEvent("broker-e2e-delivery-probe")); !cloudevents.IsNACK(result) {
+			t.Errorf("wanted NACK for probe beyond the limit, got %+v", result)
+		}
+	}
+
+	// The in-flight probes succeed once released, freeing up the semaphore.
+	close(handler.release)
+	for i := 0; i < maxConcurrentProbes; i++ {
+		if result := <-results; !cloudevents.IsACK(result) {
+			t.Errorf("wanted ACK for in-flight probe, got %+v", result)
+		}
+	}
+	handler.release = make(chan struct{})
+
+	// A timed out probe also frees up the semaphore.
+	if result := forward(*probeEvent("broker-e2e-delivery-probe", withProbeTimeout(time.Millisecond))); !cloudevents.IsNACK(result) {
+		t.Errorf("wanted NACK for timed out probe, got %+v", result)
+	}
+	<-handler.started
+	if !ph.probeSemaphore.TryAcquire(maxConcurrentProbes) {
+		t.Error("semaphore was not released by completed probes")
+	}
+}
+
+func TestProbeHelperTooManyInFlightProbesRetryAfter(t *testing.T) {
+	ctx := logtest.TestContextWithLogger(t)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	env := EnvConfig{
+		DefaultTimeoutDuration: time.Minute,
+		MaxTimeoutDuration:     time.Minute,
+		MaxConcurrentProbes:    1,
+	}
+	handler := &blockingProbeHandler{
+		started: make(chan struct{}, 1),
+		release: make(chan struct{}),
+	}
+	defer close(handler.release)
+	receiverListener, err := GetFreePortListener()
+	if err != nil {
+		t.Fatalf("Failed to get free receiver port listener: %v", err)
+	}
+	probeListener, err := GetFreePortListener()
+	if err != nil {
+		t.Fatalf("Failed to get free probe port listener: %v", err)
+	}
+	probeURL := fmt.Sprintf("http://localhost:%d", probeListener.Addr().(*net.TCPAddr).Port)
+	probeMetrics, err := utils.NewProbeMetrics(nil)
+	if err != nil {
+		t.Fatal("Failed to create probe metrics:", err)
+	}
+	// The senders are asked to back off for the average probe latency,
+	// rounded up to whole seconds.
+	probeMetrics.ReportProbeLatency("broker-e2e-delivery-probe", 2500*time.Millisecond)
+	livenessChecker := &utils.LivenessChecker{}
+	readinessChecker := utils.NewReadinessChecker()
+	inFlightProbes := utils.NewInFlightProbes()
+	mux := NewReceiverMux(ctx, livenessChecker, readinessChecker, inFlightProbes)
+	forwardClient, err := NewCeForwardClient(env, NewTestCeForwardClientOptions(probeListener), probeMetrics, &utils.ProbeRequests{})
+	if err != nil {
+		t.Fatal("Failed to create forward client:", err)
+	}
+	receiveClient, err := NewCeReceiverClient(ctx, env, mux, NewTestCeReceiverClientOptions(receiverListener, nil))
+	if err != nil {
+		t.Fatal("Failed to create receiver client:", err)
+	}
+	ph, err := NewHelper(env, handler, forwardClient, receiveClient, livenessChecker, readinessChecker, probeMetrics, inFlightProbes, mux, nil, nil, utils.NewInMemoryCorrelationStore(clock.RealClock{}), clock.RealClock{}, &utils.BackendChecker{}, &utils.ProbeRequests{})
+	if err != nil {
+		t.Fatal("Failed to create probe helper:", err)
+	}
+	go ph.Run(ctx)
+	time.Sleep(100 * time.Millisecond)
+
+	p, err := cloudevents.NewHTTP(cloudevents.WithTarget(probeURL))
+	if err != nil {
+		t.Fatal("Failed to create HTTP protocol of the testing client:" + err.Error())
+	}
+	c, err := cloudevents.NewClient(p)
+	if err != nil {
+		t.Fatal("Failed to create testing client:" + err.Error())
+	}
+
+	// Fill up the in-flight probes.
+	go c.Send(ctx, *probeEvent("broker-e2e-delivery-probe", withProbeID("in-flight-probe")))
+	<-handler.started
+
+	// A probe beyond the limit is NACKed with a 429 status.
+	result := c.Send(ctx, *probeEvent("broker-e2e-delivery-probe", withProbeID("rejected-probe")))
+	if !errors.Is(result, cloudevents.ResultNACK) {
+		t.Errorf("wanted result %+v, got %+v", cloudevents.ResultNACK, result)
+	}
+	var httpResult *cehttp.Result
+	if !cloudevents.ResultAs(result, &httpResult) || httpResult.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("wanted HTTP result with status %d, got %+v", http.StatusTooManyRequests, result)
+	}
+
+	// The response carries the Retry-After header.
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, probeURL, nil)
+	if err != nil {
+		t.Fatal("Failed to create probe request:", err)
+	}
+	event := probeEvent("broker-e2e-delivery-probe", withProbeID("rejected-probe"))
+	if err := cehttp.WriteRequest(ctx, binding.ToMessage(event), req); err != nil {
+		t.Fatal("Failed to write probe request:", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal("Failed to send probe request:", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("status code got=%d, want=%d", resp.StatusCode, http.StatusTooManyRequests)
+	}
+	if got := resp.Header.Get("Retry-After"); got != "3" {
+		t.Errorf("Retry-After header got=%q, want=%q", got, "3")
+	}
+}
+
+func TestProbeHelperIdempotencyKey(t *testing.T) {
+	ctx := logtest.TestContextWithLogger(t)
+	env := EnvConfig{
+		DefaultTimeoutDuration: time.Minute,
+		MaxTimeoutDuration:     time.Minute,
+	}
+	handler := &blockingProbeHandler{
+		started: make(chan struct{}, 3),
+		release: make(chan struct{}),
+	}
+	probeMetrics, err := utils.NewProbeMetrics(nil)
+	if err != nil {
+		t.Fatal("Failed to create probe metrics:", err)
+	}
+	inFlightProbes := utils.NewInFlightProbes()
+	ph, err := NewHelper(env, handler, nil, nil, &utils.LivenessChecker{}, utils.NewReadinessChecker(), probeMetrics, inFlightProbes, http.NewServeMux(), nil, nil, utils.NewInMemoryCorrelationStore(clock.RealClock{}), clock.RealClock{}, &utils.BackendChecker{}, &utils.ProbeRequests{})
+	if err != nil {
+		t.Fatal("Failed to create probe helper:", err)
+	}
+	forward := ph.forwardFromProbe(ctx)
+
+	// Submit the same probe twice concurrently.
+	event := probeEvent("broker-e2e-delivery-probe", withProbeExtension("idempotencykey", "test-key"))
+	results := make(chan cloudevents.Result, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			results <- forward(*event)
+		}()
+	}
+	<-handler.started
+	// Give the second submission time to attach to the in-flight probe.
+	time.Sleep(100 * time.Millisecond)
+	if got := inFlightProbes.Len(); got != 1 {
+		t.Errorf("in-flight probes got=%d, want=1", got)
+	}
+
+	// Both submissions get the result of the single forward.
+	close(handler.release)
+	for i := 0; i < 2; i++ {
+		if result := <-results; !cloudevents.IsACK(result) {
+			t.Errorf("wanted ACK for submission %d, got %+v", i, result)
+		}
+	}
+	if got := len(handler.started); got != 0 {
+		t.Errorf("forwards got=%d, want=1", got+1)
+	}
+
+	// The key is evicted once the probe completes, so that a later submission
+	// with the same key is forwarded again.
+	if result := forward(*event); !cloudevents.IsACK(result) {
+		t.Errorf("wanted ACK for later submission, got %+v", result)
+	}
+	if got := len(handler.started); got != 1 {
+		t.Errorf("forwards of later submission got=%d, want=1", got)
+	}
+}
+
+func TestProbeHelperRequestCancellation(t *testing.T) {
+	ctx := logtest.TestContextWithLogger(t)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	env := EnvConfig{
+		DefaultTimeoutDuration: time.Minute,
+		MaxTimeoutDuration:     time.Minute,
+	}
+	handler := &blockingProbeHandler{
+		started: make(chan struct{}, 1),
+		release: make(chan struct{}),
+	}
+	defer close(handler.release)
+	receiverListener, err := GetFreePortListener()
+	if err != nil {
+		t.Fatalf("Failed to get free receiver port listener: %v", err)
+	}
+	probeListener, err := GetFreePortListener()
+	if err != nil {
+		t.Fatalf("Failed to get free probe port listener: %v", err)
+	}
+	probeURL := fmt.Sprintf("http://localhost:%d", probeListener.Addr().(*net.TCPAddr).Port)
+	probeMetrics, err := utils.NewProbeMetrics(nil)
+	if err != nil {
+		t.Fatal("Failed to create probe metrics:", err)
+	}
+	livenessChecker := &utils.LivenessChecker{}
+	readinessChecker := utils.NewReadinessChecker()
+	inFlightProbes := utils.NewInFlightProbes()
+	probeRequests := utils.NewProbeRequests()
+	mux := NewReceiverMux(ctx, livenessChecker, readinessChecker, inFlightProbes)
+	forwardClient, err := NewCeForwardClient(env, NewTestCeForwardClientOptions(probeListener), probeMetrics, probeRequests)
+	if err != nil {
+		t.Fatal("Failed to create forward client:", err)
+	}
+	receiveClient, err := NewCeReceiverClient(ctx, env, mux, NewTestCeReceiverClientOptions(receiverListener, nil))
+	if err != nil {
+		t.Fatal("Failed to create receiver client:", err)
+	}
+	ph, err := NewHelper(env, handler, forwardClient, receiveClient, livenessChecker, readinessChecker, probeMetrics, inFlightProbes, mux, nil, nil, utils.NewInMemoryCorrelationStore(clock.RealClock{}), clock.RealClock{}, &utils.BackendChecker{}, probeRequests)
+	if err != nil {
+		t.Fatal("Failed to create probe helper:", err)
+	}
+	go ph.Run(ctx)
+	time.Sleep(100 * time.Millisecond)
+
+	// The client gives up on the probe while it is in flight.
+	reqCtx, cancelReq := context.WithCancel(ctx)
+	req, err := http.NewRequestWithContext(reqCtx, http.MethodPost, probeURL, nil)
+	if err != nil {
+		t.Fatal("Failed to create probe request:", err)
+	}
+	if err := cehttp.WriteRequest(ctx, binding.ToMessage(probeEvent("broker-e2e-delivery-probe")), req); err != nil {
+		t.Fatal("Failed to write probe request:", err)
+	}
+	sent := make(chan error, 1)
+	go func() {
+		resp, err := http.DefaultClient.Do(req)
+		if err == nil {
+			resp.Body.Close()
+		}
+		sent <- err
+	}()
+	<-handler.started
+	if got := inFlightProbes.Len(); got != 1 {
+		t.Errorf("in-flight probes got=%d, want=1", got)
+	}
+	cancelReq()
+	if err := <-sent; err == nil {
+		t.Error("cancelled probe request got no error, want error")
+	}
+
+	// The probe is aborted well before its timeout, and stops being tracked.
+	deadline := time.Now().Add(5 * time.Second)
+	for inFlightProbes.Len() > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := inFlightProbes.Len(); got != 0 {
+		t.Errorf("in-flight probes after cancellation got=%d, want=0", got)
+	}
+}
+
+func TestProbeHelperRequestCancellationSameID(t *testing.T) {
+	ctx := logtest.TestContextWithLogger(t)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	env := EnvConfig{
+		DefaultTimeoutDuration: time.Minute,
+		MaxTimeoutDuration:     time.Minute,
+	}
+	handler := &blockingProbeHandler{
+		started: make(chan struct{}, 8),
+		release: make(chan struct{}),
+	}
+	receiverListener, err := GetFreePortListener()
+	if err != nil {
+		t.Fatalf("Failed to get free receiver port listener: %v", err)
+	}
+	probeListener, err := GetFreePortListener()
+	if err != nil {
+		t.Fatalf("Failed to get free probe port listener: %v", err)
+	}
+	probeURL := fmt.Sprintf("http://localhost:%d", probeListener.Addr().(*net.TCPAddr).Port)
+	probeMetrics, err := utils.NewProbeMetrics(nil)
+	if err != nil {
+		t.Fatal("Failed to create probe metrics:", err)
+	}
+	livenessChecker := &utils.LivenessChecker{}
+	readinessChecker := utils.NewReadinessChecker()
+	inFlightProbes := utils.NewInFlightProbes()
+	probeRequests := utils.NewProbeRequests()
+	mux := NewReceiverMux(ctx, livenessChecker, readinessChecker, inFlightProbes)
+	forwardClient, err := NewCeForwardClient(env, NewTestCeForwardClientOptions(probeListener), probeMetrics, probeRequests)
+	if err != nil {
+		t.Fatal("Failed to create forward client:", err)
+	}
+	receiveClient, err := NewCeReceiverClient(ctx, env, mux, NewTestCeReceiverClientOptions(receiverListener, nil))
+	if err != nil {
+		t.Fatal("Failed to create receiver client:", err)
+	}
+	ph, err := NewHelper(env, handler, forwardClient, receiveClient, livenessChecker, readinessChecker, probeMetrics, inFlightProbes, mux, nil, nil, utils.NewInMemoryCorrelationStore(clock.RealClock{}), clock.RealClock{}, &utils.BackendChecker{}, probeRequests)
+	if err != nil {
+		t.Fatal("Failed to create probe helper:", err)
+	}
+	go ph.Run(ctx)
+	time.Sleep(100 * time.Millisecond)
+
+	// Several clients submit probes with the same ID at once, in both content
+	// modes, and half of them give up on their probes while they are in flight.
+	const clients = 8
+	cancels := make([]context.CancelFunc, clients)
+	sent := make([]chan *http.Response, clients)
+	for i := 0; i < clients; i++ {
+		reqCtx, cancelReq := context.WithCancel(ctx)
+		cancels[i] = cancelReq
+		req, err := http.NewRequestWithContext(reqCtx, http.MethodPost, probeURL, nil)
+		if err != nil {
+			t.Fatal("Failed to create probe request:", err)
+		}
+		writeCtx := ctx
+		if i%4 >= 2 {
+			writeCtx = binding.WithForceStructured(ctx)
+		}
+		event := probeEvent("broker-e2e-delivery-probe", withProbeID("broker-e2e-delivery-probe-same-id"))
+		if err := cehttp.WriteRequest(writeCtx, binding.ToMessage(event), req); err != nil {
+			t.Fatal("Failed to write probe request:", err)
+		}
+		sent[i] = make(chan *http.Response, 1)
+		go func(i int) {
+			resp, err := http.DefaultClient.Do(req)
+			if err == nil {
+				resp.Body.Close()
+			}
+			sent[i] <- resp
+		}(i)
+	}
+	for i := 0; i < clients; i++ {
+		<-handler.started
+	}
+	for i := 0; i < clients; i += 2 {
+		cancels[i]()
+		if resp := <-sent[i]; resp != nil {
+			t.Errorf("cancelled probe request %d got status code %d, want error", i, resp.StatusCode)
+		}
+	}
+
+	// Only the probes of the clients which gave up are aborted.
+	deadline := time.Now().Add(5 * time.Second)
+	for inFlightProbes.Len() > clients/2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if got := inFlightProbes.Len(); got != clients/2 {
+		t.Errorf("in-flight probes after cancellation got=%d, want=%d", got, clients/2)
+	}
+
+	// The other probes complete once they are released.
+	close(handler.release)
+	for i := 1; i < clients; i += 2 {
+		if resp := <-sent[i]; resp == nil || resp.StatusCode != http.StatusOK {
+			t.Errorf("probe request %d got response %+v, want status code %d", i, resp, http.StatusOK)
+		}
+		cancels[i]()
+	}
+}
+
+func TestProbeHelperRequestDeadline(t *testing.T) {
+	ctx := logtest.TestContextWithLogger(t)
+	env := EnvConfig{
+		DefaultTimeoutDuration: time.Minute,
+		MaxTimeoutDuration:     time.Minute,
+	}
+	handler := &blockingProbeHandler{
+		started: make(chan struct{}, 1),
+		release: make(chan struct{}),
+	}
+	defer close(handler.release)
+	probeMetrics, err := utils.NewProbeMetrics(nil)
+	if err != nil {
+		t.Fatal("Failed to create probe metrics:", err)
+	}
+	probeRequests := utils.NewProbeRequests()
+	ph, err := NewHelper(env, handler, nil, nil, &utils.LivenessChecker{}, utils.NewReadinessChecker(), probeMetrics, utils.NewInFlightProbes(), http.NewServeMux(), nil, nil, utils.NewInMemoryCorrelationStore(clock.RealClock{}), clock.RealClock{}, &utils.BackendChecker{}, probeRequests)
+	if err != nil {
+		t.Fatal("Failed to create probe helper:", err)
+	}
+
+	// The deadline of the request is shorter than the timeout of the probe.
+	event := probeEvent("broker-e2e-delivery-probe", withProbeExtension("timeout", "30s"))
+	reqCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	token, unregister := probeRequests.Register(reqCtx)
+	defer unregister()
+	event.SetExtension(utils.ProbeEventRequestExtension, token)
+
+	start := time.Now()
+	result := ph.forwardFromProbe(ctx)(*event)
+	var failure *FailureResult
+	if !errors.As(result, &failure) || failure.Reason != TimeoutReason {
+		t.Errorf("wanted failure with reason %s, got %+v", TimeoutReason, result)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("probe completed after %v, want it bounded by the request deadline", elapsed)
+	}
+}
+
+func TestProbeHelperRetryPolicies(t *testing.T) {
+	ctx := logtest.TestContextWithLogger(t)
+
+	// The flaky test broker and channel reject every event with a retriable status.
+	var attemptsMu sync.Mutex
+	attempts := map[string]int{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attemptsMu.Lock()
+		attempts[r.URL.Path]++
+		attemptsMu.Unlock()
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	forwardProtocol, err := cloudevents.NewHTTP()
+	if err != nil {
+		t.Fatal("Failed to create HTTP protocol of the forward client:", err)
+	}
+	forwardClient, err := cloudevents.NewClient(forwardProtocol)
+	if err != nil {
+		t.Fatal("Failed to create forward client:", err)
+	}
+	brokerProbe, err := handlers.NewBrokerE2EDeliveryProbe(srv.URL+"/broker", forwardClient, utils.NewInMemoryCorrelationStore(clock.RealClock{}))
+	if err != nil {
+		t.Fatal("Failed to create broker probe:", err)
+	}
+	channelProbe, err := handlers.NewChannelE2EDeliveryProbe(handlers.ChannelIngressTemplate(srv.URL+"/channel"), forwardClient, utils.NewInMemoryCorrelationStore(clock.RealClock{}))
+	if err != nil {
+		t.Fatal("Failed to create channel probe:", err)
+	}
+	cases := []struct {
+		name         string
+		handler      handlers.Interface
+		event        *cloudevents.Event
+		path         string
+		wantAttempts int
+	}{{
+		name:         "type-specific policy",
+		handler:      brokerProbe,
+		event:        probeEvent("broker-e2e-delivery-probe", withProbeExtension("namespace", testNamespace), withProbeExtension("broker", "default")),
+		path:         "/broker",
+		wantAttempts: 4,
+	}, {
+		name:         "default policy",
+		handler:      channelProbe,
+		event:        probeEvent("channel-e2e-delivery-probe", withProbeExtension("namespace", testNamespace), withProbeExtension("channel", testChannel)),
+		path:         "/channel",
+		wantAttempts: 2,
+	}}
+	env := EnvConfig{
+		DefaultTimeoutDuration: time.Minute,
+		MaxTimeoutDuration:     time.Minute,
+		RetryPolicies: map[string]RetryPolicy{
+			"broker-e2e-delivery-probe": {Strategy: cecontext.BackoffStrategyConstant, Period: 10 * time.Millisecond, MaxRetries: 3},
+		},
+		DefaultRetryPolicy: RetryPolicy{Strategy: cecontext.BackoffStrategyConstant, Period: 10 * time.Millisecond, MaxRetries: 1},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			probeMetrics, err := utils.NewProbeMetrics(nil)
+			if err != nil {
+				t.Fatal("Failed to create probe metrics:", err)
+			}
+			ph, err := NewHelper(env, tc.handler, nil, nil, &utils.LivenessChecker{}, utils.NewReadinessChecker(), probeMetrics, utils.NewInFlightProbes(), http.NewServeMux(), nil, nil, utils.NewInMemoryCorrelationStore(clock.RealClock{}), clock.RealClock{}, &utils.BackendChecker{}, &utils.ProbeRequests{})
+			if err != nil {
+				t.Fatal("Failed to create probe helper:", err)
+			}
+			if result := ph.forwardFromProbe(ctx)(*tc.event); !cloudevents.IsNACK(result) {
+				t.Errorf("wanted NACK, got %+v", result)
+			}
+			attemptsMu.Lock()
+			defer attemptsMu.Unlock()
+			if got := attempts[tc.path]; got != tc.wantAttempts {
+				t.Errorf("delivery attempts got=%d, want=%d", got, tc.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestProbeHelperRetryBudget(t *testing.T) {
+	ctx := logtest.TestContextWithLogger(t)
+
+	// The degraded test broker rejects every event with a retriable status.
+	var attemptsMu sync.Mutex
+	attempts := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attemptsMu.Lock()
+		attempts++
+		attemptsMu.Unlock()
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	// The budget holds the retries of a single probe, and is never refilled.
+	const retryPeriod = 500 * time.Millisecond
+	env := EnvConfig{
+		DefaultTimeoutDuration: time.Minute,
+		MaxTimeoutDuration:     time.Minute,
+		DefaultRetryPolicy:     RetryPolicy{Strategy: cecontext.BackoffStrategyConstant, Period: retryPeriod, MaxRetries: 2},
+		RetryBudgetSize:        2,
+		RetryBudgetRefillRate:  0,
+	}
+	transportOpts, err := forwardTransportOptions(env)
+	if err != nil {
+		t.Fatal("Failed to create forward transport options:", err)
+	}
+	forwardProtocol, err := cloudevents.NewHTTP(transportOpts...)
+	if err != nil {
+		t.Fatal("Failed to create HTTP protocol of the forward client:", err)
+	}
+	forwardClient, err := cloudevents.NewClient(forwardProtocol)
+	if err != nil {
+		t.Fatal("Failed to create forward client:", err)
+	}
+	brokerProbe, err := handlers.NewBrokerE2EDeliveryProbe(srv.URL+"/broker", forwardClient, utils.NewInMemoryCorrelationStore(clock.RealClock{}))
+	if err != nil {
+		t.Fatal("Failed to create broker probe:", err)
+	}
+	probeMetrics, err := utils.NewProbeMetrics(nil)
+	if err != nil {
+		t.Fatal("Failed to create probe metrics:", err)
+	}
+	ph, err := NewHelper(env, brokerProbe, nil, nil, &utils.LivenessChecker{}, utils.NewReadinessChecker(), probeMetrics, utils.NewInFlightProbes(), http.NewServeMux(), nil, nil, utils.NewInMemoryCorrelationStore(clock.RealClock{}), clock.RealClock{}, &utils.BackendChecker{}, &utils.ProbeRequests{})
+	if err != nil {
+		t.Fatal("Failed to create probe helper:", err)
+	}
+	sendProbe := func(id string) (*FailureResult, time.Duration, int) {
+		attemptsMu.Lock()
+		before := attempts
+		attemptsMu.Unlock()
+		start := time.Now()
+		result := ph.forwardFromProbe(ctx)(*probeEvent("broker-e2e-delivery-probe", withProbeID(id), withProbeExtension("namespace", testNamespace), withProbeExtension("broker", "default")))
+		elapsed := time.Since(start)
+		var failure *FailureResult
+		if !errors.As(result, &failure) {
+			t.Fatalf("wanted failure of probe %s, got %+v", id, result)
+		}
+		attemptsMu.Lock()
+		defer attemptsMu.Unlock()
+		return failure, elapsed, attempts - before
+	}
+
+	// The first probe saturates the budget with its retries.
+	failure, _, got := sendProbe("probe-1")
+	if failure.Reason != ForwardFailedReason {
+		t.Errorf("first probe failure got=%+v, want reason %s", failure, ForwardFailedReason)
+	}
+	if want := 1 + env.DefaultRetryPolicy.MaxRetries; got != want {
+		t.Errorf("first probe delivery attempts got=%d, want=%d", got, want)
+	}
+
+	// The next probes fail fast instead of retrying.
+	for _, id := range []string{"probe-2", "probe-3"} {
+		failure, elapsed, got := sendProbe(id)
+		if failure.Reason != RetryBudgetExhaustedReason {
+			t.Errorf("probe %s failure got=%+v, want reason %s", id, failure, RetryBudgetExhaustedReason)
+		}
+		if got != 1 {
+			t.Errorf("probe %s delivery attempts got=%d, want=1", id, got)
+		}
+		if elapsed >= time.Duration(env.DefaultRetryPolicy.MaxRetries)*retryPeriod {
+			t.Errorf("probe %s failed after %v, want it to fail before retrying", id, elapsed)
+		}
+	}
+}
+
+func TestNewHelperInvalidRetryBudget(t *testing.T) {
+	cases := []struct {
+		name string
+		env  EnvConfig
+	}{{
+		name: "negative size",
+		env:  EnvConfig{RetryBudgetSize: -1},
+	}, {
+		name: "negative refill rate",
+		env:  EnvConfig{RetryBudgetSize: 1, RetryBudgetRefillRate: -1},
+	}}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			probeMetrics, err := utils.NewProbeMetrics(nil)
+			if err != nil {
+				t.Fatal("Failed to create probe metrics:", err)
+			}
+			if _, err := NewHelper(tc.env, nil, nil, nil, &utils.LivenessChecker{}, utils.NewReadinessChecker(), probeMetrics, utils.NewInFlightProbes(), http.NewServeMux(), nil, nil, utils.NewInMemoryCorrelationStore(clock.RealClock{}), clock.RealClock{}, &utils.BackendChecker{}, &utils.ProbeRequests{}); err == nil {
+				t.Error("wanted error creating probe helper, got nil")
+			}
+		})
+	}
+}
+
+func TestProbeHelperDebugProbes(t *testing.T) {
+	ctx := logtest.TestContextWithLogger(t)
+	env := EnvConfig{
+		DefaultTimeoutDuration: time.Minute,
+		MaxTimeoutDuration:     time.Hour,
+	}
+	handler := &blockingProbeHandler{
+		started: make(chan struct{}, 1),
+		release: make(chan struct{}),
+	}
+	probeMetrics, err := utils.NewProbeMetrics(nil)
+	if err != nil {
+		t.Fatal("Failed to create probe metrics:", err)
+	}
+	livenessChecker := &utils.LivenessChecker{}
+	readinessChecker := utils.NewReadinessChecker()
+	inFlightProbes := utils.NewInFlightProbes()
+	mux := NewReceiverMux(ctx, livenessChecker, readinessChecker, inFlightProbes)
+	ph, err := NewHelper(env, handler, nil, nil, livenessChecker, readinessChecker, probeMetrics, inFlightProbes, mux, nil, nil, utils.NewInMemoryCorrelationStore(clock.RealClock{}), clock.RealClock{}, &utils.BackendChecker{}, &utils.ProbeRequests{})
+	if err != nil {
+		t.Fatal("Failed to create probe helper:", err)
+	}
+
+	// Start a probe which waits for a long time.
+	result := make(chan cloudevents.Result, 1)
+	go func() {
+		result <- ph.forwardFromProbe(ctx)(*probeEvent("cloudpubsubsource-probe", withProbeTimeout(time.Hour)))
+	}()
+	<-handler.started
+
+	rw := httptest.NewRecorder()
+	mux.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, debugProbesPath, nil))
+	if rw.Code != http.StatusOK {
+		t.Fatalf("debug probes status code got=%d, want=%d", rw.Code, http.StatusOK)
+	}
+	var got []struct {
+		ID              string    `json:"id"`
+		Type            string    `json:"type"`
+		ReceivedTime    time.Time `json:"receivedTime"`
+		WaitingDuration string    `json:"waitingDuration"`
+	}
+	if err := json.Unmarshal(rw.Body.Bytes(), &got); err != nil {
+		t.Fatalf("Failed to unmarshal debug probes %q: %v", rw.Body.String(), err)
+	}
+	if len(got) != 1 {
+		t.Fatalf("debug probes got %d entries, want 1: %s", len(got), rw.Body.String())
+	}
+	if got[0].ID != "cloudpubsubsource-probe-1234567890" || got[0].Type != "cloudpubsubsource-probe" {
+		t.Errorf("debug probe got id=%s type=%s, want id=cloudpubsubsource-probe-1234567890 type=cloudpubsubsource-probe", got[0].ID, got[0].Type)
+	}
+	if got[0].ReceivedTime.IsZero() {
+		t.Error("debug probe has no received time")
+	}
+	if _, err := time.ParseDuration(got[0].WaitingDuration); err != nil {
+		t.Errorf("debug probe waiting duration %q is not a duration: %v", got[0].WaitingDuration, err)
+	}
+
+	// The probe is no longer listed once it completes.
+	close(handler.release)
+	if res := <-result; !cloudevents.IsACK(res) {
+		t.Errorf("wanted ACK, got %+v", res)
+	}
+	if probes := inFlightProbes.List(); len(probes) != 0 {
+		t.Errorf("in-flight probes got=%+v, want none", probes)
+	}
+}
+
+// recognizingProbeHandler is a blocking probe handler which only recognizes
+// the forward probes of some types.
+type recognizingProbeHandler struct {
+	blockingProbeHandler
+	types []string
+}
+
+func (h *recognizingProbeHandler) Recognizes(eventType string) bool {
+	for _, t := range h.types {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+func TestProbeHelperUnrecognizedProbeType(t *testing.T) {
+	ctx := logtest.TestContextWithLogger(t)
+	env := EnvConfig{
+		DefaultTimeoutDuration: time.Minute,
+		MaxTimeoutDuration:     time.Minute,
+	}
+	handler := &recognizingProbeHandler{
+		blockingProbeHandler: blockingProbeHandler{
+			started: make(chan struct{}, 1),
+			release: make(chan struct{}),
+		},
+		types: []string{"broker-e2e-delivery-probe"},
+	}
+	probeMetrics, err := utils.NewProbeMetrics(nil)
+	if err != nil {
+		t.Fatal("Failed to create probe metrics:", err)
+	}
+	livenessChecker := &utils.LivenessChecker{}
+	readinessChecker := utils.NewReadinessChecker()
+	inFlightProbes := utils.NewInFlightProbes()
+	mux := NewReceiverMux(ctx, livenessChecker, readinessChecker, inFlightProbes)
+	ph, err := NewHelper(env, handler, nil, nil, livenessChecker, readinessChecker, probeMetrics, inFlightProbes, mux, nil, nil, utils.NewInMemoryCorrelationStore(clock.RealClock{}), clock.RealClock{}, &utils.BackendChecker{}, &utils.ProbeRequests{})
+	if err != nil {
+		t.Fatal("Failed to create probe helper:", err)
+	}
+
+	// The probes of an unrecognized type are rejected immediately, without
+	// being forwarded or tracked.
+	result := ph.forwardFromProbe(ctx)(*probeEvent("unknown-probe", withProbeTimeout(time.Minute)))
+	if !cloudevents.IsNACK(result) {
+		t.Fatalf("wanted NACK for unrecognized probe type, got %+v", result)
+	}
+	var failure *FailureResult
+	if !errors.As(result, &failure) || failure.Reason != UnrecognizedProbeTypeReason {
+		t.Errorf("failure got=%+v, want reason %s", result, UnrecognizedProbeTypeReason)
+	}
+	if len(handler.started) != 0 {
+		t.Error("probe of unrecognized type was forwarded")
+	}
+	rw := httptest.NewRecorder()
+	mux.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, debugProbesPath, nil))
+	if got := strings.TrimSpace(rw.Body.String()); got != "[]" {
+		t.Errorf("debug probes got=%s, want no entries", got)
+	}
+	if len(ph.probeTypes.types) != 0 {
+		t.Errorf("probe types got=%v, want none", ph.probeTypes.types)
+	}
+}
+
+func TestProbeHelperMaxProbeTypes(t *testing.T) {
+	ctx := logtest.TestContextWithLogger(t)
+	env := EnvConfig{
+		DefaultTimeoutDuration: time.Minute,
+		MaxTimeoutDuration:     time.Minute,
+		MaxProbeTypes:          1,
+	}
+	handler := &blockingProbeHandler{
+		started: make(chan struct{}, 2),
+		release: make(chan struct{}),
+	}
+	close(handler.release)
+	probeMetrics, err := utils.NewProbeMetrics(nil)
+	if err != nil {
+		t.Fatal("Failed to create probe metrics:", err)
+	}
+	ph, err := NewHelper(env, handler, nil, nil, &utils.LivenessChecker{}, utils.NewReadinessChecker(), probeMetrics, utils.NewInFlightProbes(), http.NewServeMux(), nil, nil, utils.NewInMemoryCorrelationStore(clock.RealClock{}), clock.RealClock{}, &utils.BackendChecker{}, &utils.ProbeRequests{})
+	if err != nil {
+		t.Fatal("Failed to create probe helper:", err)
+	}
+	forward := ph.forwardFromProbe(ctx)
+
+	if result := forward(*probeEvent("broker-e2e-delivery-probe")); !cloudevents.IsACK(result) {
+		t.Errorf("wanted ACK for first probe type, got %+v", result)
+	}
+	<-handler.started
+
+	// The probes of a new type are rejected once the limit is reached.
+	result := forward(*probeEvent("cloudpubsubsource-probe"))
+	if !cloudevents.IsNACK(result) {
+		t.Errorf("wanted NACK for probe type beyond the limit, got %+v", result)
+	}
+	var failure *FailureResult
+	if !errors.As(result, &failure) || failure.Reason != TooManyProbeTypesReason {
+		t.Errorf("failure got=%+v, want reason %s", result, TooManyProbeTypesReason)
+	}
+
+	// The probes of a type already handled are still forwarded.
+	if result := forward(*probeEvent("broker-e2e-delivery-probe")); !cloudevents.IsACK(result) {
+		t.Errorf("wanted ACK for probe type already handled, got %+v", result)
+	}
+}
+
+func TestProbeHelperDebugRecent(t *testing.T) {
+	ctx := logtest.TestContextWithLogger(t)
+	const recentResultsSize = 3
+	env := EnvConfig{
+		DefaultTimeoutDuration: time.Minute,
+		MaxTimeoutDuration:     time.Hour,
+		RecentResultsSize:      recentResultsSize,
+	}
+	// The probes are released as soon as they start.
+	handler := &blockingProbeHandler{
+		started: make(chan struct{}, 5),
+		release: make(chan struct{}),
+	}
+	close(handler.release)
+	probeMetrics, err := utils.NewProbeMetrics(nil)
+	if err != nil {
+		t.Fatal("Failed to create probe metrics:", err)
+	}
+	livenessChecker := &utils.LivenessChecker{}
+	readinessChecker := utils.NewReadinessChecker()
+	inFlightProbes := utils.NewInFlightProbes()
+	mux := NewReceiverMux(ctx, livenessChecker, readinessChecker, inFlightProbes)
+	ph, err := NewHelper(env, handler, nil, nil, livenessChecker, readinessChecker, probeMetrics, inFlightProbes, mux, nil, nil, utils.NewInMemoryCorrelationStore(clock.RealClock{}), clock.RealClock{}, &utils.BackendChecker{}, &utils.ProbeRequests{})
+	if err != nil {
+		t.Fatal("Failed to create probe helper:", err)
+	}
+
+	// Every other probe fails for lack of a target path.
+	for i := 0; i < 5; i++ {
+		opts := []probeEventOption{withProbeID(fmt.Sprintf("probe-%d", i))}
+		if i%2 == 1 {
+			opts = append(opts, withoutProbeExtension("targetpath"))
+		}
+		ph.forwardFromProbe(ctx)(*probeEvent("cloudpubsubsource-probe", opts...))
+	}
+
+	rw := httptest.NewRecorder()
+	mux.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, debugRecentPath, nil))
+	if rw.Code != http.StatusOK {
+		t.Fatalf("debug recent status code got=%d, want=%d", rw.Code, http.StatusOK)
+	}
+	var got []utils.ProbeResult
+	if err := json.Unmarshal(rw.Body.Bytes(), &got); err != nil {
+		t.Fatalf("Failed to unmarshal debug recent results %q: %v", rw.Body.String(), err)
+	}
+	// Only the most recent results are listed, most recent first.
+	want := []utils.ProbeResult{
+		{ID: "probe-4", Type: "cloudpubsubsource-probe", Result: utils.ProbeResultACK},
+		{ID: "probe-3", Type: "cloudpubsubsource-probe", Result: utils.ProbeResultNACK, Reason: string(MissingExtensionReason)},
+		{ID: "probe-2", Type: "cloudpubsubsource-probe", Result: utils.ProbeResultACK},
+	}
+	if diff := cmp.Diff(want, got, cmpopts.IgnoreFields(utils.ProbeResult{}, "LatencyMs", "CompletedTime")); diff != "" {
+		t.Errorf("debug recent results (-want,+got): %s", diff)
+	}
+	for i := 1; i < len(got); i++ {
+		if got[i].CompletedTime.After(got[i-1].CompletedTime) {
+			t.Errorf("debug recent result %s completed after the more recent %s", got[i].ID, got[i-1].ID)
+		}
+	}
+}
+
+func TestProbeHelperDebugBackends(t *testing.T) {
+	cases := []struct {
+		name        string
+		closeClient bool
+		wantCode    int
+		wantHealthy bool
+	}{{
+		name:        "reachable Pub/Sub",
+		wantCode:    http.StatusOK,
+		wantHealthy: true,
+	}, {
+		name:        "closed Pub/Sub client",
+		closeClient: true,
+		wantCode:    http.StatusServiceUnavailable,
+	}}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := logtest.TestContextWithLogger(t)
+			ctx, cancel := context.WithCancel(ctx)
+			defer cancel()
+
+			pubsubClient, closePubsub := testPubsubClient(ctx, t, testProjectID)
+			defer closePubsub()
+			if tc.closeClient {
+				pubsubClient.Close()
+			}
+			env := EnvConfig{
+				BackendChecks:        []string{PubSubBackend},
+				BackendCheckInterval: 100 * time.Millisecond,
+			}
+			backendChecker, err := NewBackendChecker(env, pubsubClient, clock.RealClock{})
+			if err != nil {
+				t.Fatal("Failed to create backend checker:", err)
+			}
+			probeMetrics, err := utils.NewProbeMetrics(nil)
+			if err != nil {
+				t.Fatal("Failed to create probe metrics:", err)
+			}
+			mux := http.NewServeMux()
+			if _, err := NewHelper(env, &blockingProbeHandler{}, nil, nil, &utils.LivenessChecker{}, utils.NewReadinessChecker(), probeMetrics, utils.NewInFlightProbes(), mux, nil, nil, utils.NewInMemoryCorrelationStore(clock.RealClock{}), clock.RealClock{}, backendChecker, &utils.ProbeRequests{}); err != nil {
+				t.Fatal("Failed to create probe helper:", err)
+			}
+			go backendChecker.Run(ctx)
+
+			// The backends are listed once they are checked.
+			var got []utils.BackendStatus
+			var code int
+			if err := wait.PollImmediate(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+				rw := httptest.NewRecorder()
+				mux.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, debugBackendsPath, nil))
+				code = rw.Code
+				if err := json.Unmarshal(rw.Body.Bytes(), &got); err != nil {
+					return false, fmt.Errorf("failed to unmarshal debug backends %q: %v", rw.Body.String(), err)
+				}
+				return len(got) > 0, nil
+			}); err != nil {
+				t.Fatal("Failed to list checked backends:", err)
+			}
+			if code != tc.wantCode {
+				t.Errorf("debug backends status code got=%d, want=%d", code, tc.wantCode)
+			}
+			if len(got) != 1 || got[0].Name != PubSubBackend || got[0].Healthy != tc.wantHealthy {
+				t.Errorf("debug backends got=%+v, want %s with healthy=%t", got, PubSubBackend, tc.wantHealthy)
+			}
+			if !tc.wantHealthy && got[0].Error == "" {
+				t.Error("debug backends got no error for unhealthy backend")
+			}
+		})
+	}
+
+	if _, err := NewBackendChecker(EnvConfig{BackendChecks: []string{"spanner"}}, nil, clock.RealClock{}); err == nil {
+		t.Error("NewBackendChecker got no error for an unsupported backend, want error")
+	}
+
+	// No backend is checked unless BACKEND_CHECKS is set.
+	var env EnvConfig
+	if err := envconfig.Process("BACKEND_CHECKS_TEST", &env); err != nil {
+		t.Fatal("Failed to process env config:", err)
+	}
+	if len(env.BackendChecks) != 0 {
+		t.Errorf("default backend checks got=%v, want none", env.BackendChecks)
+	}
+}
+
+func TestProbeHelperDebugConfig(t *testing.T) {
+	// The configuration is parsed from the environment, so that it holds the
+	// defaults of the variables which are unset.
+	os.Setenv("DEBUG_CONFIG_TEST_FORWARD_CLIENT_KEY_FILE", "/etc/probe-helper/client.key")
+	os.Setenv("DEBUG_CONFIG_TEST_MAX_CONCURRENT_PROBES", "3")
+	defer os.Unsetenv("DEBUG_CONFIG_TEST_FORWARD_CLIENT_KEY_FILE")
+	defer os.Unsetenv("DEBUG_CONFIG_TEST_MAX_CONCURRENT_PROBES")
+	var env EnvConfig
+	if err := envconfig.Process("DEBUG_CONFIG_TEST", &env); err != nil {
+		t.Fatal("Failed to process env config:", err)
+	}
+	probeMetrics, err := utils.NewProbeMetrics(nil)
+	if err != nil {
+		t.Fatal("Failed to create probe metrics:", err)
+	}
+	mux := http.NewServeMux()
+	if _, err := NewHelper(env, &blockingProbeHandler{}, nil, nil, &utils.LivenessChecker{}, utils.NewReadinessChecker(), probeMetrics, utils.NewInFlightProbes(), mux, nil, nil, utils.NewInMemoryCorrelationStore(clock.RealClock{}), clock.RealClock{}, &utils.BackendChecker{}, &utils.ProbeRequests{}); err != nil {
+		t.Fatal("Failed to create probe helper:", err)
+	}
+
+	rw := httptest.NewRecorder()
+	mux.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, debugConfigPath, nil))
+	if rw.Code != http.StatusOK {
+		t.Fatalf("debug config status code got=%d, want=%d", rw.Code, http.StatusOK)
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal(rw.Body.Bytes(), &got); err != nil {
+		t.Fatalf("Failed to unmarshal debug config %q: %v", rw.Body.String(), err)
+	}
+	for name, want := range map[string]interface{}{
+		"DEFAULT_TIMEOUT_DURATION": "2m0s",
+		"MAX_TIMEOUT_DURATION":     "30m0s",
+		"MAX_CONCURRENT_PROBES":    float64(3),
+		"FORWARD_CLIENT_KEY_FILE":  redactedValue,
+		"RECEIVER_KEY_FILE":        "",
+	} {
+		if got[name] != want {
+			t.Errorf("debug config %s got=%v, want=%v", name, got[name], want)
+		}
+	}
+	if strings.Contains(rw.Body.String(), "client.key") {
+		t.Errorf("debug config leaks the forward client key file: %s", rw.Body.String())
+	}
+}
+
+func TestProbeHelperDrain(t *testing.T) {
+	cases := []struct {
+		name         string
+		drainTimeout time.Duration
+		release      bool
+		wantResult   protocol.Result
+	}{{
+		name:         "in-flight probe completes",
+		drainTimeout: time.Minute,
+		release:      true,
+		wantResult:   cloudevents.ResultACK,
+	}, {
+		name:         "in-flight probe exceeds drain timeout",
+		drainTimeout: 500 * time.Millisecond,
+		wantResult:   cloudevents.ResultNACK,
+	}}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := logtest.TestContextWithLogger(t)
+			ctx, cancel := context.WithCancel(ctx)
+			defer cancel()
+
+			env := EnvConfig{
+				LivenessStaleDuration:  time.Minute,
+				DefaultTimeoutDuration: time.Minute,
+				MaxTimeoutDuration:     time.Minute,
+				DrainTimeout:           tc.drainTimeout,
+			}
+			handler := &blockingProbeHandler{
+				started: make(chan struct{}, 1),
+				release: make(chan struct{}),
+			}
+			receiverListener, err := GetFreePortListener()
+			if err != nil {
+				t.Fatalf("Failed to get free receiver port listener: %v", err)
+			}
+			livenessCheckURL := fmt.Sprintf("http://localhost:%d/healthz", receiverListener.Addr().(*net.TCPAddr).Port)
+			probeListener, err := GetFreePortListener()
+			if err != nil {
+				t.Fatalf("Failed to get free probe port listener: %v", err)
+			}
+			probeURL := fmt.Sprintf("http://localhost:%d", probeListener.Addr().(*net.TCPAddr).Port)
+			probeMetrics, err := utils.NewProbeMetrics(nil)
+			if err != nil {
+				t.Fatal("Failed to create probe metrics:", err)
+			}
+			livenessChecker := &utils.LivenessChecker{}
+			readinessChecker := utils.NewReadinessChecker()
+			inFlightProbes := utils.NewInFlightProbes()
+			mux := NewReceiverMux(ctx, livenessChecker, readinessChecker, inFlightProbes)
+			forwardClient, err := NewCeForwardClient(env, NewTestCeForwardClientOptions(probeListener), probeMetrics, &utils.ProbeRequests{})
+			if err != nil {
+				t.Fatal("Failed to create forward client:", err)
+			}
+			receiveClient, err := NewCeReceiverClient(ctx, env, mux, NewTestCeReceiverClientOptions(receiverListener, nil))
+			if err != nil {
+				t.Fatal("Failed to create receiver client:", err)
+			}
+			ph, err := NewHelper(env, handler, forwardClient, receiveClient, livenessChecker, readinessChecker, probeMetrics, inFlightProbes, mux, nil, nil, utils.NewInMemoryCorrelationStore(clock.RealClock{}), clock.RealClock{}, &utils.BackendChecker{}, &utils.ProbeRequests{})
+			if err != nil {
+				t.Fatal("Failed to create probe helper:", err)
+			}
+			runDone := make(chan struct{})
+			go func() {
+				ph.Run(ctx)
+				close(runDone)
+			}()
+
+			// Create a testing client from which to send probe events to the probe helper.
+			p, err := cloudevents.NewHTTP(cloudevents.WithTarget(probeURL))
+			if err != nil {
+				t.Fatal("Failed to create HTTP protocol of the testing client:" + err.Error())
+			}
+			c, err := cloudevents.NewClient(p)
+			if err != nil {
+				t.Fatal("Failed to create testing client:" + err.Error())
+			}
+			sendCtx := cloudevents.ContextWithRetriesConstantBackoff(context.Background(), 100*time.Millisecond, 30)
+			// The probe helper is live once its receiver receives an event.
+			receiverURL := fmt.Sprintf("http://localhost:%d/%s", receiverListener.Addr().(*net.TCPAddr).Port, testTargetReceiverPath)
+			if res := c.Send(cloudevents.ContextWithTarget(sendCtx, receiverURL), *probeEvent("broker-e2e-delivery-probe")); !cloudevents.IsACK(res) {
+				t.Fatalf("Failed to send event to the receiver: %+v", res)
+			}
+			result := make(chan protocol.Result, 1)
+			go func() {
+				result <- c.Send(sendCtx, *probeEvent("broker-e2e-delivery-probe"))
+			}()
+			<-handler.started
+			assertLivenessCheckResult(t, livenessCheckURL, true)
+
+			// Start draining, after which new probes are rejected.
+			cancel()
+			for !ph.isDraining() {
+				time.Sleep(10 * time.Millisecond)
+			}
+			assertLivenessCheckResult(t, livenessCheckURL, false)
+			var httpResult *cehttp.Result
+			if res := c.Send(context.Background(), *probeEvent("cloudpubsubsource-probe")); !cloudevents.ResultAs(res, &httpResult) || httpResult.StatusCode != http.StatusServiceUnavailable {
+				t.Errorf("wanted status code %d for probe sent while draining, got %+v", http.StatusServiceUnavailable, res)
+			}
+
+			if tc.release {
+				close(handler.release)
+			}
+			if res := <-result; !errors.Is(res, tc.wantResult) {
+				t.Errorf("wanted result %+v for in-flight probe, got %+v", tc.wantResult, res)
+			}
+			<-runDone
+		})
+	}
+}
+
+// spanRecorder is a trace exporter which records the exported spans.
+type spanRecorder struct {
+	sync.Mutex
+	spans []*trace.SpanData
+}
+
+func (r *spanRecorder) ExportSpan(span *trace.SpanData) {
+	r.Lock()
+	defer r.Unlock()
+	r.spans = append(r.spans, span)
+}
+
+func (r *spanRecorder) spansNamed(name string) []*trace.SpanData {
+	r.Lock()
+	defer r.Unlock()
+	var spans []*trace.SpanData
+	for _, span := range r.spans {
+		if span.Name == name {
+			spans = append(spans, span)
+		}
+	}
+	return spans
+}
+
+func TestProbeHelperTracing(t *testing.T) {
+	recorder := &spanRecorder{}
+	trace.RegisterExporter(recorder)
+	trace.ApplyConfig(trace.Config{DefaultSampler: trace.AlwaysSample()})
+	defer func() {
+		trace.UnregisterExporter(recorder)
+		trace.ApplyConfig(trace.Config{DefaultSampler: trace.ProbabilitySampler(1e-4)})
+	}()
+
+	ctx := logtest.TestContextWithLogger(t)
+	group, ctx := errgroup.WithContext(ctx)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	phr := makeProbeHelper(ctx, t, group, func(env *EnvConfig) {
+		env.TracingEnabled = true
+	})
+	go phr.probeHelper.Run(ctx)
+
+	// Create a testing client from which to send probe events to the probe helper.
+	p, err := cloudevents.NewHTTP(cloudevents.WithTarget(phr.probeURL))
+	if err != nil {
+		t.Fatal("Failed to create HTTP protocol of the testing client:" + err.Error())
+	}
+	c, err := cloudevents.NewClient(p)
+	if err != nil {
+		t.Fatal("Failed to create testing client:" + err.Error())
+	}
+	event := probeEvent("broker-e2e-delivery-probe", withProbeExtension("namespace", testNamespace))
+	if result := c.Send(ctx, *event); !errors.Is(result, cloudevents.ResultACK) {
+		t.Fatalf("wanted result %+v, got %+v", cloudevents.ResultACK, result)
+	}
+
+	// The event received back from the broker continues the trace of the forwarded probe.
+	// The receive span may end shortly after the probe result is returned.
+	forwardSpans := recorder.spansNamed(forwardSpanName)
+	receiveSpans := recorder.spansNamed(receiveSpanName)
+	for deadline := time.Now().Add(time.Second); len(receiveSpans) == 0 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+		receiveSpans = recorder.spansNamed(receiveSpanName)
+	}
+	if len(forwardSpans) != 1 || len(receiveSpans) != 1 {
+		t.Fatalf("got %d forward spans and %d receive spans, want 1 of each", len(forwardSpans), len(receiveSpans))
+	}
+	if forwardSpans[0].TraceID != receiveSpans[0].TraceID {
+		t.Errorf("receive span trace ID got=%s, want=%s", receiveSpans[0].TraceID, forwardSpans[0].TraceID)
+	}
+	if receiveSpans[0].ParentSpanID != forwardSpans[0].SpanID {
+		t.Errorf("receive span parent ID got=%s, want=%s", receiveSpans[0].ParentSpanID, forwardSpans[0].SpanID)
+	}
+	if got := forwardSpans[0].Attributes[probeTypeAttribute]; got != "broker-e2e-delivery-probe" {
+		t.Errorf("forward span probe type got=%v, want=broker-e2e-delivery-probe", got)
+	}
+
+	// Cancel gracefully to avoid logger panic if parent goroutine terminates.
+	phr.cleanup()
+	cancel()
+	if err := group.Wait(); err != nil {
+		t.Fatalf("Error in probe helper fake sources: %v", err)
+	}
+}
+
+func TestProbeHelperJSONLogs(t *testing.T) {
+	// Capture the logs which the probe helper logger writes to stderr.
+	logFile, err := ioutil.TempFile("", "probe-helper-logs")
+	if err != nil {
+		t.Fatal("Failed to create log file:", err)
+	}
+	defer os.Remove(logFile.Name())
+	stderr := os.Stderr
+	os.Stderr = logFile
+	loggingConfig, err := NewLoggingConfig(JSONLogFormat)
+	if err != nil {
+		os.Stderr = stderr
+		t.Fatal("Failed to create logging config:", err)
+	}
+	logger, _ := logging.NewLoggerFromConfig(loggingConfig, LoggerName)
+	os.Stderr = stderr
+	ctx := logging.WithLogger(context.Background(), logger)
+
+	probeMetrics, err := utils.NewProbeMetrics(nil)
+	if err != nil {
+		t.Fatal("Failed to create probe metrics:", err)
+	}
+	ph, err := NewHelper(EnvConfig{}, &blockingProbeHandler{}, nil, nil, &utils.LivenessChecker{}, utils.NewReadinessChecker(), probeMetrics, utils.NewInFlightProbes(), http.NewServeMux(), nil, nil, utils.NewInMemoryCorrelationStore(clock.RealClock{}), clock.RealClock{}, &utils.BackendChecker{}, &utils.ProbeRequests{})
+	if err != nil {
+		t.Fatal("Failed to create probe helper:", err)
+	}
+	// A probe event without a targetpath extension is rejected.
+	event := probeEvent("broker-e2e-delivery-probe")
+	event.SetExtension(utils.ProbeEventTargetPathExtension, nil)
+	if result := ph.forwardFromProbe(ctx)(*event); !cloudevents.IsNACK(result) {
+		t.Fatalf("wanted NACK, got %+v", result)
+	}
+	logger.Sync()
+
+	logs, err := ioutil.ReadFile(logFile.Name())
+	if err != nil {
+		t.Fatal("Failed to read logs:", err)
+	}
+	var got map[string]interface{}
+	for _, line := range strings.Split(string(logs), "\n") {
+		if strings.Contains(line, "Probe completed") {
+			if err := json.Unmarshal([]byte(line), &got); err != nil {
+				t.Fatalf("Failed to unmarshal probe result log line %q: %v", line, err)
+			}
+			break
+		}
+	}
+	if got == nil {
+		t.Fatalf("No probe result log line found in logs:\n%s", logs)
+	}
+	for key, want := range map[string]interface{}{
+		"probe_type": "broker-e2e-delivery-probe",
+		"probe_id":   "broker-e2e-delivery-probe-1234567890",
+		"result":     utils.ProbeResultNACK,
+	} {
+		if got[key] != want {
+			t.Errorf("log field %s got=%v, want=%v", key, got[key], want)
+		}
+	}
+	if _, ok := got["latency_ms"].(float64); !ok {
+		t.Errorf("log field latency_ms got=%v, want a number", got["latency_ms"])
+	}
+}
+
+// syncBuffer is a buffer which is safe for concurrent writes, which captures
+// the logs of the probe helper.
+type syncBuffer struct {
+	sync.Mutex
+	bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.Lock()
+	defer b.Unlock()
+	return b.Buffer.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.Lock()
+	defer b.Unlock()
+	return b.Buffer.String()
+}
+
+func TestProbeHelperProbeIDLogs(t *testing.T) {
+	// Capture the logs of the probe helper as JSON.
+	logs := &syncBuffer{}
+	logger := zap.New(zapcore.NewCore(zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()), zapcore.AddSync(logs), zapcore.DebugLevel))
+	ctx := logging.WithLogger(context.Background(), logger.Sugar())
+	group, ctx := errgroup.WithContext(ctx)
+	ctx, cancel := context.WithCancel(ctx)
+
+	phr := makeProbeHelper(ctx, t, group)
+	go phr.probeHelper.Run(ctx)
+
+	p, err := cloudevents.NewHTTP(cloudevents.WithTarget(phr.probeURL))
+	if err != nil {
+		t.Fatal("Failed to create HTTP protocol of the testing client:" + err.Error())
+	}
+	c, err := cloudevents.NewClient(p)
+	if err != nil {
+		t.Fatal("Failed to create testing client:" + err.Error())
+	}
+	event := probeEvent("broker-e2e-delivery-probe", withProbeExtension("namespace", testNamespace))
+	if result := c.Send(ctx, *event); !cloudevents.IsACK(result) {
+		t.Fatalf("wanted ACK, got %+v", result)
+	}
+
+	// Cancel gracefully to avoid logger panic if parent goroutine terminates.
+	phr.cleanup()
+	cancel()
+	if err := group.Wait(); err != nil {
+		t.Fatalf("Error in probe helper fake sources: %v", err)
+	}
+
+	// The forward and receive log lines of the probe share its ID.
+	probeIDs := map[string]interface{}{}
+	for _, line := range strings.Split(logs.String(), "\n") {
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			continue
+		}
+		if msg, ok := entry["msg"].(string); ok {
+			probeIDs[msg] = entry[utils.ProbeIDLogKey]
+		}
+	}
+	for _, msg := range []string{"Received probe request", "Successfully received broker e2e delivery probe event", "Probe completed"} {
+		if got := probeIDs[msg]; got != event.ID() {
+			t.Errorf("log line %q %s got=%v, want=%s", msg, utils.ProbeIDLogKey, got, event.ID())
+		}
+	}
+}
+
+func TestProbeHelperRedisCorrelationStore(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatal("Failed to run miniredis:", err)
+	}
+	defer mr.Close()
+
+	ctx := logtest.TestContextWithLogger(t)
+	ctx = WithProjectKey(ctx, testProjectID)
+	ctx = WithTopicKey(ctx, testTopicID)
+	ctx = WithSubscriptionKey(ctx, testSubscriptionID)
+	group, ctx := errgroup.WithContext(ctx)
+	ctx, cancel := context.WithCancel(ctx)
+
+	phr := makeProbeHelper(ctx, t, group, func(env *EnvConfig) {
+		env.CorrelationStore = RedisCorrelationStore
+		env.RedisAddress = mr.Addr()
+		env.RedisPollInterval = 10 * time.Millisecond
+	})
+	go phr.probeHelper.Run(ctx)
+
+	p, err := cloudevents.NewHTTP(cloudevents.WithTarget(phr.probeURL))
+	if err != nil {
+		t.Fatal("Failed to create HTTP protocol of the testing client:" + err.Error())
+	}
+	c, err := cloudevents.NewClient(p)
+	if err != nil {
+		t.Fatal("Failed to create testing client:" + err.Error())
+	}
+	cases := []struct {
+		name       string
+		event      *cloudevents.Event
+		wantResult protocol.Result
+	}{{
+		name:       "Broker E2E delivery probe",
+		event:      probeEvent("broker-e2e-delivery-probe", withProbeExtension("namespace", testNamespace)),
+		wantResult: cloudevents.ResultACK,
+	}, {
+		name:       "Channel E2E delivery probe",
+		event:      probeEvent("channel-e2e-delivery-probe", withProbeExtension("namespace", testNamespace), withProbeExtension("channel", testChannel)),
+		wantResult: cloudevents.ResultACK,
 	}, {
-		name: "Unrecognized probe event type",
-		steps: []eventAndResult{
-			{
-				event:      probeEvent("unrecognized-probe-type"),
-				wantResult: cloudevents.ResultNACK,
-			},
-		},
+		name:       "Broker DLQ probe",
+		event:      probeEvent("broker-dlq-probe", withProbeExtension("namespace", testNamespace)),
+		wantResult: cloudevents.ResultACK,
 	}, {
-		name: "Custom timeout",
-		steps: []eventAndResult{
-			{
-				event:      probeEvent("broker-e2e-delivery-probe", withProbeExtension("namespace", "test-namespace"), withProbeTimeout(0)),
-				wantResult: cloudevents.ResultNACK,
-			},
-		},
+		name:       "Broker E2E delivery probe rewriting IDs",
+		event:      probeEvent("broker-e2e-delivery-probe", withProbeExtension("namespace", testNamespace), withProbeExtension("broker", testIDRewritingBroker), withProbeTimeout(time.Second)),
+		wantResult: cloudevents.ResultNACK,
 	}}
 	for _, tc := range cases {
 		t.Run(tc.name, func(t *testing.T) {
-			for _, step := range tc.steps {
-				if result := c.Send(ctx, *step.event); !errors.Is(result, step.wantResult) {
-					t.Fatalf("wanted result %+v, got %+v", step.wantResult, result)
-				}
+			if result := c.Send(ctx, *tc.event); !errors.Is(result, tc.wantResult) {
+				t.Errorf("wanted result %+v, got %+v", tc.wantResult, result)
 			}
 		})
 	}
+	// The probes are evicted from Redis once they complete.
+	if keys := mr.Keys(); len(keys) != 0 {
+		t.Errorf("Redis keys got=%v, want none", keys)
+	}
+
 	// Cancel gracefully to avoid logger panic if parent goroutine terminates.
 	phr.cleanup()
 	cancel()
@@ -715,136 +5538,435 @@ func TestProbeHelper(t *testing.T) {
 	}
 }
 
-type makeProbeHelperReturn struct {
-	probeHelper      *Helper
-	probeURL         string
-	livenessCheckURL string
-	cleanup          func()
+// stuckChannelProbeHandler is a stuck probe handler which creates a receiver
+// channel for each probe, and only cleans it up once it is released.
+type stuckChannelProbeHandler struct {
+	blockingProbeHandler
+	receivedEvents *utils.SyncReceivedEvents
 }
 
-func makeProbeHelper(ctx context.Context, t *testing.T, group *errgroup.Group) makeProbeHelperReturn {
-	// Set up ports for testing the probe helper.
-	receiverListener, err := GetFreePortListener()
+func (h *stuckChannelProbeHandler) Forward(ctx context.Context, event cloudevents.Event) error {
+	cleanupFunc, err := h.receivedEvents.CreateReceiverChannel(event.ID())
 	if err != nil {
-		t.Fatalf("Failed to get free receiver port listener: %v", err)
+		return err
 	}
-	receiverPort := receiverListener.Addr().(*net.TCPAddr).Port
-	receiverURL := fmt.Sprintf("http://localhost:%d/%s", receiverPort, testTargetReceiverPath)
-	probeListener, err := GetFreePortListener()
+	defer cleanupFunc()
+	return h.blockingProbeHandler.Forward(ctx, event)
+}
+
+func TestProbeHelperDrainFakeClock(t *testing.T) {
+	ctx := logtest.TestContextWithLogger(t)
+	env := EnvConfig{
+		DefaultTimeoutDuration: time.Minute,
+		MaxTimeoutDuration:     time.Minute,
+		DrainTimeout:           time.Hour,
+	}
+	probeMetrics, err := utils.NewProbeMetrics(nil)
 	if err != nil {
-		t.Fatalf("Failed to get free probe port listener: %v", err)
+		t.Fatal("Failed to create probe metrics:", err)
+	}
+	fakeClock := clock.NewFakeClock(time.Now())
+	inFlightProbes := utils.NewInFlightProbes()
+	ph, err := NewHelper(env, &blockingProbeHandler{}, nil, nil, &utils.LivenessChecker{}, utils.NewReadinessChecker(), probeMetrics, inFlightProbes, http.NewServeMux(), nil, nil, utils.NewInMemoryCorrelationStore(fakeClock), fakeClock, &utils.BackendChecker{}, &utils.ProbeRequests{})
+	if err != nil {
+		t.Fatal("Failed to create probe helper:", err)
 	}
-	probePort := probeListener.Addr().(*net.TCPAddr).Port
-	probeURL := fmt.Sprintf("http://localhost:%d", probePort)
-	livenessCheckURL := fmt.Sprintf("http://localhost:%d/healthz", receiverPort)
 
-	// Set up the resources for testing the CloudPubSubSource.
-	pubsubClient, closePubsub := testPubsubClient(ctx, t, testProjectID)
-	topic, err := pubsubClient.CreateTopic(ctx, testTopicID)
+	// A probe stays in flight for as long as the drain lasts.
+	untrack := inFlightProbes.Add(utils.InFlightProbe{ID: "stuck"}, func() {})
+	defer untrack()
+	drained := make(chan struct{})
+	go func() {
+		ph.drain(ctx)
+		close(drained)
+	}()
+	for !fakeClock.HasWaiters() {
+		time.Sleep(10 * time.Millisecond)
+	}
+	select {
+	case <-drained:
+		t.Fatal("drain completed before its timeout")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// The drain gives up once the injected clock passes its timeout.
+	fakeClock.Step(env.DrainTimeout)
+	select {
+	case <-drained:
+	case <-time.After(5 * time.Second):
+		t.Error("drain did not complete after its timeout on the injected clock")
+	}
+}
+
+func TestProbeHelperJanitor(t *testing.T) {
+	ctx := logtest.TestContextWithLogger(t)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	env := EnvConfig{
+		DefaultTimeoutDuration: 100 * time.Millisecond,
+		MaxTimeoutDuration:     100 * time.Millisecond,
+		JanitorPeriod:          50 * time.Millisecond,
+		EvictionGracePeriod:    100 * time.Millisecond,
+		MaxConcurrentProbes:    1,
+	}
+	// The probe event never arrives, and the handler does not give up on it.
+	store := utils.NewInMemoryCorrelationStore(clock.RealClock{})
+	handler := &stuckChannelProbeHandler{
+		blockingProbeHandler: blockingProbeHandler{
+			started: make(chan struct{}, 1),
+			release: make(chan struct{}),
+			stuck:   true,
+		},
+		receivedEvents: utils.NewSyncReceivedEvents(store, "janitor"),
+	}
+	probeMetrics, err := utils.NewProbeMetrics(nil)
 	if err != nil {
-		t.Fatalf("Failed to create test topic: %v", err)
+		t.Fatal("Failed to create probe metrics:", err)
 	}
-	sub, err := pubsubClient.CreateSubscription(ctx, testSubscriptionID, pubsub.SubscriptionConfig{
-		Topic: topic,
-	})
+	inFlightProbes := utils.NewInFlightProbes()
+	ph, err := NewHelper(env, handler, nil, nil, &utils.LivenessChecker{}, utils.NewReadinessChecker(), probeMetrics, inFlightProbes, http.NewServeMux(), nil, nil, store, clock.RealClock{}, &utils.BackendChecker{}, &utils.ProbeRequests{})
 	if err != nil {
-		t.Fatalf("Failed to create test subscription: %v", err)
+		t.Fatal("Failed to create probe helper:", err)
 	}
-	// Run the test CloudPubSubSource.
-	runTestCloudPubSubSource(ctx, group, sub, receiverURL)
+	janitorDone := make(chan struct{})
+	go func() {
+		ph.runJanitor(ctx)
+		close(janitorDone)
+	}()
 
-	// Set up resources for testing the CloudStorageSource.
-	storageClient, gotCloudStorageRequest, closeStorage := testStorageClient(ctx, t)
-	// Run the test CloudStorageSource.
-	runTestCloudStorageSource(ctx, group, gotCloudStorageRequest, receiverURL)
+	stuckEvent := probeEvent("cloudpubsubsource-probe", withProbeID("stuck-probe"))
+	stuckResult := make(chan cloudevents.Result, 1)
+	go func() {
+		stuckResult <- ph.forwardFromProbe(ctx)(*stuckEvent)
+	}()
+	<-handler.started
 
-	// Run the test CloudSchedulerSource.
-	runTestCloudSchedulerSource(ctx, group, 100*time.Millisecond, receiverURL)
+	// The stuck probe times out once it is evicted, but stays in flight until
+	// its forward returns.
+	select {
+	case result := <-stuckResult:
+		var failure *FailureResult
+		if !errors.As(result, &failure) || failure.Reason != TimeoutReason {
+			t.Errorf("evicted probe got result %+v, want reason %s", result, TimeoutReason)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("evicted probe got no result")
+	}
+	if probes := inFlightProbes.List(); len(probes) != 1 || !probes[0].Evicted {
+		t.Errorf("in-flight probes got=%+v, want the evicted probe", probes)
+	}
 
-	// Run the test PingSource.
-	runTestPingSource(ctx, group, 100*time.Millisecond, receiverURL)
+	// The receiver channel of the stuck probe is evicted from the store.
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if err := store.Complete(ctx, "janitor/"+stuckEvent.ID(), "", nil); errors.Is(err, utils.ErrNotTracked) {
+			break
+		}
+	}
+	if err := store.Complete(ctx, "janitor/"+stuckEvent.ID(), "", nil); !errors.Is(err, utils.ErrNotTracked) {
+		t.Errorf("completing the evicted receiver channel got error %v, want %v", err, utils.ErrNotTracked)
+	}
 
-	// Run the test CloudAuditLogsSource.
-	runTestCloudAuditLogsSource(ctx, group, pubsubClient, receiverURL)
+	// The concurrency slot of the stuck probe is released to the next probe.
+	nextResult := make(chan cloudevents.Result, 1)
+	go func() {
+		nextResult <- ph.forwardFromProbe(ctx)(*probeEvent("cloudpubsubsource-probe", withProbeID("next-probe")))
+	}()
+	select {
+	case <-handler.started:
+	case result := <-nextResult:
+		t.Fatalf("next probe got result %+v, want it forwarded", result)
+	}
 
-	// Run the test ApiServerSource.
-	k8sClient, gotK8sAPIRequest, closeK8sAPIServer := testK8sClient(ctx, t)
-	runTestApiServerSource(ctx, group, gotK8sAPIRequest, receiverURL)
+	// The stuck forward returning stops tracking the evicted probe.
+	close(handler.release)
+	if result := <-nextResult; !cloudevents.IsACK(result) {
+		t.Errorf("next probe got result %+v, want ACK", result)
+	}
+	for deadline := time.Now().Add(time.Second); inFlightProbes.Len() > 0 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if probes := inFlightProbes.List(); len(probes) != 0 {
+		t.Errorf("in-flight probes got=%+v, want none", probes)
+	}
+	cancel()
+	<-janitorDone
+}
+
+// clockSteppingProbeHandler is a probe handler whose forwards take a given
+// duration of a fake clock.
+type clockSteppingProbeHandler struct {
+	blockingProbeHandler
+	clock *clock.FakeClock
+	step  time.Duration
+}
+
+func (h *clockSteppingProbeHandler) Forward(ctx context.Context, event cloudevents.Event) error {
+	h.clock.Step(h.step)
+	return nil
+}
+
+func TestProbeHelperFakeClockLatency(t *testing.T) {
+	ctx := logtest.TestContextWithLogger(t)
 
-	// Run the test Broker for testing Broker E2E delivery.
-	brokerCellIngressBaseURL := runTestBroker(ctx, group, receiverURL)
-	// Create the probe helper and initialize it.
 	env := EnvConfig{
-		LivenessStaleDuration:  time.Second,
-		DefaultTimeoutDuration: 2 * time.Minute,
-		MaxTimeoutDuration:     30 * time.Minute,
+		DefaultTimeoutDuration: time.Minute,
+		MaxTimeoutDuration:     time.Minute,
+		RecentResultsSize:      1,
 	}
-	ph, err := InitializeTestProbeHelper(ctx, brokerCellIngressBaseURL, testProjectID, time.Second, env, probeListener, receiverListener, storageClient, pubsubClient, k8sClient)
+	fakeClock := clock.NewFakeClock(time.Now())
+	handler := &clockSteppingProbeHandler{clock: fakeClock, step: 2500 * time.Millisecond}
+	probeMetrics, err := utils.NewProbeMetrics(nil)
+	if err != nil {
+		t.Fatal("Failed to create probe metrics:", err)
+	}
+	ph, err := NewHelper(env, handler, nil, nil, &utils.LivenessChecker{}, utils.NewReadinessChecker(), probeMetrics, utils.NewInFlightProbes(), http.NewServeMux(), nil, nil, utils.NewInMemoryCorrelationStore(clock.RealClock{}), fakeClock, &utils.BackendChecker{}, &utils.ProbeRequests{})
 	if err != nil {
 		t.Fatal("Failed to create probe helper:", err)
 	}
-	return makeProbeHelperReturn{
-		probeHelper:      ph,
-		probeURL:         probeURL,
-		livenessCheckURL: livenessCheckURL,
-		cleanup: func() {
-			closeStorage()
-			closePubsub()
-			closeK8sAPIServer()
-		},
+
+	// The latency and completion time of the probe are measured by the clock
+	// of the probe helper.
+	if result := ph.forwardFromProbe(ctx)(*probeEvent("cloudpubsubsource-probe")); !cloudevents.IsACK(result) {
+		t.Fatalf("probe got result %+v, want ACK", result)
+	}
+	results := ph.recentResults.List()
+	if len(results) != 1 {
+		t.Fatalf("recent results got=%+v, want one", results)
+	}
+	if got, want := results[0].LatencyMs, handler.step.Milliseconds(); got != want {
+		t.Errorf("latency got=%dms, want=%dms", got, want)
+	}
+	if got, want := results[0].CompletedTime, fakeClock.Now(); !got.Equal(want) {
+		t.Errorf("completed time got=%s, want=%s", got, want)
 	}
 }
 
-func assertLivenessCheckResult(t *testing.T, url string, ok bool) {
-	req, err := http.NewRequest(http.MethodGet, url, nil)
+func TestProbeHelperSourceLiveness(t *testing.T) {
+	ctx := logtest.TestContextWithLogger(t)
+
+	env := EnvConfig{
+		LivenessStaleDuration: time.Minute,
+		SourceStaleDurations: map[string]time.Duration{
+			"cloudpubsubsource":  500 * time.Millisecond,
+			"cloudstoragesource": 500 * time.Millisecond,
+		},
+	}
+	probeMetrics, err := utils.NewProbeMetrics(nil)
 	if err != nil {
-		t.Fatal("Failed to create liveness check request:", err)
+		t.Fatal("Failed to create probe metrics:", err)
 	}
-	resp, err := http.DefaultClient.Do(req)
+	livenessChecker := &utils.LivenessChecker{}
+	// The time is advanced by a fake clock rather than waited on.
+	fakeClock := clock.NewFakeClock(time.Now())
+	ph, err := NewHelper(env, &blockingProbeHandler{}, nil, nil, livenessChecker, utils.NewReadinessChecker(), probeMetrics, utils.NewInFlightProbes(), http.NewServeMux(), nil, nil, utils.NewInMemoryCorrelationStore(clock.RealClock{}), fakeClock, &utils.BackendChecker{}, &utils.ProbeRequests{})
 	if err != nil {
-		t.Log("Failed to execute liveness check:", err)
-		if ok {
-			t.Errorf("liveness check result ok got=%v, want=%v", !ok, ok)
-		}
-		return
+		t.Fatal("Failed to create probe helper:", err)
 	}
-	if ok != (resp.StatusCode == http.StatusOK) {
-		t.Log("Got liveness check status code:", resp.StatusCode)
-		t.Errorf("liveness check result ok got=%v, want=%v", !ok, ok)
+	ph.lastForwardEventTime.Set(fakeClock.Now())
+	ph.lastReceiverEventTime.Set(fakeClock.Now())
+
+	checkLiveness := func() (int, string) {
+		rec := httptest.NewRecorder()
+		livenessChecker.LivenessHandlerFunc(ctx)(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+		return rec.Code, rec.Body.String()
+	}
+	if code, body := checkLiveness(); code != http.StatusOK {
+		t.Fatalf("liveness check got=%d (%q), want=%d", code, body, http.StatusOK)
+	}
+
+	// Keep the heartbeat of the CloudPubSubSource going while the one of the
+	// CloudStorageSource stalls.
+	event := cloudevents.NewEvent()
+	event.SetID("cloudpubsubsource-probe-1234567890")
+	event.SetSource("test-source")
+	event.SetType(schemasv1.CloudPubSubMessagePublishedEventType)
+	event.SetExtension(utils.ProbeEventReceiverPathExtension, "/")
+	for i := 0; i < 20; i++ {
+		ph.receiveEvent(ctx)(event)
+		fakeClock.Step(50 * time.Millisecond)
+	}
+
+	code, body := checkLiveness()
+	if code != http.StatusServiceUnavailable {
+		t.Fatalf("liveness check got=%d, want=%d", code, http.StatusServiceUnavailable)
+	}
+	if !strings.Contains(body, "source cloudstoragesource") {
+		t.Errorf("liveness check body got=%q, want the stale cloudstoragesource", body)
+	}
+	if strings.Contains(body, "source cloudpubsubsource") {
+		t.Errorf("liveness check body got=%q, want the healthy cloudpubsubsource to be omitted", body)
 	}
 }
 
-// GetFreePortListener opens a listener on a free port.
-func GetFreePortListener() (net.Listener, error) {
-	addr, err := net.ResolveTCPAddr("tcp", "localhost:0")
+func TestProbeHelperLivenessReason(t *testing.T) {
+	ctx := logtest.TestContextWithLogger(t)
+
+	cases := []struct {
+		name       string
+		receive    bool
+		wantReason utils.LivenessReason
+		wantDetail string
+	}{{
+		name:       "stale receive",
+		wantReason: utils.LivenessStaleReceiveReason,
+		wantDetail: "receiver delay 2m0s exceeds staleness threshold 1m0s",
+	}, {
+		// The receiver keeps receiving the events of other sources.
+		name:       "source down",
+		receive:    true,
+		wantReason: utils.LivenessSourceDownReason,
+		wantDetail: "source cloudstoragesource delay 2m0s exceeds staleness threshold 1m30s",
+	}}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			env := EnvConfig{
+				LivenessStaleDuration: time.Minute,
+				SourceStaleDurations: map[string]time.Duration{
+					"cloudstoragesource": 90 * time.Second,
+				},
+			}
+			probeMetrics, err := utils.NewProbeMetrics(nil)
+			if err != nil {
+				t.Fatal("Failed to create probe metrics:", err)
+			}
+			livenessChecker := &utils.LivenessChecker{}
+			fakeClock := clock.NewFakeClock(time.Now())
+			ph, err := NewHelper(env, &blockingProbeHandler{}, nil, nil, livenessChecker, utils.NewReadinessChecker(), probeMetrics, utils.NewInFlightProbes(), http.NewServeMux(), nil, nil, utils.NewInMemoryCorrelationStore(clock.RealClock{}), fakeClock, &utils.BackendChecker{}, &utils.ProbeRequests{})
+			if err != nil {
+				t.Fatal("Failed to create probe helper:", err)
+			}
+			ph.lastReceiverEventTime.Set(fakeClock.Now())
+			ph.recordSourceEvent(*probeEvent(schemasv1.CloudStorageObjectFinalizedEventType))
+			fakeClock.Step(2 * time.Minute)
+			ph.lastForwardEventTime.Set(fakeClock.Now())
+			if tc.receive {
+				ph.lastReceiverEventTime.Set(fakeClock.Now())
+			}
+
+			rec := httptest.NewRecorder()
+			livenessChecker.LivenessHandlerFunc(ctx)(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+			if rec.Code != http.StatusServiceUnavailable {
+				t.Fatalf("liveness check got=%d, want=%d", rec.Code, http.StatusServiceUnavailable)
+			}
+			var got utils.LivenessResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Fatalf("Failed to unmarshal liveness check response %q: %v", rec.Body.String(), err)
+			}
+			if got.State != utils.LivenessStale || got.Reason != tc.wantReason || got.Detail != tc.wantDetail {
+				t.Errorf("liveness check response got=%+v, want state=%s reason=%s detail=%q", got, utils.LivenessStale, tc.wantReason, tc.wantDetail)
+			}
+		})
+	}
+}
+
+func TestProbeHelperBackendLiveness(t *testing.T) {
+	ctx := logtest.TestContextWithLogger(t)
+	probeMetrics, err := utils.NewProbeMetrics(nil)
 	if err != nil {
-		return nil, err
+		t.Fatal("Failed to create probe metrics:", err)
+	}
+	livenessChecker := &utils.LivenessChecker{}
+	backendChecker := utils.NewBackendChecker(time.Hour, clock.RealClock{})
+	backendChecker.Register(PubSubBackend, func(ctx context.Context) error {
+		return errors.New("permission denied")
+	})
+	env := EnvConfig{LivenessStaleDuration: time.Minute, BackendLiveness: true}
+	ph, err := NewHelper(env, &blockingProbeHandler{}, nil, nil, livenessChecker, utils.NewReadinessChecker(), probeMetrics, utils.NewInFlightProbes(), http.NewServeMux(), nil, nil, utils.NewInMemoryCorrelationStore(clock.RealClock{}), clock.RealClock{}, backendChecker, &utils.ProbeRequests{})
+	if err != nil {
+		t.Fatal("Failed to create probe helper:", err)
+	}
+	ph.lastForwardEventTime.Set(time.Now())
+	ph.lastReceiverEventTime.Set(time.Now())
+
+	// Check the backends once.
+	checkCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	backendChecker.Run(checkCtx)
+
+	rec := httptest.NewRecorder()
+	livenessChecker.LivenessHandlerFunc(ctx)(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("liveness check got=%d, want=%d", rec.Code, http.StatusServiceUnavailable)
+	}
+	var got utils.LivenessResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("Failed to unmarshal liveness check response %q: %v", rec.Body.String(), err)
+	}
+	if want := "backend pubsub is unreachable: permission denied"; got.Reason != utils.LivenessBackendUnreachableReason || got.Detail != want {
+		t.Errorf("liveness check response got=%+v, want reason=%s detail=%q", got, utils.LivenessBackendUnreachableReason, want)
 	}
-	return net.ListenTCP("tcp", addr)
 }
 
-func TestProbeHelperLiveness(t *testing.T) {
+func TestLoopbackPool(t *testing.T) {
+	const poolSize = 2
+	started := make(chan struct{}, 2*poolSize)
+	release := make(chan struct{})
+	var mu sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	receiver := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		mu.Unlock()
+		started <- struct{}{}
+		<-release
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+		rw.WriteHeader(http.StatusAccepted)
+	}))
+	defer receiver.Close()
+
 	ctx := logtest.TestContextWithLogger(t)
 	group, ctx := errgroup.WithContext(ctx)
 	ctx, cancel := context.WithCancel(ctx)
-	defer cancel()
+	pool := runLoopbackPool(ctx, group, poolSize, receiver.URL)
 
-	phr := makeProbeHelper(ctx, t, group)
-	go phr.probeHelper.Run(ctx)
+	// Queue twice as many loopbacks as there are workers.
+	queued := make(chan struct{})
+	go func() {
+		for i := 0; i < 2*poolSize; i++ {
+			event := cloudevents.NewEvent()
+			event.SetID(fmt.Sprintf("loopback-%d", i))
+			event.SetSource("test")
+			event.SetType("test-loopback")
+			pool.send(ctx, event, "test loopback CloudEvent")
+		}
+		close(queued)
+	}()
 
-	// Make sure the liveness checker is up.
-	time.Sleep(500 * time.Millisecond)
-	assertLivenessCheckResult(t, phr.livenessCheckURL, true)
+	// The loopbacks are sent in parallel up to the pool size.
+	for i := 0; i < poolSize; i++ {
+		select {
+		case <-started:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("wanted %d loopbacks in parallel, got %d", poolSize, i)
+		}
+	}
+	select {
+	case <-started:
+		t.Fatalf("wanted at most %d loopbacks in parallel, got more", poolSize)
+	case <-time.After(100 * time.Millisecond):
+	}
 
-	// Guarantee that it has been long enough that the stale duration has been reached. This will cause
-	// the liveness checker to fail.
-	time.Sleep(2 * phr.probeHelper.env.LivenessStaleDuration)
-	assertLivenessCheckResult(t, phr.livenessCheckURL, false)
+	// The queued loopbacks are sent once the workers are released.
+	close(release)
+	<-queued
+	for i := 0; i < poolSize; i++ {
+		<-started
+	}
+	mu.Lock()
+	if maxInFlight != poolSize {
+		t.Errorf("wanted at most %d loopbacks in flight, got %d", poolSize, maxInFlight)
+	}
+	mu.Unlock()
 
-	// Cancel gracefully to avoid logger panic if parent goroutine terminates.
-	phr.cleanup()
+	// The workers shut down once the context is cancelled.
 	cancel()
 	if err := group.Wait(); err != nil {
-		t.Fatalf("Error in probe helper fake sources: %v", err)
+		t.Fatalf("Error in loopback pool: %v", err)
 	}
 }
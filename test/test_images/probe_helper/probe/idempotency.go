@@ -0,0 +1,71 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package probe
+
+import (
+	"sync"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+)
+
+const (
+	// The idempotencykey extension identifies the submissions of the same
+	// probe, such as the retries of an external scheduler, which share a
+	// single forward.
+	idempotencyKeyExtension = "idempotencykey"
+)
+
+// idempotentProbe is a probe in flight to which the later submissions with the
+// same idempotency key are attached.
+type idempotentProbe struct {
+	// done is closed once the result of the probe is known.
+	done   chan struct{}
+	result cloudevents.Result
+}
+
+// idempotentProbes is a synchronized set of the probes in flight, keyed by
+// their idempotency key.
+type idempotentProbes struct {
+	sync.Mutex
+	probes map[string]*idempotentProbe
+}
+
+// attach returns the probe in flight with a given idempotency key, or starts
+// tracking a new one if there is none, in which case the caller is the one
+// forwarding it and must complete it.
+func (p *idempotentProbes) attach(key string) (*idempotentProbe, bool) {
+	p.Lock()
+	defer p.Unlock()
+
+	if probe, ok := p.probes[key]; ok {
+		return probe, false
+	}
+	probe := &idempotentProbe{done: make(chan struct{})}
+	p.probes[key] = probe
+	return probe, true
+}
+
+// complete stops tracking the probe with a given idempotency key, and releases
+// the submissions attached to it with its result.
+func (p *idempotentProbes) complete(key string, probe *idempotentProbe, result cloudevents.Result) {
+	p.Lock()
+	defer p.Unlock()
+
+	delete(p.probes, key)
+	probe.result = result
+	close(probe.done)
+}
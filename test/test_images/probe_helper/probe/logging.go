@@ -0,0 +1,54 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package probe
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"knative.dev/pkg/logging"
+)
+
+const (
+	// JSONLogFormat encodes each log entry as a JSON object.
+	JSONLogFormat = "json"
+	// ConsoleLogFormat encodes each log entry as human readable text.
+	ConsoleLogFormat = "console"
+
+	// LoggerName is the name of the probe helper logger.
+	LoggerName = "probe-helper"
+)
+
+// NewLoggingConfig returns the config of the probe helper logger, which logs
+// at debug level in a given format. The JSON format is used if none is given.
+func NewLoggingConfig(format string) (*logging.Config, error) {
+	switch format {
+	case "":
+		format = JSONLogFormat
+	case JSONLogFormat, ConsoleLogFormat:
+	default:
+		return nil, fmt.Errorf("unsupported log format %q", format)
+	}
+	zapLoggerConfig, err := json.Marshal(map[string]string{"encoding": format})
+	if err != nil {
+		return nil, err
+	}
+	return logging.NewConfigFromMap(map[string]string{
+		"loglevel." + LoggerName: "debug",
+		"zap-logger-config":      string(zapLoggerConfig),
+	})
+}
@@ -0,0 +1,32 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package probe
+
+import (
+	"testing"
+)
+
+func TestNewLoggingConfig(t *testing.T) {
+	for _, format := range []string{"", JSONLogFormat, ConsoleLogFormat} {
+		if _, err := NewLoggingConfig(format); err != nil {
+			t.Errorf("NewLoggingConfig(%q) got error: %v", format, err)
+		}
+	}
+	if _, err := NewLoggingConfig("xml"); err == nil {
+		t.Error("NewLoggingConfig(\"xml\") got no error, want error")
+	}
+}
@@ -0,0 +1,69 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package probe
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+)
+
+const (
+	// The payloadbytes extension makes the probe helper replace the data of a
+	// probe event with a generated payload of the given size in bytes, e.g. to
+	// test the size limits of a Broker.
+	payloadBytesExtension = "payloadbytes"
+
+	// payloadFiller is the byte which generated payloads are made of.
+	payloadFiller = 'x'
+)
+
+// withGeneratedPayload returns the probe event with a generated payload of the
+// size requested in its payloadbytes extension, or the probe event unchanged
+// if it has no such extension.
+func withGeneratedPayload(event cloudevents.Event) (cloudevents.Event, error) {
+	value, ok := event.Extensions()[payloadBytesExtension]
+	if !ok {
+		return event, nil
+	}
+	size, err := strconv.Atoi(fmt.Sprint(value))
+	if err != nil {
+		return event, fmt.Errorf("failed to parse %s extension: %v", payloadBytesExtension, err)
+	}
+	if size < 0 {
+		return event, fmt.Errorf("%s extension %d is negative", payloadBytesExtension, size)
+	}
+	event = event.Clone()
+	if err := event.SetData(cloudevents.TextPlain, bytes.Repeat([]byte{payloadFiller}, size)); err != nil {
+		return event, err
+	}
+	return event, nil
+}
+
+// checkPayloadSize ensures that the data of an event does not exceed
+// MAX_PROBE_PAYLOAD_BYTES, if set.
+func (ph *Helper) checkPayloadSize(event cloudevents.Event) error {
+	if ph.env.MaxProbePayloadBytes <= 0 {
+		return nil
+	}
+	if size := len(event.Data()); size > ph.env.MaxProbePayloadBytes {
+		return fmt.Errorf("payload of %d bytes exceeds the maximum of %d bytes", size, ph.env.MaxProbePayloadBytes)
+	}
+	return nil
+}
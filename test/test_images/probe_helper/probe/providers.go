@@ -18,7 +18,11 @@ package probe
 
 import (
 	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
 	"net/http"
+	"time"
 
 	"cloud.google.com/go/pubsub"
 	"cloud.google.com/go/storage"
@@ -26,6 +30,9 @@ import (
 	cloudevents "github.com/cloudevents/sdk-go/v2"
 	cehttp "github.com/cloudevents/sdk-go/v2/protocol/http"
 	"github.com/google/wire"
+	"golang.org/x/sync/semaphore"
+	"google.golang.org/api/option"
+	"k8s.io/apimachinery/pkg/util/clock"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/clientcmd"
 
@@ -36,58 +43,269 @@ import (
 
 var HelperSet wire.ProviderSet = wire.NewSet(
 	NewHelper,
+	NewGCPClientOptions,
 	NewPubSubClient,
 	NewCePubSubClient,
 	NewK8sClient,
 	NewStorageClient,
 	NewCeForwardClient,
+	NewCeForwardSender,
 	NewCeReceiverClient,
 	NewCeReceiverClientOptions,
 	NewCeForwardClientOptions,
+	NewReceiverTLSConfig,
+	NewProbeGRPCListener,
+	NewReceiverMux,
+	NewProbeMetrics,
+	NewAuditLogsPollInterval,
+	NewCorrelationStore,
+	NewClock,
+	NewBackendChecker,
+	utils.NewReadinessChecker,
+	utils.NewInFlightProbes,
+	utils.NewProbeRequests,
 )
 
-func NewHelper(env EnvConfig, handler handlers.Interface, ceForwardClient handlers.CeForwardClient, ceReceiveClient handlers.CeReceiveClient, livenessCheker *utils.LivenessChecker) *Helper {
+func NewHelper(env EnvConfig, handler handlers.Interface, ceForwardClient handlers.CeForwardClient, ceReceiveClient handlers.CeReceiveClient, livenessCheker *utils.LivenessChecker, readinessChecker *utils.ReadinessChecker, probeMetrics *utils.ProbeMetrics, inFlightProbes *utils.InFlightProbes, receiverMux *http.ServeMux, receiverTLSConfig *tls.Config, probeGRPCListener ProbeGRPCListener, correlationStore utils.CorrelationStore, clock clock.Clock, backendChecker *utils.BackendChecker, probeRequests *utils.ProbeRequests) (*Helper, error) {
+	if err := validateRole(env.Role); err != nil {
+		return nil, err
+	}
+	if err := validateUnmatchedEventPolicy(env.UnmatchedEventPolicy); err != nil {
+		return nil, err
+	}
+	if env.MaxEventTimeSkew < 0 {
+		return nil, fmt.Errorf("invalid max event time skew %s, it must not be negative", env.MaxEventTimeSkew)
+	}
+	if env.RecentResultsSize < 0 {
+		return nil, fmt.Errorf("invalid recent results size %d, it must not be negative", env.RecentResultsSize)
+	}
+	retryBudget, err := newRetryBudget(env.RetryBudgetSize, env.RetryBudgetRefillRate, clock)
+	if err != nil {
+		return nil, err
+	}
 	ph := &Helper{
-		env:             env,
-		probeHandler:    handler,
-		ceForwardClient: ceForwardClient,
-		ceReceiveClient: ceReceiveClient,
-		livenessChecker: livenessCheker,
-	}
-	ph.lastForwardEventTime.SetNow()
-	ph.lastReceiverEventTime.SetNow()
+		env:               env,
+		probeHandler:      handler,
+		ceForwardClient:   ceForwardClient,
+		ceReceiveClient:   ceReceiveClient,
+		livenessChecker:   livenessCheker,
+		readinessChecker:  readinessChecker,
+		metrics:           probeMetrics,
+		inFlightProbes:    inFlightProbes,
+		receiverMux:       receiverMux,
+		receiverTLS:       receiverTLSConfig,
+		probeGRPCListener: probeGRPCListener,
+		correlationStore:  correlationStore,
+		clock:             clock,
+		backendChecker:    backendChecker,
+		probeRequests:     probeRequests,
+		drainStarted:      make(chan struct{}),
+		idempotentProbes:  idempotentProbes{probes: map[string]*idempotentProbe{}},
+		probeTypes:        probeTypes{max: env.MaxProbeTypes, types: map[string]struct{}{}},
+		recentResults:     utils.NewRecentResults(env.RecentResultsSize),
+		retryBudget:       retryBudget,
+		eventIdentity:     newEventIdentity(env.ProbeSourceID),
+	}
+	resultSinkClient, err := newResultSinkClient(env.ResultSink)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create result sink client: %v", err)
+	}
+	ph.resultSinkClient = resultSinkClient
+	if env.MaxConcurrentProbes > 0 {
+		ph.probeSemaphore = semaphore.NewWeighted(int64(env.MaxConcurrentProbes))
+	}
+	// The receiver event time is only set once the receiver receives its first
+	// event, before which the probe helper is starting.
+	ph.lastForwardEventTime.Set(clock.Now())
+	ph.lastSourceEventTimes.Times = make(map[string]time.Time, len(env.SourceStaleDurations))
+	for source := range env.SourceStaleDurations {
+		ph.lastSourceEventTimes.Times[source] = clock.Now()
+	}
 	ph.livenessChecker.AddActionFunc(ph.CheckLastEventTimes())
-	return ph
-}
-
-func NewCeReceiverClient(ctx context.Context, livenessChecker *utils.LivenessChecker, opts ReceiveClientOptions) (handlers.CeReceiveClient, error) {
-	injectReceiverPath := cloudevents.WithMiddleware(func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
-			req.Header.Set(utils.ProbeEventReceiverPathHeader, req.URL.Path)
-			next.ServeHTTP(rw, req)
-		})
-	})
-	livenessCheck := cloudevents.WithGetHandlerFunc(livenessChecker.LivenessHandlerFunc(ctx))
-	opts = append(opts, injectReceiverPath)
-	opts = append(opts, livenessCheck)
+	ph.livenessChecker.AddActionFunc(ph.CheckSourceEventTimes())
+	ph.livenessChecker.AddActionFunc(ph.CheckNotDraining())
+	if env.BackendLiveness {
+		ph.livenessChecker.AddActionFunc(backendChecker.CheckBackends())
+	}
+	if ph.runsForwarder() {
+		ph.readinessChecker.Register(forwarderComponent)
+	}
+	if ph.runsReceiver() {
+		ph.readinessChecker.Register(receiverComponent)
+	}
+	// The effective configuration is served for debugging, with its secrets redacted.
+	receiverMux.HandleFunc(debugConfigPath, ph.configHandlerFunc())
+	// The results of the last completed probes are served for triage.
+	receiverMux.HandleFunc(debugRecentPath, ph.recentResults.ResultsHandlerFunc())
+	// The connectivity to the backends is served for triage.
+	receiverMux.HandleFunc(debugBackendsPath, backendChecker.BackendsHandlerFunc())
+	// The metrics are served by the receiver client unless a dedicated port is configured.
+	if env.MetricsPort == 0 {
+		receiverMux.Handle(metricsPath, probeMetrics.Handler())
+	}
+	return ph, nil
+}
+
+// NewReceiverMux creates the multiplexer which serves the GET requests made
+// to the receiver client, such as liveness and readiness checks.
+func NewReceiverMux(ctx context.Context, livenessChecker *utils.LivenessChecker, readinessChecker *utils.ReadinessChecker, inFlightProbes *utils.InFlightProbes) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", livenessChecker.LivenessHandlerFunc(ctx))
+	mux.HandleFunc("/readyz", readinessChecker.ReadinessHandlerFunc(ctx))
+	mux.HandleFunc(debugProbesPath, inFlightProbes.ProbesHandlerFunc(ctx))
+	return mux
+}
+
+func NewProbeMetrics(env EnvConfig) (*utils.ProbeMetrics, error) {
+	return utils.NewProbeMetrics(env.LatencyBuckets, utils.WithLabelAllowlist(env.MetricsBrokerAllowlist, env.MetricsTopicAllowlist))
+}
+
+// NewAuditLogsPollInterval validates the interval at which the
+// CloudAuditLogsSource probes poll the existence of the topics they delete.
+func NewAuditLogsPollInterval(env EnvConfig) (handlers.AuditLogsPollInterval, error) {
+	if env.AuditLogsPollInterval <= 0 {
+		return 0, fmt.Errorf("invalid audit logs poll interval %s, it must be positive", env.AuditLogsPollInterval)
+	}
+	return handlers.AuditLogsPollInterval(env.AuditLogsPollInterval), nil
+}
+
+// NewClock returns the real clock, with which the probe helper and the
+// periodic source probes time the events.
+func NewClock() clock.Clock {
+	return clock.RealClock{}
+}
+
+const (
+	// InMemoryCorrelationStore tracks the probes in the memory of the probe
+	// helper.
+	InMemoryCorrelationStore = "memory"
+	// RedisCorrelationStore tracks the probes in Redis, so that they can be
+	// shared between probe helpers.
+	RedisCorrelationStore = "redis"
+)
+
+// NewCorrelationStore creates the store in which the probes are tracked, either
+// in memory or in Redis. The keys of the probes tracked in Redis expire once
+// the probes would have been evicted.
+func NewCorrelationStore(env EnvConfig, clock clock.Clock) (utils.CorrelationStore, error) {
+	switch env.CorrelationStore {
+	case "", InMemoryCorrelationStore:
+		return utils.NewInMemoryCorrelationStore(clock), nil
+	case RedisCorrelationStore:
+		if env.RedisAddress == "" {
+			return nil, fmt.Errorf("the Redis correlation store requires a Redis address")
+		}
+		if env.RedisPollInterval <= 0 {
+			return nil, fmt.Errorf("invalid Redis poll interval %s, it must be positive", env.RedisPollInterval)
+		}
+		return utils.NewRedisCorrelationStore(env.RedisAddress, env.MaxTimeoutDuration+env.EvictionGracePeriod, env.RedisPollInterval), nil
+	default:
+		return nil, fmt.Errorf("unsupported correlation store %q", env.CorrelationStore)
+	}
+}
+
+func NewCeReceiverClient(ctx context.Context, env EnvConfig, receiverMux *http.ServeMux, opts ReceiveClientOptions) (handlers.CeReceiveClient, error) {
+	clientOpts, err := contentModeClientOptions(env.ReceiverContentMode)
+	if err != nil {
+		return nil, err
+	}
+	getHandler := cloudevents.WithGetHandlerFunc(receiverMux.ServeHTTP)
+	opts = append(opts, cloudevents.WithMiddleware(receiverMiddleware(env.ReceiverContentMode, env.ReceiverPathPrefix)))
+	opts = append(opts, getHandler)
 	rp, err := cloudevents.NewHTTP(opts...)
 	if err != nil {
 		return nil, err
 	}
-	return cloudevents.NewClient(rp)
+	return cloudevents.NewClient(rp, clientOpts...)
 }
 
-func NewCeForwardClient(opts ForwardClientOptions) (handlers.CeForwardClient, error) {
+func NewCeForwardClient(env EnvConfig, opts ForwardClientOptions, probeMetrics *utils.ProbeMetrics, probeRequests *utils.ProbeRequests) (handlers.CeForwardClient, error) {
+	clientOpts, err := contentModeClientOptions(env.ForwardContentMode)
+	if err != nil {
+		return nil, err
+	}
+	transportOpts, err := forwardTransportOptions(env)
+	if err != nil {
+		return nil, err
+	}
+	if env.MaxBatchSize < 0 {
+		return nil, fmt.Errorf("invalid maximum batch size %d", env.MaxBatchSize)
+	}
+	if env.BatchConcurrency < 0 {
+		return nil, fmt.Errorf("invalid batch concurrency %d", env.BatchConcurrency)
+	}
+	opts = append(opts, transportOpts...)
+	opts = append(opts, cehttp.WithMiddleware(retryAfterMiddleware(probeMetrics)))
+	opts = append(opts, cehttp.WithMiddleware(requestContextMiddleware(probeRequests)))
+	// The batches are split outside of the other middleware, which apply to
+	// each of their probes.
+	opts = append(opts, cehttp.WithMiddleware(batchMiddleware(env.MaxBatchSize, env.BatchConcurrency)))
 	sp, err := cloudevents.NewHTTP(opts...)
 	if err != nil {
 		return nil, err
 	}
-	return cloudevents.NewClient(sp)
+	return cloudevents.NewClient(sp, clientOpts...)
 }
 
-func NewCeReceiverClientOptions(port ReceivePort) ReceiveClientOptions {
-	opts := []cehttp.Option{cloudevents.WithPort(int(port))}
-	return opts
+// NewCeForwardSender creates the sender of the messages which the forward
+// client cannot send, such as malformed events. It shares the transport
+// settings of the forward client, but does not listen for probe requests.
+func NewCeForwardSender(env EnvConfig) (handlers.CeForwardSender, error) {
+	opts, err := forwardTransportOptions(env)
+	if err != nil {
+		return nil, err
+	}
+	return cehttp.New(opts...)
+}
+
+// forwardTransportOptions returns the options which set the transport of the
+// forward client, if any is configured.
+func forwardTransportOptions(env EnvConfig) ([]cehttp.Option, error) {
+	tlsConfig, err := forwardTLSConfig(env)
+	if err != nil {
+		return nil, err
+	}
+	transport, err := forwardTransport(env, tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	// The retries of the probes are taken from the retry budget if there is one.
+	if env.RetryBudgetSize > 0 {
+		if transport == nil {
+			transport = http.DefaultTransport
+		}
+		transport = retryBudgetTransport{next: transport}
+	}
+	if transport == nil {
+		return nil, nil
+	}
+	return []cehttp.Option{cehttp.WithRoundTripper(transport)}, nil
+}
+
+func NewCeReceiverClientOptions(port ReceivePort, tlsConfig *tls.Config) (ReceiveClientOptions, error) {
+	if tlsConfig == nil {
+		opts := []cehttp.Option{cloudevents.WithPort(int(port))}
+		return opts, nil
+	}
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return nil, err
+	}
+	opts := []cehttp.Option{cloudevents.WithListener(receiverListener(listener, tlsConfig))}
+	return opts, nil
+}
+
+// NewProbeGRPCListener listens on the probe port for the gRPC server if the
+// probe requests are accepted over gRPC, in which case the forward client does
+// not listen on it.
+func NewProbeGRPCListener(env EnvConfig, port ForwardPort) (ProbeGRPCListener, error) {
+	if err := validateProbeProtocol(env.ProbeProtocol); err != nil {
+		return nil, err
+	}
+	if env.ProbeProtocol != GRPCProbeProtocol {
+		return nil, nil
+	}
+	return net.Listen("tcp", fmt.Sprintf(":%d", port))
 }
 
 func NewCeForwardClientOptions(port ForwardPort) ForwardClientOptions {
@@ -103,12 +321,27 @@ func NewCePubSubClient(ctx context.Context, pc *pubsub.Client) (handlers.CePubSu
 	return cloudevents.NewClient(pst)
 }
 
-func NewPubSubClient(ctx context.Context, projectID clients.ProjectID) (c *pubsub.Client, err error) {
-	return pubsub.NewClient(ctx, string(projectID))
+// NewGCPClientOptions returns the options which authenticate the clients of
+// the GCP services with the configured credentials file, impersonating the
+// configured service account, if any. Without either, the clients fall back to
+// the Application Default Credentials, e.g. those of the workload identity.
+func NewGCPClientOptions(env EnvConfig) GCPClientOptions {
+	var opts GCPClientOptions
+	if env.CredentialsFile != "" {
+		opts = append(opts, option.WithCredentialsFile(env.CredentialsFile))
+	}
+	if env.ImpersonateServiceAccount != "" {
+		opts = append(opts, option.ImpersonateCredentials(env.ImpersonateServiceAccount))
+	}
+	return opts
+}
+
+func NewPubSubClient(ctx context.Context, projectID clients.ProjectID, opts GCPClientOptions) (c *pubsub.Client, err error) {
+	return pubsub.NewClient(ctx, string(projectID), opts...)
 }
 
-func NewStorageClient(ctx context.Context) (c *storage.Client, err error) {
-	return storage.NewClient(ctx)
+func NewStorageClient(ctx context.Context, opts GCPClientOptions) (c *storage.Client, err error) {
+	return storage.NewClient(ctx, opts...)
 }
 
 func NewK8sClient(ctx context.Context) (c kubernetes.Interface, err error) {
@@ -124,3 +357,4 @@ type ForwardPort int
 type ReceivePort int
 type ForwardClientOptions []cehttp.Option
 type ReceiveClientOptions []cehttp.Option
+type GCPClientOptions []option.ClientOption
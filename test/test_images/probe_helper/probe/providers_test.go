@@ -0,0 +1,106 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package probe
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"google.golang.org/api/option"
+
+	logtest "knative.dev/pkg/logging/testing"
+
+	"k8s.io/apimachinery/pkg/util/clock"
+)
+
+func TestNewCorrelationStoreInvalidConfig(t *testing.T) {
+	for _, env := range []EnvConfig{
+		{CorrelationStore: "etcd"},
+		{CorrelationStore: RedisCorrelationStore, RedisPollInterval: time.Second},
+		{CorrelationStore: RedisCorrelationStore, RedisAddress: "localhost:6379"},
+	} {
+		if _, err := NewCorrelationStore(env, clock.RealClock{}); err == nil {
+			t.Errorf("NewCorrelationStore(%+v) got no error, want error", env)
+		}
+	}
+}
+
+func TestGCPClientOptions(t *testing.T) {
+	ctx := logtest.TestContextWithLogger(t)
+	dir := t.TempDir()
+	credentialsFile := filepath.Join(dir, "credentials.json")
+	credentials := `{"type":"authorized_user","client_id":"test-client","client_secret":"test-secret","refresh_token":"test-token"}`
+	if err := ioutil.WriteFile(credentialsFile, []byte(credentials), 0600); err != nil {
+		t.Fatal("Failed to write credentials file:", err)
+	}
+	const serviceAccount = "probe-helper@test-project-id.iam.gserviceaccount.com"
+
+	cases := []struct {
+		name     string
+		env      EnvConfig
+		wantOpts GCPClientOptions
+		wantErr  bool
+	}{{
+		name: "application default credentials",
+		env:  EnvConfig{},
+	}, {
+		name:     "credentials file",
+		env:      EnvConfig{CredentialsFile: credentialsFile},
+		wantOpts: GCPClientOptions{option.WithCredentialsFile(credentialsFile)},
+	}, {
+		name:     "impersonated service account",
+		env:      EnvConfig{CredentialsFile: credentialsFile, ImpersonateServiceAccount: serviceAccount},
+		wantOpts: GCPClientOptions{option.WithCredentialsFile(credentialsFile), option.ImpersonateCredentials(serviceAccount)},
+	}, {
+		name:     "missing credentials file",
+		env:      EnvConfig{CredentialsFile: filepath.Join(dir, "missing.json")},
+		wantOpts: GCPClientOptions{option.WithCredentialsFile(filepath.Join(dir, "missing.json"))},
+		wantErr:  true,
+	}}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := NewGCPClientOptions(tc.env)
+			if !reflect.DeepEqual(opts, tc.wantOpts) {
+				t.Errorf("NewGCPClientOptions(%+v) got=%v, want=%v", tc.env, opts, tc.wantOpts)
+			}
+			// The Application Default Credentials are not available in tests.
+			if len(opts) == 0 {
+				return
+			}
+
+			// The clients are built with the credentials of the options, so
+			// they fail to build when the credentials file is missing.
+			storageClient, err := NewStorageClient(ctx, opts)
+			if (err != nil) != tc.wantErr {
+				t.Errorf("NewStorageClient got error %v, want error %v", err, tc.wantErr)
+			}
+			if err == nil {
+				storageClient.Close()
+			}
+			pubsubClient, err := NewPubSubClient(ctx, testProjectID, opts)
+			if (err != nil) != tc.wantErr {
+				t.Errorf("NewPubSubClient got error %v, want error %v", err, tc.wantErr)
+			}
+			if err == nil {
+				pubsubClient.Close()
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package probe
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+
+	"github.com/google/knative-gcp/test/test_images/probe_helper/probe/handlers"
+	"github.com/google/knative-gcp/test/test_images/probe_helper/utils"
+)
+
+// normalizeReceiverPathPrefix returns the receiver path prefix in its canonical
+// form, which starts with a slash and only ends with one if it is the root.
+func normalizeReceiverPathPrefix(prefix string) string {
+	return path.Clean("/" + prefix)
+}
+
+// isUnderReceiverPathPrefix returns whether a path lies under the receiver
+// path prefix, i.e. is the prefix itself or one of its subpaths.
+func isUnderReceiverPathPrefix(prefix, p string) bool {
+	prefix = normalizeReceiverPathPrefix(prefix)
+	if prefix == "/" {
+		return strings.HasPrefix(p, "/")
+	}
+	return p == prefix || strings.HasPrefix(p, prefix+"/")
+}
+
+// validateTargetPath ensures that the target path of a probe event is a clean
+// path under the receiver path prefix. Paths with '.' or '..' segments are
+// rejected, since they may escape the prefix.
+func validateTargetPath(prefix, targetPath string) error {
+	if path.Clean(targetPath) != targetPath {
+		return fmt.Errorf("target path %q is not clean", targetPath)
+	}
+	if !isUnderReceiverPathPrefix(prefix, targetPath) {
+		return fmt.Errorf("target path %q is not under the receiver path prefix %q", targetPath, normalizeReceiverPathPrefix(prefix))
+	}
+	return nil
+}
+
+// sourceReceiverPath returns the path along which a source delivers the
+// events of the probes with a given target path when each source has its own
+// path, i.e. the target path followed by the name of the source.
+func sourceReceiverPath(targetPath, source string) string {
+	return path.Join(targetPath, source)
+}
+
+// routeSourceEvent checks that an event delivered by a source was delivered
+// along the path of that source, and restores the target path of its probe as
+// its receiver path. Events delivered along the path of another source, or
+// along no source path at all, match no probe in flight. The events which are
+// not delivered by a source are left as they are.
+func routeSourceEvent(event cloudevents.Event) (cloudevents.Event, error) {
+	source, ok := handlers.ReceiveSource(event.Type())
+	if !ok || !handlers.IsForwardSource(source) {
+		return event, nil
+	}
+	receiverPath := fmt.Sprint(event.Extensions()[utils.ProbeEventReceiverPathExtension])
+	if path.Base(receiverPath) != source {
+		return event, fmt.Errorf("%w: %s event delivered along path %q, want a path ending in '/%s'", utils.ErrNotTracked, source, receiverPath, source)
+	}
+	routed := event.Clone()
+	routed.SetExtension(utils.ProbeEventReceiverPathExtension, path.Dir(receiverPath))
+	return routed, nil
+}
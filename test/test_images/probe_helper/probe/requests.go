@@ -0,0 +1,112 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package probe
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	cehttp "github.com/cloudevents/sdk-go/v2/protocol/http"
+	"github.com/cloudevents/sdk-go/v2/types"
+
+	"github.com/google/knative-gcp/test/test_images/probe_helper/utils"
+)
+
+// requestContextMiddleware registers the context of each probe request while
+// it is served, and sets the token of the request on its probe event, so that
+// the probe is bounded by the deadline and cancellation of its own request even
+// when other requests submit probes with the same ID.
+func requestContextMiddleware(requests *utils.ProbeRequests) cehttp.Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if req.Method == http.MethodPost {
+				token, unregister := requests.Register(req.Context())
+				defer unregister()
+				setRequestToken(req, token)
+			}
+			next.ServeHTTP(w, req)
+		})
+	}
+}
+
+// setRequestToken sets the token of an HTTP request on the CloudEvent it
+// carries. The body of the structured content mode requests is rewritten, and
+// left as is if it is not a JSON object.
+func setRequestToken(req *http.Request, token string) {
+	if !isStructuredRequest(req) {
+		req.Header.Set("Ce-"+utils.ProbeEventRequestExtension, token)
+		return
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return
+	}
+	var event map[string]json.RawMessage
+	// A malformed event is rejected by the next handler.
+	if err := json.Unmarshal(body, &event); err == nil && event != nil {
+		event[utils.ProbeEventRequestExtension], _ = json.Marshal(token)
+		if rewritten, err := json.Marshal(event); err == nil {
+			body = rewritten
+		}
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+}
+
+// withRequestContext returns a copy of the context which is cancelled once the
+// context of the request submitting the probe is done, and whose deadline is
+// the earliest of the two.
+func withRequestContext(ctx, reqCtx context.Context) (context.Context, context.CancelFunc) {
+	var cancel context.CancelFunc
+	if deadline, ok := reqCtx.Deadline(); ok {
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	go func() {
+		select {
+		case <-reqCtx.Done():
+			// A request past its deadline times the probe out on its own.
+			if reqCtx.Err() == context.Canceled {
+				cancel()
+			}
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// withProbeRequestContext bounds the context of a probe by the context of the
+// request submitting it, if it is known, and removes the token of the request
+// from the probe event.
+func (ph *Helper) withProbeRequestContext(ctx context.Context, event *cloudevents.Event) (context.Context, context.CancelFunc) {
+	value, ok := event.Extensions()[utils.ProbeEventRequestExtension]
+	if !ok {
+		return ctx, func() {}
+	}
+	event.SetExtension(utils.ProbeEventRequestExtension, nil)
+	token, _ := types.ToString(value)
+	reqCtx, ok := ph.probeRequests.Context(token)
+	if !ok {
+		return ctx, func() {}
+	}
+	return withRequestContext(ctx, reqCtx)
+}
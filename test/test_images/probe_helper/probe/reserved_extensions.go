@@ -0,0 +1,59 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package probe
+
+import (
+	"fmt"
+	"sort"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+)
+
+// reservedExtensionNames are the names of the CloudEvent context attributes,
+// including those of the 0.3 spec version, and of the data of the event. An
+// extension with such a name is either dropped or overrides the attribute on
+// the way to the receiver, depending on the protocol and content mode which
+// carry it, so that the event delivered back does not match its probe.
+var reservedExtensionNames = map[string]struct{}{
+	"id":                  {},
+	"source":              {},
+	"specversion":         {},
+	"type":                {},
+	"datacontenttype":     {},
+	"dataschema":          {},
+	"subject":             {},
+	"time":                {},
+	"data":                {},
+	"schemaurl":           {},
+	"datacontentencoding": {},
+}
+
+// checkReservedExtensions ensures that a probe event sets no extension with a
+// reserved name.
+func checkReservedExtensions(event cloudevents.Event) error {
+	var reserved []string
+	for name := range event.Extensions() {
+		if _, ok := reservedExtensionNames[name]; ok {
+			reserved = append(reserved, name)
+		}
+	}
+	if len(reserved) == 0 {
+		return nil
+	}
+	sort.Strings(reserved)
+	return fmt.Errorf("probe event sets extensions %q with the reserved names of CloudEvent attributes", reserved)
+}
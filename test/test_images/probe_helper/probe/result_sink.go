@@ -0,0 +1,80 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package probe
+
+import (
+	"context"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"knative.dev/pkg/logging"
+)
+
+const (
+	// ResultEventType is the type of the events sent to RESULT_SINK for every
+	// completed probe.
+	ResultEventType = "com.google.knative-gcp.probe.result"
+
+	// The extensions of the result events, which describe the completed probe.
+	resultProbeTypeExtension = "probetype"
+	resultProbeIDExtension   = "probeid"
+	resultExtension          = "result"
+	resultLatencyExtension   = "latencyms"
+
+	// resultSinkTimeout bounds the time spent sending a result event.
+	resultSinkTimeout = 10 * time.Second
+)
+
+// newResultSinkClient creates the client which sends the result events to a
+// sink, or returns nil if there is no sink.
+func newResultSinkClient(sink string) (cloudevents.Client, error) {
+	if sink == "" {
+		return nil, nil
+	}
+	p, err := cloudevents.NewHTTP(cloudevents.WithTarget(sink))
+	if err != nil {
+		return nil, err
+	}
+	return cloudevents.NewClient(p)
+}
+
+// sendProbeResult sends the result event of a completed probe to RESULT_SINK,
+// if set. The result event is sent in the background, so that failing to send
+// it neither delays nor fails the probe.
+func (ph *Helper) sendProbeResult(ctx context.Context, event cloudevents.Event, result string, latency time.Duration) {
+	if ph.resultSinkClient == nil {
+		return
+	}
+	resultEvent := cloudevents.NewEvent()
+	ph.eventIdentity.identify(&resultEvent, uuid.New().String())
+	resultEvent.SetType(ResultEventType)
+	resultEvent.SetTime(ph.clock.Now())
+	resultEvent.SetExtension(resultProbeTypeExtension, event.Type())
+	resultEvent.SetExtension(resultProbeIDExtension, event.ID())
+	resultEvent.SetExtension(resultExtension, result)
+	resultEvent.SetExtension(resultLatencyExtension, latency.Milliseconds())
+
+	ctx, cancel := context.WithTimeout(withoutCancel(ctx), resultSinkTimeout)
+	go func() {
+		defer cancel()
+		if res := ph.resultSinkClient.Send(ctx, resultEvent); !cloudevents.IsACK(res) {
+			logging.FromContext(ctx).Warnw("Failed to send probe result event", zap.String("sink", ph.env.ResultSink), zap.Error(res))
+		}
+	}()
+}
@@ -0,0 +1,93 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package probe
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	cecontext "github.com/cloudevents/sdk-go/v2/context"
+)
+
+// RetryPolicy is the policy with which the events sent by a probe are retried.
+// It is decoded from the form 'strategy/period/maxretries', e.g.
+// 'exponential/100ms/5', where the strategy is one of 'none', 'constant',
+// 'linear' or 'exponential'.
+type RetryPolicy struct {
+	Strategy   cecontext.BackoffStrategy
+	Period     time.Duration
+	MaxRetries int
+}
+
+// Decode implements envconfig.Decoder.
+func (r *RetryPolicy) Decode(value string) error {
+	parts := strings.Split(value, "/")
+	if len(parts) != 3 {
+		return fmt.Errorf("invalid retry policy %q, expected 'strategy/period/maxretries'", value)
+	}
+	strategy := cecontext.BackoffStrategy(parts[0])
+	switch strategy {
+	case cecontext.BackoffStrategyNone, cecontext.BackoffStrategyConstant, cecontext.BackoffStrategyLinear, cecontext.BackoffStrategyExponential:
+	default:
+		return fmt.Errorf("invalid retry policy %q, unsupported backoff strategy %q", value, parts[0])
+	}
+	period, err := time.ParseDuration(parts[1])
+	if err != nil {
+		return fmt.Errorf("invalid retry policy %q, unparsable period: %v", value, err)
+	}
+	maxRetries, err := strconv.Atoi(parts[2])
+	if err != nil || maxRetries < 0 {
+		return fmt.Errorf("invalid retry policy %q, max retries must be a non-negative integer", value)
+	}
+	*r = RetryPolicy{
+		Strategy:   strategy,
+		Period:     period,
+		MaxRetries: maxRetries,
+	}
+	return nil
+}
+
+// String formats a retry policy the way it is decoded, or returns an empty
+// string if it is unset.
+func (r RetryPolicy) String() string {
+	if r == (RetryPolicy{}) {
+		return ""
+	}
+	return fmt.Sprintf("%s/%s/%d", r.Strategy, r.Period, r.MaxRetries)
+}
+
+// withRetryPolicy returns a context with the retry policy of the event's probe
+// type, or otherwise the default retry policy. The context is left untouched
+// if neither is set.
+func (ph *Helper) withRetryPolicy(ctx context.Context, event cloudevents.Event) context.Context {
+	policy, ok := ph.env.RetryPolicies[event.Type()]
+	if !ok {
+		policy = ph.env.DefaultRetryPolicy
+	}
+	if policy.Strategy == "" {
+		return ctx
+	}
+	return cecontext.WithRetryParams(ctx, &cecontext.RetryParams{
+		Strategy: policy.Strategy,
+		Period:   policy.Period,
+		MaxTries: policy.MaxRetries,
+	})
+}
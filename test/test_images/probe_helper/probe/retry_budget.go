@@ -0,0 +1,166 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package probe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/clock"
+)
+
+// ErrRetryBudgetExhausted is returned by the forward transport in place of a
+// retry which the retry budget denies.
+var ErrRetryBudgetExhausted = errors.New("retry budget exhausted")
+
+// retryBudget is a token bucket shared by the probes, which holds the retries
+// that their sends may make, so that the retries of every probe do not
+// amplify the load of a degraded target. Each retry takes a token, and the
+// bucket is refilled at a constant rate up to its size.
+type retryBudget struct {
+	mu     sync.Mutex
+	clock  clock.Clock
+	size   float64
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+// newRetryBudget creates a full retry budget of a given size, refilled with a
+// given number of retries per second. It returns nil if the size is zero, in
+// which case the retries are not budgeted.
+func newRetryBudget(size int, rate float64, clock clock.Clock) (*retryBudget, error) {
+	if size < 0 {
+		return nil, fmt.Errorf("invalid retry budget size %d, it must not be negative", size)
+	}
+	if rate < 0 || math.IsNaN(rate) || math.IsInf(rate, 0) {
+		return nil, fmt.Errorf("invalid retry budget refill rate %v, it must be a non-negative number", rate)
+	}
+	if size == 0 {
+		return nil, nil
+	}
+	return &retryBudget{
+		clock:  clock,
+		size:   float64(size),
+		rate:   rate,
+		tokens: float64(size),
+		last:   clock.Now(),
+	}, nil
+}
+
+// take takes a token for a retry from the budget, and returns whether there
+// was one left.
+func (b *retryBudget) take() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.clock.Now()
+	b.tokens = math.Min(b.size, b.tokens+b.rate*now.Sub(b.last).Seconds())
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// retryAccount tracks the requests sent by a probe, so that their retries are
+// taken from the retry budget. Once a retry is denied, the context of the
+// probe is cancelled for its send to stop retrying.
+type retryAccount struct {
+	budget *retryBudget
+	cancel context.CancelFunc
+
+	mu sync.Mutex
+	// The requests sent so far. The CloudEvents HTTP protocol sends the
+	// same request again on each retry.
+	sent      map[*http.Request]struct{}
+	exhausted bool
+}
+
+type retryAccountKey struct{}
+
+// withRetryBudget returns a copy of the context of a probe whose retries are
+// taken from the retry budget, along with its account and the function which
+// releases it. The context is left untouched if the retries are not budgeted.
+func (ph *Helper) withRetryBudget(ctx context.Context) (context.Context, *retryAccount, context.CancelFunc) {
+	if ph.retryBudget == nil {
+		return ctx, nil, func() {}
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	account := &retryAccount{
+		budget: ph.retryBudget,
+		cancel: cancel,
+		sent:   map[*http.Request]struct{}{},
+	}
+	return context.WithValue(ctx, retryAccountKey{}, account), account, cancel
+}
+
+// admit records a request sent by the probe, and returns whether it may be
+// sent, i.e. whether it is sent for the first time or the retry budget holds
+// a token for its retry.
+func (a *retryAccount) admit(req *http.Request) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.exhausted {
+		return false
+	}
+	if _, ok := a.sent[req]; !ok {
+		a.sent[req] = struct{}{}
+		return true
+	}
+	if a.budget.take() {
+		return true
+	}
+	a.exhausted = true
+	a.cancel()
+	return false
+}
+
+// isExhausted returns whether a retry of the probe was denied.
+func (a *retryAccount) isExhausted() bool {
+	if a == nil {
+		return false
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.exhausted
+}
+
+// retryBudgetTransport is the forward transport which takes the retries of
+// the requests sent by the probes from their retry budget, and fails those
+// which it denies.
+type retryBudgetTransport struct {
+	next http.RoundTripper
+}
+
+func (t retryBudgetTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if account, ok := req.Context().Value(retryAccountKey{}).(*retryAccount); ok && !account.admit(req) {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, ErrRetryBudgetExhausted
+	}
+	return t.next.RoundTrip(req)
+}
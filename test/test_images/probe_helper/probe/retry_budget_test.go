@@ -0,0 +1,150 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package probe
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/clock"
+)
+
+func TestNewRetryBudget(t *testing.T) {
+	cases := []struct {
+		name       string
+		size       int
+		rate       float64
+		wantBudget bool
+		wantErr    bool
+	}{{
+		name:       "budgeted retries",
+		size:       2,
+		rate:       1,
+		wantBudget: true,
+	}, {
+		name: "unbudgeted retries",
+		size: 0,
+		rate: 1,
+	}, {
+		name:    "negative size",
+		size:    -1,
+		wantErr: true,
+	}, {
+		name:    "negative refill rate",
+		size:    1,
+		rate:    -1,
+		wantErr: true,
+	}, {
+		name:    "NaN refill rate",
+		size:    1,
+		rate:    math.NaN(),
+		wantErr: true,
+	}, {
+		name:    "infinite refill rate",
+		size:    1,
+		rate:    math.Inf(1),
+		wantErr: true,
+	}}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			budget, err := newRetryBudget(tc.size, tc.rate, clock.RealClock{})
+			if gotErr := err != nil; gotErr != tc.wantErr {
+				t.Fatalf("newRetryBudget got error %v, want error %v", err, tc.wantErr)
+			}
+			if gotBudget := budget != nil; gotBudget != tc.wantBudget {
+				t.Errorf("newRetryBudget got budget %v, want budget %v", gotBudget, tc.wantBudget)
+			}
+		})
+	}
+}
+
+func TestRetryBudgetTake(t *testing.T) {
+	fakeClock := clock.NewFakeClock(time.Now())
+	budget, err := newRetryBudget(2, 0.5, fakeClock)
+	if err != nil {
+		t.Fatal("Failed to create retry budget:", err)
+	}
+
+	cases := []struct {
+		name string
+		step time.Duration
+		want []bool
+	}{{
+		name: "full budget",
+		want: []bool{true, true, false},
+	}, {
+		name: "partial refill",
+		step: time.Second,
+		want: []bool{false},
+	}, {
+		name: "one token refilled",
+		step: time.Second,
+		want: []bool{true, false},
+	}, {
+		name: "refill capped at the size",
+		step: time.Hour,
+		want: []bool{true, true, false},
+	}}
+	// The cases follow each other on the same budget.
+	for _, tc := range cases {
+		fakeClock.Step(tc.step)
+		for i, want := range tc.want {
+			if got := budget.take(); got != want {
+				t.Errorf("%s: take %d got=%v, want=%v", tc.name, i, got, want)
+			}
+		}
+	}
+}
+
+func TestRetryAccountAdmit(t *testing.T) {
+	budget, err := newRetryBudget(1, 0, clock.RealClock{})
+	if err != nil {
+		t.Fatal("Failed to create retry budget:", err)
+	}
+	ph := &Helper{retryBudget: budget}
+	ctx, account, cancel := ph.withRetryBudget(context.Background())
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://localhost", nil)
+	if err != nil {
+		t.Fatal("Failed to create request:", err)
+	}
+	// The first send is free, the first retry takes the only token, and the
+	// second retry exhausts the budget of the probe.
+	for i, want := range []bool{true, true, false} {
+		if got := account.admit(req); got != want {
+			t.Errorf("send %d admitted got=%v, want=%v", i, got, want)
+		}
+	}
+	if !account.isExhausted() {
+		t.Error("retry account got not exhausted, want exhausted")
+	}
+	if ctx.Err() == nil {
+		t.Error("probe context got not cancelled, want cancelled")
+	}
+	// The other requests of the probe are denied as well.
+	other, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://localhost", nil)
+	if err != nil {
+		t.Fatal("Failed to create request:", err)
+	}
+	if account.admit(other) {
+		t.Error("request of an exhausted probe got admitted, want denied")
+	}
+}
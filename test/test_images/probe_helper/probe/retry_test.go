@@ -0,0 +1,62 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package probe
+
+import (
+	"testing"
+	"time"
+
+	cecontext "github.com/cloudevents/sdk-go/v2/context"
+)
+
+func TestRetryPolicyDecode(t *testing.T) {
+	cases := []struct {
+		value      string
+		wantPolicy RetryPolicy
+		wantErr    bool
+	}{{
+		value:      "exponential/100ms/5",
+		wantPolicy: RetryPolicy{Strategy: cecontext.BackoffStrategyExponential, Period: 100 * time.Millisecond, MaxRetries: 5},
+	}, {
+		value:      "none/0s/0",
+		wantPolicy: RetryPolicy{Strategy: cecontext.BackoffStrategyNone},
+	}, {
+		value:   "exponential/100ms",
+		wantErr: true,
+	}, {
+		value:   "random/100ms/5",
+		wantErr: true,
+	}, {
+		value:   "constant/soon/5",
+		wantErr: true,
+	}, {
+		value:   "constant/100ms/-1",
+		wantErr: true,
+	}}
+	for _, tc := range cases {
+		t.Run(tc.value, func(t *testing.T) {
+			var policy RetryPolicy
+			err := policy.Decode(tc.value)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("decode error got=%v, want error=%t", err, tc.wantErr)
+			}
+			if policy != tc.wantPolicy {
+				t.Errorf("retry policy got=%+v, want=%+v", policy, tc.wantPolicy)
+			}
+		})
+	}
+}
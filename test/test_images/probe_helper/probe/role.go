@@ -0,0 +1,52 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package probe
+
+import (
+	"fmt"
+)
+
+const (
+	// CombinedRole runs both the forwarder and the receiver.
+	CombinedRole = "combined"
+	// ForwarderRole only runs the forwarder, which accepts the probe requests
+	// and waits on their events to be received.
+	ForwarderRole = "forwarder"
+	// ReceiverRole only runs the receiver, which accepts the events delivered
+	// back to the probe helper.
+	ReceiverRole = "receiver"
+)
+
+// validateRole ensures that the role of the probe helper is supported.
+func validateRole(role string) error {
+	switch role {
+	case "", CombinedRole, ForwarderRole, ReceiverRole:
+		return nil
+	default:
+		return fmt.Errorf("unsupported role %q", role)
+	}
+}
+
+// runsForwarder returns whether the probe helper runs the forwarder.
+func (ph *Helper) runsForwarder() bool {
+	return ph.env.Role != ReceiverRole
+}
+
+// runsReceiver returns whether the probe helper runs the receiver.
+func (ph *Helper) runsReceiver() bool {
+	return ph.env.Role != ForwarderRole
+}
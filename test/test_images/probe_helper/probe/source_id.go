@@ -0,0 +1,51 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package probe
+
+import (
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+)
+
+// defaultProbeSourceID is the source of the events which the probe helper
+// emits, unless it is given a probe source ID.
+const defaultProbeSourceID = "probe-helper"
+
+// eventIdentity is the identity under which the probe helper emits the result
+// and failure events, so that the events of the probe helper instances which
+// report to a shared sink are told apart.
+type eventIdentity struct {
+	// The source of the emitted events
+	source string
+	// The prefix of the IDs of the emitted events, if any
+	idPrefix string
+}
+
+// newEventIdentity returns the identity of the events emitted by a probe
+// helper with a given probe source ID. Without one, the events have the
+// default source and unprefixed IDs.
+func newEventIdentity(probeSourceID string) eventIdentity {
+	if probeSourceID == "" {
+		return eventIdentity{source: defaultProbeSourceID}
+	}
+	return eventIdentity{source: probeSourceID, idPrefix: probeSourceID + "/"}
+}
+
+// identify sets the source and the prefixed ID of an emitted event.
+func (i eventIdentity) identify(event *cloudevents.Event, id string) {
+	event.SetID(i.idPrefix + id)
+	event.SetSource(i.source)
+}
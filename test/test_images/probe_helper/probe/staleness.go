@@ -0,0 +1,45 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package probe
+
+import (
+	"fmt"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+
+	"github.com/google/knative-gcp/test/test_images/probe_helper/utils"
+)
+
+// checkEventStaleness checks that a received event is not a replay of an
+// older probe with the same ID, e.g. one redelivered from a prior run of the
+// probe helper. The event is stale if its time precedes the forward time of
+// the probe in flight with its ID by more than MAX_EVENT_TIME_SKEW. The events
+// with no time or whose ID matches no probe in flight are not checked.
+func (ph *Helper) checkEventStaleness(event cloudevents.Event) error {
+	if ph.env.MaxEventTimeSkew <= 0 || event.Time().IsZero() {
+		return nil
+	}
+	probe, ok := ph.inFlightProbes.Get(event.ID())
+	if !ok {
+		return nil
+	}
+	if skew := probe.ReceivedTime.Sub(event.Time()); skew > ph.env.MaxEventTimeSkew {
+		return fmt.Errorf("%w: event %s was sent at %s, %s before its probe was forwarded", utils.ErrNotTracked, event.ID(), event.Time().Format(time.RFC3339Nano), skew)
+	}
+	return nil
+}
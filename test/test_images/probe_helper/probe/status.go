@@ -0,0 +1,218 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package probe
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/knative-gcp/test/test_images/probe_helper/utils"
+)
+
+// latencyBucketPattern matches the samples of the buckets of the probe latency
+// histogram in the Prometheus text format, capturing their upper bound and
+// their cumulative count.
+var latencyBucketPattern = regexp.MustCompile(`^` + utils.ProbeLatencyMetric + `_bucket\{(?:.*,)?le="([^"]+)"(?:,.*)?\} (\S+)`)
+
+// Status is the state of a running probe helper, as served by its receiver
+// client along the liveness, recent results and metrics paths.
+type Status struct {
+	// Healthy is whether the liveness check of the probe helper succeeds.
+	Healthy bool
+	// Liveness is the response of the liveness check.
+	Liveness utils.LivenessResponse
+	// Recent lists the results of the last completed probes, most recent
+	// first.
+	Recent []utils.ProbeResult
+	// P50 and P99 are the median and 99th percentile of the latencies of the
+	// successful probes, estimated from the latency histogram. They are
+	// negative if no probe succeeded yet.
+	P50, P99 time.Duration
+}
+
+// FetchStatus queries the status of the probe helper whose receiver client is
+// served at a given base URL, e.g. 'http://localhost:8080'. The metrics are
+// only found there if the probe helper has no dedicated METRICS_PORT.
+func FetchStatus(ctx context.Context, client *http.Client, baseURL string) (*Status, error) {
+	baseURL = strings.TrimSuffix(baseURL, "/")
+	status := &Status{}
+
+	res, err := getStatusEndpoint(ctx, client, baseURL+"/healthz")
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	status.Healthy = res.StatusCode == http.StatusOK
+	if err := json.NewDecoder(res.Body).Decode(&status.Liveness); err != nil {
+		return nil, fmt.Errorf("failed to decode liveness response: %w", err)
+	}
+
+	res, err = getStatusEndpoint(ctx, client, baseURL+debugRecentPath)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("recent results request answered with status %d", res.StatusCode)
+	}
+	if err := json.NewDecoder(res.Body).Decode(&status.Recent); err != nil {
+		return nil, fmt.Errorf("failed to decode recent results: %w", err)
+	}
+
+	res, err = getStatusEndpoint(ctx, client, baseURL+metricsPath)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("metrics request answered with status %d", res.StatusCode)
+	}
+	buckets, err := parseLatencyBuckets(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse metrics: %w", err)
+	}
+	status.P50 = latencyQuantile(0.5, buckets)
+	status.P99 = latencyQuantile(0.99, buckets)
+	return status, nil
+}
+
+// getStatusEndpoint sends a GET request to an endpoint of a probe helper.
+func getStatusEndpoint(ctx context.Context, client *http.Client, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query %s: %w", url, err)
+	}
+	return res, nil
+}
+
+// latencyBucket is a bucket of the probe latency histogram, summed over the
+// probe types.
+type latencyBucket struct {
+	// upperBound is the upper bound of the bucket, in seconds.
+	upperBound float64
+	// count is the cumulative count of the latencies within the bound.
+	count float64
+}
+
+// parseLatencyBuckets parses the buckets of the probe latency histogram from
+// metrics in the Prometheus text format, summing them over the probe types,
+// in increasing order of their upper bound.
+func parseLatencyBuckets(r io.Reader) ([]latencyBucket, error) {
+	counts := map[float64]float64{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		match := latencyBucketPattern.FindStringSubmatch(scanner.Text())
+		if match == nil {
+			continue
+		}
+		upperBound, err := strconv.ParseFloat(match[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bucket bound %q: %w", match[1], err)
+		}
+		count, err := strconv.ParseFloat(match[2], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bucket count %q: %w", match[2], err)
+		}
+		counts[upperBound] += count
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	buckets := make([]latencyBucket, 0, len(counts))
+	for upperBound, count := range counts {
+		buckets = append(buckets, latencyBucket{upperBound: upperBound, count: count})
+	}
+	sort.Slice(buckets, func(i, j int) bool {
+		return buckets[i].upperBound < buckets[j].upperBound
+	})
+	return buckets, nil
+}
+
+// latencyQuantile estimates a quantile of the probe latencies from the buckets
+// of their histogram, by linear interpolation within the bucket holding it
+// like the Prometheus histogram_quantile function. The quantiles which fall in
+// the +Inf bucket are estimated as the largest finite bound. A negative
+// duration is returned if the histogram is empty.
+func latencyQuantile(q float64, buckets []latencyBucket) time.Duration {
+	if len(buckets) == 0 || buckets[len(buckets)-1].count == 0 {
+		return -1
+	}
+	rank := q * buckets[len(buckets)-1].count
+	lowerBound, lowerCount := 0.0, 0.0
+	for _, b := range buckets {
+		if b.count >= rank {
+			if math.IsInf(b.upperBound, 1) {
+				return secondsDuration(lowerBound)
+			}
+			return secondsDuration(lowerBound + (b.upperBound-lowerBound)*(rank-lowerCount)/(b.count-lowerCount))
+		}
+		lowerBound, lowerCount = b.upperBound, b.count
+	}
+	return secondsDuration(lowerBound)
+}
+
+// secondsDuration converts a number of seconds to a duration.
+func secondsDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// Print writes a concise summary of the status: whether the probe helper is
+// healthy, the latency percentiles, and at most a given number of the most
+// recent probe results.
+func (s *Status) Print(w io.Writer, lastN int) error {
+	health := "healthy: yes"
+	if !s.Healthy {
+		health = fmt.Sprintf("healthy: no (%s", s.Liveness.State)
+		if s.Liveness.Reason != "" {
+			health += fmt.Sprintf(", %s: %s", s.Liveness.Reason, s.Liveness.Detail)
+		}
+		health += ")"
+	}
+	latency := "latency: no successful probe"
+	if s.P50 >= 0 {
+		latency = fmt.Sprintf("latency: p50=%s p99=%s", s.P50.Round(time.Millisecond), s.P99.Round(time.Millisecond))
+	}
+	lines := []string{health, latency}
+	recent := s.Recent
+	if len(recent) > lastN {
+		recent = recent[:lastN]
+	}
+	lines = append(lines, fmt.Sprintf("recent results: %d", len(recent)))
+	for _, r := range recent {
+		line := fmt.Sprintf("  %s %-4s %s %s %dms", r.CompletedTime.Format(time.RFC3339), r.Result, r.Type, r.ID, r.LatencyMs)
+		if r.Reason != "" {
+			line += " " + r.Reason
+		}
+		lines = append(lines, line)
+	}
+	_, err := io.WriteString(w, strings.Join(lines, "\n")+"\n")
+	return err
+}
@@ -17,24 +17,37 @@ limitations under the License.
 package probe
 
 import (
+	"crypto/tls"
 	"net"
 
 	cloudevents "github.com/cloudevents/sdk-go/v2"
 	cehttp "github.com/cloudevents/sdk-go/v2/protocol/http"
 	"github.com/google/wire"
+
+	"github.com/google/knative-gcp/test/test_images/probe_helper/utils"
 )
 
 var TestHelperSet wire.ProviderSet = wire.NewSet(
 	NewHelper,
 	NewCePubSubClient,
 	NewCeForwardClient,
+	NewCeForwardSender,
 	NewCeReceiverClient,
 	NewTestCeReceiverClientOptions,
 	NewTestCeForwardClientOptions,
+	NewReceiverTLSConfig,
+	NewTestProbeGRPCListener,
+	NewReceiverMux,
+	NewProbeMetrics,
+	NewAuditLogsPollInterval,
+	NewCorrelationStore,
+	NewBackendChecker,
+	utils.NewInFlightProbes,
+	utils.NewProbeRequests,
 )
 
-func NewTestCeReceiverClientOptions(listener ReceiveListener) ReceiveClientOptions {
-	opts := []cehttp.Option{cloudevents.WithListener(listener)}
+func NewTestCeReceiverClientOptions(listener ReceiveListener, tlsConfig *tls.Config) ReceiveClientOptions {
+	opts := []cehttp.Option{cloudevents.WithListener(receiverListener(listener, tlsConfig))}
 	return opts
 }
 
@@ -43,5 +56,17 @@ func NewTestCeForwardClientOptions(listener ForwardListener) ForwardClientOption
 	return opts
 }
 
+// NewTestProbeGRPCListener reuses the forward listener for the gRPC server if
+// the probe requests are accepted over gRPC.
+func NewTestProbeGRPCListener(env EnvConfig, listener ForwardListener) (ProbeGRPCListener, error) {
+	if err := validateProbeProtocol(env.ProbeProtocol); err != nil {
+		return nil, err
+	}
+	if env.ProbeProtocol != GRPCProbeProtocol {
+		return nil, nil
+	}
+	return listener, nil
+}
+
 type ForwardListener net.Listener
 type ReceiveListener net.Listener
@@ -0,0 +1,119 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package probe
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+
+	"knative.dev/pkg/logging"
+)
+
+// forwardTLSConfig returns the TLS config with which the forward client sends
+// events, or nil if no forward TLS material is configured and the targets are
+// verified as usual.
+func forwardTLSConfig(env EnvConfig) (*tls.Config, error) {
+	if env.ForwardClientCertFile == "" && env.ForwardClientKeyFile == "" && env.ForwardCABundleFile == "" && !env.InsecureSkipTLSVerify {
+		return nil, nil
+	}
+	config := &tls.Config{InsecureSkipVerify: env.InsecureSkipTLSVerify}
+	if env.ForwardClientCertFile != "" || env.ForwardClientKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(env.ForwardClientCertFile, env.ForwardClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load forward client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	if env.ForwardCABundleFile != "" {
+		pool, err := loadCABundle(env.ForwardCABundleFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = pool
+	}
+	return config, nil
+}
+
+// warnInsecureForwardTLS warns that the forward client does not verify the
+// certificates of the targets of the probes, if so configured, since this must
+// only be done in development clusters.
+func warnInsecureForwardTLS(ctx context.Context, env EnvConfig) {
+	if env.InsecureSkipTLSVerify {
+		logging.FromContext(ctx).Warn("INSECURE: the forward client skips the TLS verification of the targets of the probes, which must only be done in development clusters")
+	}
+}
+
+// NewReceiverTLSConfig returns the TLS config with which the receiver client
+// serves, or nil if the receiver serves plaintext. Client certificates are
+// required if a receiver client CA bundle is configured.
+func NewReceiverTLSConfig(env EnvConfig) (*tls.Config, error) {
+	if env.ReceiverCertFile == "" && env.ReceiverKeyFile == "" {
+		if env.ReceiverClientCABundleFile != "" {
+			return nil, errors.New("receiver client CA bundle is configured without a receiver certificate")
+		}
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(env.ReceiverCertFile, env.ReceiverKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load receiver certificate: %w", err)
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{cert}}
+	if env.ReceiverClientCABundleFile != "" {
+		pool, err := loadCABundle(env.ReceiverClientCABundleFile)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+func loadCABundle(path string) (*x509.CertPool, error) {
+	bundle, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(bundle) {
+		return nil, fmt.Errorf("no certificate found in CA bundle %q", path)
+	}
+	return pool, nil
+}
+
+// tlsTransport returns a copy of the default HTTP transport which uses a given
+// TLS config.
+func tlsTransport(config *tls.Config) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config
+	return transport
+}
+
+// receiverListener wraps the listener of the receiver client in TLS if a TLS
+// config is given.
+func receiverListener(listener net.Listener, config *tls.Config) net.Listener {
+	if config == nil {
+		return listener
+	}
+	return tls.NewListener(listener, config)
+}
@@ -0,0 +1,294 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package probe
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	cecontext "github.com/cloudevents/sdk-go/v2/context"
+	cehttp "github.com/cloudevents/sdk-go/v2/protocol/http"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+
+	"knative.dev/pkg/logging"
+	logtest "knative.dev/pkg/logging/testing"
+)
+
+// writeTestCertificate writes a self-signed certificate for localhost and its
+// private key in PEM files, and returns their paths along with the
+// certificate. The certificate is its own CA.
+func writeTestCertificate(t *testing.T, dir, commonName string) (string, string, *x509.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal("Failed to generate key:", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal("Failed to create certificate:", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal("Failed to parse certificate:", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal("Failed to marshal key:", err)
+	}
+	certFile := filepath.Join(dir, commonName+".crt")
+	keyFile := filepath.Join(dir, commonName+".key")
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal("Failed to write certificate:", err)
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal("Failed to write key:", err)
+	}
+	return certFile, keyFile, cert
+}
+
+func TestForwardClientTLS(t *testing.T) {
+	ctx := logtest.TestContextWithLogger(t)
+	dir := t.TempDir()
+	clientCertFile, clientKeyFile, clientCert := writeTestCertificate(t, dir, "probe-helper-client")
+
+	// The target requires client certificates signed by the client certificate.
+	peers := make(chan []*x509.Certificate, 1)
+	target := httptest.NewUnstartedServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		peers <- req.TLS.PeerCertificates
+		rw.WriteHeader(http.StatusAccepted)
+	}))
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert)
+	target.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	target.StartTLS()
+	defer target.Close()
+	caBundleFile := filepath.Join(dir, "ca-bundle.crt")
+	if err := ioutil.WriteFile(caBundleFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: target.Certificate().Raw}), 0600); err != nil {
+		t.Fatal("Failed to write CA bundle:", err)
+	}
+
+	cases := []struct {
+		name    string
+		env     EnvConfig
+		wantACK bool
+	}{{
+		name: "client certificate",
+		env: EnvConfig{
+			ForwardClientCertFile: clientCertFile,
+			ForwardClientKeyFile:  clientKeyFile,
+			ForwardCABundleFile:   caBundleFile,
+		},
+		wantACK: true,
+	}, {
+		name: "no client certificate",
+		env: EnvConfig{
+			ForwardCABundleFile: caBundleFile,
+		},
+		wantACK: false,
+	}, {
+		name: "unknown target CA",
+		env: EnvConfig{
+			ForwardClientCertFile: clientCertFile,
+			ForwardClientKeyFile:  clientKeyFile,
+		},
+		wantACK: false,
+	}}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c, err := NewCeForwardClient(tc.env, nil, nil, nil)
+			if err != nil {
+				t.Fatal("Failed to create forward client:", err)
+			}
+			sendCtx := cecontext.WithTarget(ctx, target.URL)
+			if res := c.Send(sendCtx, *probeEvent("broker-e2e-delivery-probe")); cloudevents.IsACK(res) != tc.wantACK {
+				t.Fatalf("send result got=%v, want ACK=%v", res, tc.wantACK)
+			}
+			if !tc.wantACK {
+				return
+			}
+			if got := <-peers; len(got) != 1 || got[0].Subject.CommonName != "probe-helper-client" {
+				t.Errorf("peer certificates got=%v, want the probe-helper-client certificate", got)
+			}
+		})
+	}
+}
+
+func TestForwardClientTLSInvalidConfig(t *testing.T) {
+	dir := t.TempDir()
+	certFile, _, _ := writeTestCertificate(t, dir, "probe-helper-client")
+	for _, env := range []EnvConfig{
+		{ForwardClientCertFile: certFile},
+		{ForwardCABundleFile: filepath.Join(dir, "missing.crt")},
+		{ForwardCABundleFile: filepath.Join(dir, "probe-helper-client.key")},
+	} {
+		if _, err := NewCeForwardClient(env, nil, nil, nil); err == nil {
+			t.Errorf("NewCeForwardClient(%+v) got no error, want error", env)
+		}
+	}
+}
+
+func TestForwardClientInsecureSkipTLSVerify(t *testing.T) {
+	ctx := logtest.TestContextWithLogger(t)
+
+	// The target serves TLS with a self-signed certificate.
+	target := httptest.NewTLSServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusAccepted)
+	}))
+	defer target.Close()
+
+	cases := []struct {
+		name        string
+		env         EnvConfig
+		wantACK     bool
+		wantWarning bool
+	}{{
+		name:    "verified",
+		env:     EnvConfig{},
+		wantACK: false,
+	}, {
+		name:        "verification skipped",
+		env:         EnvConfig{InsecureSkipTLSVerify: true},
+		wantACK:     true,
+		wantWarning: true,
+	}}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c, err := NewCeForwardClient(tc.env, nil, nil, nil)
+			if err != nil {
+				t.Fatal("Failed to create forward client:", err)
+			}
+			sendCtx := cecontext.WithTarget(ctx, target.URL)
+			if res := c.Send(sendCtx, *probeEvent("broker-e2e-delivery-probe")); cloudevents.IsACK(res) != tc.wantACK {
+				t.Fatalf("send result got=%v, want ACK=%v", res, tc.wantACK)
+			}
+
+			core, logs := observer.New(zap.WarnLevel)
+			warnInsecureForwardTLS(logging.WithLogger(ctx, zap.New(core).Sugar()), tc.env)
+			if got := logs.Len() > 0; got != tc.wantWarning {
+				t.Errorf("insecure forward TLS warning got=%v, want=%v", got, tc.wantWarning)
+			}
+		})
+	}
+}
+
+func TestReceiverTLS(t *testing.T) {
+	ctx := logtest.TestContextWithLogger(t)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	dir := t.TempDir()
+	receiverCertFile, receiverKeyFile, receiverCert := writeTestCertificate(t, dir, "probe-helper-receiver")
+	clientCertFile, clientKeyFile, _ := writeTestCertificate(t, dir, "probe-helper-client")
+
+	env := EnvConfig{
+		ReceiverCertFile:           receiverCertFile,
+		ReceiverKeyFile:            receiverKeyFile,
+		ReceiverClientCABundleFile: clientCertFile,
+	}
+	tlsConfig, err := NewReceiverTLSConfig(env)
+	if err != nil {
+		t.Fatal("Failed to create receiver TLS config:", err)
+	}
+	listener, err := GetFreePortListener()
+	if err != nil {
+		t.Fatal("Failed to get free receiver port listener:", err)
+	}
+	receiverURL := fmt.Sprintf("https://localhost:%d", listener.Addr().(*net.TCPAddr).Port)
+	c, err := NewCeReceiverClient(ctx, env, http.NewServeMux(), NewTestCeReceiverClientOptions(listener, tlsConfig))
+	if err != nil {
+		t.Fatal("Failed to create receiver client:", err)
+	}
+	go c.StartReceiver(ctx, func(event cloudevents.Event) {})
+
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(receiverCert)
+	clientCert, err := tls.LoadX509KeyPair(clientCertFile, clientKeyFile)
+	if err != nil {
+		t.Fatal("Failed to load client certificate:", err)
+	}
+	cases := []struct {
+		name    string
+		config  *tls.Config
+		wantACK bool
+	}{{
+		name:    "client certificate",
+		config:  &tls.Config{RootCAs: rootCAs, Certificates: []tls.Certificate{clientCert}},
+		wantACK: true,
+	}, {
+		name:    "no client certificate",
+		config:  &tls.Config{RootCAs: rootCAs},
+		wantACK: false,
+	}}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			p, err := cloudevents.NewHTTP(cloudevents.WithTarget(receiverURL), cehttp.WithRoundTripper(tlsTransport(tc.config)))
+			if err != nil {
+				t.Fatal("Failed to create HTTP protocol of the sender:", err)
+			}
+			sender, err := cloudevents.NewClient(p)
+			if err != nil {
+				t.Fatal("Failed to create sender:", err)
+			}
+			sendCtx := cloudevents.ContextWithRetriesConstantBackoff(ctx, 100*time.Millisecond, 10)
+			if res := sender.Send(sendCtx, *probeEvent("broker-e2e-delivery-probe")); cloudevents.IsACK(res) != tc.wantACK {
+				t.Errorf("send result got=%v, want ACK=%v", res, tc.wantACK)
+			}
+		})
+	}
+}
+
+func TestReceiverTLSInvalidConfig(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, _ := writeTestCertificate(t, dir, "probe-helper-receiver")
+	for _, env := range []EnvConfig{
+		{ReceiverClientCABundleFile: certFile},
+		{ReceiverCertFile: certFile},
+		{ReceiverCertFile: certFile, ReceiverKeyFile: keyFile, ReceiverClientCABundleFile: filepath.Join(dir, "missing.crt")},
+	} {
+		if _, err := NewReceiverTLSConfig(env); err == nil {
+			t.Errorf("NewReceiverTLSConfig(%+v) got no error, want error", env)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package probe
+
+import (
+	"context"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/cloudevents/sdk-go/v2/extensions"
+	"go.opencensus.io/trace"
+
+	kntracing "knative.dev/eventing/pkg/tracing"
+)
+
+const (
+	// The names of the spans of forwarded and received probe events.
+	forwardSpanName = "probe-helper.forward"
+	receiveSpanName = "probe-helper.receive"
+
+	probeTypeAttribute = "probe.type"
+)
+
+// startForwardSpan starts the span of a forward probe request, and propagates
+// its trace context into the probe event through the 'traceparent' extension.
+// No span is started unless tracing is enabled, in which case the returned
+// span is nil. It is safe to end a nil span.
+func (ph *Helper) startForwardSpan(ctx context.Context, event *cloudevents.Event) (context.Context, *trace.Span) {
+	if !ph.env.TracingEnabled {
+		return ctx, nil
+	}
+	ctx, span := trace.StartSpan(ctx, forwardSpanName, trace.WithSpanKind(trace.SpanKindClient))
+	addProbeAttributes(span, *event)
+	extensions.FromSpanContext(span.SpanContext()).AddTracingAttributes(event)
+	return ctx, span
+}
+
+// startReceiveSpan starts the span of a received event, as a child of the
+// trace context carried by its 'traceparent' extension if there is one. No
+// span is started unless tracing is enabled, in which case the returned span
+// is nil.
+func (ph *Helper) startReceiveSpan(ctx context.Context, event cloudevents.Event) (context.Context, *trace.Span) {
+	if !ph.env.TracingEnabled {
+		return ctx, nil
+	}
+	var span *trace.Span
+	if dt, ok := extensions.GetDistributedTracingExtension(event); ok {
+		ctx, span = dt.StartChildSpan(ctx, receiveSpanName, trace.WithSpanKind(trace.SpanKindServer))
+	} else {
+		ctx, span = trace.StartSpan(ctx, receiveSpanName, trace.WithSpanKind(trace.SpanKindServer))
+	}
+	addProbeAttributes(span, event)
+	return ctx, span
+}
+
+func addProbeAttributes(span *trace.Span, event cloudevents.Event) {
+	if span.IsRecordingEvents() {
+		span.AddAttributes(
+			trace.StringAttribute(probeTypeAttribute, event.Type()),
+			kntracing.MessagingMessageIDAttribute(event.ID()),
+		)
+	}
+}
+
+// endSpan ends a span with a status reflecting the error, if any.
+func endSpan(span *trace.Span, err error) {
+	if err != nil {
+		span.SetStatus(trace.Status{Code: trace.StatusCodeUnknown, Message: err.Error()})
+	}
+	span.End()
+}
@@ -0,0 +1,64 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package probe
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+
+	"golang.org/x/net/http2"
+)
+
+// forwardTransport returns the transport with which the forward client sends
+// events, tuned according to the forward transport settings and using a given
+// TLS config, if any. It returns nil if neither is configured, in which case
+// the default transport is used.
+func forwardTransport(env EnvConfig, config *tls.Config) (http.RoundTripper, error) {
+	if env.ForwardMaxIdleConns < 0 {
+		return nil, fmt.Errorf("invalid forward max idle conns %d, it must not be negative", env.ForwardMaxIdleConns)
+	}
+	if env.ForwardIdleConnTimeout < 0 {
+		return nil, fmt.Errorf("invalid forward idle conn timeout %s, it must not be negative", env.ForwardIdleConnTimeout)
+	}
+	// Plaintext targets are sent events over HTTP/2 with prior knowledge,
+	// while TLS targets negotiate HTTP/2 by default.
+	if env.ForwardForceHTTP2 && config == nil {
+		return &http2.Transport{
+			AllowHTTP: true,
+			DialTLS: func(network, addr string, _ *tls.Config) (net.Conn, error) {
+				return net.Dial(network, addr)
+			},
+		}, nil
+	}
+	if config == nil && env.ForwardMaxIdleConns == 0 && env.ForwardIdleConnTimeout == 0 && !env.ForwardDisableKeepAlives {
+		return nil, nil
+	}
+	transport := tlsTransport(config)
+	if env.ForwardMaxIdleConns > 0 {
+		transport.MaxIdleConnsPerHost = env.ForwardMaxIdleConns
+		if transport.MaxIdleConns < env.ForwardMaxIdleConns {
+			transport.MaxIdleConns = env.ForwardMaxIdleConns
+		}
+	}
+	if env.ForwardIdleConnTimeout > 0 {
+		transport.IdleConnTimeout = env.ForwardIdleConnTimeout
+	}
+	transport.DisableKeepAlives = env.ForwardDisableKeepAlives
+	return transport, nil
+}
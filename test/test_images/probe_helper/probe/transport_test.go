@@ -0,0 +1,128 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package probe
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	cecontext "github.com/cloudevents/sdk-go/v2/context"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+
+	logtest "knative.dev/pkg/logging/testing"
+)
+
+// countingListener counts the connections it accepts.
+type countingListener struct {
+	net.Listener
+	accepted int32
+}
+
+func (l *countingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err == nil {
+		atomic.AddInt32(&l.accepted, 1)
+	}
+	return conn, err
+}
+
+func TestForwardClientConnectionReuse(t *testing.T) {
+	const probes = 5
+	cases := []struct {
+		name      string
+		env       EnvConfig
+		wantConns int32
+		wantProto int
+	}{{
+		name:      "default keep-alives",
+		env:       EnvConfig{},
+		wantConns: 1,
+		wantProto: 1,
+	}, {
+		name: "tuned keep-alives",
+		env: EnvConfig{
+			ForwardMaxIdleConns:    4,
+			ForwardIdleConnTimeout: time.Minute,
+		},
+		wantConns: 1,
+		wantProto: 1,
+	}, {
+		name:      "disabled keep-alives",
+		env:       EnvConfig{ForwardDisableKeepAlives: true},
+		wantConns: probes,
+		wantProto: 1,
+	}, {
+		name:      "forced HTTP/2",
+		env:       EnvConfig{ForwardForceHTTP2: true},
+		wantConns: 1,
+		wantProto: 2,
+	}}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := logtest.TestContextWithLogger(t)
+			listener, err := GetFreePortListener()
+			if err != nil {
+				t.Fatal("Failed to get free target port listener:", err)
+			}
+			counter := &countingListener{Listener: listener}
+			protos := make(chan int, probes)
+			srv := &http.Server{
+				// The target accepts HTTP/2 with prior knowledge along with HTTP/1.
+				Handler: h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+					protos <- req.ProtoMajor
+					w.WriteHeader(http.StatusAccepted)
+				}), &http2.Server{}),
+			}
+			go srv.Serve(counter)
+			defer srv.Close()
+
+			c, err := NewCeForwardClient(tc.env, nil, nil, nil)
+			if err != nil {
+				t.Fatal("Failed to create forward client:", err)
+			}
+			sendCtx := cecontext.WithTarget(ctx, fmt.Sprintf("http://%s", listener.Addr()))
+			for i := 0; i < probes; i++ {
+				if res := c.Send(sendCtx, *probeEvent("broker-e2e-delivery-probe", withProbeID(fmt.Sprintf("probe-%d", i)))); !cloudevents.IsACK(res) {
+					t.Fatalf("send result got=%v, want ACK", res)
+				}
+				if got := <-protos; got != tc.wantProto {
+					t.Errorf("HTTP protocol major version got=%d, want=%d", got, tc.wantProto)
+				}
+			}
+			if got := atomic.LoadInt32(&counter.accepted); got != tc.wantConns {
+				t.Errorf("connections got=%d, want=%d", got, tc.wantConns)
+			}
+		})
+	}
+}
+
+func TestForwardClientInvalidTransportConfig(t *testing.T) {
+	for _, env := range []EnvConfig{
+		{ForwardMaxIdleConns: -1},
+		{ForwardIdleConnTimeout: -time.Second},
+	} {
+		if _, err := NewCeForwardClient(env, nil, nil, nil); err == nil {
+			t.Errorf("NewCeForwardClient(%+v) got no error, want error", env)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package probe
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	cehttp "github.com/cloudevents/sdk-go/v2/protocol/http"
+	"go.uber.org/zap"
+	"knative.dev/pkg/logging"
+)
+
+const (
+	// UnmatchedEventACK acknowledges the received events which match no probe
+	// in flight, and drops them.
+	UnmatchedEventACK = "ack"
+	// UnmatchedEventNACK rejects the received events which match no probe in
+	// flight with a retriable status, so that their sender redelivers them.
+	UnmatchedEventNACK = "nack"
+	// UnmatchedEventLog acknowledges the received events which match no probe
+	// in flight, and logs them as warnings, e.g. to surface events delivered
+	// to the wrong target path.
+	UnmatchedEventLog = "log"
+)
+
+// validateUnmatchedEventPolicy ensures that the policy for the received events
+// which match no probe in flight is supported.
+func validateUnmatchedEventPolicy(policy string) error {
+	switch policy {
+	case "", UnmatchedEventACK, UnmatchedEventNACK, UnmatchedEventLog:
+		return nil
+	default:
+		return fmt.Errorf("unsupported unmatched event policy %q", policy)
+	}
+}
+
+// handleUnmatchedEvent returns the result of a received event which matches
+// no probe in flight, according to the unmatched event policy.
+func (ph *Helper) handleUnmatchedEvent(ctx context.Context, err error) cloudevents.Result {
+	switch ph.env.UnmatchedEventPolicy {
+	case UnmatchedEventNACK:
+		logging.FromContext(ctx).Debugw("Probe receiver rejected unmatched event", zap.Error(err))
+		return cehttp.NewResult(http.StatusServiceUnavailable, "%v", err)
+	case UnmatchedEventLog:
+		logging.FromContext(ctx).Warnw("Probe receiver received unmatched event", zap.Error(err))
+		return cloudevents.ResultACK
+	default:
+		logging.FromContext(ctx).Debugw("Probe receiver failed", zap.Error(err))
+		return cloudevents.ResultACK
+	}
+}
@@ -0,0 +1,56 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package probe
+
+import (
+	"context"
+	"errors"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"go.uber.org/zap"
+	"knative.dev/pkg/logging"
+
+	"github.com/google/knative-gcp/test/test_images/probe_helper/probe/handlers"
+)
+
+// warmupProbe primes the forward connection and the source path of a probe
+// by sending its event without waiting for it to be delivered back. Warmup
+// probes are neither tracked nor counted in the probe metrics, so that they
+// absorb the cold-start latency of the probes which follow them.
+func (ph *Helper) warmupProbe(ctx context.Context, event cloudevents.Event) cloudevents.Result {
+	if err := ph.validateProbe(event); err != nil {
+		logging.FromContext(ctx).Debugw("Warmup probe validation failed", zap.Error(err))
+		return newFailureResult(validationFailureReason(err), "%v", err)
+	}
+	w, ok := ph.probeHandler.(handlers.Warmer)
+	if !ok {
+		return newFailureResult(WarmupUnsupportedReason, "%v for probe type '%s'", handlers.ErrWarmupUnsupported, event.Type())
+	}
+
+	ctx, cancel := ph.withProbeTimeout(ctx, event)
+	defer cancel()
+	ctx = ph.withRetryPolicy(ctx, event)
+	if err := w.Warmup(ctx, event); err != nil {
+		logging.FromContext(ctx).Debugw("Warmup probe failed", zap.Error(err))
+		if errors.Is(err, handlers.ErrWarmupUnsupported) {
+			return newFailureResult(WarmupUnsupportedReason, "%v", err)
+		}
+		return newFailureResult(forwardFailureReason(ctx, err), "%v", err)
+	}
+	logging.FromContext(ctx).Debugw("Warmup probe succeeded")
+	return cloudevents.ResultACK
+}
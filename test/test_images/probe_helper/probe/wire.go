@@ -25,12 +25,14 @@ import (
 	"cloud.google.com/go/pubsub"
 	"cloud.google.com/go/storage"
 	"github.com/google/wire"
+	"k8s.io/apimachinery/pkg/util/clock"
 	"k8s.io/client-go/kubernetes"
 
 	"github.com/google/knative-gcp/pkg/utils/clients"
 	"github.com/google/knative-gcp/test/test_images/probe_helper/probe/handlers"
+	"github.com/google/knative-gcp/test/test_images/probe_helper/utils"
 )
 
-func InitializeTestProbeHelper(ctx context.Context, brokerCellBaseUrl string, projectID clients.ProjectID, cronStaleDuration time.Duration, helperEnv EnvConfig, forwardListener ForwardListener, receiveListener ReceiveListener, storageClient *storage.Client, psClient *pubsub.Client, k8sClient kubernetes.Interface) (*Helper, error) {
+func InitializeTestProbeHelper(ctx context.Context, brokerIngressTemplate string, channelIngressTemplate handlers.ChannelIngressTemplate, projectID clients.ProjectID, cronStaleDuration time.Duration, helperEnv EnvConfig, forwardListener ForwardListener, receiveListener ReceiveListener, readinessChecker *utils.ReadinessChecker, storageClient *storage.Client, psClient *pubsub.Client, k8sClient kubernetes.Interface, clk clock.Clock) (*Helper, error) {
 	panic(wire.Build(TestHelperSet, handlers.HandlerSet))
 }
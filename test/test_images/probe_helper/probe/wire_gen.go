@@ -11,25 +11,55 @@ import (
 	"context"
 	"github.com/google/knative-gcp/pkg/utils/clients"
 	"github.com/google/knative-gcp/test/test_images/probe_helper/probe/handlers"
+	"github.com/google/knative-gcp/test/test_images/probe_helper/utils"
+	"k8s.io/apimachinery/pkg/util/clock"
 	"k8s.io/client-go/kubernetes"
 	"time"
 )
 
 // Injectors from wire.go:
 
-func InitializeTestProbeHelper(ctx context.Context, brokerCellBaseUrl string, projectID clients.ProjectID, cronStaleDuration time.Duration, helperEnv EnvConfig, forwardListener ForwardListener, receiveListener ReceiveListener, storageClient *storage.Client, psClient *pubsub.Client, k8sClient kubernetes.Interface) (*Helper, error) {
+func InitializeTestProbeHelper(ctx context.Context, brokerIngressTemplate string, channelIngressTemplate handlers.ChannelIngressTemplate, projectID clients.ProjectID, cronStaleDuration time.Duration, helperEnv EnvConfig, forwardListener ForwardListener, receiveListener ReceiveListener, readinessChecker *utils.ReadinessChecker, storageClient *storage.Client, psClient *pubsub.Client, k8sClient kubernetes.Interface, clk clock.Clock) (*Helper, error) {
+	correlationStore, err := NewCorrelationStore(helperEnv, clk)
+	if err != nil {
+		return nil, err
+	}
 	forwardClientOptions := NewTestCeForwardClientOptions(forwardListener)
-	ceForwardClient, err := NewCeForwardClient(forwardClientOptions)
+	probeMetrics, err := NewProbeMetrics(helperEnv)
+	if err != nil {
+		return nil, err
+	}
+	probeRequests := utils.NewProbeRequests()
+	ceForwardClient, err := NewCeForwardClient(helperEnv, forwardClientOptions, probeMetrics, probeRequests)
+	if err != nil {
+		return nil, err
+	}
+	brokerE2EDeliveryProbe, err := handlers.NewBrokerE2EDeliveryProbe(brokerIngressTemplate, ceForwardClient, correlationStore)
+	if err != nil {
+		return nil, err
+	}
+	brokerDLQProbe, err := handlers.NewBrokerDLQProbe(brokerIngressTemplate, ceForwardClient, correlationStore)
+	if err != nil {
+		return nil, err
+	}
+	ceForwardSender, err := NewCeForwardSender(helperEnv)
+	if err != nil {
+		return nil, err
+	}
+	brokerRejectProbe, err := handlers.NewBrokerRejectProbe(brokerIngressTemplate, ceForwardSender)
+	if err != nil {
+		return nil, err
+	}
+	channelE2EDeliveryProbe, err := handlers.NewChannelE2EDeliveryProbe(channelIngressTemplate, ceForwardClient, correlationStore)
 	if err != nil {
 		return nil, err
 	}
-	brokerE2EDeliveryProbe := handlers.NewBrokerE2EDeliveryProbe(brokerCellBaseUrl, ceForwardClient)
 	cePubSubClient, err := NewCePubSubClient(ctx, psClient)
 	if err != nil {
 		return nil, err
 	}
-	cloudPubSubSourceProbe := handlers.NewCloudPubSubSourceProbe(cePubSubClient)
-	cloudStorageSourceProbe := handlers.NewCloudStorageSourceProbe(storageClient)
+	cloudPubSubSourceProbe := handlers.NewCloudPubSubSourceProbe(cePubSubClient, correlationStore)
+	cloudStorageSourceProbe := handlers.NewCloudStorageSourceProbe(storageClient, correlationStore)
 	cloudStorageSourceCreateProbe := &handlers.CloudStorageSourceCreateProbe{
 		CloudStorageSourceProbe: cloudStorageSourceProbe,
 	}
@@ -42,8 +72,24 @@ func InitializeTestProbeHelper(ctx context.Context, brokerCellBaseUrl string, pr
 	cloudStorageSourceDeleteProbe := &handlers.CloudStorageSourceDeleteProbe{
 		CloudStorageSourceProbe: cloudStorageSourceProbe,
 	}
-	cloudAuditLogsSourceProbe := handlers.NewCloudAuditLogsSourceProbe(projectID, psClient)
-	apiServerSourceProbe := handlers.NewApiServerSourceProbe(projectID, k8sClient)
+	cloudStorageSourceComposeProbe := &handlers.CloudStorageSourceComposeProbe{
+		CloudStorageSourceProbe: cloudStorageSourceProbe,
+	}
+	cloudStorageSourcePrefixProbe := &handlers.CloudStorageSourcePrefixProbe{
+		CloudStorageSourceProbe: cloudStorageSourceProbe,
+	}
+	cloudStorageSourceResumableProbe := &handlers.CloudStorageSourceResumableProbe{
+		CloudStorageSourceProbe: cloudStorageSourceProbe,
+	}
+	auditLogsPollInterval, err := NewAuditLogsPollInterval(helperEnv)
+	if err != nil {
+		return nil, err
+	}
+	cloudAuditLogsSourceProbe := handlers.NewCloudAuditLogsSourceProbe(projectID, psClient, auditLogsPollInterval, correlationStore)
+	cloudAuditLogsSourceDeleteProbe := &handlers.CloudAuditLogsSourceDeleteProbe{
+		CloudAuditLogsSourceProbe: cloudAuditLogsSourceProbe,
+	}
+	apiServerSourceProbe := handlers.NewApiServerSourceProbe(projectID, k8sClient, correlationStore)
 	apiServerSourceCreateProbe := &handlers.ApiServerSourceCreateProbe{
 		ApiServerSourceProbe: apiServerSourceProbe,
 	}
@@ -53,15 +99,37 @@ func InitializeTestProbeHelper(ctx context.Context, brokerCellBaseUrl string, pr
 	apiServerSourceDeleteProbe := &handlers.ApiServerSourceDeleteProbe{
 		ApiServerSourceProbe: apiServerSourceProbe,
 	}
-	cloudSchedulerSourceProbe := handlers.NewCloudSchedulerSourceProbe(cronStaleDuration)
-	pingSourceProbe := handlers.NewPingSourceProbe(cronStaleDuration)
-	eventTypeProbe := handlers.NewEventTypeHandler(brokerE2EDeliveryProbe, cloudPubSubSourceProbe, cloudStorageSourceCreateProbe, cloudStorageSourceUpdateMetadataProbe, cloudStorageSourceArchiveProbe, cloudStorageSourceDeleteProbe, cloudAuditLogsSourceProbe, apiServerSourceCreateProbe, apiServerSourceUpdateProbe, apiServerSourceDeleteProbe, cloudSchedulerSourceProbe, pingSourceProbe)
+	cloudSchedulerSourceProbe := handlers.NewCloudSchedulerSourceProbe(cronStaleDuration, clk)
+	cloudSchedulerSourceRateProbe := &handlers.CloudSchedulerSourceRateProbe{
+		CloudSchedulerSourceProbe: cloudSchedulerSourceProbe,
+	}
+	pingSourceProbe := handlers.NewPingSourceProbe(cronStaleDuration, clk)
+	pubSubRoundtripProbe := handlers.NewPubSubRoundtripProbe(psClient)
+	orderingProbe := handlers.NewOrderingProbe(cloudPubSubSourceProbe, psClient)
+	eventTypeProbe := handlers.NewEventTypeHandler(brokerE2EDeliveryProbe, brokerDLQProbe, brokerRejectProbe, channelE2EDeliveryProbe, cloudPubSubSourceProbe, cloudStorageSourceCreateProbe, cloudStorageSourceUpdateMetadataProbe, cloudStorageSourceArchiveProbe, cloudStorageSourceDeleteProbe, cloudStorageSourceComposeProbe, cloudStorageSourcePrefixProbe, cloudStorageSourceResumableProbe, cloudAuditLogsSourceProbe, cloudAuditLogsSourceDeleteProbe, apiServerSourceCreateProbe, apiServerSourceUpdateProbe, apiServerSourceDeleteProbe, cloudSchedulerSourceProbe, cloudSchedulerSourceRateProbe, pingSourceProbe, pubSubRoundtripProbe, orderingProbe)
 	livenessChecker := handlers.NewLivenessChecker(cloudSchedulerSourceProbe, pingSourceProbe)
-	receiveClientOptions := NewTestCeReceiverClientOptions(receiveListener)
-	ceReceiveClient, err := NewCeReceiverClient(ctx, livenessChecker, receiveClientOptions)
+	inFlightProbes := utils.NewInFlightProbes()
+	serveMux := NewReceiverMux(ctx, livenessChecker, readinessChecker, inFlightProbes)
+	config, err := NewReceiverTLSConfig(helperEnv)
+	if err != nil {
+		return nil, err
+	}
+	receiveClientOptions := NewTestCeReceiverClientOptions(receiveListener, config)
+	ceReceiveClient, err := NewCeReceiverClient(ctx, helperEnv, serveMux, receiveClientOptions)
+	if err != nil {
+		return nil, err
+	}
+	probeGRPCListener, err := NewTestProbeGRPCListener(helperEnv, forwardListener)
+	if err != nil {
+		return nil, err
+	}
+	backendChecker, err := NewBackendChecker(helperEnv, psClient, clk)
+	if err != nil {
+		return nil, err
+	}
+	helper, err := NewHelper(helperEnv, eventTypeProbe, ceForwardClient, ceReceiveClient, livenessChecker, readinessChecker, probeMetrics, inFlightProbes, serveMux, config, probeGRPCListener, correlationStore, clk, backendChecker, probeRequests)
 	if err != nil {
 		return nil, err
 	}
-	helper := NewHelper(helperEnv, eventTypeProbe, ceForwardClient, ceReceiveClient, livenessChecker)
 	return helper, nil
 }
@@ -0,0 +1,61 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/google/knative-gcp/test/test_images/probe_helper/probe"
+)
+
+// statusCommand is the subcommand which queries the status of a running probe
+// helper rather than running one.
+const statusCommand = "status"
+
+// runStatus prints the status of a running probe helper, and returns the exit
+// code of the status subcommand: 0 if the probe helper is healthy, 1 if it is
+// not, and 2 if its status cannot be queried.
+func runStatus(args []string) int {
+	flags := flag.NewFlagSet(statusCommand, flag.ContinueOnError)
+	url := flags.String("url", "http://localhost:8080", "base URL of the receiver client of the probe helper")
+	lastN := flags.Int("n", 10, "number of the most recent probe results to print")
+	timeout := flags.Duration("timeout", 10*time.Second, "timeout of the status queries")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	status, err := probe.FetchStatus(ctx, http.DefaultClient, *url)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to query probe helper status: %v\n", err)
+		return 2
+	}
+	if err := status.Print(os.Stdout, *lastN); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to print probe helper status: %v\n", err)
+		return 2
+	}
+	if !status.Healthy {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,173 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	nethttp "net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/multierr"
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"knative.dev/pkg/logging"
+)
+
+// backendCheckTimeout is the maximum duration of a single backend check.
+const backendCheckTimeout = 10 * time.Second
+
+// BackendCheck checks that a backend of the probe helper can be reached, e.g.
+// with a cheap call to its API.
+type BackendCheck func(ctx context.Context) error
+
+// BackendStatus describes the outcome of the last check of a backend.
+type BackendStatus struct {
+	// Name is the name of the backend.
+	Name string `json:"name"`
+	// Healthy is whether the last check of the backend succeeded.
+	Healthy bool `json:"healthy"`
+	// Error is the error of the last check of the backend, if it failed.
+	Error string `json:"error,omitempty"`
+	// CheckedTime is the time at which the backend was last checked.
+	CheckedTime time.Time `json:"checkedTime"`
+}
+
+func NewBackendChecker(interval time.Duration, clock clock.Clock) *BackendChecker {
+	return &BackendChecker{
+		interval: interval,
+		clock:    clock,
+	}
+}
+
+// BackendChecker periodically checks that the backends of the probe helper
+// can be reached, so that e.g. expired credentials are told apart from probes
+// which time out. The zero value checks no backend.
+type BackendChecker struct {
+	// The interval between the checks of the backends
+	interval time.Duration
+
+	// The clock which times the checks of the backends
+	clock clock.Clock
+
+	// The checks of the backends, keyed by their name
+	checks map[string]BackendCheck
+
+	// The outcome of the last check of the backends, keyed by their name
+	statusesMu sync.RWMutex
+	statuses   map[string]BackendStatus
+}
+
+// Register adds the check of a backend of a given name. The backends must be
+// registered before the checker runs.
+func (c *BackendChecker) Register(name string, check BackendCheck) {
+	if c.checks == nil {
+		c.checks = map[string]BackendCheck{}
+	}
+	c.checks[name] = check
+}
+
+// Run checks the backends at once, and then at every interval until the
+// context is done.
+func (c *BackendChecker) Run(ctx context.Context) {
+	if len(c.checks) == 0 || c.interval <= 0 {
+		return
+	}
+	ticker := c.clock.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		c.checkBackends(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
+	}
+}
+
+func (c *BackendChecker) checkBackends(ctx context.Context) {
+	for name, check := range c.checks {
+		checkCtx, cancel := context.WithTimeout(ctx, backendCheckTimeout)
+		err := check(checkCtx)
+		cancel()
+		status := BackendStatus{
+			Name:        name,
+			Healthy:     err == nil,
+			CheckedTime: c.clock.Now(),
+		}
+		if err != nil {
+			status.Error = err.Error()
+			logging.FromContext(ctx).Warnw("Backend check failed", zap.String("backend", name), zap.Error(err))
+		}
+		c.statusesMu.Lock()
+		if c.statuses == nil {
+			c.statuses = map[string]BackendStatus{}
+		}
+		c.statuses[name] = status
+		c.statusesMu.Unlock()
+	}
+}
+
+// Statuses returns the outcome of the last check of the backends which were
+// checked, sorted by name.
+func (c *BackendChecker) Statuses() []BackendStatus {
+	c.statusesMu.RLock()
+	defer c.statusesMu.RUnlock()
+
+	statuses := make([]BackendStatus, 0, len(c.statuses))
+	for _, status := range c.statuses {
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Name < statuses[j].Name
+	})
+	return statuses
+}
+
+// CheckBackends returns an ActionFunc which fails the liveness check once the
+// last check of any of the backends failed.
+func (c *BackendChecker) CheckBackends() ActionFunc {
+	return func(ctx context.Context) error {
+		var err error
+		for _, status := range c.Statuses() {
+			if !status.Healthy {
+				err = multierr.Append(err, NewLivenessError(LivenessBackendUnreachableReason, "backend %s is unreachable: %s", status.Name, status.Error))
+			}
+		}
+		return err
+	}
+}
+
+// BackendsHandlerFunc returns the HTTP handler which lists the outcome of the
+// last check of the backends. It answers 503 if any of them is unhealthy.
+func (c *BackendChecker) BackendsHandlerFunc() nethttp.HandlerFunc {
+	return func(w nethttp.ResponseWriter, req *nethttp.Request) {
+		statuses := c.Statuses()
+		w.Header().Set("Content-Type", "application/json")
+		for _, status := range statuses {
+			if !status.Healthy {
+				w.WriteHeader(nethttp.StatusServiceUnavailable)
+				break
+			}
+		}
+		if err := json.NewEncoder(w).Encode(statuses); err != nil {
+			logging.FromContext(req.Context()).Warnw("Failed to write backend statuses", zap.Error(err))
+		}
+	}
+}
@@ -0,0 +1,66 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/clock"
+)
+
+func TestBackendCheckerFakeClock(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	fakeClock := clock.NewFakeClock(time.Now())
+	checker := NewBackendChecker(time.Minute, fakeClock)
+	checked := make(chan struct{}, 1)
+	failing := false
+	checker.Register("backend", func(ctx context.Context) error {
+		defer func() { checked <- struct{}{} }()
+		if failing {
+			return errors.New("unreachable")
+		}
+		return nil
+	})
+	done := make(chan struct{})
+	go func() {
+		checker.Run(ctx)
+		close(done)
+	}()
+
+	// The backend is checked at once, and then once every interval of the
+	// clock, which also times the checks.
+	<-checked
+	if got := checker.Statuses(); len(got) != 1 || !got[0].Healthy || !got[0].CheckedTime.Equal(fakeClock.Now()) {
+		t.Errorf("statuses got=%+v, want a healthy backend checked at %s", got, fakeClock.Now())
+	}
+	for !fakeClock.HasWaiters() {
+		time.Sleep(time.Millisecond)
+	}
+	failing = true
+	fakeClock.Step(time.Minute)
+	<-checked
+	if got := checker.Statuses(); len(got) != 1 || got[0].Healthy || !got[0].CheckedTime.Equal(fakeClock.Now()) {
+		t.Errorf("statuses got=%+v, want an unhealthy backend checked at %s", got, fakeClock.Now())
+	}
+	cancel()
+	<-done
+}
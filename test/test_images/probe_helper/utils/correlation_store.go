@@ -0,0 +1,176 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/clock"
+)
+
+// ErrNotTracked is returned by Complete when no probe is tracked for the key,
+// such as when the event which caused the result matches no probe in flight.
+var ErrNotTracked = errors.New("no probe tracked")
+
+// ErrEvicted is the reason of the failure of the probes which are evicted from
+// a correlation store while they are still tracked.
+var ErrEvicted = errors.New("probe evicted")
+
+// CorrelationStore tracks the probes which wait on their events to be
+// received, keyed on the correlation key of the events. A store shared between
+// processes lets the events of a probe be forwarded and received by different
+// probe helpers.
+type CorrelationStore interface {
+	// Track starts tracking the probe of a given key, which expects a given
+	// number of events, and returns the channel on which the result of the
+	// probe is sent once it is completed, i.e. nil or the reason why it
+	// failed. The channel is only waited on until the context is done.
+	Track(ctx context.Context, key string, count int) (<-chan error, error)
+	// Complete records a result for the probe of a given key, caused by the
+	// event of a given ID. The probe is completed once it has as many
+	// successful results as the events it expects, or on its first failure.
+	// The successful results of an event which already counted toward the
+	// probe are ignored, so that redelivered events are only counted once for
+	// as long as the probe is tracked. Results with an empty event ID are
+	// always counted.
+	Complete(ctx context.Context, key, eventID string, reason error) error
+	// Evict stops tracking the probe of a given key.
+	Evict(ctx context.Context, key string) error
+}
+
+// ExpirableCorrelationStore is a CorrelationStore whose probes do not expire
+// on their own, and are evicted once they are tracked for too long instead.
+type ExpirableCorrelationStore interface {
+	CorrelationStore
+	// EvictExpired evicts the probes which were tracked for longer than a
+	// given duration, failing them with ErrEvicted, and returns their keys.
+	EvictExpired(now time.Time, maxAge time.Duration) []string
+}
+
+func NewInMemoryCorrelationStore(clock clock.Clock) *InMemoryCorrelationStore {
+	return &InMemoryCorrelationStore{
+		probes: map[string]*inMemoryProbe{},
+		clock:  clock,
+	}
+}
+
+// inMemoryProbe is a probe tracked in memory.
+type inMemoryProbe struct {
+	// The channel on which the result of the probe is sent
+	result chan error
+	// The number of events which the probe still expects
+	remaining int
+	// The IDs of the events which counted toward the probe
+	seen map[string]struct{}
+	// The time at which the probe started being tracked
+	trackedTime time.Time
+}
+
+// InMemoryCorrelationStore is a CorrelationStore which tracks the probes in a
+// synchronized map, and thus only correlates the events within a process.
+type InMemoryCorrelationStore struct {
+	sync.Mutex
+	probes map[string]*inMemoryProbe
+	// The clock with which the probes are timestamped
+	clock clock.Clock
+}
+
+var _ ExpirableCorrelationStore = (*InMemoryCorrelationStore)(nil)
+
+// Track creates the result channel of a given key.
+func (s *InMemoryCorrelationStore) Track(ctx context.Context, key string, count int) (<-chan error, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if _, ok := s.probes[key]; ok {
+		return nil, fmt.Errorf("probe already tracked for key: %s", key)
+	}
+	probe := &inMemoryProbe{
+		result:      make(chan error, 1),
+		remaining:   count,
+		seen:        map[string]struct{}{},
+		trackedTime: s.clock.Now(),
+	}
+	s.probes[key] = probe
+	return probe.result, nil
+}
+
+// Complete counts a result of the probe of a given key, unless it is the
+// successful result of an event which was already counted, and sends the
+// result of the probe on its result channel once it is completed. Only the
+// first result of a completed probe is kept.
+func (s *InMemoryCorrelationStore) Complete(ctx context.Context, key, eventID string, reason error) error {
+	s.Lock()
+	defer s.Unlock()
+
+	probe, ok := s.probes[key]
+	if !ok {
+		return fmt.Errorf("%w for key: %s", ErrNotTracked, key)
+	}
+	if reason == nil && eventID != "" {
+		if _, ok := probe.seen[eventID]; ok {
+			return nil
+		}
+		probe.seen[eventID] = struct{}{}
+	}
+	probe.remaining--
+	if reason == nil && probe.remaining > 0 {
+		return nil
+	}
+	select {
+	case probe.result <- reason:
+	default:
+	}
+	return nil
+}
+
+// Evict deletes the result channel of a given key.
+func (s *InMemoryCorrelationStore) Evict(ctx context.Context, key string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	delete(s.probes, key)
+	return nil
+}
+
+// EvictExpired fails and deletes the result channels of the probes which were
+// tracked for longer than a given duration, so that the probes whose forward
+// never cleans them up do not leak.
+func (s *InMemoryCorrelationStore) EvictExpired(now time.Time, maxAge time.Duration) []string {
+	s.Lock()
+	defer s.Unlock()
+
+	var evicted []string
+	for key, probe := range s.probes {
+		if now.Sub(probe.trackedTime) <= maxAge {
+			continue
+		}
+		select {
+		case probe.result <- ErrEvicted:
+		default:
+		}
+		delete(s.probes, key)
+		evicted = append(evicted, key)
+	}
+	sort.Strings(evicted)
+	return evicted
+}
@@ -0,0 +1,230 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"k8s.io/apimachinery/pkg/util/clock"
+)
+
+const testRedisPollInterval = 10 * time.Millisecond
+
+// newTestCorrelationStores returns the correlation stores under test keyed by
+// their name, with the Redis store backed by a miniredis server which is
+// closed along with the test.
+func newTestCorrelationStores(t *testing.T) (map[string]CorrelationStore, *miniredis.Miniredis) {
+	t.Helper()
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatal("Failed to run miniredis:", err)
+	}
+	t.Cleanup(mr.Close)
+	redisStore := NewRedisCorrelationStore(mr.Addr(), time.Minute, testRedisPollInterval)
+	t.Cleanup(func() { redisStore.Close() })
+	return map[string]CorrelationStore{
+		"inmemory": NewInMemoryCorrelationStore(clock.RealClock{}),
+		"redis":    redisStore,
+	}, mr
+}
+
+type completion struct {
+	eventID string
+	reason  error
+}
+
+func TestCorrelationStore(t *testing.T) {
+	cases := []struct {
+		name        string
+		count       int
+		evict       bool
+		completions []completion
+		// The error wanted from the last completion
+		wantErr error
+		// Whether a result is wanted, and the reason of its failure if any
+		wantResult bool
+		wantReason string
+	}{{
+		name:        "completed",
+		count:       1,
+		completions: []completion{{}},
+		wantResult:  true,
+	}, {
+		name:        "only first result kept",
+		count:       1,
+		completions: []completion{{}, {reason: errors.New("too late")}},
+		wantResult:  true,
+	}, {
+		name:        "failed",
+		count:       1,
+		completions: []completion{{reason: errors.New("rejected")}},
+		wantResult:  true,
+		wantReason:  "rejected",
+	}, {
+		name:        "failed before all events",
+		count:       2,
+		completions: []completion{{reason: errors.New("rejected")}},
+		wantResult:  true,
+		wantReason:  "rejected",
+	}, {
+		name:        "expecting more events",
+		count:       2,
+		completions: []completion{{}},
+	}, {
+		name:        "all events counted",
+		count:       2,
+		completions: []completion{{}, {}},
+		wantResult:  true,
+	}, {
+		name:        "redelivered event counted once",
+		count:       2,
+		completions: []completion{{eventID: "event-1"}, {eventID: "event-1"}},
+	}, {
+		name:        "distinct events counted",
+		count:       2,
+		completions: []completion{{eventID: "event-1"}, {eventID: "event-2"}},
+		wantResult:  true,
+	}, {
+		name:        "evicted",
+		count:       1,
+		evict:       true,
+		completions: []completion{{}},
+		wantErr:     ErrNotTracked,
+	}}
+	stores, _ := newTestCorrelationStores(t)
+	for storeName, store := range stores {
+		for _, tc := range cases {
+			store, tc := store, tc
+			t.Run(storeName+"/"+tc.name, func(t *testing.T) {
+				ctx, cancel := context.WithCancel(context.Background())
+				defer cancel()
+
+				result, err := store.Track(ctx, tc.name, tc.count)
+				if err != nil {
+					t.Fatal("Failed to track probe:", err)
+				}
+				defer store.Evict(ctx, tc.name)
+				if _, err := store.Track(ctx, tc.name, tc.count); err == nil {
+					t.Error("tracking a tracked probe got no error, want error")
+				}
+				if tc.evict {
+					if err := store.Evict(ctx, tc.name); err != nil {
+						t.Fatal("Failed to evict probe:", err)
+					}
+				}
+				for i, c := range tc.completions {
+					err := store.Complete(ctx, tc.name, c.eventID, c.reason)
+					if i < len(tc.completions)-1 && err != nil {
+						t.Fatal("Failed to complete probe:", err)
+					}
+					if i == len(tc.completions)-1 && !errors.Is(err, tc.wantErr) {
+						t.Errorf("completing probe got error %v, want %v", err, tc.wantErr)
+					}
+				}
+
+				select {
+				case reason := <-result:
+					if !tc.wantResult {
+						t.Fatalf("result got=%v, want none", reason)
+					}
+					if got := errorString(reason); got != tc.wantReason {
+						t.Errorf("result got=%q, want=%q", got, tc.wantReason)
+					}
+				case <-time.After(10 * testRedisPollInterval):
+					if tc.wantResult {
+						t.Error("got no result, want one")
+					}
+				}
+			})
+		}
+	}
+}
+
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+func TestRedisCorrelationStoreTTL(t *testing.T) {
+	ctx := context.Background()
+	stores, mr := newTestCorrelationStores(t)
+	store := stores["redis"]
+
+	// The set of the events which counted toward a probe shares the TTL of
+	// its key, and is deleted along with it.
+	if _, err := store.Track(ctx, "deduplicated", 2); err != nil {
+		t.Fatal("Failed to track probe:", err)
+	}
+	if err := store.Complete(ctx, "deduplicated", "event-1", nil); err != nil {
+		t.Fatal("Failed to complete probe:", err)
+	}
+	if ttl := mr.TTL("probe-helper/correlation/deduplicated/seen"); ttl != time.Minute {
+		t.Errorf("seen events TTL got=%s, want=%s", ttl, time.Minute)
+	}
+	if err := store.Evict(ctx, "deduplicated"); err != nil {
+		t.Fatal("Failed to evict probe:", err)
+	}
+	if mr.Exists("probe-helper/correlation/deduplicated/seen") {
+		t.Error("seen events of an evicted probe were not deleted")
+	}
+
+	// The keys of the probes expire after the TTL.
+	if _, err := store.Track(ctx, "expiring", 1); err != nil {
+		t.Fatal("Failed to track probe:", err)
+	}
+	if ttl := mr.TTL("probe-helper/correlation/expiring"); ttl != time.Minute {
+		t.Errorf("TTL got=%s, want=%s", ttl, time.Minute)
+	}
+	mr.FastForward(time.Minute)
+	if err := store.Complete(ctx, "expiring", "", nil); !errors.Is(err, ErrNotTracked) {
+		t.Errorf("completing an expired probe got error %v, want %v", err, ErrNotTracked)
+	}
+}
+
+func TestInMemoryCorrelationStoreEvictExpired(t *testing.T) {
+	ctx := context.Background()
+	clk := clock.NewFakeClock(time.Now())
+	store := NewInMemoryCorrelationStore(clk)
+	result, err := store.Track(ctx, "expired", 1)
+	if err != nil {
+		t.Fatal("Failed to track probe:", err)
+	}
+	clk.Step(time.Hour)
+	if _, err := store.Track(ctx, "recent", 1); err != nil {
+		t.Fatal("Failed to track probe:", err)
+	}
+
+	if got := store.EvictExpired(clk.Now(), time.Minute); len(got) != 1 || got[0] != "expired" {
+		t.Errorf("evicted keys got=%v, want=[expired]", got)
+	}
+	// The expired probe fails, and can no longer be completed.
+	if reason := <-result; !errors.Is(reason, ErrEvicted) {
+		t.Errorf("result got=%v, want=%v", reason, ErrEvicted)
+	}
+	if err := store.Complete(ctx, "expired", "", nil); !errors.Is(err, ErrNotTracked) {
+		t.Errorf("completing an expired probe got error %v, want %v", err, ErrNotTracked)
+	}
+	if err := store.Complete(ctx, "recent", "", nil); err != nil {
+		t.Errorf("completing a recent probe got error %v, want none", err)
+	}
+}
@@ -17,7 +17,13 @@ limitations under the License.
 package utils
 
 import (
+	"fmt"
+	"strconv"
 	"strings"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/cloudevents/sdk-go/v2/types"
 )
 
 const (
@@ -38,9 +44,56 @@ const (
 	// be made to include the header 'Ce-Targetpath: /some-path-goes-here'.
 	ProbeEventTargetPathExtension   = "targetpath"
 	ProbeEventReceiverPathExtension = "receiverpath"
+
+	// This is the CloudEvent extension which marks the warmup probes, whose
+	// events prime the forward connection and the source path of a probe
+	// without being waited on. The receiver client drops the events it
+	// delivers back.
+	ProbeEventWarmupExtension = "warmup"
+
+	// This is the CloudEvent extension which holds the time of the condition
+	// which triggered a source to emit an event, e.g. the scheduled tick of a
+	// PingSource, while the time of the event is when the source emitted it.
+	// The sources which set it have their emit latency measured apart from the
+	// delivery of their events.
+	ProbeEventTriggerTimeExtension = "triggertime"
+
+	// This is the CloudEvent extension which the forward client sets on each
+	// probe event to the token of the request which submitted it, overwriting
+	// any value set by the sender. It is removed before the probe is forwarded.
+	ProbeEventRequestExtension = "proberequest"
 )
 
 var (
 	ProbeEventTargetPathHeader   = "Ce-" + strings.Title(ProbeEventTargetPathExtension)
 	ProbeEventReceiverPathHeader = "Ce-" + strings.Title(ProbeEventReceiverPathExtension)
 )
+
+// SourceEmitLatency returns the time between the condition which triggered a
+// source to emit an event and the emission of the event, if the event carries
+// both.
+func SourceEmitLatency(event cloudevents.Event) (time.Duration, bool) {
+	value, ok := event.Extensions()[ProbeEventTriggerTimeExtension]
+	if !ok || event.Time().IsZero() {
+		return 0, false
+	}
+	triggerTime, err := types.ToTime(value)
+	if err != nil {
+		return 0, false
+	}
+	latency := event.Time().Sub(triggerTime)
+	if latency < 0 {
+		return 0, false
+	}
+	return latency, true
+}
+
+// IsWarmupProbeEvent returns whether an event is sent by a warmup probe.
+func IsWarmupProbeEvent(event cloudevents.Event) bool {
+	value, ok := event.Extensions()[ProbeEventWarmupExtension]
+	if !ok {
+		return false
+	}
+	warmup, err := strconv.ParseBool(fmt.Sprint(value))
+	return err == nil && warmup
+}
@@ -0,0 +1,175 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	nethttp "net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"knative.dev/pkg/logging"
+)
+
+func NewInFlightProbes() *InFlightProbes {
+	return &InFlightProbes{
+		probes: map[uint64]*inFlightEntry{},
+	}
+}
+
+// InFlightProbe describes a forward probe request which is waiting on its
+// result.
+type InFlightProbe struct {
+	// ID is the ID of the probe event.
+	ID string `json:"id"`
+	// Type is the type of the probe event.
+	Type string `json:"type"`
+	// ReceivedTime is the time at which the probe event was received.
+	ReceivedTime time.Time `json:"receivedTime"`
+	// Deadline is the time at which the probe times out.
+	Deadline time.Time `json:"deadline"`
+	// Evicted is whether the probe was evicted for outliving its deadline,
+	// while its forward has yet to return.
+	Evicted bool `json:"evicted,omitempty"`
+}
+
+// inFlightEntry is a tracked in-flight probe.
+type inFlightEntry struct {
+	probe InFlightProbe
+	// The function which abandons the forward of the probe once it is evicted
+	evict func()
+}
+
+// InFlightProbes is a synchronized set of the probes which are in flight.
+type InFlightProbes struct {
+	sync.RWMutex
+	probes map[uint64]*inFlightEntry
+	nextID uint64
+}
+
+// Add tracks a probe as in flight, with the function which abandons its
+// forward once it is evicted, and returns the function which stops tracking
+// it.
+func (p *InFlightProbes) Add(probe InFlightProbe, evict func()) func() {
+	p.Lock()
+	defer p.Unlock()
+
+	id := p.nextID
+	p.nextID++
+	p.probes[id] = &inFlightEntry{probe: probe, evict: evict}
+	return func() {
+		p.Lock()
+		defer p.Unlock()
+
+		delete(p.probes, id)
+	}
+}
+
+// Len returns the number of probes which are in flight.
+func (p *InFlightProbes) Len() int {
+	p.RLock()
+	defer p.RUnlock()
+
+	return len(p.probes)
+}
+
+// Get returns the probe in flight with a given event ID, if any. When several
+// probes with the same ID are in flight, the one received last is returned.
+func (p *InFlightProbes) Get(id string) (InFlightProbe, bool) {
+	p.RLock()
+	defer p.RUnlock()
+
+	var latest InFlightProbe
+	found := false
+	for _, entry := range p.probes {
+		probe := entry.probe
+		if probe.ID == id && (!found || probe.ReceivedTime.After(latest.ReceivedTime)) {
+			latest, found = probe, true
+		}
+	}
+	return latest, found
+}
+
+// EvictExpired evicts the probes whose deadline passed by more than a grace
+// period, and returns them. The forwards of the evicted probes are abandoned,
+// but the probes are tracked until their forwards return, so that the stuck
+// forwards remain listed and drained. Each probe is only evicted once.
+func (p *InFlightProbes) EvictExpired(now time.Time, gracePeriod time.Duration) []InFlightProbe {
+	p.Lock()
+	var evicted []InFlightProbe
+	var evictFuncs []func()
+	for _, entry := range p.probes {
+		if !entry.probe.Evicted && now.Sub(entry.probe.Deadline) > gracePeriod {
+			entry.probe.Evicted = true
+			evicted = append(evicted, entry.probe)
+			if entry.evict != nil {
+				evictFuncs = append(evictFuncs, entry.evict)
+			}
+		}
+	}
+	p.Unlock()
+
+	for _, evict := range evictFuncs {
+		evict()
+	}
+	return evicted
+}
+
+// List returns the probes which are in flight, oldest first.
+func (p *InFlightProbes) List() []InFlightProbe {
+	p.RLock()
+	defer p.RUnlock()
+
+	probes := make([]InFlightProbe, 0, len(p.probes))
+	for _, entry := range p.probes {
+		probes = append(probes, entry.probe)
+	}
+	sort.Slice(probes, func(i, j int) bool {
+		return probes[i].ReceivedTime.Before(probes[j].ReceivedTime)
+	})
+	return probes
+}
+
+// inFlightProbeStatus is the representation of an in-flight probe served for
+// debugging.
+type inFlightProbeStatus struct {
+	InFlightProbe
+	// WaitingDuration is how long the probe has been waiting on its result.
+	WaitingDuration string `json:"waitingDuration"`
+}
+
+// ProbesHandlerFunc returns the HTTP handler which lists the in-flight probes.
+func (p *InFlightProbes) ProbesHandlerFunc(ctx context.Context) nethttp.HandlerFunc {
+	return func(w nethttp.ResponseWriter, req *nethttp.Request) {
+		now := time.Now()
+		probes := p.List()
+		statuses := make([]inFlightProbeStatus, 0, len(probes))
+		for _, probe := range probes {
+			statuses = append(statuses, inFlightProbeStatus{
+				InFlightProbe:   probe,
+				WaitingDuration: now.Sub(probe.ReceivedTime).String(),
+			})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(statuses); err != nil {
+			logging.FromContext(ctx).Warnw("Failed to write in-flight probes", zap.Error(err))
+		}
+	}
+}
@@ -0,0 +1,74 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestInFlightProbesEvictExpired(t *testing.T) {
+	now := time.Now()
+	cases := []struct {
+		name        string
+		deadline    time.Time
+		evictions   int
+		wantEvicted bool
+	}{{
+		name:     "before deadline",
+		deadline: now.Add(time.Minute),
+	}, {
+		name:     "within grace period",
+		deadline: now.Add(-time.Second),
+	}, {
+		name:        "past grace period",
+		deadline:    now.Add(-time.Hour),
+		wantEvicted: true,
+	}, {
+		name:        "evicted once",
+		deadline:    now.Add(-time.Hour),
+		evictions:   1,
+		wantEvicted: true,
+	}}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			probes := NewInFlightProbes()
+			evicted := 0
+			untrack := probes.Add(InFlightProbe{ID: tc.name, Deadline: tc.deadline}, func() { evicted++ })
+			for i := 0; i < tc.evictions; i++ {
+				probes.EvictExpired(now, time.Minute)
+			}
+
+			got := probes.EvictExpired(now, time.Minute)
+			if wantNew := tc.wantEvicted && tc.evictions == 0; (len(got) == 1) != wantNew {
+				t.Errorf("newly evicted probes got=%+v, want evicted=%v", got, wantNew)
+			}
+			if want := map[bool]int{true: 1}[tc.wantEvicted]; evicted != want {
+				t.Errorf("evict calls got=%d, want=%d", evicted, want)
+			}
+			// The evicted probes are tracked until their forward returns.
+			probe, ok := probes.Get(tc.name)
+			if !ok || probe.Evicted != tc.wantEvicted {
+				t.Errorf("tracked probe got=%+v (found %v), want evicted=%v", probe, ok, tc.wantEvicted)
+			}
+			untrack()
+			if got := probes.Len(); got != 0 {
+				t.Errorf("in-flight probes got=%d, want=0", got)
+			}
+		})
+	}
+}
@@ -18,6 +18,9 @@ package utils
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	nethttp "net/http"
 
 	"go.uber.org/multierr"
@@ -25,6 +28,92 @@ import (
 	"knative.dev/pkg/logging"
 )
 
+const (
+	// The states reported in the body of the liveness check responses. The
+	// probe helper is starting until it processes its first event, and any
+	// other liveness failure is reported as stale.
+	LivenessOK       = "ok"
+	LivenessStarting = "starting"
+	LivenessStale    = "stale"
+)
+
+// LivenessReason is the machine-readable reason why a liveness check fails.
+type LivenessReason string
+
+const (
+	// The reasons why a liveness check fails, reported in the body of its
+	// response. The errors of the ActionFuncs which do not carry a reason are
+	// reported as unknown.
+	LivenessStartingReason           LivenessReason = "starting"
+	LivenessStaleForwardReason       LivenessReason = "stale_forward"
+	LivenessStaleReceiveReason       LivenessReason = "stale_receive"
+	LivenessSourceDownReason         LivenessReason = "source_down"
+	LivenessBackendUnreachableReason LivenessReason = "backend_unreachable"
+	LivenessDrainingReason           LivenessReason = "draining"
+	LivenessUnknownReason            LivenessReason = "unknown"
+)
+
+// ErrStarting is wrapped by the errors of the ActionFuncs which fail because
+// the probe helper has not processed its first event yet.
+var ErrStarting = errors.New("probe helper is starting")
+
+// LivenessError is the error of an ActionFunc which carries the reason why
+// the liveness check fails.
+type LivenessError struct {
+	Reason LivenessReason
+	Err    error
+}
+
+// NewLivenessError returns the error of an ActionFunc which fails for a given
+// reason. The error wraps the %w verb of the format, if any.
+func NewLivenessError(reason LivenessReason, format string, args ...interface{}) *LivenessError {
+	return &LivenessError{
+		Reason: reason,
+		Err:    fmt.Errorf(format, args...),
+	}
+}
+
+func (e *LivenessError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *LivenessError) Unwrap() error {
+	return e.Err
+}
+
+// LivenessFailure is the reason and detail of an error which fails the
+// liveness check.
+type LivenessFailure struct {
+	// Reason is the machine-readable reason of the failure.
+	Reason LivenessReason `json:"reason"`
+	// Detail describes the failure, e.g. the offending delay or source.
+	Detail string `json:"detail"`
+}
+
+// LivenessResponse is the JSON body of the liveness check responses.
+type LivenessResponse struct {
+	// State is either LivenessOK, LivenessStarting or LivenessStale.
+	State string `json:"state"`
+	// Reason and Detail are those of the first failure, if any.
+	Reason LivenessReason `json:"reason,omitempty"`
+	Detail string         `json:"detail,omitempty"`
+	// Failures lists every failure, in the order of their ActionFuncs.
+	Failures []LivenessFailure `json:"failures,omitempty"`
+}
+
+// livenessFailure returns the failure of an error of an ActionFunc.
+func livenessFailure(err error) LivenessFailure {
+	failure := LivenessFailure{Reason: LivenessUnknownReason, Detail: err.Error()}
+	var livenessErr *LivenessError
+	switch {
+	case errors.As(err, &livenessErr):
+		failure.Reason = livenessErr.Reason
+	case errors.Is(err, ErrStarting):
+		failure.Reason = LivenessStartingReason
+	}
+	return failure
+}
+
 // ActionFunc represents a function which is called during a liveness probe. If
 // it returns a non-nil error, the probe is considered unsuccessful.
 type ActionFunc func(context.Context) error
@@ -56,13 +145,31 @@ func (c *LivenessChecker) LivenessHandlerFunc(ctx context.Context) nethttp.Handl
 				totalErr = multierr.Append(totalErr, err)
 			}
 		}
+		w.Header().Set("Content-Type", "application/json")
 		if totalErr != nil {
-			// If any error was encountered, declare liveness failed
+			// If any error was encountered, declare liveness failed and report
+			// the liveness state along with the reason of each of the errors
 			logging.FromContext(ctx).Infow("Liveness check failed", zap.Error(totalErr))
-			w.WriteHeader(nethttp.StatusServiceUnavailable)
+			response := LivenessResponse{State: LivenessStale}
+			if errors.Is(totalErr, ErrStarting) {
+				response.State = LivenessStarting
+			}
+			for _, err := range multierr.Errors(totalErr) {
+				response.Failures = append(response.Failures, livenessFailure(err))
+			}
+			response.Reason = response.Failures[0].Reason
+			response.Detail = response.Failures[0].Detail
+			writeLivenessResponse(ctx, w, nethttp.StatusServiceUnavailable, response)
 			return
 		}
 		logging.FromContext(ctx).Info("Liveness check succeeded")
-		w.WriteHeader(nethttp.StatusOK)
+		writeLivenessResponse(ctx, w, nethttp.StatusOK, LivenessResponse{State: LivenessOK})
+	}
+}
+
+func writeLivenessResponse(ctx context.Context, w nethttp.ResponseWriter, statusCode int, response LivenessResponse) {
+	w.WriteHeader(statusCode)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		logging.FromContext(ctx).Warnw("Failed to write liveness check response", zap.Error(err))
 	}
 }
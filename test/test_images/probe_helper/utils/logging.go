@@ -0,0 +1,35 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+	"knative.dev/pkg/logging"
+)
+
+// ProbeIDLogKey is the log field which holds the ID of the probe which a log
+// line is about, so that the forward and receive log lines of a probe can be
+// correlated.
+const ProbeIDLogKey = "probe_id"
+
+// WithProbeIDLogger attaches a logger to the context which tags its log lines
+// with the ID of a probe.
+func WithProbeIDLogger(ctx context.Context, probeID string) context.Context {
+	return logging.WithLogger(ctx, logging.FromContext(ctx).With(zap.String(ProbeIDLogKey, probeID)))
+}
@@ -0,0 +1,223 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"fmt"
+	nethttp "net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	// ProbeResultACK is the result label value of successful probes.
+	ProbeResultACK = "ACK"
+	// ProbeResultNACK is the result label value of failed probes.
+	ProbeResultNACK = "NACK"
+
+	// OtherLabelValue is the value of the namespace, broker and topic labels
+	// of the probes whose targets are not in the label allowlists, which
+	// bounds the cardinality of the probe metrics.
+	OtherLabelValue = "other"
+
+	// ProbeLatencyMetric is the name of the histogram of the end to end
+	// latencies of the successful probes.
+	ProbeLatencyMetric = "probe_latency_seconds"
+
+	// SourceEmitLatencyMetric is the name of the histogram of the latencies
+	// with which the sources emit the events they deliver to the receiver,
+	// from the condition which triggers them.
+	SourceEmitLatencyMetric = "source_emit_latency_seconds"
+
+	probeTypeLabel      = "type"
+	probeResultLabel    = "result"
+	probeNamespaceLabel = "namespace"
+	probeBrokerLabel    = "broker"
+	probeTopicLabel     = "topic"
+	sourceLabel         = "source"
+
+	// averageLatencyWeight is the weight of the latest latency in the moving
+	// average of the probe latencies.
+	averageLatencyWeight = 0.2
+)
+
+// sourceEmitLatencyBuckets are the bucket boundaries of the source emit
+// latency histogram, from 1ms to about 4s. The sources emit their events well
+// within the end to end latency of the probes, whose buckets are too coarse to
+// tell their lag apart.
+var sourceEmitLatencyBuckets = prometheus.ExponentialBuckets(0.001, 2, 13)
+
+// ProbeMetrics holds the Prometheus collectors which record the outcome of
+// forward probe requests.
+type ProbeMetrics struct {
+	registry *prometheus.Registry
+
+	// latency is the histogram of end to end probe latencies, labeled by probe type.
+	latency *prometheus.HistogramVec
+
+	// sourceEmitLatency is the histogram of the latencies with which the
+	// sources emit their events, labeled by source type. It isolates the lag
+	// of the sources from their delivery.
+	sourceEmitLatency *prometheus.HistogramVec
+
+	// results is the counter of probe results, labeled by probe type, result
+	// and probe target.
+	results *prometheus.CounterVec
+
+	// The targets of the probes which are labeled as such in the result
+	// counter, the others being labeled as OtherLabelValue. The brokers are
+	// keyed by their namespace.
+	namespaces map[string]struct{}
+	brokers    map[string]map[string]struct{}
+	topics     map[string]struct{}
+
+	// averageLatency is the exponentially weighted moving average of the
+	// latencies of successful probes of any type.
+	averageLatency   time.Duration
+	averageLatencyMu sync.RWMutex
+}
+
+// ProbeTarget holds the resources which a probe targets, which label its
+// result. The resources which a probe does not target are empty.
+type ProbeTarget struct {
+	Namespace string
+	Broker    string
+	Topic     string
+}
+
+// ProbeMetricsOption is for providing individual options of the ProbeMetrics.
+type ProbeMetricsOption func(*ProbeMetrics) error
+
+// WithLabelAllowlist makes the result counter label the probes of the given
+// brokers, named as '<namespace>/<broker>', and topics with their target. The
+// namespaces of the given brokers are labeled as such as well. The probes of
+// other targets are labeled as OtherLabelValue.
+func WithLabelAllowlist(brokers, topics []string) ProbeMetricsOption {
+	return func(m *ProbeMetrics) error {
+		for _, broker := range brokers {
+			parts := strings.Split(broker, "/")
+			if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+				return fmt.Errorf("invalid broker %q in label allowlist, want '<namespace>/<broker>'", broker)
+			}
+			m.namespaces[parts[0]] = struct{}{}
+			if m.brokers[parts[0]] == nil {
+				m.brokers[parts[0]] = map[string]struct{}{}
+			}
+			m.brokers[parts[0]][parts[1]] = struct{}{}
+		}
+		for _, topic := range topics {
+			m.topics[topic] = struct{}{}
+		}
+		return nil
+	}
+}
+
+// NewProbeMetrics creates the probe metrics collectors and registers them in a
+// dedicated registry. If no bucket boundaries are given, the Prometheus
+// default buckets are used.
+func NewProbeMetrics(buckets []float64, opts ...ProbeMetricsOption) (*ProbeMetrics, error) {
+	if len(buckets) == 0 {
+		buckets = prometheus.DefBuckets
+	}
+	m := &ProbeMetrics{
+		registry: prometheus.NewRegistry(),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    ProbeLatencyMetric,
+			Help:    "The end to end latency of successful probes, in seconds",
+			Buckets: buckets,
+		}, []string{probeTypeLabel}),
+		sourceEmitLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    SourceEmitLatencyMetric,
+			Help:    "The latency with which the sources emit their events from the condition which triggers them, in seconds",
+			Buckets: sourceEmitLatencyBuckets,
+		}, []string{sourceLabel}),
+		results: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "probe_result_total",
+			Help: "The number of completed probes",
+		}, []string{probeTypeLabel, probeResultLabel, probeNamespaceLabel, probeBrokerLabel, probeTopicLabel}),
+		namespaces: map[string]struct{}{},
+		brokers:    map[string]map[string]struct{}{},
+		topics:     map[string]struct{}{},
+	}
+	for _, opt := range opts {
+		if err := opt(m); err != nil {
+			return nil, err
+		}
+	}
+	if err := m.registry.Register(m.latency); err != nil {
+		return nil, err
+	}
+	if err := m.registry.Register(m.results); err != nil {
+		return nil, err
+	}
+	if err := m.registry.Register(m.sourceEmitLatency); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// ReportProbeLatency records the latency of a successful probe.
+func (m *ProbeMetrics) ReportProbeLatency(probeType string, latency time.Duration) {
+	m.latency.WithLabelValues(probeType).Observe(latency.Seconds())
+
+	m.averageLatencyMu.Lock()
+	defer m.averageLatencyMu.Unlock()
+	if m.averageLatency == 0 {
+		m.averageLatency = latency
+	} else {
+		m.averageLatency += time.Duration(averageLatencyWeight * float64(latency-m.averageLatency))
+	}
+}
+
+// ReportSourceEmitLatency records the latency with which a source of a given
+// type emitted an event.
+func (m *ProbeMetrics) ReportSourceEmitLatency(source string, latency time.Duration) {
+	m.sourceEmitLatency.WithLabelValues(source).Observe(latency.Seconds())
+}
+
+// AverageLatency returns the moving average of the latencies of successful
+// probes, which is zero until a probe succeeds.
+func (m *ProbeMetrics) AverageLatency() time.Duration {
+	m.averageLatencyMu.RLock()
+	defer m.averageLatencyMu.RUnlock()
+	return m.averageLatency
+}
+
+// ReportProbeResult increments the result counter of a given probe type and
+// target.
+func (m *ProbeMetrics) ReportProbeResult(probeType, result string, target ProbeTarget) {
+	namespace, broker, topic := target.Namespace, target.Broker, target.Topic
+	if _, ok := m.namespaces[namespace]; namespace != "" && !ok {
+		namespace = OtherLabelValue
+	}
+	if _, ok := m.brokers[target.Namespace][broker]; broker != "" && !ok {
+		broker = OtherLabelValue
+	}
+	if _, ok := m.topics[topic]; topic != "" && !ok {
+		topic = OtherLabelValue
+	}
+	m.results.WithLabelValues(probeType, result, namespace, broker, topic).Inc()
+}
+
+// Handler returns the HTTP handler which exports the probe metrics.
+func (m *ProbeMetrics) Handler() nethttp.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
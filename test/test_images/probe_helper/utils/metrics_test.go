@@ -0,0 +1,47 @@
+/*
+Copyright 2020 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"testing"
+)
+
+func TestProbeMetricsInvalidLabelAllowlist(t *testing.T) {
+	cases := []struct {
+		name   string
+		broker string
+	}{{
+		name:   "no namespace",
+		broker: "default",
+	}, {
+		name:   "empty namespace",
+		broker: "/default",
+	}, {
+		name:   "empty broker",
+		broker: "test-namespace/",
+	}, {
+		name:   "too many segments",
+		broker: "test-namespace/default/other",
+	}}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := NewProbeMetrics(nil, WithLabelAllowlist([]string{tc.broker}, nil)); err == nil {
+				t.Errorf("NewProbeMetrics got no error for broker %q in label allowlist, want error", tc.broker)
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"context"
+	"strconv"
+	"sync"
+)
+
+func NewProbeRequests() *ProbeRequests {
+	return &ProbeRequests{}
+}
+
+// ProbeRequests is a synchronized registry of the contexts of the requests
+// which submitted the probes being forwarded, keyed by a token which is unique
+// to each request. The CloudEvents clients do not hand the context of a request
+// to its handler, which looks it up here with the token carried by its probe
+// event instead. The zero value is ready to use.
+type ProbeRequests struct {
+	mu       sync.Mutex
+	requests map[string]context.Context
+	next     uint64
+}
+
+// Register records the context of a request submitting a probe, and returns
+// the token of the request along with the function which removes it.
+func (r *ProbeRequests) Register(ctx context.Context) (string, func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.requests == nil {
+		r.requests = map[string]context.Context{}
+	}
+	token := strconv.FormatUint(r.next, 10)
+	r.next++
+	r.requests[token] = ctx
+	return token, func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		delete(r.requests, token)
+	}
+}
+
+// Context returns the context of the request with a given token, if it is
+// still being served.
+func (r *ProbeRequests) Context(token string) (context.Context, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ctx, ok := r.requests[token]
+	return ctx, ok
+}
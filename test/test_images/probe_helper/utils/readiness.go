@@ -0,0 +1,84 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"context"
+	nethttp "net/http"
+	"sort"
+	"sync"
+
+	"go.uber.org/zap"
+	"knative.dev/pkg/logging"
+)
+
+func NewReadinessChecker() *ReadinessChecker {
+	return &ReadinessChecker{
+		components: map[string]bool{},
+	}
+}
+
+// ReadinessChecker tracks whether each of the components which are required
+// to serve probe requests has started.
+type ReadinessChecker struct {
+	sync.RWMutex
+	components map[string]bool
+}
+
+// Register adds a component which must be declared ready before the probe
+// helper is considered ready.
+func (c *ReadinessChecker) Register(component string) {
+	c.Lock()
+	defer c.Unlock()
+	if _, ok := c.components[component]; !ok {
+		c.components[component] = false
+	}
+}
+
+// SetReady declares a registered component ready.
+func (c *ReadinessChecker) SetReady(component string) {
+	c.Lock()
+	defer c.Unlock()
+	c.components[component] = true
+}
+
+// NotReady returns the sorted names of the registered components which have
+// not yet been declared ready.
+func (c *ReadinessChecker) NotReady() []string {
+	c.RLock()
+	defer c.RUnlock()
+	var notReady []string
+	for component, ready := range c.components {
+		if !ready {
+			notReady = append(notReady, component)
+		}
+	}
+	sort.Strings(notReady)
+	return notReady
+}
+
+// ReadinessHandlerFunc returns the HTTP handler for probe helper readiness checks.
+func (c *ReadinessChecker) ReadinessHandlerFunc(ctx context.Context) nethttp.HandlerFunc {
+	return func(w nethttp.ResponseWriter, req *nethttp.Request) {
+		if notReady := c.NotReady(); len(notReady) > 0 {
+			logging.FromContext(ctx).Infow("Readiness check failed", zap.Strings("notReady", notReady))
+			w.WriteHeader(nethttp.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(nethttp.StatusOK)
+	}
+}
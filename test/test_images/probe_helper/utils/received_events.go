@@ -22,65 +22,134 @@ import (
 	"sync"
 )
 
-func NewSyncReceivedEvents() *SyncReceivedEvents {
+// ReceivedEvents is the store on which the forward and receive handlers of a
+// probe correlate the events they send and receive. Forwarded events wait on
+// a receiver channel which is signaled once their event is received.
+type ReceivedEvents interface {
+	// CreateReceiverChannel creates the receiver channel of a given ID, and
+	// returns the function which cleans it up.
+	CreateReceiverChannel(channelID string) (func(), error)
+	// ExpectReceiverChannel creates the receiver channel of a given ID which
+	// is only signaled once it receives a given number of signals, and
+	// returns the function which cleans it up.
+	ExpectReceiverChannel(channelID string, count int) (func(), error)
+	// SignalReceiverChannel signals the receiver channel of a given ID.
+	SignalReceiverChannel(channelID string) error
+	// SignalReceiverChannelOnce signals the receiver channel of a given ID
+	// with an event of a given ID, unless the event already signaled it.
+	SignalReceiverChannelOnce(channelID, eventID string) error
+	// FailReceiverChannel signals the receiver channel of a given ID with the
+	// reason why its probe failed.
+	FailReceiverChannel(channelID string, reason error) error
+	// WaitOnReceiverChannel waits until the receiver channel of a given ID is
+	// signaled or the context expires.
+	WaitOnReceiverChannel(ctx context.Context, channelID string) error
+}
+
+var _ ReceivedEvents = (*SyncReceivedEvents)(nil)
+
+func NewSyncReceivedEvents(store CorrelationStore, prefix string) *SyncReceivedEvents {
 	return &SyncReceivedEvents{
-		Channels: map[string]chan bool{},
+		store:   store,
+		prefix:  prefix,
+		results: map[string]<-chan error{},
 	}
 }
 
-// SyncReceivedEvents is a synchronized wrapped around a map of channels.
+// SyncReceivedEvents tracks the receiver channels in a correlation store, and
+// keeps the results of the channels created in this process in a synchronized
+// map.
 type SyncReceivedEvents struct {
 	sync.RWMutex
-	Channels map[string]chan bool
+	// The store in which the receiver channels are tracked
+	store CorrelationStore
+	// The prefix of the keys of the receiver channels in the store, which
+	// keeps apart the probes sharing it
+	prefix string
+	// The results of the receiver channels created in this process
+	results map[string]<-chan error
+}
+
+func (r *SyncReceivedEvents) key(channelID string) string {
+	return r.prefix + "/" + channelID
 }
 
-// CreateReceiverChannel creates a receiver channel at a given index in a map
-// of receiver channels.
+// CreateReceiverChannel creates a receiver channel with a given ID.
 func (r *SyncReceivedEvents) CreateReceiverChannel(channelID string) (func(), error) {
+	return r.ExpectReceiverChannel(channelID, 1)
+}
+
+// ExpectReceiverChannel creates a receiver channel with a given ID which is
+// signaled once it receives a given number of signals, or on its first
+// failure signal.
+func (r *SyncReceivedEvents) ExpectReceiverChannel(channelID string, count int) (func(), error) {
 	r.Lock()
 	defer r.Unlock()
 
-	if _, ok := r.Channels[channelID]; ok {
+	if _, ok := r.results[channelID]; ok {
 		return nil, fmt.Errorf("receiver channel already exists for key:" + channelID)
 	}
-	receiverChannel := make(chan bool, 1)
-	r.Channels[channelID] = receiverChannel
+	ctx, cancel := context.WithCancel(context.Background())
+	result, err := r.store.Track(ctx, r.key(channelID), count)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to track receiver channel %s: %v", channelID, err)
+	}
+	r.results[channelID] = result
 	cleanupFunc := func() {
+		cancel()
+		r.store.Evict(context.Background(), r.key(channelID))
+
 		r.Lock()
 		defer r.Unlock()
 
-		close(receiverChannel)
-		delete(r.Channels, channelID)
+		delete(r.results, channelID)
 	}
 	return cleanupFunc, nil
 }
 
-// SignalReceiverChannel sends a closing signal to a receiver channel at a given
-// index in a map of receiver channels.
+// SignalReceiverChannel sends a closing signal to the receiver channel with a
+// given ID.
 func (r *SyncReceivedEvents) SignalReceiverChannel(channelID string) error {
-	r.RLock()
-	defer r.RUnlock()
+	return r.signalReceiverChannel(channelID, "", nil)
+}
 
-	receiverChannel, ok := r.Channels[channelID]
-	if !ok {
-		return fmt.Errorf("failed to signal non-existent channel:" + channelID)
+// SignalReceiverChannelOnce sends a closing signal to the receiver channel
+// with a given ID on behalf of an event, which only counts once toward the
+// signals the channel expects however many times the event is delivered.
+func (r *SyncReceivedEvents) SignalReceiverChannelOnce(channelID, eventID string) error {
+	return r.signalReceiverChannel(channelID, eventID, nil)
+}
+
+// FailReceiverChannel sends a failure signal to the receiver channel with a
+// given ID, which makes the wait on the channel return an error carrying the
+// reason of the failure.
+func (r *SyncReceivedEvents) FailReceiverChannel(channelID string, reason error) error {
+	return r.signalReceiverChannel(channelID, "", reason)
+}
+
+func (r *SyncReceivedEvents) signalReceiverChannel(channelID, eventID string, reason error) error {
+	if err := r.store.Complete(context.Background(), r.key(channelID), eventID, reason); err != nil {
+		return fmt.Errorf("failed to signal non-existent channel:%s: %w", channelID, err)
 	}
-	receiverChannel <- true
 	return nil
 }
 
-// WaitOnReceiverChannel waits on a receiver channel at a given index until it
+// WaitOnReceiverChannel waits on the receiver channel with a given ID until it
 // receives something or until the context expires.
 func (r *SyncReceivedEvents) WaitOnReceiverChannel(ctx context.Context, channelID string) error {
 	r.RLock()
-	receiverChannel, ok := r.Channels[channelID]
+	result, ok := r.results[channelID]
 	r.RUnlock()
 	if !ok {
 		return fmt.Errorf("failed to wait on non-existent channel:" + channelID)
 	}
 
 	select {
-	case <-receiverChannel:
+	case reason := <-result:
+		if reason != nil {
+			return fmt.Errorf("receiver channel signaled a failure: %v", reason)
+		}
 		return nil
 	case <-ctx.Done():
 		return fmt.Errorf("timed out waiting for receiver channel")
@@ -0,0 +1,96 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSyncReceivedEventsDeduplication(t *testing.T) {
+	cases := []struct {
+		name     string
+		count    int
+		eventIDs []string
+		wantDone bool
+	}{{
+		name:     "redelivered event counted once",
+		count:    2,
+		eventIDs: []string{"event-1", "event-1"},
+	}, {
+		name:     "distinct events counted",
+		count:    2,
+		eventIDs: []string{"event-1", "event-2"},
+		wantDone: true,
+	}, {
+		name:     "anonymous signals always counted",
+		count:    2,
+		eventIDs: []string{"", ""},
+		wantDone: true,
+	}}
+	stores, _ := newTestCorrelationStores(t)
+	for storeName, store := range stores {
+		for _, tc := range cases {
+			store, tc := store, tc
+			t.Run(storeName+"/"+tc.name, func(t *testing.T) {
+				receivedEvents := NewSyncReceivedEvents(store, "deduplication")
+				cleanup, err := receivedEvents.ExpectReceiverChannel(tc.name, tc.count)
+				if err != nil {
+					t.Fatal("Failed to create receiver channel:", err)
+				}
+				defer cleanup()
+				for _, id := range tc.eventIDs {
+					if err := receivedEvents.SignalReceiverChannelOnce(tc.name, id); err != nil {
+						t.Fatal("Failed to signal receiver channel:", err)
+					}
+				}
+				ctx, cancel := context.WithTimeout(context.Background(), 10*testRedisPollInterval)
+				defer cancel()
+				err = receivedEvents.WaitOnReceiverChannel(ctx, tc.name)
+				if done := err == nil; done != tc.wantDone {
+					t.Errorf("receiver channel done got=%v (error %v), want=%v", done, err, tc.wantDone)
+				}
+			})
+		}
+	}
+}
+
+func TestSyncReceivedEventsReusedChannel(t *testing.T) {
+	stores, _ := newTestCorrelationStores(t)
+	for storeName, store := range stores {
+		t.Run(storeName, func(t *testing.T) {
+			receivedEvents := NewSyncReceivedEvents(store, "reuse")
+			for i := 0; i < 2; i++ {
+				// A later probe reusing the channel counts the same event again.
+				cleanup, err := receivedEvents.ExpectReceiverChannel("probe", 1)
+				if err != nil {
+					t.Fatal("Failed to create receiver channel:", err)
+				}
+				if err := receivedEvents.SignalReceiverChannelOnce("probe", "event-1"); err != nil {
+					t.Fatal("Failed to signal receiver channel:", err)
+				}
+				ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+				if err := receivedEvents.WaitOnReceiverChannel(ctx, "probe"); err != nil {
+					t.Errorf("receiver channel of probe %d got error %v, want none", i, err)
+				}
+				cancel()
+				cleanup()
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"encoding/json"
+	nethttp "net/http"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"knative.dev/pkg/logging"
+)
+
+// NewRecentResults creates the buffer of the results of the last completed
+// probes, which holds at most a given number of them.
+func NewRecentResults(size int) *RecentResults {
+	return &RecentResults{
+		results: make([]ProbeResult, size),
+	}
+}
+
+// ProbeResult describes the outcome of a completed probe.
+type ProbeResult struct {
+	// ID is the ID of the probe event.
+	ID string `json:"id"`
+	// Type is the type of the probe event.
+	Type string `json:"type"`
+	// Result is either ProbeResultACK or ProbeResultNACK.
+	Result string `json:"result"`
+	// Reason is the reason why the probe failed, if it did.
+	Reason string `json:"reason,omitempty"`
+	// LatencyMs is the latency of the probe, in milliseconds.
+	LatencyMs int64 `json:"latencyMs"`
+	// CompletedTime is the time at which the probe completed.
+	CompletedTime time.Time `json:"completedTime"`
+}
+
+// RecentResults is a synchronized ring buffer of the results of the last
+// completed probes, which are overwritten oldest first once it is full.
+type RecentResults struct {
+	sync.RWMutex
+	results []ProbeResult
+	// The index at which the next result is written
+	next int
+	// The number of results held
+	len int
+}
+
+// Add records the result of a completed probe.
+func (r *RecentResults) Add(result ProbeResult) {
+	r.Lock()
+	defer r.Unlock()
+
+	if len(r.results) == 0 {
+		return
+	}
+	r.results[r.next] = result
+	r.next = (r.next + 1) % len(r.results)
+	if r.len < len(r.results) {
+		r.len++
+	}
+}
+
+// List returns the results held, most recent first.
+func (r *RecentResults) List() []ProbeResult {
+	r.RLock()
+	defer r.RUnlock()
+
+	results := make([]ProbeResult, 0, r.len)
+	for i := 1; i <= r.len; i++ {
+		results = append(results, r.results[(r.next-i+len(r.results))%len(r.results)])
+	}
+	return results
+}
+
+// ResultsHandlerFunc returns the HTTP handler which lists the results of the
+// last completed probes.
+func (r *RecentResults) ResultsHandlerFunc() nethttp.HandlerFunc {
+	return func(w nethttp.ResponseWriter, req *nethttp.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(r.List()); err != nil {
+			logging.FromContext(req.Context()).Warnw("Failed to write recent probe results", zap.Error(err))
+		}
+	}
+}
@@ -0,0 +1,213 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+const (
+	// redisKeyPrefix is the prefix of the Redis keys of the tracked probes.
+	redisKeyPrefix = "probe-helper/correlation/"
+	// redisSeenSuffix is the suffix of the Redis keys of the sets of the IDs
+	// of the events which counted toward the tracked probes.
+	redisSeenSuffix = "/seen"
+
+	// The values of the Redis key of a tracked probe, which is pending with
+	// the number of events it still expects until the probe is completed with
+	// a result.
+	redisPendingPrefix = "pending:"
+	redisSucceedValue  = "succeeded"
+	redisFailurePrefix = "failed:"
+
+	// redisMaxIdleConns is the maximum number of idle connections to Redis.
+	redisMaxIdleConns = 8
+)
+
+// redisCompleteScript counts a result of a probe which is still pending,
+// unless it is the successful result of an event which was already counted,
+// and sets the result of the probe once it expects no more events or on its
+// first failure, so that only the first result of a completed probe is kept.
+// The TTL of the key of the probe is kept, and shared by the set of the IDs of
+// the events which counted toward it.
+var redisCompleteScript = redis.NewScript(2, `
+local value = redis.call('GET', KEYS[1])
+if not value then
+	return 0
+end
+local pending = string.match(value, '^pending:(%d+)$')
+if not pending then
+	return 1
+end
+if ARGV[1] == ARGV[2] and ARGV[3] ~= '' then
+	if redis.call('SADD', KEYS[2], ARGV[3]) == 0 then
+		return 1
+	end
+	redis.call('PEXPIRE', KEYS[2], redis.call('PTTL', KEYS[1]))
+end
+local remaining = tonumber(pending)
+if ARGV[1] ~= ARGV[2] or remaining <= 1 then
+	redis.call('SET', KEYS[1], ARGV[1], 'KEEPTTL')
+else
+	redis.call('SET', KEYS[1], 'pending:' .. (remaining - 1), 'KEEPTTL')
+end
+return 1
+`)
+
+func NewRedisCorrelationStore(address string, ttl, pollInterval time.Duration) *RedisCorrelationStore {
+	return &RedisCorrelationStore{
+		pool: &redis.Pool{
+			MaxIdle: redisMaxIdleConns,
+			DialContext: func(ctx context.Context) (redis.Conn, error) {
+				return redis.DialContext(ctx, "tcp", address)
+			},
+		},
+		ttl:          ttl,
+		pollInterval: pollInterval,
+	}
+}
+
+// RedisCorrelationStore is a CorrelationStore which tracks the probes in
+// Redis, and thus correlates the events between the probe helpers sharing it.
+// The keys of the probes expire after a TTL, so that the probes which are
+// never evicted, e.g. because their probe helper crashed, are still cleaned up.
+type RedisCorrelationStore struct {
+	pool *redis.Pool
+
+	// The TTL of the keys of the tracked probes
+	ttl time.Duration
+
+	// The interval at which the keys of the tracked probes are polled for
+	// their result
+	pollInterval time.Duration
+}
+
+var _ CorrelationStore = (*RedisCorrelationStore)(nil)
+
+func redisKey(key string) string {
+	return redisKeyPrefix + key
+}
+
+func redisSeenKey(key string) string {
+	return redisKey(key) + redisSeenSuffix
+}
+
+// Track sets the key of a probe as pending, and polls it for its result until
+// the context is done or the key is gone.
+func (s *RedisCorrelationStore) Track(ctx context.Context, key string, count int) (<-chan error, error) {
+	conn, err := s.pool.GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	reply, err := redis.String(conn.Do("SET", redisKey(key), fmt.Sprintf("%s%d", redisPendingPrefix, count), "PX", s.ttl.Milliseconds(), "NX"))
+	if errors.Is(err, redis.ErrNil) {
+		return nil, fmt.Errorf("probe already tracked for key: %s", key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if reply != "OK" {
+		return nil, fmt.Errorf("unexpected reply to tracking probe for key %s: %s", key, reply)
+	}
+	result := make(chan error, 1)
+	go s.poll(ctx, key, result)
+	return result, nil
+}
+
+// poll sends the result of a probe on its result channel once it is set.
+func (s *RedisCorrelationStore) poll(ctx context.Context, key string, result chan<- error) {
+	ticker := time.NewTicker(s.pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		value, err := s.get(ctx, key)
+		if errors.Is(err, redis.ErrNil) {
+			// The probe was evicted or its key expired.
+			return
+		}
+		if err != nil || strings.HasPrefix(value, redisPendingPrefix) {
+			continue
+		}
+		if strings.HasPrefix(value, redisFailurePrefix) {
+			result <- errors.New(strings.TrimPrefix(value, redisFailurePrefix))
+		} else {
+			result <- nil
+		}
+		return
+	}
+}
+
+func (s *RedisCorrelationStore) get(ctx context.Context, key string) (string, error) {
+	conn, err := s.pool.GetContext(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	return redis.String(conn.Do("GET", redisKey(key)))
+}
+
+// Complete sets the result of a pending probe.
+func (s *RedisCorrelationStore) Complete(ctx context.Context, key, eventID string, reason error) error {
+	conn, err := s.pool.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	value := redisSucceedValue
+	if reason != nil {
+		value = redisFailurePrefix + reason.Error()
+	}
+	tracked, err := redis.Bool(redisCompleteScript.Do(conn, redisKey(key), redisSeenKey(key), value, redisSucceedValue, eventID))
+	if err != nil {
+		return err
+	}
+	if !tracked {
+		return fmt.Errorf("%w for key: %s", ErrNotTracked, key)
+	}
+	return nil
+}
+
+// Evict deletes the key of a probe, along with the IDs of the events which
+// counted toward it.
+func (s *RedisCorrelationStore) Evict(ctx context.Context, key string) error {
+	conn, err := s.pool.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Do("DEL", redisKey(key), redisSeenKey(key))
+	return err
+}
+
+// Close closes the connections to Redis.
+func (s *RedisCorrelationStore) Close() error {
+	return s.pool.Close()
+}
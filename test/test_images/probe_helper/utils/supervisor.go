@@ -0,0 +1,114 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/multierr"
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"knative.dev/pkg/logging"
+)
+
+// ReceiveFunc is a receive loop, which runs until its context is done or it
+// fails.
+type ReceiveFunc func(context.Context) error
+
+func NewReceiveSupervisor(backoff wait.Backoff) *ReceiveSupervisor {
+	return &ReceiveSupervisor{
+		backoff: backoff,
+		failing: map[string]error{},
+	}
+}
+
+// ReceiveSupervisor restarts the receive loops which fail unexpectedly, e.g.
+// the receivers of the Pub/Sub subscriptions, so that a transient error does
+// not stop the receiving for good. Each loop is restarted after a growing
+// backoff, as many times as the steps of the backoff, and is reported by the
+// liveness check until it is restarted.
+type ReceiveSupervisor struct {
+	// The backoff between the restarts of a receive loop
+	backoff wait.Backoff
+
+	// The last error of the receive loops which are not running, keyed by
+	// their name
+	failingMu sync.RWMutex
+	failing   map[string]error
+}
+
+// Run runs a receive loop of a given name until the context is done, the loop
+// returns without error, or it has failed once more than it can be
+// restarted, in which case its last error is returned.
+func (s *ReceiveSupervisor) Run(ctx context.Context, name string, receive ReceiveFunc) error {
+	backoff := s.backoff
+	for {
+		err := receive(ctx)
+		if err == nil || ctx.Err() != nil {
+			s.setFailing(name, nil)
+			return nil
+		}
+		s.setFailing(name, err)
+		if backoff.Steps <= 0 {
+			logging.FromContext(ctx).Errorw("Receive loop failed, giving up on restarting it", zap.String("name", name), zap.Error(err))
+			return err
+		}
+		delay := backoff.Step()
+		logging.FromContext(ctx).Warnw("Receive loop failed, restarting it", zap.String("name", name), zap.Duration("backoff", delay), zap.Error(err))
+		select {
+		case <-ctx.Done():
+			s.setFailing(name, nil)
+			return nil
+		case <-time.After(delay):
+		}
+		s.setFailing(name, nil)
+	}
+}
+
+func (s *ReceiveSupervisor) setFailing(name string, err error) {
+	s.failingMu.Lock()
+	defer s.failingMu.Unlock()
+	if err == nil {
+		delete(s.failing, name)
+	} else {
+		s.failing[name] = err
+	}
+}
+
+// CheckReceiving returns an ActionFunc which fails the liveness check while
+// any of the receive loops is not running.
+func (s *ReceiveSupervisor) CheckReceiving() ActionFunc {
+	return func(ctx context.Context) error {
+		s.failingMu.RLock()
+		defer s.failingMu.RUnlock()
+
+		names := make([]string, 0, len(s.failing))
+		for name := range s.failing {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		var err error
+		for _, name := range names {
+			err = multierr.Append(err, fmt.Errorf("receive loop %s is not running: %v", name, s.failing[name]))
+		}
+		return err
+	}
+}
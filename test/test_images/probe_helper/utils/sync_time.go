@@ -27,11 +27,11 @@ type SyncTime struct {
 	time time.Time
 }
 
-// SetNow sets the desired timestamp to the current time.
-func (t *SyncTime) SetNow() {
+// Set sets the desired timestamp to a given time.
+func (t *SyncTime) Set(now time.Time) {
 	t.Lock()
 	defer t.Unlock()
-	t.time = time.Now()
+	t.time = now
 }
 
 // Get gets the timestamp's time.
@@ -10,19 +10,50 @@ import (
 	"github.com/google/knative-gcp/pkg/utils/clients"
 	"github.com/google/knative-gcp/test/test_images/probe_helper/probe"
 	"github.com/google/knative-gcp/test/test_images/probe_helper/probe/handlers"
+	"github.com/google/knative-gcp/test/test_images/probe_helper/utils"
 	"time"
 )
 
 // Injectors from wire.go:
 
-func InitializeProbeHelper(ctx context.Context, brokerCellBaseUrl string, projectID clients.ProjectID, cronStaleDuration time.Duration, helperEnv probe.EnvConfig, forwardPort probe.ForwardPort, receivePort probe.ReceivePort) (*probe.Helper, error) {
+func InitializeProbeHelper(ctx context.Context, brokerIngressTemplate string, channelIngressTemplate handlers.ChannelIngressTemplate, projectID clients.ProjectID, cronStaleDuration time.Duration, helperEnv probe.EnvConfig, forwardPort probe.ForwardPort, receivePort probe.ReceivePort) (*probe.Helper, error) {
+	clock := probe.NewClock()
+	correlationStore, err := probe.NewCorrelationStore(helperEnv, clock)
+	if err != nil {
+		return nil, err
+	}
 	forwardClientOptions := probe.NewCeForwardClientOptions(forwardPort)
-	ceForwardClient, err := probe.NewCeForwardClient(forwardClientOptions)
+	probeMetrics, err := probe.NewProbeMetrics(helperEnv)
+	if err != nil {
+		return nil, err
+	}
+	probeRequests := utils.NewProbeRequests()
+	ceForwardClient, err := probe.NewCeForwardClient(helperEnv, forwardClientOptions, probeMetrics, probeRequests)
+	if err != nil {
+		return nil, err
+	}
+	brokerE2EDeliveryProbe, err := handlers.NewBrokerE2EDeliveryProbe(brokerIngressTemplate, ceForwardClient, correlationStore)
+	if err != nil {
+		return nil, err
+	}
+	brokerDLQProbe, err := handlers.NewBrokerDLQProbe(brokerIngressTemplate, ceForwardClient, correlationStore)
 	if err != nil {
 		return nil, err
 	}
-	brokerE2EDeliveryProbe := handlers.NewBrokerE2EDeliveryProbe(brokerCellBaseUrl, ceForwardClient)
-	client, err := probe.NewPubSubClient(ctx, projectID)
+	ceForwardSender, err := probe.NewCeForwardSender(helperEnv)
+	if err != nil {
+		return nil, err
+	}
+	brokerRejectProbe, err := handlers.NewBrokerRejectProbe(brokerIngressTemplate, ceForwardSender)
+	if err != nil {
+		return nil, err
+	}
+	channelE2EDeliveryProbe, err := handlers.NewChannelE2EDeliveryProbe(channelIngressTemplate, ceForwardClient, correlationStore)
+	if err != nil {
+		return nil, err
+	}
+	gcpClientOptions := probe.NewGCPClientOptions(helperEnv)
+	client, err := probe.NewPubSubClient(ctx, projectID, gcpClientOptions)
 	if err != nil {
 		return nil, err
 	}
@@ -30,12 +61,12 @@ func InitializeProbeHelper(ctx context.Context, brokerCellBaseUrl string, projec
 	if err != nil {
 		return nil, err
 	}
-	cloudPubSubSourceProbe := handlers.NewCloudPubSubSourceProbe(cePubSubClient)
-	storageClient, err := probe.NewStorageClient(ctx)
+	cloudPubSubSourceProbe := handlers.NewCloudPubSubSourceProbe(cePubSubClient, correlationStore)
+	storageClient, err := probe.NewStorageClient(ctx, gcpClientOptions)
 	if err != nil {
 		return nil, err
 	}
-	cloudStorageSourceProbe := handlers.NewCloudStorageSourceProbe(storageClient)
+	cloudStorageSourceProbe := handlers.NewCloudStorageSourceProbe(storageClient, correlationStore)
 	cloudStorageSourceCreateProbe := &handlers.CloudStorageSourceCreateProbe{
 		CloudStorageSourceProbe: cloudStorageSourceProbe,
 	}
@@ -48,12 +79,28 @@ func InitializeProbeHelper(ctx context.Context, brokerCellBaseUrl string, projec
 	cloudStorageSourceDeleteProbe := &handlers.CloudStorageSourceDeleteProbe{
 		CloudStorageSourceProbe: cloudStorageSourceProbe,
 	}
-	cloudAuditLogsSourceProbe := handlers.NewCloudAuditLogsSourceProbe(projectID, client)
+	cloudStorageSourceComposeProbe := &handlers.CloudStorageSourceComposeProbe{
+		CloudStorageSourceProbe: cloudStorageSourceProbe,
+	}
+	cloudStorageSourcePrefixProbe := &handlers.CloudStorageSourcePrefixProbe{
+		CloudStorageSourceProbe: cloudStorageSourceProbe,
+	}
+	cloudStorageSourceResumableProbe := &handlers.CloudStorageSourceResumableProbe{
+		CloudStorageSourceProbe: cloudStorageSourceProbe,
+	}
+	auditLogsPollInterval, err := probe.NewAuditLogsPollInterval(helperEnv)
+	if err != nil {
+		return nil, err
+	}
+	cloudAuditLogsSourceProbe := handlers.NewCloudAuditLogsSourceProbe(projectID, client, auditLogsPollInterval, correlationStore)
+	cloudAuditLogsSourceDeleteProbe := &handlers.CloudAuditLogsSourceDeleteProbe{
+		CloudAuditLogsSourceProbe: cloudAuditLogsSourceProbe,
+	}
 	kubernetesInterface, err := probe.NewK8sClient(ctx)
 	if err != nil {
 		return nil, err
 	}
-	apiServerSourceProbe := handlers.NewApiServerSourceProbe(projectID, kubernetesInterface)
+	apiServerSourceProbe := handlers.NewApiServerSourceProbe(projectID, kubernetesInterface, correlationStore)
 	apiServerSourceCreateProbe := &handlers.ApiServerSourceCreateProbe{
 		ApiServerSourceProbe: apiServerSourceProbe,
 	}
@@ -63,15 +110,41 @@ func InitializeProbeHelper(ctx context.Context, brokerCellBaseUrl string, projec
 	apiServerSourceDeleteProbe := &handlers.ApiServerSourceDeleteProbe{
 		ApiServerSourceProbe: apiServerSourceProbe,
 	}
-	cloudSchedulerSourceProbe := handlers.NewCloudSchedulerSourceProbe(cronStaleDuration)
-	pingSourceProbe := handlers.NewPingSourceProbe(cronStaleDuration)
-	eventTypeProbe := handlers.NewEventTypeHandler(brokerE2EDeliveryProbe, cloudPubSubSourceProbe, cloudStorageSourceCreateProbe, cloudStorageSourceUpdateMetadataProbe, cloudStorageSourceArchiveProbe, cloudStorageSourceDeleteProbe, cloudAuditLogsSourceProbe, apiServerSourceCreateProbe, apiServerSourceUpdateProbe, apiServerSourceDeleteProbe, cloudSchedulerSourceProbe, pingSourceProbe)
+	cloudSchedulerSourceProbe := handlers.NewCloudSchedulerSourceProbe(cronStaleDuration, clock)
+	cloudSchedulerSourceRateProbe := &handlers.CloudSchedulerSourceRateProbe{
+		CloudSchedulerSourceProbe: cloudSchedulerSourceProbe,
+	}
+	pingSourceProbe := handlers.NewPingSourceProbe(cronStaleDuration, clock)
+	pubSubRoundtripProbe := handlers.NewPubSubRoundtripProbe(client)
+	orderingProbe := handlers.NewOrderingProbe(cloudPubSubSourceProbe, client)
+	eventTypeProbe := handlers.NewEventTypeHandler(brokerE2EDeliveryProbe, brokerDLQProbe, brokerRejectProbe, channelE2EDeliveryProbe, cloudPubSubSourceProbe, cloudStorageSourceCreateProbe, cloudStorageSourceUpdateMetadataProbe, cloudStorageSourceArchiveProbe, cloudStorageSourceDeleteProbe, cloudStorageSourceComposeProbe, cloudStorageSourcePrefixProbe, cloudStorageSourceResumableProbe, cloudAuditLogsSourceProbe, cloudAuditLogsSourceDeleteProbe, apiServerSourceCreateProbe, apiServerSourceUpdateProbe, apiServerSourceDeleteProbe, cloudSchedulerSourceProbe, cloudSchedulerSourceRateProbe, pingSourceProbe, pubSubRoundtripProbe, orderingProbe)
 	livenessChecker := handlers.NewLivenessChecker(cloudSchedulerSourceProbe, pingSourceProbe)
-	receiveClientOptions := probe.NewCeReceiverClientOptions(receivePort)
-	ceReceiveClient, err := probe.NewCeReceiverClient(ctx, livenessChecker, receiveClientOptions)
+	readinessChecker := utils.NewReadinessChecker()
+	inFlightProbes := utils.NewInFlightProbes()
+	serveMux := probe.NewReceiverMux(ctx, livenessChecker, readinessChecker, inFlightProbes)
+	config, err := probe.NewReceiverTLSConfig(helperEnv)
+	if err != nil {
+		return nil, err
+	}
+	receiveClientOptions, err := probe.NewCeReceiverClientOptions(receivePort, config)
+	if err != nil {
+		return nil, err
+	}
+	ceReceiveClient, err := probe.NewCeReceiverClient(ctx, helperEnv, serveMux, receiveClientOptions)
+	if err != nil {
+		return nil, err
+	}
+	probeGRPCListener, err := probe.NewProbeGRPCListener(helperEnv, forwardPort)
+	if err != nil {
+		return nil, err
+	}
+	backendChecker, err := probe.NewBackendChecker(helperEnv, client, clock)
+	if err != nil {
+		return nil, err
+	}
+	helper, err := probe.NewHelper(helperEnv, eventTypeProbe, ceForwardClient, ceReceiveClient, livenessChecker, readinessChecker, probeMetrics, inFlightProbes, serveMux, config, probeGRPCListener, correlationStore, clock, backendChecker, probeRequests)
 	if err != nil {
 		return nil, err
 	}
-	helper := probe.NewHelper(helperEnv, eventTypeProbe, ceForwardClient, ceReceiveClient, livenessChecker)
 	return helper, nil
 }